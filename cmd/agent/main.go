@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/KubeOperator/kubepi/pkg/tunnel"
+	"github.com/spf13/cobra"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+const (
+	serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+	// secretName 保存 agent 令牌的 secret,agent 重启后无需重新注册
+	secretName     = "kubepi-agent"
+	agentTokenKey  = "agentToken"
+	maxBackoff     = time.Minute
+	defaultBackoff = time.Second
+)
+
+var (
+	serverAddr string
+	cluster    string
+	joinToken  string
+	insecure   bool
+)
+
+func init() {
+	RootCmd.Flags().StringVar(&serverAddr, "server", "", "kubepi address, e.g. https://kubepi.example.com")
+	RootCmd.Flags().StringVar(&cluster, "cluster", "", "cluster name in kubepi")
+	RootCmd.Flags().StringVar(&joinToken, "join-token", "", "one-time join token of the cluster")
+	RootCmd.Flags().BoolVar(&insecure, "insecure", false, "skip tls verification of kubepi")
+	_ = RootCmd.MarkFlagRequired("server")
+	_ = RootCmd.MarkFlagRequired("cluster")
+}
+
+var RootCmd = &cobra.Command{
+	Use:   "kubepi-agent",
+	Short: "Connect a kubernetes cluster to kubepi through a reverse tunnel",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer cancel()
+		return run(ctx)
+	},
+}
+
+func run(ctx context.Context) error {
+	cfg, err := rest.InClusterConfig()
+	if err != nil {
+		return err
+	}
+	client, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		return err
+	}
+	namespace, err := readFile("namespace")
+	if err != nil {
+		return err
+	}
+	agentToken, err := loadAgentToken(ctx, client, namespace)
+	if err != nil {
+		return err
+	}
+	agent := &tunnel.Agent{
+		Server:     serverAddr,
+		Cluster:    cluster,
+		AgentToken: agentToken,
+		Target:     net.JoinHostPort(os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")),
+		TokenFile:  serviceAccountDir + "/token",
+		Insecure:   insecure,
+	}
+	backoff := defaultBackoff
+	for {
+		if agent.AgentToken == "" {
+			token, err := register()
+			if err != nil {
+				return err
+			}
+			if err := saveAgentToken(ctx, client, namespace, token); err != nil {
+				log.Printf("can not save agent token: %s", err)
+			}
+			agent.AgentToken = token
+		}
+		start := time.Now()
+		err := agent.Run(ctx)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if errors.Is(err, tunnel.ErrUnauthorized) {
+			if joinToken == "" {
+				return err
+			}
+			// agent 令牌失效时使用加入令牌重新注册
+			agent.AgentToken = ""
+			continue
+		}
+		log.Printf("tunnel disconnected: %v", err)
+		if time.Since(start) > maxBackoff {
+			backoff = defaultBackoff
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+func register() (string, error) {
+	if joinToken == "" {
+		return "", errors.New("join token is required for the first connection")
+	}
+	token, err := readFile("token")
+	if err != nil {
+		return "", err
+	}
+	caData, err := readFile("ca.crt")
+	if err != nil {
+		return "", err
+	}
+	return tunnel.Register(serverAddr, insecure, tunnel.RegisterRequest{
+		Cluster:   cluster,
+		JoinToken: joinToken,
+		Token:     token,
+		CaData:    caData,
+	})
+}
+
+func loadAgentToken(ctx context.Context, client kubernetes.Interface, namespace string) (string, error) {
+	secret, err := client.CoreV1().Secrets(namespace).Get(ctx, secretName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return string(secret.Data[agentTokenKey]), nil
+}
+
+func saveAgentToken(ctx context.Context, client kubernetes.Interface, namespace, token string) error {
+	secrets := client.CoreV1().Secrets(namespace)
+	secret, err := secrets.Get(ctx, secretName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		_, err = secrets.Create(ctx, &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: secretName, Namespace: namespace},
+			Data:       map[string][]byte{agentTokenKey: []byte(token)},
+		}, metav1.CreateOptions{})
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	if secret.Data == nil {
+		secret.Data = map[string][]byte{}
+	}
+	secret.Data[agentTokenKey] = []byte(token)
+	_, err = secrets.Update(ctx, secret, metav1.UpdateOptions{})
+	return err
+}
+
+func readFile(name string) (string, error) {
+	bs, err := os.ReadFile(serviceAccountDir + "/" + name)
+	if err != nil {
+		return "", fmt.Errorf("read service account %s failed: %s", name, err)
+	}
+	return strings.TrimSpace(string(bs)), nil
+}
+
+func main() {
+	if err := RootCmd.Execute(); err != nil {
+		log.Println(err)
+		os.Exit(1)
+	}
+}
@@ -10,6 +10,7 @@ import (
 	_ "github.com/KubeOperator/kubepi/internal/model/v1/docs"
 	_ "github.com/KubeOperator/kubepi/internal/model/v1/imagerepo"
 	_ "github.com/KubeOperator/kubepi/internal/model/v1/role"
+	_ "github.com/KubeOperator/kubepi/internal/model/v1/token"
 	_ "github.com/KubeOperator/kubepi/internal/model/v1/user"
 	"github.com/KubeOperator/kubepi/internal/route"
 	"github.com/KubeOperator/kubepi/internal/server"
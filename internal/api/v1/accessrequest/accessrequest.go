@@ -0,0 +1,428 @@
+package accessrequest
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/KubeOperator/kubepi/internal/api/v1/cluster"
+	"github.com/KubeOperator/kubepi/internal/api/v1/session"
+	v1AccessRequest "github.com/KubeOperator/kubepi/internal/model/v1/accessrequest"
+	v1Cluster "github.com/KubeOperator/kubepi/internal/model/v1/cluster"
+	v1System "github.com/KubeOperator/kubepi/internal/model/v1/system"
+	"github.com/KubeOperator/kubepi/internal/service/v1/accessrequest"
+	v1ClusterService "github.com/KubeOperator/kubepi/internal/service/v1/cluster"
+	"github.com/KubeOperator/kubepi/internal/service/v1/clusterbinding"
+	"github.com/KubeOperator/kubepi/internal/service/v1/common"
+	v1SystemService "github.com/KubeOperator/kubepi/internal/service/v1/system"
+	"github.com/asdine/storm/v3"
+	"github.com/kataras/iris/v12"
+	"github.com/kataras/iris/v12/context"
+)
+
+// maxDuration 申请授权时长的上限(秒)
+const maxDuration = int64(365 * 24 * time.Hour / time.Second)
+
+// reviewLock 审批需要先创建集群成员再修改申请状态,同一时间只处理一个审批,避免重复创建成员
+var reviewLock sync.Mutex
+
+type Handler struct {
+	accessRequestService  accessrequest.Service
+	clusterService        v1ClusterService.Service
+	clusterBindingService clusterbinding.Service
+	systemService         v1SystemService.Service
+	clusterHandler        *cluster.Handler
+}
+
+func NewHandler() *Handler {
+	return &Handler{
+		accessRequestService:  accessrequest.NewService(),
+		clusterService:        v1ClusterService.NewService(),
+		clusterBindingService: clusterbinding.NewService(),
+		systemService:         v1SystemService.NewService(),
+		clusterHandler:        cluster.NewHandler(),
+	}
+}
+
+// List Access Requests
+// @Tags accessrequests
+// @Summary List access requests
+// @Description 指定 cluster 时返回该集群的申请,需要集群管理员权限;否则返回当前用户的申请
+// @Accept  json
+// @Produce  json
+// @Param cluster query string false "集群名称"
+// @Param status query string false "申请状态"
+// @Success 200 {object} []v1AccessRequest.AccessRequest
+// @Security ApiKeyAuth
+// @Router /accessrequests [get]
+func (h *Handler) ListAccessRequests() iris.Handler {
+	return func(ctx *context.Context) {
+		profile := ctx.Values().Get("profile").(session.UserProfile)
+		clusterName := ctx.URLParam("cluster")
+		requester := ""
+		if clusterName != "" {
+			c, err := h.clusterService.Get(clusterName, common.DBOptions{})
+			if err != nil {
+				h.setError(ctx, err, []string{"cluster %s not found", clusterName})
+				return
+			}
+			if !h.checkClusterAdmin(ctx, c, profile) {
+				return
+			}
+		} else if !profile.IsAdministrator {
+			requester = profile.Name
+		}
+		requests, err := h.accessRequestService.List(clusterName, requester, ctx.URLParam("status"), common.DBOptions{})
+		if err != nil && !errors.Is(err, storm.ErrNotFound) {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		ctx.Values().Set("data", requests)
+	}
+}
+
+// Submit Access Request
+// @Tags accessrequests
+// @Summary Submit access request
+// @Description 申请集群或命名空间角色
+// @Accept  json
+// @Produce  json
+// @Param request body SubmitRequest true "request"
+// @Success 200 {object} v1AccessRequest.AccessRequest
+// @Security ApiKeyAuth
+// @Router /accessrequests [post]
+func (h *Handler) SubmitAccessRequest() iris.Handler {
+	return func(ctx *context.Context) {
+		var req SubmitRequest
+		if err := ctx.ReadJSON(&req); err != nil {
+			ctx.StatusCode(iris.StatusBadRequest)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		profile := ctx.Values().Get("profile").(session.UserProfile)
+		if len(req.ClusterRoles) == 0 && len(req.NamespaceRoles) == 0 {
+			ctx.StatusCode(iris.StatusBadRequest)
+			ctx.Values().Set("message", "must select one role")
+			return
+		}
+		if req.Duration < 0 || req.Duration > maxDuration {
+			ctx.StatusCode(iris.StatusBadRequest)
+			ctx.Values().Set("message", []string{"duration must be between 0 and %s seconds", fmt.Sprint(maxDuration)})
+			return
+		}
+		c, err := h.clusterService.Get(req.Cluster, common.DBOptions{})
+		if err != nil {
+			h.setError(ctx, err, []string{"cluster %s not found", req.Cluster})
+			return
+		}
+		if !h.checkNotMember(ctx, c, profile.Name) {
+			return
+		}
+		pending, err := h.accessRequestService.List(c.Name, profile.Name, v1AccessRequest.StatusPending, common.DBOptions{})
+		if err != nil && !errors.Is(err, storm.ErrNotFound) {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		if len(pending) > 0 {
+			ctx.StatusCode(iris.StatusBadRequest)
+			ctx.Values().Set("message", []string{"access request for cluster %s is already pending", c.Name})
+			return
+		}
+		request := v1AccessRequest.AccessRequest{
+			Cluster:        c.Name,
+			Requester:      profile.Name,
+			ClusterRoles:   req.ClusterRoles,
+			NamespaceRoles: req.NamespaceRoles,
+			Duration:       req.Duration,
+			Reason:         req.Reason,
+		}
+		request.Kind = "AccessRequest"
+		request.CreatedBy = profile.Name
+		if err := h.accessRequestService.Create(&request, common.DBOptions{}); err != nil {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		h.saveLog(profile.Name, v1AccessRequest.ActionSubmit, &request)
+		ctx.Values().Set("data", &request)
+	}
+}
+
+// Get Access Request
+// @Tags accessrequests
+// @Summary Get access request by name
+// @Description Get access request by name
+// @Accept  json
+// @Produce  json
+// @Param name path string true "申请名称"
+// @Success 200 {object} v1AccessRequest.AccessRequest
+// @Security ApiKeyAuth
+// @Router /accessrequests/{name} [get]
+func (h *Handler) GetAccessRequest() iris.Handler {
+	return func(ctx *context.Context) {
+		profile := ctx.Values().Get("profile").(session.UserProfile)
+		request, ok := h.getRequest(ctx)
+		if !ok {
+			return
+		}
+		if request.Requester != profile.Name {
+			c, err := h.clusterService.Get(request.Cluster, common.DBOptions{})
+			if err != nil {
+				h.setError(ctx, err, []string{"cluster %s not found", request.Cluster})
+				return
+			}
+			if !h.checkClusterAdmin(ctx, c, profile) {
+				return
+			}
+		}
+		ctx.Values().Set("data", request)
+	}
+}
+
+// Approve Access Request
+// @Tags accessrequests
+// @Summary Approve access request
+// @Description 审批通过后按申请的角色创建集群成员
+// @Accept  json
+// @Produce  json
+// @Param name path string true "申请名称"
+// @Param request body ReviewRequest false "request"
+// @Success 200 {object} v1AccessRequest.AccessRequest
+// @Security ApiKeyAuth
+// @Router /accessrequests/{name}/approve [post]
+func (h *Handler) ApproveAccessRequest() iris.Handler {
+	return func(ctx *context.Context) {
+		profile := ctx.Values().Get("profile").(session.UserProfile)
+		reviewLock.Lock()
+		defer reviewLock.Unlock()
+		request, review, c, ok := h.prepareReview(ctx, profile)
+		if !ok {
+			return
+		}
+		// 提交申请后用户可能已经通过用户组成为成员
+		if !h.checkNotMember(ctx, c, request.Requester) {
+			return
+		}
+		var expire time.Time
+		if request.Duration > 0 {
+			expire = time.Now().Add(time.Duration(request.Duration) * time.Second)
+		}
+		member := cluster.Member{
+			Name:           request.Requester,
+			Kind:           "User",
+			ClusterRoles:   request.ClusterRoles,
+			NamespaceRoles: request.NamespaceRoles,
+		}
+		if err := h.clusterHandler.CreateMember(c.Name, &member, expire, profile.Name); err != nil {
+			_ = h.accessRequestService.AddEvent(request.Name, v1AccessRequest.Event{
+				Action:   v1AccessRequest.ActionApproveFailed,
+				Operator: profile.Name,
+				Comment:  err.Error(),
+			}, common.DBOptions{})
+			h.saveLog(profile.Name, v1AccessRequest.ActionApproveFailed, request)
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		h.review(ctx, request, v1AccessRequest.StatusApproved, v1AccessRequest.Event{
+			Action:   v1AccessRequest.ActionApprove,
+			Operator: profile.Name,
+			Comment:  review.Comment,
+		})
+	}
+}
+
+// Reject Access Request
+// @Tags accessrequests
+// @Summary Reject access request
+// @Description Reject access request
+// @Accept  json
+// @Produce  json
+// @Param name path string true "申请名称"
+// @Param request body ReviewRequest false "request"
+// @Success 200 {object} v1AccessRequest.AccessRequest
+// @Security ApiKeyAuth
+// @Router /accessrequests/{name}/reject [post]
+func (h *Handler) RejectAccessRequest() iris.Handler {
+	return func(ctx *context.Context) {
+		profile := ctx.Values().Get("profile").(session.UserProfile)
+		reviewLock.Lock()
+		defer reviewLock.Unlock()
+		request, review, _, ok := h.prepareReview(ctx, profile)
+		if !ok {
+			return
+		}
+		h.review(ctx, request, v1AccessRequest.StatusRejected, v1AccessRequest.Event{
+			Action:   v1AccessRequest.ActionReject,
+			Operator: profile.Name,
+			Comment:  review.Comment,
+		})
+	}
+}
+
+// Cancel Access Request
+// @Tags accessrequests
+// @Summary Cancel access request
+// @Description 申请人撤销待审批的申请
+// @Accept  json
+// @Produce  json
+// @Param name path string true "申请名称"
+// @Success 200 {object} v1AccessRequest.AccessRequest
+// @Security ApiKeyAuth
+// @Router /accessrequests/{name}/cancel [post]
+func (h *Handler) CancelAccessRequest() iris.Handler {
+	return func(ctx *context.Context) {
+		profile := ctx.Values().Get("profile").(session.UserProfile)
+		reviewLock.Lock()
+		defer reviewLock.Unlock()
+		request, ok := h.getRequest(ctx)
+		if !ok {
+			return
+		}
+		if request.Requester != profile.Name {
+			ctx.StatusCode(iris.StatusForbidden)
+			ctx.Values().Set("message", []string{"user %s can not cancel access request %s", profile.Name, request.Name})
+			return
+		}
+		if !checkPending(ctx, request) {
+			return
+		}
+		h.review(ctx, request, v1AccessRequest.StatusCanceled, v1AccessRequest.Event{
+			Action:   v1AccessRequest.ActionCancel,
+			Operator: profile.Name,
+		})
+	}
+}
+
+func (h *Handler) getRequest(ctx *context.Context) (*v1AccessRequest.AccessRequest, bool) {
+	name := ctx.Params().GetString("name")
+	request, err := h.accessRequestService.Get(name, common.DBOptions{})
+	if err != nil {
+		h.setError(ctx, err, []string{"access request %s not found", name})
+		return nil, false
+	}
+	return request, true
+}
+
+// prepareReview 审批和驳回前检查申请状态及审批人权限
+func (h *Handler) prepareReview(ctx *context.Context, profile session.UserProfile) (*v1AccessRequest.AccessRequest, *ReviewRequest, *v1Cluster.Cluster, bool) {
+	var review ReviewRequest
+	if ctx.GetContentLength() > 0 {
+		if err := ctx.ReadJSON(&review); err != nil {
+			ctx.StatusCode(iris.StatusBadRequest)
+			ctx.Values().Set("message", err.Error())
+			return nil, nil, nil, false
+		}
+	}
+	request, ok := h.getRequest(ctx)
+	if !ok || !checkPending(ctx, request) {
+		return nil, nil, nil, false
+	}
+	c, err := h.clusterService.Get(request.Cluster, common.DBOptions{})
+	if err != nil {
+		h.setError(ctx, err, []string{"cluster %s not found", request.Cluster})
+		return nil, nil, nil, false
+	}
+	if !h.checkClusterAdmin(ctx, c, profile) {
+		return nil, nil, nil, false
+	}
+	return request, &review, c, true
+}
+
+func (h *Handler) review(ctx *context.Context, request *v1AccessRequest.AccessRequest, status string, event v1AccessRequest.Event) {
+	if err := h.accessRequestService.Review(request.Name, status, event, common.DBOptions{}); err != nil {
+		if errors.Is(err, accessrequest.ErrNotPending) {
+			ctx.StatusCode(iris.StatusBadRequest)
+			ctx.Values().Set("message", []string{"access request %s is not pending", request.Name})
+			return
+		}
+		ctx.StatusCode(iris.StatusInternalServerError)
+		ctx.Values().Set("message", err.Error())
+		return
+	}
+	h.saveLog(event.Operator, event.Action, request)
+	updated, err := h.accessRequestService.Get(request.Name, common.DBOptions{})
+	if err != nil {
+		ctx.StatusCode(iris.StatusInternalServerError)
+		ctx.Values().Set("message", err.Error())
+		return
+	}
+	ctx.Values().Set("data", updated)
+}
+
+func (h *Handler) checkClusterAdmin(ctx *context.Context, c *v1Cluster.Cluster, profile session.UserProfile) bool {
+	ok, err := h.clusterHandler.IsClusterAdmin(c, profile)
+	if err != nil {
+		ctx.StatusCode(iris.StatusInternalServerError)
+		ctx.Values().Set("message", err.Error())
+		return false
+	}
+	if !ok {
+		ctx.StatusCode(iris.StatusForbidden)
+		ctx.Values().Set("message", []string{"user %s is not an administrator of cluster %s", profile.Name, c.Name})
+		return false
+	}
+	return true
+}
+
+// checkNotMember 集群导入者以及直接或通过用户组加入集群的用户不需要申请
+func (h *Handler) checkNotMember(ctx *context.Context, c *v1Cluster.Cluster, userName string) bool {
+	if c.CreatedBy == userName {
+		ctx.StatusCode(iris.StatusBadRequest)
+		ctx.Values().Set("message", []string{"user %s is already a member of cluster %s", userName, c.Name})
+		return false
+	}
+	if _, err := h.clusterBindingService.GetCredentialBinding(c.Name, userName, common.DBOptions{}); err == nil {
+		ctx.StatusCode(iris.StatusBadRequest)
+		ctx.Values().Set("message", []string{"user %s is already a member of cluster %s", userName, c.Name})
+		return false
+	} else if !errors.Is(err, storm.ErrNotFound) {
+		ctx.StatusCode(iris.StatusInternalServerError)
+		ctx.Values().Set("message", err.Error())
+		return false
+	}
+	return true
+}
+
+func checkPending(ctx *context.Context, request *v1AccessRequest.AccessRequest) bool {
+	if request.Status != v1AccessRequest.StatusPending {
+		ctx.StatusCode(iris.StatusBadRequest)
+		ctx.Values().Set("message", []string{"access request %s is %s", request.Name, request.Status})
+		return false
+	}
+	return true
+}
+
+func (h *Handler) setError(ctx *context.Context, err error, notFound []string) {
+	if errors.Is(err, storm.ErrNotFound) {
+		ctx.StatusCode(iris.StatusNotFound)
+		ctx.Values().Set("message", notFound)
+		return
+	}
+	ctx.StatusCode(iris.StatusInternalServerError)
+	ctx.Values().Set("message", err.Error())
+}
+
+// saveLog 申请接口不经过 logHandler,每一步单独记录操作日志
+func (h *Handler) saveLog(operator, operation string, request *v1AccessRequest.AccessRequest) {
+	go h.systemService.CreateOperationLog(&v1System.OperationLog{
+		Operator:            operator,
+		Operation:           operation,
+		OperationDomain:     "accessrequests",
+		SpecificInformation: fmt.Sprintf("[%s] %s", request.Cluster, request.Requester),
+	}, common.DBOptions{})
+}
+
+func Install(parent iris.Party) {
+	handler := NewHandler()
+	sp := parent.Party("/accessrequests")
+	sp.Get("", handler.ListAccessRequests())
+	sp.Post("", handler.SubmitAccessRequest())
+	sp.Get("/:name", handler.GetAccessRequest())
+	sp.Post("/:name/approve", handler.ApproveAccessRequest())
+	sp.Post("/:name/reject", handler.RejectAccessRequest())
+	sp.Post("/:name/cancel", handler.CancelAccessRequest())
+}
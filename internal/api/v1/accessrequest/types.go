@@ -0,0 +1,16 @@
+package accessrequest
+
+import v1Cluster "github.com/KubeOperator/kubepi/internal/model/v1/cluster"
+
+type SubmitRequest struct {
+	Cluster        string                     `json:"cluster"`
+	ClusterRoles   []string                   `json:"clusterRoles"`
+	NamespaceRoles []v1Cluster.NamespaceRoles `json:"namespaceRoles"`
+	// Duration 授权时长(秒),为 0 时永久有效,最长一年
+	Duration int64  `json:"duration"`
+	Reason   string `json:"reason"`
+}
+
+type ReviewRequest struct {
+	Comment string `json:"comment"`
+}
@@ -0,0 +1,458 @@
+package cluster
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/KubeOperator/kubepi/internal/api/v1/session"
+	v1Cluster "github.com/KubeOperator/kubepi/internal/model/v1/cluster"
+	v1System "github.com/KubeOperator/kubepi/internal/model/v1/system"
+	"github.com/KubeOperator/kubepi/internal/server"
+	"github.com/KubeOperator/kubepi/internal/service/v1/common"
+	v1SystemService "github.com/KubeOperator/kubepi/internal/service/v1/system"
+	"github.com/KubeOperator/kubepi/pkg/certificate"
+	"github.com/KubeOperator/kubepi/pkg/kubernetes"
+	"github.com/asdine/storm/v3"
+	"github.com/kataras/iris/v12"
+	"github.com/kataras/iris/v12/context"
+	"github.com/robfig/cron/v3"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+const (
+	// certificateCheckSchedule 检查证书有效期的周期
+	certificateCheckSchedule = "@every 1h"
+	// certificateRenewBefore 剩余有效期小于该值的证书视为即将过期,用户证书会被重新签发
+	certificateRenewBefore = 30 * 24 * time.Hour
+
+	certificateKindCA     = "ca"
+	certificateKindClient = "client"
+	certificateKindUser   = "user"
+)
+
+// List Certificates
+// @Tags clusters
+// @Summary List certificates of cluster
+// @Description List certificates of cluster
+// @Accept  json
+// @Produce  json
+// @Param cluster path string true "集群名称"
+// @Success 200 {object} []CertificateStatus
+// @Security ApiKeyAuth
+// @Router /clusters/{cluster}/certificates [get]
+func (h *Handler) ListCertificates() iris.Handler {
+	return func(ctx *context.Context) {
+		name := ctx.Params().GetString("name")
+		c, err := h.clusterService.Get(name, common.DBOptions{})
+		if err != nil {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		bindings, err := h.clusterBindingService.GetClusterBindingByClusterName(name, common.DBOptions{})
+		if err != nil {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		now := time.Now()
+		result := clusterCertificates(c, now)
+		for i := range bindings {
+			if len(bindings[i].Certificate) == 0 || bindings[i].UserRef == "" {
+				continue
+			}
+			result = append(result, certificateStatus(certificateKindUser, bindings[i].UserRef, bindings[i].Certificate, now))
+		}
+		ctx.Values().Set("data", result)
+	}
+}
+
+// Rotate Credentials
+// @Tags clusters
+// @Summary Rotate credentials of cluster
+// @Description Rotate credentials of cluster
+// @Accept  json
+// @Produce  json
+// @Param cluster path string true "集群名称"
+// @Param request body RotateCredentials true "request"
+// @Success 200 {object} []CertificateStatus
+// @Security ApiKeyAuth
+// @Router /clusters/{cluster}/credentials [post]
+func (h *Handler) RotateCredentials() iris.Handler {
+	return func(ctx *context.Context) {
+		name := ctx.Params().GetString("name")
+		var req RotateCredentials
+		if err := ctx.ReadJSON(&req); err != nil {
+			ctx.StatusCode(iris.StatusBadRequest)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		c, err := h.clusterService.Get(name, common.DBOptions{})
+		if err != nil {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		if !h.requireClusterAdmin(ctx, c) {
+			return
+		}
+		if c.Spec.Connect.Direction == v1Cluster.DirectionReverse {
+			ctx.StatusCode(iris.StatusBadRequest)
+			ctx.Values().Set("message", "can not update connection of reverse cluster")
+			return
+		}
+		auth := v1Cluster.Authentication{Mode: req.Mode}
+		switch strings.ToLower(req.Mode) {
+		case "bearer":
+			auth.BearerToken = req.Token
+		case "certificate":
+			auth.Certificate.CertData = []byte(req.CertData)
+			auth.Certificate.KeyData = []byte(req.KeyData)
+		case "configfile":
+			auth.ConfigFileContent = []byte(req.ConfigFileContent)
+		default:
+			ctx.StatusCode(iris.StatusBadRequest)
+			ctx.Values().Set("message", []string{"unsupported authentication mode %s", req.Mode})
+			return
+		}
+		c.Spec.Authentication = auth
+		if req.CaData != "" {
+			c.CaCertificate.CertData = []byte(req.CaData)
+		}
+		client := kubernetes.NewKubernetes(c)
+		if err := client.Ping(); err != nil {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		notAllowed, err := checkRequiredPermissions(client, requiredPermissions)
+		if err != nil {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		if notAllowed != "" {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", []string{"permission %s required", notAllowed})
+			return
+		}
+		if strings.ToLower(req.Mode) == "configfile" {
+			kubeCfg, err := client.Config()
+			if err != nil {
+				ctx.StatusCode(iris.StatusInternalServerError)
+				ctx.Values().Set("message", err.Error())
+				return
+			}
+			c.Spec.Connect.Forward.ApiServer = kubeCfg.Host
+		}
+		if err := h.clusterService.Update(c.Name, c, common.DBOptions{}); err != nil {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		ctx.Values().Set("data", clusterCertificates(c, time.Now()))
+	}
+}
+
+// Rotate Private Key
+// @Tags clusters
+// @Summary Rotate private key of cluster and reissue certificates of members
+// @Description Rotate private key of cluster and reissue certificates of members. Certificates are issued by the cluster ca and keep the user name as common name, so old certificates stay valid until they expire, to cut off access remove the member instead
+// @Accept  json
+// @Produce  json
+// @Param cluster path string true "集群名称"
+// @Success 200 {object} []CertificateStatus
+// @Security ApiKeyAuth
+// @Router /clusters/{cluster}/privatekey [post]
+func (h *Handler) RotatePrivateKey() iris.Handler {
+	return func(ctx *context.Context) {
+		name := ctx.Params().GetString("name")
+		c, err := h.clusterService.Get(name, common.DBOptions{})
+		if err != nil {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		if !h.requireClusterAdmin(ctx, c) {
+			return
+		}
+		bindings, err := h.clusterBindingService.GetClusterBindingByClusterName(name, common.DBOptions{})
+		if err != nil && !errors.Is(err, storm.ErrNotFound) {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		privateKey, err := certificate.GeneratePrivateKey()
+		if err != nil {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		rotated := *c
+		rotated.PrivateKey = privateKey
+		client := kubernetes.NewKubernetes(&rotated)
+		// 先签发全部新证书,任一失败时保留原私钥和证书
+		var users []string
+		certs := map[string][]byte{}
+		for i := range bindings {
+			userName := bindings[i].UserRef
+			if len(bindings[i].Certificate) == 0 || userName == "" {
+				continue
+			}
+			if _, ok := certs[userName]; ok {
+				continue
+			}
+			cert, err := client.CreateCommonUser(userName)
+			if err != nil {
+				ctx.StatusCode(iris.StatusInternalServerError)
+				ctx.Values().Set("message", []string{"can not issue certificate for user %s: %s", userName, err.Error()})
+				return
+			}
+			users = append(users, userName)
+			certs[userName] = cert
+		}
+
+		tx, err := server.DB().Begin(true)
+		if err != nil {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		txOptions := common.DBOptions{DB: tx}
+		if err := h.clusterService.Update(rotated.Name, &rotated, txOptions); err != nil {
+			_ = tx.Rollback()
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		for i := range bindings {
+			cert, ok := certs[bindings[i].UserRef]
+			if !ok || len(bindings[i].Certificate) == 0 {
+				continue
+			}
+			old := bindings[i].Certificate
+			bindings[i].Certificate = cert
+			if err := h.clusterBindingService.UpdateClusterBinding(bindings[i].Name, &bindings[i], txOptions); err != nil {
+				_ = tx.Rollback()
+				ctx.StatusCode(iris.StatusInternalServerError)
+				ctx.Values().Set("message", err.Error())
+				return
+			}
+			if err := h.clusterBindingService.RevokeCertificate(name, bindings[i].UserRef, old, v1Cluster.RevokeReasonRotated, txOptions); err != nil {
+				_ = tx.Rollback()
+				ctx.StatusCode(iris.StatusInternalServerError)
+				ctx.Values().Set("message", err.Error())
+				return
+			}
+		}
+		if err := tx.Commit(); err != nil {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		now := time.Now()
+		result := make([]CertificateStatus, 0, len(users))
+		for _, userName := range users {
+			result = append(result, certificateStatus(certificateKindUser, userName, certs[userName], now))
+		}
+		ctx.Values().Set("data", result)
+	}
+}
+
+// List Revoked Certificates
+// @Tags clusters
+// @Summary List revoked certificates of cluster
+// @Description List revoked certificates of cluster
+// @Accept  json
+// @Produce  json
+// @Param cluster path string true "集群名称"
+// @Success 200 {object} []v1Cluster.RevokedCertificate
+// @Security ApiKeyAuth
+// @Router /clusters/{cluster}/certificates/revoked [get]
+func (h *Handler) ListRevokedCertificates() iris.Handler {
+	return func(ctx *context.Context) {
+		name := ctx.Params().GetString("name")
+		rcs, err := h.clusterBindingService.ListRevokedCertificates(name, common.DBOptions{})
+		if err != nil {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		ctx.Values().Set("data", rcs)
+	}
+}
+
+func (h *Handler) requireClusterAdmin(ctx *context.Context, c *v1Cluster.Cluster) bool {
+	profile := ctx.Values().Get("profile").(session.UserProfile)
+	ok, err := h.IsClusterAdmin(c, profile)
+	if err != nil {
+		ctx.StatusCode(iris.StatusInternalServerError)
+		ctx.Values().Set("message", err.Error())
+		return false
+	}
+	if !ok {
+		ctx.StatusCode(iris.StatusForbidden)
+		ctx.Values().Set("message", []string{"user %s is not an administrator of cluster %s", profile.Name, c.Name})
+		return false
+	}
+	return true
+}
+
+// clusterCertificates 集群自身凭证中的证书,kubeconfig 只解析内嵌的证书数据
+func clusterCertificates(c *v1Cluster.Cluster, now time.Time) []CertificateStatus {
+	var result []CertificateStatus
+	caData := c.CaCertificate.CertData
+	var certData []byte
+	switch strings.ToLower(c.Spec.Authentication.Mode) {
+	case "certificate":
+		certData = c.Spec.Authentication.Certificate.CertData
+	case "configfile":
+		cfg, err := clientcmd.RESTConfigFromKubeConfig(c.Spec.Authentication.ConfigFileContent)
+		if err != nil {
+			result = append(result, CertificateStatus{Kind: certificateKindClient, Name: c.Name, Message: err.Error()})
+			break
+		}
+		if len(cfg.CAData) > 0 {
+			caData = cfg.CAData
+		}
+		certData = cfg.CertData
+	}
+	if len(caData) > 0 {
+		result = append(result, certificateStatus(certificateKindCA, c.Name, caData, now))
+	}
+	if len(certData) > 0 {
+		result = append(result, certificateStatus(certificateKindClient, c.Name, certData, now))
+	}
+	return result
+}
+
+func certificateStatus(kind, name string, certPem []byte, now time.Time) CertificateStatus {
+	s := CertificateStatus{Kind: kind, Name: name}
+	cert, err := certificate.ParseX509Certificate(certPem)
+	if err != nil {
+		s.Message = err.Error()
+		return s
+	}
+	s.Subject = cert.Subject.String()
+	s.Fingerprint = certificate.Fingerprint(cert)
+	s.NotBefore = cert.NotBefore
+	s.NotAfter = cert.NotAfter
+	s.Expired = !now.Before(cert.NotAfter)
+	s.Expiring = cert.NotAfter.Sub(now) < certificateRenewBefore
+	return s
+}
+
+// startCertificateChecker 定时检查证书有效期并重新签发即将过期的用户证书
+func (h *Handler) startCertificateChecker() {
+	c := cron.New()
+	if _, err := c.AddFunc(certificateCheckSchedule, h.checkCertificates); err != nil {
+		server.Logger().Errorf("can not start certificate checker: %s", err)
+		return
+	}
+	c.Start()
+}
+
+func (h *Handler) checkCertificates() {
+	clusters, err := h.clusterService.List(common.DBOptions{})
+	if err != nil {
+		server.Logger().Errorf("can not list clusters: %s", err)
+		return
+	}
+	now := time.Now()
+	for i := range clusters {
+		for _, s := range clusterCertificates(&clusters[i], now) {
+			if s.Expiring {
+				server.Logger().Warnf("%s certificate of cluster %s expires at %s", s.Kind, clusters[i].Name, s.NotAfter.Format(time.RFC3339))
+			}
+		}
+		if clusters[i].Status.Phase != clusterStatusCompleted {
+			continue
+		}
+		if err := h.renewUserCertificates(&clusters[i], now); err != nil {
+			server.Logger().Errorf("can not renew user certificates of cluster %s: %s", clusters[i].Name, err)
+		}
+	}
+}
+
+// renewUserCertificates 集群不可访问时直接返回,等待下一次检查。
+// 用户直接添加和通过用户组添加的绑定共用一张证书,重新签发后一并更新并作废旧证书
+func (h *Handler) renewUserCertificates(c *v1Cluster.Cluster, now time.Time) error {
+	bindings, err := h.clusterBindingService.GetClusterBindingByClusterName(c.Name, common.DBOptions{})
+	if err != nil {
+		return err
+	}
+	var users []string
+	userBindings := map[string][]int{}
+	renew := map[string]bool{}
+	for i := range bindings {
+		userName := bindings[i].UserRef
+		if len(bindings[i].Certificate) == 0 || userName == "" {
+			continue
+		}
+		if _, ok := userBindings[userName]; !ok {
+			users = append(users, userName)
+		}
+		userBindings[userName] = append(userBindings[userName], i)
+		if s := certificateStatus(certificateKindUser, userName, bindings[i].Certificate, now); s.Message != "" || s.Expiring {
+			renew[userName] = true
+		}
+	}
+	client := kubernetes.NewKubernetes(c)
+	for _, userName := range users {
+		if !renew[userName] {
+			continue
+		}
+		cert, err := client.CreateCommonUser(userName)
+		if err != nil {
+			return err
+		}
+		if err := h.replaceUserCertificate(c.Name, userName, bindings, userBindings[userName], cert); err != nil {
+			return err
+		}
+		v1SystemService.NewService().CreateOperationLog(&v1System.OperationLog{
+			Operator:            memberExpireOperator,
+			Operation:           "renew",
+			OperationDomain:     "clusters_certificates",
+			SpecificInformation: fmt.Sprintf("[%s] %s", c.Name, userName),
+		}, common.DBOptions{})
+	}
+	return nil
+}
+
+// replaceUserCertificate 在同一事务中更新用户的全部绑定并作废旧证书
+func (h *Handler) replaceUserCertificate(clusterName, userName string, bindings []v1Cluster.Binding, indexes []int, cert []byte) error {
+	tx, err := server.DB().Begin(true)
+	if err != nil {
+		return err
+	}
+	txOptions := common.DBOptions{DB: tx}
+	var olds [][]byte
+	for _, i := range indexes {
+		old := bindings[i].Certificate
+		bindings[i].Certificate = cert
+		if err := h.clusterBindingService.UpdateClusterBinding(bindings[i].Name, &bindings[i], txOptions); err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+		seen := false
+		for j := range olds {
+			if bytes.Equal(olds[j], old) {
+				seen = true
+				break
+			}
+		}
+		if !seen {
+			olds = append(olds, old)
+		}
+	}
+	for i := range olds {
+		if err := h.clusterBindingService.RevokeCertificate(clusterName, userName, olds[i], v1Cluster.RevokeReasonRenewed, txOptions); err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
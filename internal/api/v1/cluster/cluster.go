@@ -19,15 +19,20 @@ import (
 	"github.com/KubeOperator/kubepi/internal/server"
 	"github.com/KubeOperator/kubepi/internal/service/v1/cluster"
 	"github.com/KubeOperator/kubepi/internal/service/v1/clusterbinding"
+	"github.com/KubeOperator/kubepi/internal/service/v1/clusterhealth"
 	"github.com/KubeOperator/kubepi/internal/service/v1/common"
+	"github.com/KubeOperator/kubepi/internal/service/v1/group"
 	pkgV1 "github.com/KubeOperator/kubepi/pkg/api/v1"
 	"github.com/KubeOperator/kubepi/pkg/certificate"
+	"github.com/KubeOperator/kubepi/pkg/collectons"
 	"github.com/KubeOperator/kubepi/pkg/kubernetes"
+	"github.com/KubeOperator/kubepi/pkg/tunnel"
 	"github.com/asdine/storm/v3"
 	"github.com/kataras/iris/v12"
 	"github.com/kataras/iris/v12/context"
 	authV1 "k8s.io/api/authorization/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clientset "k8s.io/client-go/kubernetes"
 )
 
 type Handler struct {
@@ -36,6 +41,8 @@ type Handler struct {
 	clusterRepoService    clusterrepo.Service
 	imageRepoService      imagerepo.Service
 	clusterAppService     clusterapp.Service
+	groupService          group.Service
+	clusterHealthService  clusterhealth.Service
 }
 
 func NewHandler() *Handler {
@@ -45,6 +52,8 @@ func NewHandler() *Handler {
 		clusterRepoService:    clusterrepo.NewService(),
 		imageRepoService:      imagerepo.NewService(),
 		clusterAppService:     clusterapp.NewService(),
+		groupService:          group.NewService(),
+		clusterHealthService:  clusterhealth.NewService(),
 	}
 }
 
@@ -66,6 +75,10 @@ func (h *Handler) CreateCluster() iris.Handler {
 			ctx.Values().Set("message", err.Error())
 			return
 		}
+		if req.Spec.Connect.Direction == v1Cluster.DirectionReverse {
+			h.createReverseCluster(ctx, &req.Cluster)
+			return
+		}
 		if req.ConfigFileContentStr != "" {
 			req.Spec.Authentication.ConfigFileContent = []byte(req.ConfigFileContentStr)
 		}
@@ -82,6 +95,12 @@ func (h *Handler) CreateCluster() iris.Handler {
 			}
 		}
 
+		if _, err := kubernetes.ProxyURL(req.Spec.Connect.Forward.Proxy); err != nil {
+			ctx.StatusCode(iris.StatusBadRequest)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+
 		// 生成一个rsa格式的私钥
 		privateKey, err := certificate.GeneratePrivateKey()
 		if err != nil {
@@ -126,13 +145,6 @@ func (h *Handler) CreateCluster() iris.Handler {
 			return
 		}
 
-		requiredPermissions := map[string][]string{
-			"namespaces":       {"get", "post", "delete"},
-			"clusterroles":     {"get", "post", "delete"},
-			"clusterrolebings": {"get", "post", "delete"},
-			"roles":            {"get", "post", "delete"},
-			"rolebindings":     {"get", "post", "delete"},
-		}
 		notAllowed, err := checkRequiredPermissions(client, requiredPermissions)
 		if err != nil {
 			_ = tx.Rollback()
@@ -148,71 +160,73 @@ func (h *Handler) CreateCluster() iris.Handler {
 		}
 		_ = tx.Commit()
 		ctx.Values().Set("data", &req)
-		go func() {
-			req.Status.Phase = clusterStatusInitializing
-			if e := h.clusterService.Update(req.Name, &req.Cluster, common.DBOptions{}); e != nil {
-				server.Logger().Errorf("can not update cluster status %s", err)
-				return
-			}
-			if err := client.CreateDefaultClusterRoles(); err != nil {
-				req.Status.Phase = clusterStatusFailed
-				req.Status.Message = err.Error()
-				if e := h.clusterService.Update(req.Name, &req.Cluster, common.DBOptions{}); e != nil {
-					server.Logger().Errorf("can not update cluster status %s", err)
-					return
-				}
-				server.Logger().Errorf("can not init  built in clusterroles %s", err)
-				return
-			}
-			if !profile.IsAdministrator {
-				binding := v1Cluster.Binding{
-					BaseModel: v1.BaseModel{
-						Kind: "ClusterBinding",
-					},
-					Metadata: v1.Metadata{
-						Name: fmt.Sprintf("%s-%s-cluster-binding", req.Name, profile.Name),
-					},
-					UserRef:    profile.Name,
-					ClusterRef: req.Name,
-				}
-				if err := h.clusterBindingService.CreateClusterBinding(&binding, common.DBOptions{}); err != nil {
-					ctx.StatusCode(iris.StatusInternalServerError)
-					ctx.Values().Set("message", err.Error())
-					return
-				}
-				if err := client.CreateOrUpdateClusterRoleBinding("cluster-owner", profile.Name, true); err != nil {
-					_ = tx.Rollback()
-					ctx.StatusCode(iris.StatusInternalServerError)
-					ctx.Values().Set("message", err.Error())
-					return
-				}
-				if err := h.updateUserCert(client, &binding); err != nil {
-					req.Status.Phase = clusterStatusFailed
-					req.Status.Message = err.Error()
-					if e := h.clusterService.Update(req.Name, &req.Cluster, common.DBOptions{}); e != nil {
-						server.Logger().Errorf("can not update cluster status %s", err)
-						return
-					}
-					server.Logger().Errorf("can not create cluster user  %s", err)
-					return
-				}
-				req.Status.Phase = clusterStatusCompleted
-				if e := h.clusterService.Update(req.Name, &req.Cluster, common.DBOptions{}); e != nil {
-					server.Logger().Errorf("can not update cluster status %s", err)
-					return
-				}
-			} else {
-				req.Status.Phase = clusterStatusCompleted
-				if e := h.clusterService.Update(req.Name, &req.Cluster, common.DBOptions{}); e != nil {
-					server.Logger().Errorf("can not update cluster status %s", err)
-					return
-				}
-			}
-			err = client.CreateAppMarketCRD()
-			if err != nil {
-				server.Logger().Errorf("create app-market crd failed %s", err)
-			}
-		}()
+		go h.initCluster(&req.Cluster, client, profile.Name, profile.IsAdministrator)
+	}
+}
+
+// requiredPermissions 导入集群时要求集群凭据具备的权限
+var requiredPermissions = map[string][]string{
+	"namespaces":       {"get", "post", "delete"},
+	"clusterroles":     {"get", "post", "delete"},
+	"clusterrolebings": {"get", "post", "delete"},
+	"roles":            {"get", "post", "delete"},
+	"rolebindings":     {"get", "post", "delete"},
+}
+
+// initCluster 创建内置集群角色,非管理员导入时将导入者设置为集群管理员
+func (h *Handler) initCluster(c *v1Cluster.Cluster, client kubernetes.Interface, creator string, creatorIsAdmin bool) {
+	c.Status.Phase = clusterStatusInitializing
+	if err := h.clusterService.Update(c.Name, c, common.DBOptions{}); err != nil {
+		server.Logger().Errorf("can not update cluster status %s", err)
+		return
+	}
+	fail := func(err error) {
+		c.Status.Phase = clusterStatusFailed
+		c.Status.Message = err.Error()
+		if e := h.clusterService.Update(c.Name, c, common.DBOptions{}); e != nil {
+			server.Logger().Errorf("can not update cluster status %s", e)
+		}
+	}
+	if err := client.CreateDefaultClusterRoles(); err != nil {
+		fail(err)
+		server.Logger().Errorf("can not init  built in clusterroles %s", err)
+		return
+	}
+	if !creatorIsAdmin {
+		binding := v1Cluster.Binding{
+			BaseModel: v1.BaseModel{
+				Kind: "ClusterBinding",
+			},
+			Metadata: v1.Metadata{
+				Name: fmt.Sprintf("%s-%s-cluster-binding", c.Name, creator),
+			},
+			UserRef:    creator,
+			ClusterRef: c.Name,
+		}
+		if err := h.clusterBindingService.CreateClusterBinding(&binding, common.DBOptions{}); err != nil {
+			fail(err)
+			server.Logger().Errorf("can not create cluster binding %s", err)
+			return
+		}
+		if err := client.CreateOrUpdateClusterRoleBinding("cluster-owner", creator, true); err != nil {
+			fail(err)
+			server.Logger().Errorf("can not create cluster owner %s", err)
+			return
+		}
+		if err := h.updateUserCert(client, &binding); err != nil {
+			fail(err)
+			server.Logger().Errorf("can not create cluster user  %s", err)
+			return
+		}
+	}
+	c.Status.Phase = clusterStatusCompleted
+	c.Status.Message = ""
+	if err := h.clusterService.Update(c.Name, c, common.DBOptions{}); err != nil {
+		server.Logger().Errorf("can not update cluster status %s", err)
+		return
+	}
+	if err := client.CreateAppMarketCRD(); err != nil {
+		server.Logger().Errorf("create app-market crd failed %s", err)
 	}
 }
 
@@ -285,6 +299,7 @@ func (h *Handler) SearchClusters() iris.Handler {
 		}
 		u := ctx.Values().Get("profile")
 		profile := u.(session.UserProfile)
+		conditions.Conditions = commons.LimitResourceNames(ctx, conditions.Conditions)
 		clusters, total, err := h.clusterService.Search(pageNum, pageSize, conditions.Conditions, common.DBOptions{})
 		if err != nil && err != storm.ErrNotFound {
 			ctx.StatusCode(iris.StatusInternalServerError)
@@ -304,8 +319,10 @@ func (h *Handler) SearchClusters() iris.Handler {
 					ctx.Values().Set("message", err.Error())
 					return
 				}
-				c.MemberCount = len(bs)
 				for j := range bs {
+					if bs[j].IsUser() || bs[j].IsGroup() {
+						c.MemberCount++
+					}
 					if bs[j].UserRef == profile.Name {
 						c.Accessable = true
 					}
@@ -314,48 +331,31 @@ func (h *Handler) SearchClusters() iris.Handler {
 			result = append(result, c)
 		}
 		if showExtra {
-			ctx1, cancel := goContext.WithTimeout(goContext.Background(), 2*time.Second)
-			defer cancel()
-
-			wg := sync.WaitGroup{}
-			go func(result []Cluster) {
-				for i := range result {
-					wg.Add(1)
-					c := kubernetes.NewKubernetes(&result[i].Cluster)
-					go func(i int, ctx1 goContext.Context) {
-						defer wg.Done()
-						info, _ := getExtraClusterInfo(ctx1, c)
-						result[i].ExtraClusterInfo = info
-					}(i, ctx1)
-				}
-				wg.Wait()
-				cancel()
-			}(result)
-
-			<-ctx1.Done()
+			for i := range result {
+				result[i].ExtraClusterInfo = h.cachedHealth(result[i].Name)
+			}
 		}
 		ctx.Values().Set("data", pkgV1.Page{Items: result, Total: total})
 	}
 }
 func getExtraClusterInfo(context goContext.Context, client kubernetes.Interface) (ExtraClusterInfo, error) {
-	err := client.Ping()
+	c, err := client.Client()
 	if err != nil {
 		return ExtraClusterInfo{Health: false, Message: err.Error()}, err
 	}
-	c, err := client.Client()
-	if err != nil {
+	start := time.Now()
+	if _, err := c.CoreV1().Namespaces().List(context, metav1.ListOptions{Limit: 1}); err != nil {
 		return ExtraClusterInfo{Health: false, Message: err.Error()}, err
 	}
+	latency := time.Since(start).Milliseconds()
 	nodesList, err := c.CoreV1().Nodes().List(context, metav1.ListOptions{})
 	if err != nil {
-		return ExtraClusterInfo{Health: true, Message: err.Error()}, err
+		return ExtraClusterInfo{Health: true, Latency: latency, Message: err.Error()}, err
 	}
 	nodes := nodesList.Items
 
 	totalCpu := float64(0)
 	totalMemory := float64(0)
-	usedCpu := float64(0)
-	usedMemory := float64(0)
 	readyNodes := 0
 	for i := range nodes {
 		conditions := nodes[i].Status.Conditions
@@ -371,22 +371,13 @@ func getExtraClusterInfo(context goContext.Context, client kubernetes.Interface)
 		memory := nodes[i].Status.Allocatable.Memory().AsApproximateFloat64()
 		totalMemory += memory
 	}
-	podsList, err := c.CoreV1().Pods("").List(goContext.TODO(), metav1.ListOptions{})
+	usedCpu, usedMemory, err := podRequests(context, c)
 	if err != nil {
-		return ExtraClusterInfo{Health: true, Message: err.Error()}, err
-	}
-	pods := podsList.Items
-	for i := range pods {
-		for j := range pods[i].Spec.Containers {
-			cpu := pods[i].Spec.Containers[j].Resources.Requests.Cpu().AsApproximateFloat64()
-			usedCpu += cpu
-			memory := pods[i].Spec.Containers[j].Resources.Requests.Memory().AsApproximateFloat64()
-			usedMemory += memory
-
-		}
+		return ExtraClusterInfo{Health: true, Latency: latency, Message: err.Error()}, err
 	}
 	result := ExtraClusterInfo{
 		Health:            true,
+		Latency:           latency,
 		TotalNodeNum:      len(nodes),
 		ReadyNodeNum:      readyNodes,
 		CPUAllocatable:    totalCpu,
@@ -398,6 +389,35 @@ func getExtraClusterInfo(context goContext.Context, client kubernetes.Interface)
 
 }
 
+// podPageSize 统计资源请求时每页列出的 pod 数量
+const podPageSize = 500
+
+// podRequests 分页统计未结束的 pod 的资源请求,避免一次列出集群的全部 pod
+func podRequests(context goContext.Context, c clientset.Interface) (float64, float64, error) {
+	cpu, memory := float64(0), float64(0)
+	opts := metav1.ListOptions{
+		Limit:         podPageSize,
+		FieldSelector: "status.phase!=Succeeded,status.phase!=Failed",
+	}
+	for {
+		podsList, err := c.CoreV1().Pods("").List(context, opts)
+		if err != nil {
+			return 0, 0, err
+		}
+		pods := podsList.Items
+		for i := range pods {
+			for j := range pods[i].Spec.Containers {
+				cpu += pods[i].Spec.Containers[j].Resources.Requests.Cpu().AsApproximateFloat64()
+				memory += pods[i].Spec.Containers[j].Resources.Requests.Memory().AsApproximateFloat64()
+			}
+		}
+		if podsList.Continue == "" {
+			return cpu, memory, nil
+		}
+		opts.Continue = podsList.Continue
+	}
+}
+
 // Get Cluster
 // @Tags clusters
 // @Summary Get cluster by name
@@ -454,12 +474,25 @@ func (h *Handler) UpdateCluster() iris.Handler {
 				req.Labels = []string{}
 			}
 		} else {
+			if c.Spec.Connect.Direction == v1Cluster.DirectionReverse {
+				ctx.StatusCode(iris.StatusBadRequest)
+				ctx.Values().Set("message", "can not update connection of reverse cluster")
+				return
+			}
 			c.Spec.Authentication.ConfigFileContent = []byte(req.ConfigFileContent)
 			c.Spec.Authentication.Certificate.CertData = []byte(req.CertData)
 			c.Spec.Authentication.Certificate.KeyData = []byte(req.KeyData)
 			c.Spec.Connect.Forward.ApiServer = req.ApiServer
 			c.Spec.Authentication.Mode = req.Mode
 			c.Spec.Authentication.BearerToken = req.Token
+			if req.Proxy != nil {
+				if _, err := kubernetes.ProxyURL(*req.Proxy); err != nil {
+					ctx.StatusCode(iris.StatusBadRequest)
+					ctx.Values().Set("message", err.Error())
+					return
+				}
+				c.Spec.Connect.Forward.Proxy = *req.Proxy
+			}
 
 			client := kubernetes.NewKubernetes(c)
 			if err := client.Ping(); err != nil {
@@ -506,10 +539,15 @@ func (h *Handler) ListClusters() iris.Handler {
 			ctx.Values().Set("message", err.Error())
 			return
 		}
+		showExtra := ctx.URLParamExists("showExtra")
 		resultClusters := make([]Cluster, 0)
 		u := ctx.Values().Get("profile")
 		profile := u.(session.UserProfile)
+		names, limited := commons.AllowedResourceNames(ctx)
 		for i := range clusters {
+			if limited && collectons.IndexOfStringSlice(names, clusters[i].Name) == -1 {
+				continue
+			}
 			mbs, err := h.clusterBindingService.GetClusterBindingByClusterName(clusters[i].Name, common.DBOptions{})
 			if err != nil && !errors.Is(err, storm.ErrNotFound) {
 				ctx.StatusCode(iris.StatusInternalServerError)
@@ -524,6 +562,9 @@ func (h *Handler) ListClusters() iris.Handler {
 					rc.Accessable = true
 				}
 			}
+			if showExtra {
+				rc.ExtraClusterInfo = h.cachedHealth(rc.Name)
+			}
 			resultClusters = append(resultClusters, rc)
 		}
 		ctx.StatusCode(iris.StatusOK)
@@ -594,22 +635,43 @@ func (h *Handler) DeleteCluster() iris.Handler {
 				return
 			}
 		}
+		if err := h.clusterHealthService.DeleteByCluster(name, txOptions); err != nil {
+			_ = tx.Rollback()
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", fmt.Sprintf("delete cluster failed: %s", err.Error()))
+			return
+		}
+		if err := h.clusterBindingService.DeleteRevokedCertificates(name, txOptions); err != nil {
+			_ = tx.Rollback()
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", fmt.Sprintf("delete cluster failed: %s", err.Error()))
+			return
+		}
 		k := kubernetes.NewKubernetes(c)
 		_ = k.CleanAllRBACResource()
 		_ = tx.Commit()
+		tunnel.DefaultServer.Close(c.UUID)
+		forgetHealth(name)
 		ctx.StatusCode(iris.StatusOK)
 	}
 }
 
-func Install(parent iris.Party) {
+func Install(parent iris.Party, noAuthParty iris.Party) {
 	handler := NewHandler()
+	handler.startMemberReaper()
+	handler.startCertificateChecker()
+	handler.startHealthChecker()
+	tp := noAuthParty.Party("/tunnel")
+	tp.Post("/register", handler.RegisterAgent())
+	tp.Get("/ws/connect", handler.ConnectAgent())
+	tp.Get("/ws/data", handler.AgentData())
 	sp := parent.Party("/clusters")
 	sp.Post("", handler.CreateCluster())
-	sp.Get("", handler.ListClusters())
+	commons.FilterResourceNames(sp.Get("", handler.ListClusters()))
 	sp.Get("/:name", handler.GetCluster())
 	sp.Put("/:name", handler.UpdateCluster())
 	sp.Delete("/:name", handler.DeleteCluster())
-	sp.Post("/search", handler.SearchClusters())
+	commons.FilterResourceNames(sp.Post("/search", handler.SearchClusters()))
 	sp.Get("/:name/members", handler.ListClusterMembers())
 	sp.Post("/:name/members", handler.CreateClusterMember())
 	sp.Delete("/:name/members/:member", handler.DeleteClusterMember())
@@ -627,5 +689,11 @@ func Install(parent iris.Party) {
 	sp.Get("/:name/repos", handler.ListClusterRepos())
 	sp.Get("/:name/repos/detail", handler.ListClusterReposDetail())
 	sp.Post("/:name/repos", handler.AddCLusterRepo())
+	sp.Post("/:name/jointoken", handler.CreateJoinToken())
+	sp.Get("/:name/health", handler.GetClusterHealth())
+	sp.Get("/:name/certificates", handler.ListCertificates())
+	sp.Get("/:name/certificates/revoked", handler.ListRevokedCertificates())
+	sp.Post("/:name/privatekey", handler.RotatePrivateKey())
+	sp.Post("/:name/credentials", handler.RotateCredentials())
 	sp.Delete("/:name/repos/:repo", handler.DeleteClusterRepo())
 }
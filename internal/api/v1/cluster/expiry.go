@@ -0,0 +1,72 @@
+package cluster
+
+import (
+	"fmt"
+	"time"
+
+	v1Cluster "github.com/KubeOperator/kubepi/internal/model/v1/cluster"
+	v1System "github.com/KubeOperator/kubepi/internal/model/v1/system"
+	"github.com/KubeOperator/kubepi/internal/server"
+	"github.com/KubeOperator/kubepi/internal/service/v1/common"
+	v1SystemService "github.com/KubeOperator/kubepi/internal/service/v1/system"
+	"github.com/robfig/cron/v3"
+)
+
+const (
+	// memberReapSchedule 检查过期集群成员的周期
+	memberReapSchedule   = "@every 1m"
+	memberExpireOperator = "system"
+)
+
+// startMemberReaper 定时移除已过期的集群成员
+func (h *Handler) startMemberReaper() {
+	c := cron.New()
+	if _, err := c.AddFunc(memberReapSchedule, h.reapExpiredMembers); err != nil {
+		server.Logger().Errorf("can not start cluster member reaper: %s", err)
+		return
+	}
+	c.Start()
+}
+
+func (h *Handler) reapExpiredMembers() {
+	bindings, err := h.clusterBindingService.ListExpired(time.Now(), common.DBOptions{})
+	if err != nil {
+		server.Logger().Errorf("can not list expired cluster members: %s", err)
+		return
+	}
+	for i := range bindings {
+		if err := h.expireMember(&bindings[i]); err != nil {
+			server.Logger().Errorf("can not remove expired cluster member %s: %s", bindings[i].Name, err)
+		}
+	}
+}
+
+// expireMember 由用户组展开的绑定随用户组绑定一起删除,不单独处理
+func (h *Handler) expireMember(binding *v1Cluster.Binding) error {
+	var member string
+	switch {
+	case binding.IsGroup():
+		member = binding.GroupRef
+		if err := h.groupService.DeleteClusterMember(binding.ClusterRef, binding.GroupRef); err != nil {
+			return err
+		}
+	case binding.IsUser():
+		member = binding.UserRef
+		c, err := h.clusterService.Get(binding.ClusterRef, common.DBOptions{})
+		if err != nil {
+			return err
+		}
+		if err := h.deleteUserMember(c, binding); err != nil {
+			return err
+		}
+	default:
+		return nil
+	}
+	v1SystemService.NewService().CreateOperationLog(&v1System.OperationLog{
+		Operator:            memberExpireOperator,
+		Operation:           "expire",
+		OperationDomain:     "clusters_members",
+		SpecificInformation: fmt.Sprintf("[%s] %s", binding.ClusterRef, member),
+	}, common.DBOptions{})
+	return nil
+}
@@ -0,0 +1,207 @@
+package cluster
+
+import (
+	goContext "context"
+	"errors"
+	"sync"
+	"time"
+
+	v1 "github.com/KubeOperator/kubepi/internal/model/v1"
+	v1Cluster "github.com/KubeOperator/kubepi/internal/model/v1/cluster"
+	"github.com/KubeOperator/kubepi/internal/server"
+	"github.com/KubeOperator/kubepi/internal/service/v1/common"
+	"github.com/KubeOperator/kubepi/pkg/kubernetes"
+	"github.com/asdine/storm/v3"
+	"github.com/kataras/iris/v12"
+	"github.com/kataras/iris/v12/context"
+	"github.com/robfig/cron/v3"
+)
+
+const (
+	// healthCheckSchedule 后台健康检查的周期
+	healthCheckSchedule = "@every 1m"
+	healthCheckTimeout  = 30 * time.Second
+	// healthHistoryRetention 健康检查历史的保留时间
+	healthHistoryRetention = 24 * time.Hour
+)
+
+// healthCache 每个集群最近一次的健康检查结果,列表接口直接读取
+var healthCache = struct {
+	sync.RWMutex
+	items map[string]ExtraClusterInfo
+}{items: map[string]ExtraClusterInfo{}}
+
+// Get Cluster Health
+// @Tags clusters
+// @Summary Get health status and history of cluster
+// @Description Get health status and history of cluster
+// @Accept  json
+// @Produce  json
+// @Param cluster path string true "集群名称"
+// @Param hours query int false "历史记录的小时数,默认 24"
+// @Success 200 {object} HealthHistory
+// @Security ApiKeyAuth
+// @Router /clusters/{cluster}/health [get]
+func (h *Handler) GetClusterHealth() iris.Handler {
+	return func(ctx *context.Context) {
+		name := ctx.Params().GetString("name")
+		if _, err := h.clusterService.Get(name, common.DBOptions{}); err != nil {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		period := healthHistoryRetention
+		if hours := ctx.URLParamIntDefault("hours", 0); hours > 0 && time.Duration(hours)*time.Hour < period {
+			period = time.Duration(hours) * time.Hour
+		}
+		records, err := h.clusterHealthService.List(name, time.Now().Add(-period), common.DBOptions{})
+		if err != nil {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		ctx.Values().Set("data", HealthHistory{
+			Current: h.cachedHealth(name),
+			History: records,
+		})
+	}
+}
+
+// startHealthChecker 定时检查所有集群,启动时立即执行一次,上一次检查未结束时跳过
+func (h *Handler) startHealthChecker() {
+	c := cron.New(cron.WithChain(cron.SkipIfStillRunning(cron.DefaultLogger)))
+	if _, err := c.AddFunc(healthCheckSchedule, h.checkHealth); err != nil {
+		server.Logger().Errorf("can not start cluster health checker: %s", err)
+		return
+	}
+	c.Start()
+	go h.checkHealth()
+}
+
+func (h *Handler) checkHealth() {
+	clusters, err := h.clusterService.List(common.DBOptions{})
+	if err != nil {
+		server.Logger().Errorf("can not list clusters: %s", err)
+		return
+	}
+	now := time.Now()
+	wg := sync.WaitGroup{}
+	for i := range clusters {
+		wg.Add(1)
+		go func(c *v1Cluster.Cluster) {
+			defer wg.Done()
+			h.checkClusterHealth(c, now)
+		}(&clusters[i])
+	}
+	wg.Wait()
+	if err := h.clusterHealthService.DeleteBefore(now.Add(-healthHistoryRetention), common.DBOptions{}); err != nil {
+		server.Logger().Errorf("can not clean cluster health history: %s", err)
+	}
+}
+
+func (h *Handler) checkClusterHealth(c *v1Cluster.Cluster, now time.Time) {
+	ctx, cancel := goContext.WithTimeout(goContext.Background(), healthCheckTimeout)
+	defer cancel()
+	info, _ := getExtraClusterInfo(ctx, kubernetes.NewKubernetes(c))
+	info.CheckAt = now
+	if err := h.saveHealth(c.Name, info); err != nil {
+		server.Logger().Errorf("can not save health of cluster %s: %s", c.Name, err)
+	}
+}
+
+// saveHealth 在同一事务中确认集群仍然存在后保存检查结果,
+// 删除集群的事务会等待该事务结束,不会留下已删除集群的记录和缓存
+func (h *Handler) saveHealth(name string, info ExtraClusterInfo) error {
+	tx, err := server.DB().Begin(true)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+	txOptions := common.DBOptions{DB: tx}
+	c, err := h.clusterService.Get(name, txOptions)
+	if err != nil {
+		if errors.Is(err, storm.ErrNotFound) {
+			return nil
+		}
+		return err
+	}
+	record := v1Cluster.HealthRecord{
+		BaseModel: v1.BaseModel{
+			Kind:     "HealthRecord",
+			CreateAt: info.CheckAt,
+		},
+		ClusterRef:        name,
+		Health:            info.Health,
+		Message:           info.Message,
+		Latency:           info.Latency,
+		TotalNodeNum:      info.TotalNodeNum,
+		ReadyNodeNum:      info.ReadyNodeNum,
+		CPUAllocatable:    info.CPUAllocatable,
+		CPURequested:      info.CPURequested,
+		MemoryAllocatable: info.MemoryAllocatable,
+		MemoryRequested:   info.MemoryRequested,
+	}
+	if err := h.clusterHealthService.Create(&record, txOptions); err != nil {
+		return err
+	}
+	if err := h.updateClusterPhase(c, info, txOptions); err != nil {
+		return err
+	}
+	healthCache.Lock()
+	healthCache.items[name] = info
+	healthCache.Unlock()
+	return tx.Commit()
+}
+
+// updateClusterPhase 只在 Completed 和 Unreachable 之间切换,其他状态由导入流程维护
+func (h *Handler) updateClusterPhase(c *v1Cluster.Cluster, info ExtraClusterInfo, options common.DBOptions) error {
+	status := c.Status
+	switch {
+	case !info.Health && status.Phase == clusterStatusCompleted:
+		status.Phase = clusterStatusUnreachable
+		status.Message = info.Message
+	case info.Health && status.Phase == clusterStatusUnreachable:
+		status.Phase = clusterStatusCompleted
+		status.Message = ""
+	default:
+		return nil
+	}
+	return h.clusterService.UpdateStatus(c.Name, status, options)
+}
+
+// cachedHealth 重启后缓存为空时使用最近一次保存的检查结果
+func (h *Handler) cachedHealth(name string) ExtraClusterInfo {
+	healthCache.RLock()
+	info, ok := healthCache.items[name]
+	healthCache.RUnlock()
+	if ok {
+		return info
+	}
+	record, err := h.clusterHealthService.Latest(name, common.DBOptions{})
+	if err != nil {
+		if !errors.Is(err, storm.ErrNotFound) {
+			server.Logger().Errorf("can not get health of cluster %s: %s", name, err)
+		}
+		return ExtraClusterInfo{Message: "health check has not run yet"}
+	}
+	return ExtraClusterInfo{
+		TotalNodeNum:      record.TotalNodeNum,
+		ReadyNodeNum:      record.ReadyNodeNum,
+		CPUAllocatable:    record.CPUAllocatable,
+		CPURequested:      record.CPURequested,
+		MemoryAllocatable: record.MemoryAllocatable,
+		MemoryRequested:   record.MemoryRequested,
+		Health:            record.Health,
+		Message:           record.Message,
+		Latency:           record.Latency,
+		CheckAt:           record.CreateAt,
+	}
+}
+
+func forgetHealth(name string) {
+	healthCache.Lock()
+	delete(healthCache.items, name)
+	healthCache.Unlock()
+}
@@ -5,11 +5,13 @@ import (
 	"errors"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/KubeOperator/kubepi/internal/api/v1/session"
 	v1 "github.com/KubeOperator/kubepi/internal/model/v1"
 	v1Cluster "github.com/KubeOperator/kubepi/internal/model/v1/cluster"
 	"github.com/KubeOperator/kubepi/internal/server"
+	"github.com/KubeOperator/kubepi/internal/service/v1/clusterbinding"
 	"github.com/KubeOperator/kubepi/internal/service/v1/common"
 	"github.com/KubeOperator/kubepi/pkg/collectons"
 	"github.com/KubeOperator/kubepi/pkg/kubernetes"
@@ -19,6 +21,60 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
+const (
+	memberKindUser  = "User"
+	memberKindGroup = "Group"
+)
+
+func groupMember(binding *v1Cluster.Binding) *Member {
+	member := Member{
+		Name:           binding.GroupRef,
+		Kind:           memberKindGroup,
+		BindingName:    binding.Name,
+		CreateAt:       binding.CreateAt,
+		ClusterRoles:   binding.ClusterRoles,
+		NamespaceRoles: binding.NamespaceRoles,
+		ExpireAt:       expireAt(binding),
+	}
+	if member.ClusterRoles == nil {
+		member.ClusterRoles = make([]string, 0)
+	}
+	if member.NamespaceRoles == nil {
+		member.NamespaceRoles = make([]NamespaceRoles, 0)
+	}
+	return &member
+}
+
+func expireAt(binding *v1Cluster.Binding) *time.Time {
+	if binding.ExpireAt.IsZero() {
+		return nil
+	}
+	t := binding.ExpireAt
+	return &t
+}
+
+// updatedExpireAt 更新成员时未提供过期时间则保留 current,ClearExpireAt 为 true 时改为永久有效
+func (m *Member) updatedExpireAt(current time.Time) (time.Time, error) {
+	if m.ClearExpireAt {
+		return time.Time{}, nil
+	}
+	if m.ExpireAt == nil {
+		return current, nil
+	}
+	return m.validateExpireAt()
+}
+
+// validateExpireAt 返回绑定的过期时间,为空时永久有效
+func (m *Member) validateExpireAt() (time.Time, error) {
+	if m.ExpireAt == nil || m.ExpireAt.IsZero() {
+		return time.Time{}, nil
+	}
+	if !m.ExpireAt.After(time.Now()) {
+		return time.Time{}, errors.New("expire time must be later than now")
+	}
+	return *m.ExpireAt, nil
+}
+
 // Update Cluster Member
 // @Tags clusters
 // @Summary Update Cluster Member
@@ -41,6 +97,27 @@ func (h *Handler) UpdateClusterMember() iris.Handler {
 			ctx.Values().Set("message", fmt.Sprintf("delete cluster failed: %s", err.Error()))
 			return
 		}
+		if req.Kind == memberKindGroup {
+			binding, err := h.clusterBindingService.GetBindingByClusterNameAndGroupName(name, req.Name, common.DBOptions{})
+			if err != nil {
+				ctx.StatusCode(iris.StatusInternalServerError)
+				ctx.Values().Set("message", fmt.Sprintf("get cluster binding failed: %s", err.Error()))
+				return
+			}
+			expire, err := req.updatedExpireAt(binding.ExpireAt)
+			if err != nil {
+				ctx.StatusCode(iris.StatusBadRequest)
+				ctx.Values().Set("message", err.Error())
+				return
+			}
+			if err := h.groupService.UpdateClusterMember(name, req.Name, req.ClusterRoles, req.NamespaceRoles, expire); err != nil {
+				ctx.StatusCode(iris.StatusInternalServerError)
+				ctx.Values().Set("message", err.Error())
+				return
+			}
+			ctx.Values().Set("data", &req)
+			return
+		}
 		c, err := h.clusterService.Get(name, common.DBOptions{})
 		if err != nil {
 			ctx.StatusCode(iris.StatusInternalServerError)
@@ -52,6 +129,18 @@ func (h *Handler) UpdateClusterMember() iris.Handler {
 			ctx.Values().Set("message", fmt.Sprintf("can not delete or update cluster importer %s", req.Name))
 			return
 		}
+		binding, err := h.clusterBindingService.GetBindingByClusterNameAndUserName(name, req.Name, common.DBOptions{})
+		if err != nil {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", fmt.Sprintf("get cluster binding failed: %s", err.Error()))
+			return
+		}
+		expire, err := req.updatedExpireAt(binding.ExpireAt)
+		if err != nil {
+			ctx.StatusCode(iris.StatusBadRequest)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
 		k := kubernetes.NewKubernetes(c)
 		if err := k.CleanManagedClusterRoleBinding(req.Name); err != nil {
 			ctx.StatusCode(iris.StatusInternalServerError)
@@ -80,6 +169,11 @@ func (h *Handler) UpdateClusterMember() iris.Handler {
 				return
 			}
 		}
+		if err := h.clusterBindingService.UpdateExpireAt(binding.Name, expire, common.DBOptions{}); err != nil {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
 		ctx.Values().Set("data", &req)
 	}
 }
@@ -99,6 +193,16 @@ func (h *Handler) GetClusterMember() iris.Handler {
 	return func(ctx *context.Context) {
 		name := ctx.Params().GetString("name")
 		memberName := ctx.Params().Get("member")
+		if ctx.URLParam("kind") == memberKindGroup {
+			binding, err := h.clusterBindingService.GetBindingByClusterNameAndGroupName(name, memberName, common.DBOptions{})
+			if err != nil {
+				ctx.StatusCode(iris.StatusInternalServerError)
+				ctx.Values().Set("message", fmt.Sprintf("get cluster binding failed: %s", err.Error()))
+				return
+			}
+			ctx.Values().Set("data", groupMember(binding))
+			return
+		}
 
 		c, err := h.clusterService.Get(name, common.DBOptions{})
 		if err != nil {
@@ -124,6 +228,7 @@ func (h *Handler) GetClusterMember() iris.Handler {
 			fmt.Sprintf("%s=%s", kubernetes.LabelManageKey, "kubepi"),
 			fmt.Sprintf("%s=%s", kubernetes.LabelClusterId, c.UUID),
 			fmt.Sprintf("%s=%s", kubernetes.LabelUsername, binding.UserRef),
+			fmt.Sprintf("!%s", kubernetes.LabelGroupname),
 		}
 		clusterRoleBindings, err := client.RbacV1().ClusterRoleBindings().List(goContext.TODO(), metav1.ListOptions{
 			LabelSelector: strings.Join(labels, ","),
@@ -146,6 +251,8 @@ func (h *Handler) GetClusterMember() iris.Handler {
 		member.ClusterRoles = make([]string, 0)
 		member.NamespaceRoles = make([]NamespaceRoles, 0)
 		member.Name = binding.UserRef
+		member.Kind = memberKindUser
+		member.ExpireAt = expireAt(binding)
 		set := collectons.NewStringSet()
 		for i := range clusterRoleBindings.Items {
 			set.Add(clusterRoleBindings.Items[i].RoleRef.Name)
@@ -193,10 +300,20 @@ func (h *Handler) ListClusterMembers() iris.Handler {
 		}
 		members := make([]Member, 0)
 		for i := range bindings {
+			if bindings[i].IsGroup() {
+				members = append(members, *groupMember(&bindings[i]))
+				continue
+			}
+			// 通过用户组获得的访问凭证不作为成员显示
+			if !bindings[i].IsUser() {
+				continue
+			}
 			members = append(members, Member{
 				Name:        bindings[i].UserRef,
+				Kind:        memberKindUser,
 				BindingName: bindings[i].Name,
 				CreateAt:    bindings[i].CreateAt,
+				ExpireAt:    expireAt(&bindings[i]),
 			})
 		}
 		ctx.Values().Set("data", members)
@@ -234,66 +351,89 @@ func (h *Handler) CreateClusterMember() iris.Handler {
 			ctx.Values().Set("message", "must select one role")
 			return
 		}
+		expire, err := req.validateExpireAt()
+		if err != nil {
+			ctx.StatusCode(iris.StatusBadRequest)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
 		u := ctx.Values().Get("profile")
 		profile := u.(session.UserProfile)
+		if err := h.CreateMember(name, &req, expire, profile.Name); err != nil {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		ctx.Values().Set("data", req)
+	}
+}
+
+// CreateMember 为用户或用户组创建集群绑定及 rbac 绑定,审批通过的访问申请也使用该方法
+func (h *Handler) CreateMember(clusterName string, req *Member, expire time.Time, createdBy string) error {
+	if req.Kind == memberKindGroup {
 		binding := v1Cluster.Binding{
 			BaseModel: v1.BaseModel{
-				Kind:      "ClusterBinding",
-				CreatedBy: profile.Name,
+				CreatedBy: createdBy,
 			},
-			Metadata: v1.Metadata{
-				Name: fmt.Sprintf("%s-%s-cluster-binding", name, req.Name),
-			},
-			UserRef:    req.Name,
-			ClusterRef: name,
+			GroupRef:       req.Name,
+			ClusterRef:     clusterName,
+			ClusterRoles:   req.ClusterRoles,
+			NamespaceRoles: req.NamespaceRoles,
+			ExpireAt:       expire,
 		}
+		return h.groupService.CreateClusterMember(&binding)
+	}
+	binding := v1Cluster.Binding{
+		BaseModel: v1.BaseModel{
+			Kind:      "ClusterBinding",
+			CreatedBy: createdBy,
+		},
+		Metadata: v1.Metadata{
+			Name: fmt.Sprintf("%s-%s-cluster-binding", clusterName, req.Name),
+		},
+		UserRef:    req.Name,
+		ClusterRef: clusterName,
+		ExpireAt:   expire,
+	}
 
-		tx, _ := server.DB().Begin(true)
-		c, err := h.clusterService.Get(name, common.DBOptions{DB: tx})
-		if err != nil {
-			ctx.StatusCode(iris.StatusInternalServerError)
-			ctx.Values().Set("message", fmt.Sprintf("get cluster failed: %s", err.Error()))
-			return
-		}
+	tx, err := server.DB().Begin(true)
+	if err != nil {
+		return err
+	}
+	c, err := h.clusterService.Get(clusterName, common.DBOptions{DB: tx})
+	if err != nil {
+		_ = tx.Rollback()
+		return fmt.Errorf("get cluster failed: %s", err.Error())
+	}
 
-		k := kubernetes.NewKubernetes(c)
-		cert, err := k.CreateCommonUser(req.Name)
-		if err != nil {
-			_ = tx.Rollback()
-			ctx.StatusCode(iris.StatusInternalServerError)
-			ctx.Values().Set("message", fmt.Sprintf("create common user failed: %s", err.Error()))
-			return
-		}
-		binding.Certificate = cert
-		if err := h.clusterBindingService.CreateClusterBinding(&binding, common.DBOptions{DB: tx}); err != nil {
+	k := kubernetes.NewKubernetes(c)
+	cert, err := k.CreateCommonUser(req.Name)
+	if err != nil {
+		_ = tx.Rollback()
+		return fmt.Errorf("create common user failed: %s", err.Error())
+	}
+	binding.Certificate = cert
+	if err := h.clusterBindingService.CreateClusterBinding(&binding, common.DBOptions{DB: tx}); err != nil {
+		_ = tx.Rollback()
+		return errors.New("unable to complete authorization")
+	}
+	// 创建clusterrolebinding
+	for i := range req.ClusterRoles {
+		if err := k.CreateOrUpdateClusterRoleBinding(req.ClusterRoles[i], req.Name, false); err != nil {
 			_ = tx.Rollback()
-			ctx.StatusCode(iris.StatusInternalServerError)
-			ctx.Values().Set("message", "unable to complete authorization")
-			return
+			return errors.New("unable to complete authorization")
 		}
-		// 创建clusterrolebinding
-		for i := range req.ClusterRoles {
-			if err := k.CreateOrUpdateClusterRoleBinding(req.ClusterRoles[i], req.Name, false); err != nil {
+	}
+	// 创建Rolebinding
+	for i := range req.NamespaceRoles {
+		for j := range req.NamespaceRoles[i].Roles {
+			if err := k.CreateOrUpdateRolebinding(req.NamespaceRoles[i].Namespace, req.NamespaceRoles[i].Roles[j], req.Name, false); err != nil {
 				_ = tx.Rollback()
-				ctx.StatusCode(iris.StatusInternalServerError)
-				ctx.Values().Set("message", "unable to complete authorization")
-				return
+				return err
 			}
 		}
-		// 创建Rolebinding
-		for i := range req.NamespaceRoles {
-			for j := range req.NamespaceRoles[i].Roles {
-				if err := k.CreateOrUpdateRolebinding(req.NamespaceRoles[i].Namespace, req.NamespaceRoles[i].Roles[j], req.Name, false); err != nil {
-					_ = tx.Rollback()
-					ctx.StatusCode(iris.StatusInternalServerError)
-					ctx.Values().Set("message", err.Error())
-					return
-				}
-			}
-		}
-		_ = tx.Commit()
-		ctx.Values().Set("data", req)
 	}
+	return tx.Commit()
 }
 
 // Delete ClusterMember
@@ -313,6 +453,13 @@ func (h *Handler) DeleteClusterMember() iris.Handler {
 		memberName := ctx.Params().GetString("member")
 		u := ctx.Values().Get("profile")
 		profile := u.(session.UserProfile)
+		if ctx.URLParam("kind") == memberKindGroup {
+			if err := h.groupService.DeleteClusterMember(name, memberName); err != nil {
+				ctx.StatusCode(iris.StatusInternalServerError)
+				ctx.Values().Set("message", fmt.Sprintf("delete cluster binding failed: %s", err.Error()))
+			}
+			return
+		}
 		c, err := h.clusterService.Get(name, common.DBOptions{})
 		if err != nil {
 			ctx.StatusCode(iris.StatusInternalServerError)
@@ -326,31 +473,67 @@ func (h *Handler) DeleteClusterMember() iris.Handler {
 		}
 
 		binding, err := h.clusterBindingService.GetBindingByClusterNameAndUserName(c.Name, memberName, common.DBOptions{})
-		if err != nil {
-			ctx.StatusCode(iris.StatusInternalServerError)
-			ctx.StatusCode(iris.StatusInternalServerError)
-			ctx.Values().Set("message", fmt.Sprintf("get cluster failed: %s", err.Error()))
-			return
-		}
-		tx, err := server.DB().Begin(true)
 		if err != nil {
 			ctx.StatusCode(iris.StatusInternalServerError)
 			ctx.Values().Set("message", fmt.Sprintf("get cluster failed: %s", err.Error()))
 			return
 		}
-		if err := h.clusterBindingService.Delete(binding.Name, common.DBOptions{DB: tx}); err != nil {
-			_ = tx.Rollback()
+		if err := h.deleteUserMember(c, binding); err != nil {
 			ctx.StatusCode(iris.StatusInternalServerError)
 			ctx.Values().Set("message", fmt.Sprintf("delete cluster binding failed: %s", err.Error()))
 			return
 		}
-		k := kubernetes.NewKubernetes(c)
-		if err := k.CleanManagedClusterRoleBinding(memberName); err != nil {
-			server.Logger().Errorf("can not delete cluster member %s : %s", memberName, err)
+	}
+}
+
+// deleteUserMember 删除用户的集群绑定及 kubepi 在集群中为其创建的 rbac 绑定
+func (h *Handler) deleteUserMember(c *v1Cluster.Cluster, binding *v1Cluster.Binding) error {
+	tx, err := server.DB().Begin(true)
+	if err != nil {
+		return err
+	}
+	if err := h.clusterBindingService.Delete(binding.Name, common.DBOptions{DB: tx}); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	k := kubernetes.NewKubernetes(c)
+	// rbac 清理失败时保留成员,过期的成员由定时任务重试
+	_, err = h.clusterBindingService.GetCredentialBinding(c.Name, binding.UserRef, common.DBOptions{DB: tx})
+	switch {
+	case errors.Is(err, storm.ErrNotFound):
+		// 用户不再通过任何方式属于该集群时证书已被吊销,清理该用户名的全部绑定
+		err = k.CleanUserRoleBinding(binding.UserRef)
+	case err == nil:
+		if err = k.CleanManagedClusterRoleBinding(binding.UserRef); err == nil {
+			err = k.CleanManagedRoleBinding(binding.UserRef)
 		}
-		if err := k.CleanManagedRoleBinding(memberName); err != nil {
-			server.Logger().Errorf("can not delete cluster member %s : %s", memberName, err)
+	}
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// IsClusterAdmin 管理员、集群导入者以及拥有 cluster-owner 角色的成员可以管理集群成员
+func (h *Handler) IsClusterAdmin(c *v1Cluster.Cluster, profile session.UserProfile) (bool, error) {
+	if profile.IsAdministrator || c.CreatedBy == profile.Name {
+		return true, nil
+	}
+	if _, err := h.clusterBindingService.GetActiveCredentialBinding(c.Name, profile.Name, common.DBOptions{}); err != nil {
+		if errors.Is(err, storm.ErrNotFound) || errors.Is(err, clusterbinding.ErrMemberExpired) {
+			return false, nil
+		}
+		return false, err
+	}
+	bindings, err := kubernetes.NewKubernetes(c).ManagedRoleBindings(profile.Name)
+	if err != nil {
+		return false, err
+	}
+	for i := range bindings {
+		if bindings[i].Kind == "ClusterRoleBinding" && bindings[i].RoleRef == "cluster-owner" {
+			return true, nil
 		}
-		_ = tx.Commit()
 	}
+	return false, nil
 }
@@ -0,0 +1,269 @@
+package cluster
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/KubeOperator/kubepi/internal/api/v1/session"
+	v1Cluster "github.com/KubeOperator/kubepi/internal/model/v1/cluster"
+	"github.com/KubeOperator/kubepi/internal/server"
+	"github.com/KubeOperator/kubepi/internal/service/v1/common"
+	"github.com/KubeOperator/kubepi/internal/service/v1/user"
+	"github.com/KubeOperator/kubepi/pkg/certificate"
+	"github.com/KubeOperator/kubepi/pkg/kubernetes"
+	"github.com/KubeOperator/kubepi/pkg/tunnel"
+	"github.com/kataras/iris/v12"
+	"github.com/kataras/iris/v12/context"
+)
+
+// joinTokenTTL 加入令牌的有效期
+const joinTokenTTL = time.Hour
+
+// createReverseCluster 反向连接的集群只保存记录,等 agent 接入后再初始化
+func (h *Handler) createReverseCluster(ctx *context.Context, c *v1Cluster.Cluster) {
+	privateKey, err := certificate.GeneratePrivateKey()
+	if err != nil {
+		ctx.StatusCode(iris.StatusInternalServerError)
+		ctx.Values().Set("message", err.Error())
+		return
+	}
+	c.PrivateKey = privateKey
+	c.Spec.Connect.Forward = v1Cluster.Forward{}
+	c.Spec.Connect.Reverse = v1Cluster.Reverse{}
+	u := ctx.Values().Get("profile")
+	profile := u.(session.UserProfile)
+	c.CreatedBy = profile.Name
+	c.Status.Phase = clusterStatusWaiting
+	if err := h.clusterService.Create(c, common.DBOptions{}); err != nil {
+		ctx.StatusCode(iris.StatusInternalServerError)
+		ctx.Values().Set("message", err.Error())
+		return
+	}
+	ctx.Values().Set("data", c)
+}
+
+// Create Join Token
+// @Tags clusters
+// @Summary Create join token of reverse cluster
+// @Description Create join token of reverse cluster, the ca certificate of cluster is pinned at the same time
+// @Accept  json
+// @Produce  json
+// @Param cluster path string true "集群名称"
+// @Param request body CreateJoinToken true "request"
+// @Success 200 {object} JoinToken
+// @Security ApiKeyAuth
+// @Router /clusters/{cluster}/jointoken [post]
+func (h *Handler) CreateJoinToken() iris.Handler {
+	return func(ctx *context.Context) {
+		name := ctx.Params().GetString("name")
+		var req CreateJoinToken
+		if err := ctx.ReadJSON(&req); err != nil {
+			ctx.StatusCode(iris.StatusBadRequest)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		externalURL := strings.TrimSuffix(server.Config().Spec.Server.ExternalURL, "/")
+		if externalURL == "" {
+			ctx.StatusCode(iris.StatusBadRequest)
+			ctx.Values().Set("message", "external url of kubepi is not configured")
+			return
+		}
+		// 加入令牌创建时固定集群的 ca,之后只信任该 ca
+		if _, err := certificate.ParseX509Certificate([]byte(req.CaData)); err != nil {
+			ctx.StatusCode(iris.StatusBadRequest)
+			ctx.Values().Set("message", []string{"invalid ca certificate: %s", err.Error()})
+			return
+		}
+		c, err := h.clusterService.Get(name, common.DBOptions{})
+		if err != nil {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		if c.Spec.Connect.Direction != v1Cluster.DirectionReverse {
+			ctx.StatusCode(iris.StatusBadRequest)
+			ctx.Values().Set("message", "cluster is not a reverse cluster")
+			return
+		}
+		token, err := tunnel.GenerateToken()
+		if err != nil {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		c.Spec.Connect.Reverse.JoinTokenHash = tunnel.HashToken(token)
+		c.Spec.Connect.Reverse.JoinTokenExpireAt = time.Now().Add(joinTokenTTL)
+		c.CaCertificate.CertData = []byte(req.CaData)
+		if err := h.clusterService.Update(c.Name, c, common.DBOptions{}); err != nil {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		ctx.Values().Set("data", JoinToken{
+			Token:    token,
+			ExpireAt: c.Spec.Connect.Reverse.JoinTokenExpireAt,
+			Command:  fmt.Sprintf("kubepi-agent --server %s --cluster %s --join-token %s", externalURL, c.Name, token),
+		})
+	}
+}
+
+// RegisterAgent agent 使用加入令牌注册,换取后续连接使用的 agent 令牌
+func (h *Handler) RegisterAgent() iris.Handler {
+	return func(ctx *context.Context) {
+		var req tunnel.RegisterRequest
+		if err := ctx.ReadJSON(&req); err != nil {
+			ctx.StatusCode(iris.StatusBadRequest)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		c, err := h.clusterService.Get(req.Cluster, common.DBOptions{})
+		if err != nil || c.Spec.Connect.Direction != v1Cluster.DirectionReverse {
+			ctx.StatusCode(iris.StatusUnauthorized)
+			ctx.Values().Set("message", "join token is invalid or expired")
+			return
+		}
+		reverse := c.Spec.Connect.Reverse
+		if !tunnel.VerifyToken(req.JoinToken, reverse.JoinTokenHash) || time.Now().After(reverse.JoinTokenExpireAt) {
+			ctx.StatusCode(iris.StatusUnauthorized)
+			ctx.Values().Set("message", "join token is invalid or expired")
+			return
+		}
+		if req.Token == "" {
+			ctx.StatusCode(iris.StatusBadRequest)
+			ctx.Values().Set("message", "service account token is required")
+			return
+		}
+		// agent 上报的 ca 只用来发现接入了错误的集群,访问集群始终使用创建加入令牌时固定的 ca
+		if !sameCertificate(c.CaCertificate.CertData, []byte(req.CaData)) {
+			ctx.StatusCode(iris.StatusBadRequest)
+			ctx.Values().Set("message", "ca certificate of agent does not match the cluster")
+			return
+		}
+		agentToken, err := tunnel.GenerateToken()
+		if err != nil {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		// 加入令牌只能使用一次
+		c.Spec.Connect.Reverse = v1Cluster.Reverse{AgentTokenHash: tunnel.HashToken(agentToken)}
+		c.Spec.Connect.Forward.ApiServer = "https://" + tunnel.APIServerName
+		c.Spec.Authentication.Mode = "bearer"
+		c.Spec.Authentication.BearerToken = req.Token
+		if err := h.clusterService.Update(c.Name, c, common.DBOptions{}); err != nil {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		ctx.Values().Set("data", tunnel.RegisterResponse{AgentToken: agentToken})
+	}
+}
+
+// ConnectAgent agent 的控制连接,连接存续期间集群经由隧道访问
+func (h *Handler) ConnectAgent() iris.Handler {
+	return func(ctx *context.Context) {
+		c, ok := h.authAgent(ctx)
+		if !ok {
+			return
+		}
+		onReady := func() {
+			if c.Status.Phase != clusterStatusWaiting && c.Status.Phase != clusterStatusFailed {
+				return
+			}
+			h.initReverseCluster(c)
+		}
+		// service account token 轮换后 agent 会发送新的 token
+		onMessage := func(m tunnel.Message) {
+			if m.Type != tunnel.MessageCredentials || m.Token == "" {
+				return
+			}
+			cur, err := h.clusterService.Get(c.Name, common.DBOptions{})
+			if err != nil || cur.Spec.Authentication.BearerToken == m.Token {
+				return
+			}
+			cur.Spec.Authentication.BearerToken = m.Token
+			// 新 token 能通过集群认证并且具备所需权限时才保存
+			notAllowed, err := checkRequiredPermissions(kubernetes.NewKubernetes(cur), requiredPermissions)
+			if err != nil || notAllowed != "" {
+				server.Logger().Errorf("reject credentials of cluster %s: permission %s required, %v", cur.Name, notAllowed, err)
+				return
+			}
+			if err := h.clusterService.Update(cur.Name, cur, common.DBOptions{}); err != nil {
+				server.Logger().Errorf("can not update credentials of cluster %s: %s", cur.Name, err)
+			}
+		}
+		if err := tunnel.DefaultServer.ServeControl(c.UUID, ctx.ResponseWriter(), ctx.Request(), onReady, onMessage); err != nil {
+			server.Logger().Debugf("agent of cluster %s disconnected: %s", c.Name, err)
+		}
+	}
+}
+
+// AgentData agent 为每个经由隧道的连接建立的数据连接
+func (h *Handler) AgentData() iris.Handler {
+	return func(ctx *context.Context) {
+		c, ok := h.authAgent(ctx)
+		if !ok {
+			return
+		}
+		if err := tunnel.DefaultServer.ServeData(c.UUID, ctx.URLParam("id"), ctx.ResponseWriter(), ctx.Request()); err != nil {
+			server.Logger().Debugf("tunnel data connection of cluster %s failed: %s", c.Name, err)
+		}
+	}
+}
+
+func (h *Handler) authAgent(ctx *context.Context) (*v1Cluster.Cluster, bool) {
+	token := strings.TrimPrefix(ctx.GetHeader("Authorization"), "Bearer ")
+	c, err := h.clusterService.Get(ctx.URLParam("cluster"), common.DBOptions{})
+	if err != nil || c.Spec.Connect.Direction != v1Cluster.DirectionReverse || !tunnel.VerifyToken(token, c.Spec.Connect.Reverse.AgentTokenHash) {
+		ctx.StatusCode(iris.StatusUnauthorized)
+		ctx.Values().Set("message", tunnel.ErrUnauthorized.Error())
+		return nil, false
+	}
+	return c, true
+}
+
+// initReverseCluster agent 首次接入后检查权限并初始化集群
+func (h *Handler) initReverseCluster(c *v1Cluster.Cluster) {
+	client := kubernetes.NewKubernetes(c)
+	fail := func(err error) {
+		c.Status.Phase = clusterStatusFailed
+		c.Status.Message = err.Error()
+		if e := h.clusterService.Update(c.Name, c, common.DBOptions{}); e != nil {
+			server.Logger().Errorf("can not update cluster status %s", e)
+		}
+	}
+	v, err := client.Version()
+	if err != nil {
+		fail(err)
+		return
+	}
+	c.Status.Version = v.GitVersion
+	notAllowed, err := checkRequiredPermissions(client, requiredPermissions)
+	if err != nil {
+		fail(err)
+		return
+	}
+	if notAllowed != "" {
+		fail(fmt.Errorf("permission %s required", notAllowed))
+		return
+	}
+	creatorIsAdmin := false
+	if u, err := user.NewService().GetByNameOrEmail(c.CreatedBy, common.DBOptions{}); err == nil {
+		creatorIsAdmin = u.IsAdmin
+	}
+	h.initCluster(c, client, c.CreatedBy, creatorIsAdmin)
+}
+
+// sameCertificate 比较两份 pem 证书的第一个证书是否相同
+func sameCertificate(a, b []byte) bool {
+	ca, err := certificate.ParseX509Certificate(a)
+	if err != nil {
+		return false
+	}
+	cb, err := certificate.ParseX509Certificate(b)
+	if err != nil {
+		return false
+	}
+	return certificate.Fingerprint(ca) == certificate.Fingerprint(cb)
+}
@@ -12,6 +12,10 @@ const (
 	clusterStatusFailed       = "Failed"
 	clusterStatusCompleted    = "Completed"
 	clusterStatusSaved        = "Saved"
+	// clusterStatusWaiting 反向连接集群等待 agent 接入
+	clusterStatusWaiting = "Waiting"
+	// clusterStatusUnreachable 后台健康检查无法访问已完成初始化的集群
+	clusterStatusUnreachable = "Unreachable"
 )
 
 type Cluster struct {
@@ -25,6 +29,16 @@ type Cluster struct {
 	ExtraClusterInfo     ExtraClusterInfo `json:"extraClusterInfo"`
 }
 
+type CreateJoinToken struct {
+	CaData string `json:"caData"`
+}
+
+type JoinToken struct {
+	Token    string    `json:"token"`
+	ExpireAt time.Time `json:"expireAt"`
+	Command  string    `json:"command"`
+}
+
 type UpdateCluster struct {
 	Mode              string   `json:"mode"`
 	ApiServer         string   `json:"apiServer"`
@@ -34,6 +48,8 @@ type UpdateCluster struct {
 	ConfigFileContent string   `json:"configFileContent"`
 	WithLabel         bool     `json:"withLabel"`
 	Labels            []string `json:"labels"`
+	// Proxy 为空时不修改集群的代理配置
+	Proxy *v1Cluster.Proxy `json:"proxy"`
 }
 
 type ExtraClusterInfo struct {
@@ -45,19 +61,30 @@ type ExtraClusterInfo struct {
 	MemoryRequested   float64 `json:"memoryRequested"`
 	Health            bool    `json:"health"`
 	Message           string  `json:"message"`
+	// Latency apiserver 响应时间,单位毫秒
+	Latency int64     `json:"latency"`
+	CheckAt time.Time `json:"checkAt"`
 }
 
-type NamespaceRoles struct {
-	Namespace string   `json:"namespace"`
-	Roles     []string `json:"roles"`
+type HealthHistory struct {
+	Current ExtraClusterInfo         `json:"current"`
+	History []v1Cluster.HealthRecord `json:"history"`
 }
 
+type NamespaceRoles = v1Cluster.NamespaceRoles
+
+// Member Kind 为 User 或 Group
 type Member struct {
 	Name           string           `json:"name"`
+	Kind           string           `json:"kind"`
 	ClusterRoles   []string         `json:"clusterRoles"`
 	BindingName    string           `json:"bindingName"`
 	CreateAt       time.Time        `json:"createAt"`
 	NamespaceRoles []NamespaceRoles `json:"namespaceRoles"`
+	// ExpireAt 为空时永久有效,更新成员时为空则保留原来的过期时间
+	ExpireAt *time.Time `json:"expireAt,omitempty"`
+	// ClearExpireAt 更新成员时取消过期时间
+	ClearExpireAt bool `json:"clearExpireAt,omitempty"`
 }
 
 type Privilege struct {
@@ -72,3 +99,27 @@ type CreateRepo struct {
 	Repos   []string
 	Cluster string
 }
+
+// CertificateStatus 集群中保存的证书的有效期
+type CertificateStatus struct {
+	// Kind ca/client/user
+	Kind        string    `json:"kind"`
+	Name        string    `json:"name"`
+	Subject     string    `json:"subject"`
+	Fingerprint string    `json:"fingerprint"`
+	NotBefore   time.Time `json:"notBefore"`
+	NotAfter    time.Time `json:"notAfter"`
+	Expiring    bool      `json:"expiring"`
+	Expired     bool      `json:"expired"`
+	Message     string    `json:"message,omitempty"`
+}
+
+// RotateCredentials 只替换集群的访问凭证,连接地址和代理保持不变
+type RotateCredentials struct {
+	Mode              string `json:"mode"`
+	Token             string `json:"token"`
+	CertData          string `json:"certData"`
+	KeyData           string `json:"keyData"`
+	ConfigFileContent string `json:"configFileContent"`
+	CaData            string `json:"caData"`
+}
@@ -0,0 +1,56 @@
+package commons
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/KubeOperator/kubepi/internal/service/v1/common"
+	"github.com/kataras/iris/v12/context"
+	"github.com/kataras/iris/v12/core/router"
+)
+
+// ResourceNamesKey 角色限定了资源名称时,列表请求可访问的资源名称
+const ResourceNamesKey = "resourceNames"
+
+// resourceNamesRoutes 会按照资源名称过滤结果的列表路由
+var resourceNamesRoutes sync.Map
+
+// FilterResourceNames 标记路由的处理函数会按照 AllowedResourceNames 过滤结果,
+// 角色限定了资源名称时只有标记过的列表路由可以访问
+func FilterResourceNames(r *router.Route) *router.Route {
+	resourceNamesRoutes.Store(r.Name, struct{}{})
+	return r
+}
+
+// FiltersResourceNames 当前路由是否按照资源名称过滤结果
+func FiltersResourceNames(ctx *context.Context) bool {
+	route := ctx.GetCurrentRoute()
+	if route == nil {
+		return false
+	}
+	_, ok := resourceNamesRoutes.Load(route.Name())
+	return ok
+}
+
+// AllowedResourceNames ok 为 false 时不限制资源名称
+func AllowedResourceNames(ctx *context.Context) ([]string, bool) {
+	names, ok := ctx.Values().Get(ResourceNamesKey).([]string)
+	return names, ok
+}
+
+// LimitResourceNames 将可访问的资源名称加入查询条件
+func LimitResourceNames(ctx *context.Context, conditions common.Conditions) common.Conditions {
+	names, ok := AllowedResourceNames(ctx)
+	if !ok {
+		return conditions
+	}
+	if conditions == nil {
+		conditions = common.Conditions{}
+	}
+	conditions[ResourceNamesKey] = common.Condition{
+		Field:    "name",
+		Operator: "in",
+		Value:    strings.Join(names, ","),
+	}
+	return conditions
+}
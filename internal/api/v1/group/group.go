@@ -0,0 +1,309 @@
+package group
+
+import (
+	"errors"
+
+	"github.com/KubeOperator/kubepi/internal/api/v1/commons"
+	"github.com/KubeOperator/kubepi/internal/api/v1/session"
+	v1Role "github.com/KubeOperator/kubepi/internal/model/v1/role"
+	"github.com/KubeOperator/kubepi/internal/service/v1/common"
+	"github.com/KubeOperator/kubepi/internal/service/v1/group"
+	"github.com/KubeOperator/kubepi/internal/service/v1/rolebinding"
+	pkgV1 "github.com/KubeOperator/kubepi/pkg/api/v1"
+	"github.com/KubeOperator/kubepi/pkg/collectons"
+	"github.com/asdine/storm/v3"
+	"github.com/kataras/iris/v12"
+	"github.com/kataras/iris/v12/context"
+)
+
+type Handler struct {
+	groupService       group.Service
+	roleBindingService rolebinding.Service
+}
+
+func NewHandler() *Handler {
+	return &Handler{
+		groupService:       group.NewService(),
+		roleBindingService: rolebinding.NewService(),
+	}
+}
+
+// Search Group
+// @Tags groups
+// @Summary Search groups
+// @Description Search groups by Condition
+// @Accept  json
+// @Produce  json
+// @Success 200 {object} api.Page
+// @Security ApiKeyAuth
+// @Router /groups/search [post]
+func (h *Handler) SearchGroups() iris.Handler {
+	return func(ctx *context.Context) {
+		pageNum, _ := ctx.Values().GetInt(pkgV1.PageNum)
+		pageSize, _ := ctx.Values().GetInt(pkgV1.PageSize)
+		var conditions commons.SearchConditions
+		if err := ctx.ReadJSON(&conditions); err != nil {
+			ctx.StatusCode(iris.StatusBadRequest)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		groups, total, err := h.groupService.Search(pageNum, pageSize, conditions.Conditions, common.DBOptions{})
+		if err != nil && !errors.Is(err, storm.ErrNotFound) {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		items := make([]Group, 0)
+		for i := range groups {
+			roles, err := h.groupRoles(groups[i].Name)
+			if err != nil {
+				ctx.StatusCode(iris.StatusInternalServerError)
+				ctx.Values().Set("message", err.Error())
+				return
+			}
+			items = append(items, Group{Group: groups[i], Roles: roles})
+		}
+		ctx.Values().Set("data", pkgV1.Page{Items: items, Total: total})
+	}
+}
+
+// List Group
+// @Tags groups
+// @Summary List all groups
+// @Description List all groups
+// @Accept  json
+// @Produce  json
+// @Success 200 {object} []v1Group.Group
+// @Security ApiKeyAuth
+// @Router /groups [get]
+func (h *Handler) ListGroups() iris.Handler {
+	return func(ctx *context.Context) {
+		groups, err := h.groupService.List(common.DBOptions{})
+		if err != nil && !errors.Is(err, storm.ErrNotFound) {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		ctx.Values().Set("data", groups)
+	}
+}
+
+// Create Group
+// @Tags groups
+// @Summary Create group
+// @Description Create group with members and roles
+// @Accept  json
+// @Produce  json
+// @Param request body Group true "request"
+// @Success 200 {object} Group
+// @Security ApiKeyAuth
+// @Router /groups [post]
+func (h *Handler) CreateGroup() iris.Handler {
+	return func(ctx *context.Context) {
+		var req Group
+		if err := ctx.ReadJSON(&req); err != nil {
+			ctx.StatusCode(iris.StatusBadRequest)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		u := ctx.Values().Get("profile")
+		profile := u.(session.UserProfile)
+		req.Kind = "Group"
+		req.CreatedBy = profile.Name
+		if err := h.groupService.Create(&req.Group, common.DBOptions{}); err != nil {
+			if errors.Is(err, storm.ErrAlreadyExists) {
+				ctx.StatusCode(iris.StatusBadRequest)
+				ctx.Values().Set("message", "group name already exists")
+				return
+			}
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		if err := h.roleBindingService.SyncGroupRoleBindings(req.Name, req.Roles, profile.Name); err != nil {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		ctx.Values().Set("data", &req)
+	}
+}
+
+// Get Group
+// @Tags groups
+// @Summary Get group by name
+// @Description Get group by name
+// @Accept  json
+// @Produce  json
+// @Param name path string true "用户组名称"
+// @Success 200 {object} Group
+// @Security ApiKeyAuth
+// @Router /groups/{name} [get]
+func (h *Handler) GetGroup() iris.Handler {
+	return func(ctx *context.Context) {
+		name := ctx.Params().GetString("name")
+		g, err := h.groupService.Get(name, common.DBOptions{})
+		if err != nil {
+			if errors.Is(err, storm.ErrNotFound) {
+				ctx.StatusCode(iris.StatusNotFound)
+				ctx.Values().Set("message", []string{"group %s not found", name})
+				return
+			}
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		roles, err := h.groupRoles(name)
+		if err != nil {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		ctx.Values().Set("data", &Group{Group: *g, Roles: roles})
+	}
+}
+
+// Update Group
+// @Tags groups
+// @Summary Update group by name
+// @Description Update description, members and roles of group
+// @Accept  json
+// @Produce  json
+// @Param name path string true "用户组名称"
+// @Param request body Group true "request"
+// @Success 200 {object} Group
+// @Security ApiKeyAuth
+// @Router /groups/{name} [put]
+func (h *Handler) UpdateGroup() iris.Handler {
+	return func(ctx *context.Context) {
+		name := ctx.Params().GetString("name")
+		var req Group
+		if err := ctx.ReadJSON(&req); err != nil {
+			ctx.StatusCode(iris.StatusBadRequest)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		if _, err := h.groupService.Get(name, common.DBOptions{}); err != nil {
+			if errors.Is(err, storm.ErrNotFound) {
+				ctx.StatusCode(iris.StatusNotFound)
+				ctx.Values().Set("message", []string{"group %s not found", name})
+				return
+			}
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		// 先校验并保存成员,成员无效时不修改角色
+		if err := h.groupService.Update(name, &req.Group); err != nil {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		u := ctx.Values().Get("profile")
+		profile := u.(session.UserProfile)
+		if err := h.roleBindingService.SyncGroupRoleBindings(name, req.Roles, profile.Name); err != nil {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		req.Name = name
+		ctx.Values().Set("data", &req)
+	}
+}
+
+// Delete Group
+// @Tags groups
+// @Summary Delete group by name
+// @Description Delete group and its role bindings and cluster members
+// @Accept  json
+// @Produce  json
+// @Param name path string true "用户组名称"
+// @Success 200 {number} 200
+// @Security ApiKeyAuth
+// @Router /groups/{name} [delete]
+func (h *Handler) DeleteGroup() iris.Handler {
+	return func(ctx *context.Context) {
+		name := ctx.Params().GetString("name")
+		if err := h.groupService.Delete(name); err != nil {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+	}
+}
+
+// Add Group Members
+// @Tags groups
+// @Summary Add members to group
+// @Description Add members to group
+// @Accept  json
+// @Produce  json
+// @Param name path string true "用户组名称"
+// @Param request body MembersRequest true "request"
+// @Success 200 {number} 200
+// @Security ApiKeyAuth
+// @Router /groups/{name}/members [post]
+func (h *Handler) AddGroupMembers() iris.Handler {
+	return func(ctx *context.Context) {
+		name := ctx.Params().GetString("name")
+		var req MembersRequest
+		if err := ctx.ReadJSON(&req); err != nil {
+			ctx.StatusCode(iris.StatusBadRequest)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		if err := h.groupService.AddMembers(name, req.Members); err != nil {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+	}
+}
+
+// Delete Group Member
+// @Tags groups
+// @Summary Remove member from group
+// @Description Remove member from group
+// @Accept  json
+// @Produce  json
+// @Param name path string true "用户组名称"
+// @Param member path string true "用户名称"
+// @Success 200 {number} 200
+// @Security ApiKeyAuth
+// @Router /groups/{name}/members/{member} [delete]
+func (h *Handler) DeleteGroupMember() iris.Handler {
+	return func(ctx *context.Context) {
+		name := ctx.Params().GetString("name")
+		member := ctx.Params().GetString("member")
+		if err := h.groupService.RemoveMember(name, member); err != nil {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+	}
+}
+
+func (h *Handler) groupRoles(name string) ([]string, error) {
+	bindings, err := h.roleBindingService.GetRoleBindingBySubject(v1Role.Subject{Kind: "Group", Name: name}, common.DBOptions{})
+	if err != nil && !errors.Is(err, storm.ErrNotFound) {
+		return nil, err
+	}
+	roles := collectons.NewStringSet()
+	for i := range bindings {
+		roles.Add(bindings[i].RoleRef)
+	}
+	return roles.ToSlice(), nil
+}
+
+func Install(parent iris.Party) {
+	handler := NewHandler()
+	sp := parent.Party("/groups")
+	sp.Post("/search", handler.SearchGroups())
+	sp.Get("", handler.ListGroups())
+	sp.Post("", handler.CreateGroup())
+	sp.Get("/:name", handler.GetGroup())
+	sp.Put("/:name", handler.UpdateGroup())
+	sp.Delete("/:name", handler.DeleteGroup())
+	sp.Post("/:name/members", handler.AddGroupMembers())
+	sp.Delete("/:name/members/:member", handler.DeleteGroupMember())
+}
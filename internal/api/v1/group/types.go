@@ -0,0 +1,12 @@
+package group
+
+import v1Group "github.com/KubeOperator/kubepi/internal/model/v1/group"
+
+type Group struct {
+	v1Group.Group
+	Roles []string `json:"roles"`
+}
+
+type MembersRequest struct {
+	Members []string `json:"members"`
+}
@@ -37,6 +37,7 @@ func (h *Handler) SearchRepos() iris.Handler {
 			ctx.Values().Set("message", err.Error())
 			return
 		}
+		conditions.Conditions = commons.LimitResourceNames(ctx, conditions.Conditions)
 		repos, total, err := h.imageRepoService.Search(pageNum, pageSize, conditions.Conditions, common.DBOptions{})
 		if err != nil {
 			if !errors.Is(err, storm.ErrNotFound) {
@@ -273,7 +274,7 @@ func (h *Handler) ListImagesByRepo() iris.Handler {
 func Install(parent iris.Party) {
 	handler := NewHandler()
 	sp := parent.Party("/imagerepos")
-	sp.Post("/search", handler.SearchRepos())
+	commons.FilterResourceNames(sp.Post("/search", handler.SearchRepos()))
 	sp.Post("/", handler.CreateRepo())
 	sp.Delete("/:name", handler.DeleteRepo())
 	sp.Post("/repositories/search", handler.ListInternalRepos())
@@ -1,19 +1,28 @@
 package ldap
 
 import (
+	"errors"
+
+	"github.com/KubeOperator/kubepi/internal/api/v1/session"
+	v1Ldap "github.com/KubeOperator/kubepi/internal/model/v1/ldap"
 	"github.com/KubeOperator/kubepi/internal/service/v1/common"
 	"github.com/KubeOperator/kubepi/internal/service/v1/ldap"
+	"github.com/asdine/storm/v3"
 	"github.com/kataras/iris/v12"
 	"github.com/kataras/iris/v12/context"
 )
 
 type Handler struct {
-	ldapService ldap.Service
+	ldapService    ldap.Service
+	sessionHandler *session.Handler
+	scheduler      *scheduler
 }
 
 func NewHandler() *Handler {
 	return &Handler{
-		ldapService: ldap.NewService(),
+		ldapService:    ldap.NewService(),
+		sessionHandler: session.NewHandler(),
+		scheduler:      newScheduler(),
 	}
 }
 
@@ -25,6 +34,9 @@ func (h *Handler) ListLdap() iris.Handler {
 			ctx.Values().Set("message", err.Error())
 			return
 		}
+		for i := range ldaps {
+			ldaps[i].ClientKey = ""
+		}
 		ctx.Values().Set("data", ldaps)
 	}
 }
@@ -42,6 +54,7 @@ func (h *Handler) AddLdap() iris.Handler {
 			ctx.Values().Set("message", err.Error())
 			return
 		}
+		h.reloadSchedule()
 		ctx.Values().Set("data", &req)
 	}
 }
@@ -59,6 +72,7 @@ func (h *Handler) UpdateLdap() iris.Handler {
 			ctx.Values().Set("message", err.Error())
 			return
 		}
+		h.reloadSchedule()
 		ctx.Values().Set("data", &req)
 	}
 }
@@ -82,7 +96,7 @@ func (h *Handler) TestConnect() iris.Handler {
 
 func (h *Handler) SyncLdapUser() iris.Handler {
 	return func(ctx *context.Context) {
-		users, err := h.ldapService.GetLdapUser()
+		users, err := h.ldapService.GetLdapUser(ctx.URLParam("ldapId"))
 		if err != nil {
 			ctx.StatusCode(iris.StatusInternalServerError)
 			ctx.Values().Set("message", err.Error())
@@ -99,7 +113,7 @@ func (h *Handler) TestLogin() iris.Handler {
 			ctx.StatusCode(iris.StatusBadRequest)
 			ctx.Values().Set("message", err.Error())
 		}
-		err := h.ldapService.TestLogin(req.Username, req.Password)
+		err := h.ldapService.TestLogin(req.LdapID, req.Username, req.Password)
 		if err != nil {
 			ctx.StatusCode(iris.StatusInternalServerError)
 			ctx.Values().Set("message", err.Error())
@@ -115,7 +129,7 @@ func (h *Handler) ImportUser() iris.Handler {
 			ctx.StatusCode(iris.StatusBadRequest)
 			ctx.Values().Set("message", err.Error())
 		}
-		result, err := h.ldapService.ImportUsers(req.Users)
+		result, err := h.ldapService.ImportUsers(req.LdapID, req.Users)
 		if err != nil {
 			ctx.StatusCode(iris.StatusInternalServerError)
 			ctx.Values().Set("message", err.Error())
@@ -125,8 +139,81 @@ func (h *Handler) ImportUser() iris.Handler {
 	}
 }
 
+func (h *Handler) DeleteLdap() iris.Handler {
+	return func(ctx *context.Context) {
+		id := ctx.Params().GetString("id")
+		if err := h.ldapService.Delete(id, common.DBOptions{}); err != nil {
+			if errors.Is(err, storm.ErrNotFound) {
+				ctx.StatusCode(iris.StatusNotFound)
+				ctx.Values().Set("message", []string{"ldap %s not found", id})
+				return
+			}
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		h.reloadSchedule()
+	}
+}
+
+// SyncLdap 在后台同步目录中的用户,结果通过同步记录查询
+func (h *Handler) SyncLdap() iris.Handler {
+	return func(ctx *context.Context) {
+		id := ctx.Params().GetString("id")
+		l, err := h.ldapService.GetById(id, common.DBOptions{})
+		if err != nil {
+			if errors.Is(err, storm.ErrNotFound) {
+				ctx.StatusCode(iris.StatusNotFound)
+				ctx.Values().Set("message", []string{"ldap %s not found", id})
+				return
+			}
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		if !l.Enable {
+			ctx.StatusCode(iris.StatusBadRequest)
+			ctx.Values().Set("message", "ldap is not enable!")
+			return
+		}
+		go h.sync(id, v1Ldap.SyncTriggerManual)
+	}
+}
+
+func (h *Handler) ListSyncReports() iris.Handler {
+	return func(ctx *context.Context) {
+		reports, err := h.ldapService.ListSyncReports(ctx.URLParam("ldapId"), common.DBOptions{})
+		if err != nil {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		ctx.Values().Set("data", reports)
+	}
+}
+
+func (h *Handler) GetSyncReport() iris.Handler {
+	return func(ctx *context.Context) {
+		name := ctx.Params().GetString("name")
+		report, err := h.ldapService.GetSyncReport(name, common.DBOptions{})
+		if err != nil {
+			if errors.Is(err, storm.ErrNotFound) {
+				ctx.StatusCode(iris.StatusNotFound)
+				ctx.Values().Set("message", []string{"sync report %s not found", name})
+				return
+			}
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		ctx.Values().Set("data", report)
+	}
+}
+
 func Install(parent iris.Party) {
 	handler := NewHandler()
+	handler.reloadSchedule()
+	handler.scheduler.cron.Start()
 	sp := parent.Party("/ldap")
 	sp.Get("/", handler.ListLdap())
 	sp.Post("/", handler.AddLdap())
@@ -135,4 +222,8 @@ func Install(parent iris.Party) {
 	sp.Post("/test/connect", handler.TestConnect())
 	sp.Post("/test/login", handler.TestLogin())
 	sp.Post("/import", handler.ImportUser())
+	sp.Delete("/:id", handler.DeleteLdap())
+	sp.Post("/:id/sync", handler.SyncLdap())
+	sp.Get("/syncreports", handler.ListSyncReports())
+	sp.Get("/syncreports/:name", handler.GetSyncReport())
 }
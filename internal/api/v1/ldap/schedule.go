@@ -0,0 +1,70 @@
+package ldap
+
+import (
+	"sync"
+
+	v1Ldap "github.com/KubeOperator/kubepi/internal/model/v1/ldap"
+	"github.com/KubeOperator/kubepi/internal/server"
+	"github.com/KubeOperator/kubepi/internal/service/v1/common"
+	"github.com/robfig/cron/v3"
+)
+
+// scheduler 按照 ldap 配置中的 cron 表达式定时同步用户
+type scheduler struct {
+	cron    *cron.Cron
+	entries map[string]cron.EntryID
+	mu      sync.Mutex
+}
+
+func newScheduler() *scheduler {
+	return &scheduler{
+		cron:    cron.New(),
+		entries: map[string]cron.EntryID{},
+	}
+}
+
+// reloadSchedule 配置变更后重新登记定时任务
+func (h *Handler) reloadSchedule() {
+	ldaps, err := h.ldapService.List(common.DBOptions{})
+	if err != nil {
+		server.Logger().Errorf("can not load ldap sync schedule: %s", err)
+		return
+	}
+	s := h.scheduler
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, entry := range s.entries {
+		s.cron.Remove(entry)
+		delete(s.entries, id)
+	}
+	for i := range ldaps {
+		if !ldaps[i].Enable || ldaps[i].SyncSchedule == "" {
+			continue
+		}
+		id := ldaps[i].UUID
+		entry, err := s.cron.AddFunc(ldaps[i].SyncSchedule, func() {
+			h.sync(id, v1Ldap.SyncTriggerSchedule)
+		})
+		if err != nil {
+			server.Logger().Errorf("invalid ldap sync schedule %s: %s", ldaps[i].SyncSchedule, err)
+			continue
+		}
+		s.entries[id] = entry
+	}
+}
+
+// sync 同步完成后强制下线被禁用或删除的用户
+func (h *Handler) sync(id string, trigger string) {
+	report, err := h.ldapService.Sync(id, trigger, common.DBOptions{})
+	if err != nil {
+		server.Logger().Errorf("can not sync ldap user: %s", err)
+		return
+	}
+	for _, names := range [][]string{report.Disabled, report.Deleted} {
+		for _, name := range names {
+			if err := h.sessionHandler.TerminateUserSessions(name); err != nil {
+				server.Logger().Errorf("can not terminate sessions of user %s: %s", name, err)
+			}
+		}
+	}
+}
@@ -10,10 +10,12 @@ type Ldap struct {
 }
 
 type TestLogin struct {
+	LdapID   string `json:"ldapId"`
 	Username string `json:"username"`
 	Password string `json:"password"`
 }
 
 type ImportRequest struct {
-	Users []user.ImportUser `json:"users"`
+	LdapID string            `json:"ldapId"`
+	Users  []user.ImportUser `json:"users"`
 }
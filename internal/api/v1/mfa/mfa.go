@@ -2,7 +2,7 @@ package mfa
 
 import (
 	sessionAuth "github.com/KubeOperator/kubepi/internal/api/v1/session"
-	v1 "github.com/KubeOperator/kubepi/internal/model/v1"
+	v1System "github.com/KubeOperator/kubepi/internal/model/v1/system"
 	v1User "github.com/KubeOperator/kubepi/internal/model/v1/user"
 	"github.com/KubeOperator/kubepi/internal/server"
 	"github.com/KubeOperator/kubepi/internal/service/v1/common"
@@ -10,15 +10,18 @@ import (
 	mfaUtil "github.com/KubeOperator/kubepi/pkg/util/mfa"
 	"github.com/kataras/iris/v12"
 	"github.com/kataras/iris/v12/context"
+	"github.com/kataras/iris/v12/sessions"
 )
 
 type Handler struct {
-	userService user.Service
+	userService    user.Service
+	sessionHandler *sessionAuth.Handler
 }
 
 func NewHandler() *Handler {
 	return &Handler{
-		userService: user.NewService(),
+		userService:    user.NewService(),
+		sessionHandler: sessionAuth.NewHandler(),
 	}
 }
 
@@ -41,21 +44,29 @@ func (m *Handler) MfaValidate() iris.Handler {
 			ctx.StatusCode(iris.StatusOK)
 			return
 		}
+		if m.sessionHandler.CheckLockout(ctx, p.Name) {
+			return
+		}
 		var mfa sessionAuth.MfaCredential
 		if err := ctx.ReadJSON(&mfa); err != nil {
 			ctx.StatusCode(iris.StatusBadRequest)
 			ctx.Values().Set("message", err.Error())
 			return
 		}
-		success := mfaUtil.ValidCode(mfa.Code, mfa.Secret)
+		// 使用数据库中的密钥校验,不信任客户端提交的密钥
+		success, err := m.sessionHandler.ValidateMfaCode(p.Name, mfa.Code)
+		if err != nil {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
 		if !success {
+			m.sessionHandler.LoginFailed(ctx, p.Name, sessionAuth.FailureReasonMfa)
 			ctx.StatusCode(iris.StatusInternalServerError)
 			ctx.Values().Set("message", "code is invalid")
 			return
 		} else {
-			p.Mfa.Approved = true
-			session.Set("profile", p)
-			ctx.StatusCode(iris.StatusOK)
+			m.approve(ctx, session, p)
 			return
 		}
 	}
@@ -86,28 +97,20 @@ func (m *Handler) MfaBind() iris.Handler {
 			ctx.Values().Set("message", err.Error())
 			return
 		}
+		if !canBind(p) {
+			ctx.StatusCode(iris.StatusBadRequest)
+			ctx.Values().Set("message", "mfa is already bound")
+			return
+		}
 		success := mfaUtil.ValidCode(mfa.Code, mfa.Secret)
 		if !success {
 			ctx.StatusCode(iris.StatusInternalServerError)
 			ctx.Values().Set("message", "code is invalid")
 			return
 		} else {
-			session.Delete("profile")
-			us := &v1User.User{
-				Metadata: v1.Metadata{
-					Name: mfa.Username,
-				},
-				Mfa: v1User.Mfa{
-					Enable: true,
-					Secret: mfa.Secret,
-				},
-			}
-			if err := m.userService.Update(mfa.Username, us, common.DBOptions{}); err != nil {
-				ctx.StatusCode(iris.StatusInternalServerError)
-				ctx.Values().Set("message", err.Error())
-				return
-			}
-			ctx.StatusCode(iris.StatusOK)
+			m.bind(ctx, session, p, func(um *v1User.Mfa) {
+				um.Secret = mfa.Secret
+			})
 			return
 		}
 	}
@@ -145,10 +148,109 @@ func (m *Handler) GetMfa() iris.Handler {
 	}
 }
 
+// authenticated 未开启 mfa 或者已经通过二次验证
+func authenticated(p sessionAuth.UserProfile) bool {
+	return !p.Mfa.Enable || p.Mfa.Approved
+}
+
+// canBind 未绑定时可以绑定第一个验证方式,已绑定的用户需要通过验证后才能添加或更换
+func canBind(p sessionAuth.UserProfile) bool {
+	return authenticated(p) || (p.Mfa.Secret == "" && !p.Mfa.WebAuthn)
+}
+
+// bind 保存新的验证方式,首次绑定时生成恢复码,未通过验证的会话需要重新登录
+func (m *Handler) bind(ctx *context.Context, session *sessions.Session, p sessionAuth.UserProfile, update func(um *v1User.Mfa)) {
+	u, err := m.userService.GetByNameOrEmail(p.Name, common.DBOptions{})
+	if err != nil {
+		ctx.StatusCode(iris.StatusInternalServerError)
+		ctx.Values().Set("message", err.Error())
+		return
+	}
+	um := u.Mfa
+	um.Enable = true
+	update(&um)
+	var codes []string
+	if len(um.RecoveryCodes) == 0 {
+		var hashes []string
+		codes, hashes, err = mfaUtil.GenerateRecoveryCodes(mfaUtil.RecoveryCodeCount)
+		if err != nil {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		um.RecoveryCodes = hashes
+	}
+	if err := m.userService.UpdateMfa(p.Name, um, common.DBOptions{}); err != nil {
+		ctx.StatusCode(iris.StatusInternalServerError)
+		ctx.Values().Set("message", err.Error())
+		return
+	}
+	if authenticated(p) {
+		u.Mfa = um
+		p.Mfa = sessionAuth.NewMfa(u)
+		p.Mfa.Approved = true
+		session.Set("profile", p)
+	} else {
+		session.Delete("profile")
+	}
+	ctx.StatusCode(iris.StatusOK)
+	ctx.Values().Set("data", RecoveryCodes{RecoveryCodes: codes})
+}
+
+// approve 二次验证通过,清除失败次数并登记会话
+func (m *Handler) approve(ctx *context.Context, session *sessions.Session, p sessionAuth.UserProfile) {
+	m.sessionHandler.LoginSucceeded(p.Name)
+	p.Mfa.Approved = true
+	session.Set("profile", p)
+	if err := m.sessionHandler.RegisterCookieSession(ctx, session.ID(), p.Name, v1System.AuthMethodCookie); err != nil {
+		server.Logger().Errorf("can not register session of user %s: %s", p.Name, err)
+	}
+	ctx.StatusCode(iris.StatusOK)
+}
+
+// RegenerateRecoveryCodes 重新生成恢复码,之前的恢复码全部失效
+func (m *Handler) RegenerateRecoveryCodes() iris.Handler {
+	return func(ctx *context.Context) {
+		session := server.SessionMgr.Start(ctx)
+		p, ok := session.Get("profile").(sessionAuth.UserProfile)
+		if !ok || !p.Mfa.Approved {
+			ctx.StatusCode(iris.StatusUnauthorized)
+			ctx.Values().Set("message", "no login user")
+			return
+		}
+		u, err := m.userService.GetByNameOrEmail(p.Name, common.DBOptions{})
+		if err != nil {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		codes, hashes, err := mfaUtil.GenerateRecoveryCodes(mfaUtil.RecoveryCodeCount)
+		if err != nil {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		u.Mfa.RecoveryCodes = hashes
+		if err := m.userService.UpdateMfa(p.Name, u.Mfa, common.DBOptions{}); err != nil {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		ctx.Values().Set("data", RecoveryCodes{RecoveryCodes: codes})
+	}
+}
+
 func Install(parent iris.Party) {
 	handler := NewHandler()
 	sp := parent.Party("/mfa")
 	sp.Get("/", handler.GetMfa())
 	sp.Post("/bind", handler.MfaBind())
 	sp.Post("/valid", handler.MfaValidate())
+	sp.Post("/recoverycodes", handler.RegenerateRecoveryCodes())
+	sp.Post("/webauthn/register/begin", handler.BeginWebAuthnRegistration())
+	sp.Post("/webauthn/register/finish", handler.FinishWebAuthnRegistration())
+	sp.Post("/webauthn/login/begin", handler.BeginWebAuthnLogin())
+	sp.Post("/webauthn/login/finish", handler.FinishWebAuthnLogin())
+	sp.Get("/webauthn/credentials", handler.ListWebAuthnCredentials())
+	sp.Delete("/webauthn/credentials/:name", handler.DeleteWebAuthnCredential())
 }
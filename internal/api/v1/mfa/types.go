@@ -0,0 +1,16 @@
+package mfa
+
+import "time"
+
+// RecoveryCodes 恢复码明文只在生成时返回一次
+type RecoveryCodes struct {
+	RecoveryCodes []string `json:"recoveryCodes"`
+}
+
+// WebAuthnCredential 安全密钥信息,不返回公钥
+type WebAuthnCredential struct {
+	Name         string    `json:"name"`
+	CreateAt     time.Time `json:"createAt"`
+	LastUsedAt   time.Time `json:"lastUsedAt"`
+	CloneWarning bool      `json:"cloneWarning"`
+}
@@ -0,0 +1,434 @@
+package mfa
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"time"
+
+	sessionAuth "github.com/KubeOperator/kubepi/internal/api/v1/session"
+	v1User "github.com/KubeOperator/kubepi/internal/model/v1/user"
+	"github.com/KubeOperator/kubepi/internal/server"
+	"github.com/KubeOperator/kubepi/internal/service/v1/common"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/kataras/iris/v12"
+	"github.com/kataras/iris/v12/context"
+	"github.com/kataras/iris/v12/sessions"
+)
+
+const (
+	webAuthnRegistrationKey = "webAuthnRegistration"
+	webAuthnLoginKey        = "webAuthnLogin"
+)
+
+var _ webauthn.User = (*webAuthnUser)(nil)
+
+type webAuthnUser struct {
+	u *v1User.User
+}
+
+func (w *webAuthnUser) WebAuthnID() []byte {
+	return []byte(w.u.UUID)
+}
+
+func (w *webAuthnUser) WebAuthnName() string {
+	return w.u.Name
+}
+
+func (w *webAuthnUser) WebAuthnDisplayName() string {
+	if w.u.NickName != "" {
+		return w.u.NickName
+	}
+	return w.u.Name
+}
+
+func (w *webAuthnUser) WebAuthnIcon() string {
+	return ""
+}
+
+func (w *webAuthnUser) WebAuthnCredentials() []webauthn.Credential {
+	cs := make([]webauthn.Credential, 0, len(w.u.Mfa.WebAuthnCredentials))
+	for i := range w.u.Mfa.WebAuthnCredentials {
+		cs = append(cs, w.u.Mfa.WebAuthnCredentials[i].Credential)
+	}
+	return cs
+}
+
+// newWebAuthn 未配置 relying party 时使用请求的域名
+func newWebAuthn(ctx *context.Context) (*webauthn.WebAuthn, error) {
+	c := server.Config().Spec.WebAuthn
+	rpID := c.RPID
+	if rpID == "" {
+		rpID = ctx.Host()
+		if host, _, err := net.SplitHostPort(rpID); err == nil {
+			rpID = host
+		}
+	}
+	origins := c.RPOrigins
+	if len(origins) == 0 {
+		scheme := "http"
+		if ctx.Request().TLS != nil || ctx.GetHeader("X-Forwarded-Proto") == "https" {
+			scheme = "https"
+		}
+		origins = []string{fmt.Sprintf("%s://%s", scheme, ctx.Host())}
+	}
+	return webauthn.New(&webauthn.Config{
+		RPID:          rpID,
+		RPDisplayName: "KubePi",
+		RPOrigins:     origins,
+	})
+}
+
+func (m *Handler) sessionProfile(ctx *context.Context) (*sessions.Session, sessionAuth.UserProfile, bool) {
+	session := server.SessionMgr.Start(ctx)
+	p, ok := session.Get("profile").(sessionAuth.UserProfile)
+	if !ok {
+		ctx.StatusCode(iris.StatusUnauthorized)
+		ctx.Values().Set("message", "no login user")
+		return nil, p, false
+	}
+	return session, p, true
+}
+
+// ceremony 数据以 json 保存在会话中,避免持久化会话存储需要注册类型
+func saveCeremony(session *sessions.Session, key string, data *webauthn.SessionData) error {
+	bs, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	session.Set(key, string(bs))
+	return nil
+}
+
+func loadCeremony(session *sessions.Session, key string) (*webauthn.SessionData, error) {
+	raw := session.GetString(key)
+	session.Delete(key)
+	if raw == "" {
+		return nil, fmt.Errorf("webauthn ceremony not found")
+	}
+	var data webauthn.SessionData
+	if err := json.Unmarshal([]byte(raw), &data); err != nil {
+		return nil, err
+	}
+	return &data, nil
+}
+
+// BeginWebAuthnRegistration
+// @Tags mfa
+// @Summary Begin security key registration
+// @Description Return the credential creation options for navigator.credentials.create
+// @Accept  json
+// @Produce  json
+// @Router /mfa/webauthn/register/begin [post]
+func (m *Handler) BeginWebAuthnRegistration() iris.Handler {
+	return func(ctx *context.Context) {
+		session, p, ok := m.sessionProfile(ctx)
+		if !ok {
+			return
+		}
+		if !canBind(p) {
+			ctx.StatusCode(iris.StatusBadRequest)
+			ctx.Values().Set("message", "mfa is already bound")
+			return
+		}
+		u, err := m.userService.GetByNameOrEmail(p.Name, common.DBOptions{})
+		if err != nil {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		w, err := newWebAuthn(ctx)
+		if err != nil {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		user := &webAuthnUser{u: u}
+		exclusions := make([]protocol.CredentialDescriptor, 0)
+		for _, c := range user.WebAuthnCredentials() {
+			exclusions = append(exclusions, c.Descriptor())
+		}
+		creation, data, err := w.BeginRegistration(user, webauthn.WithExclusions(exclusions))
+		if err != nil {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		if err := saveCeremony(session, webAuthnRegistrationKey, data); err != nil {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		ctx.Values().Set("data", creation)
+	}
+}
+
+// FinishWebAuthnRegistration
+// @Tags mfa
+// @Summary Finish security key registration
+// @Description Verify the attestation returned by navigator.credentials.create and save the credential
+// @Accept  json
+// @Produce  json
+// @Param name query string false "安全密钥名称"
+// @Success 200 {object} RecoveryCodes
+// @Router /mfa/webauthn/register/finish [post]
+func (m *Handler) FinishWebAuthnRegistration() iris.Handler {
+	return func(ctx *context.Context) {
+		session, p, ok := m.sessionProfile(ctx)
+		if !ok {
+			return
+		}
+		if !canBind(p) {
+			ctx.StatusCode(iris.StatusBadRequest)
+			ctx.Values().Set("message", "mfa is already bound")
+			return
+		}
+		data, err := loadCeremony(session, webAuthnRegistrationKey)
+		if err != nil {
+			ctx.StatusCode(iris.StatusBadRequest)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		u, err := m.userService.GetByNameOrEmail(p.Name, common.DBOptions{})
+		if err != nil {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		w, err := newWebAuthn(ctx)
+		if err != nil {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		parsed, err := protocol.ParseCredentialCreationResponseBody(ctx.Request().Body)
+		if err != nil {
+			ctx.StatusCode(iris.StatusBadRequest)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		credential, err := w.CreateCredential(&webAuthnUser{u: u}, *data, parsed)
+		if err != nil {
+			ctx.StatusCode(iris.StatusBadRequest)
+			ctx.Values().Set("message", "security key verification failed")
+			return
+		}
+		name := ctx.URLParamDefault("name", fmt.Sprintf("key-%d", len(u.Mfa.WebAuthnCredentials)+1))
+		for i := range u.Mfa.WebAuthnCredentials {
+			if u.Mfa.WebAuthnCredentials[i].Name == name {
+				ctx.StatusCode(iris.StatusBadRequest)
+				ctx.Values().Set("message", "already exists")
+				return
+			}
+		}
+		m.bind(ctx, session, p, func(um *v1User.Mfa) {
+			um.WebAuthnCredentials = append(um.WebAuthnCredentials, v1User.WebAuthnCredential{
+				Name:       name,
+				Credential: *credential,
+				CreateAt:   time.Now(),
+			})
+		})
+	}
+}
+
+// BeginWebAuthnLogin
+// @Tags mfa
+// @Summary Begin security key assertion
+// @Description Return the credential request options for navigator.credentials.get
+// @Accept  json
+// @Produce  json
+// @Router /mfa/webauthn/login/begin [post]
+func (m *Handler) BeginWebAuthnLogin() iris.Handler {
+	return func(ctx *context.Context) {
+		session, p, ok := m.sessionProfile(ctx)
+		if !ok {
+			return
+		}
+		if !p.Mfa.Enable || !p.Mfa.WebAuthn {
+			ctx.StatusCode(iris.StatusBadRequest)
+			ctx.Values().Set("message", "no security key registered")
+			return
+		}
+		if m.sessionHandler.CheckLockout(ctx, p.Name) {
+			return
+		}
+		u, err := m.userService.GetByNameOrEmail(p.Name, common.DBOptions{})
+		if err != nil {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		w, err := newWebAuthn(ctx)
+		if err != nil {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		assertion, data, err := w.BeginLogin(&webAuthnUser{u: u})
+		if err != nil {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		if err := saveCeremony(session, webAuthnLoginKey, data); err != nil {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		ctx.Values().Set("data", assertion)
+	}
+}
+
+// FinishWebAuthnLogin
+// @Tags mfa
+// @Summary Finish security key assertion
+// @Description Verify the assertion returned by navigator.credentials.get, same as /mfa/valid on success
+// @Accept  json
+// @Produce  json
+// @Router /mfa/webauthn/login/finish [post]
+func (m *Handler) FinishWebAuthnLogin() iris.Handler {
+	return func(ctx *context.Context) {
+		session, p, ok := m.sessionProfile(ctx)
+		if !ok {
+			return
+		}
+		if !p.Mfa.Enable {
+			ctx.StatusCode(iris.StatusOK)
+			return
+		}
+		if m.sessionHandler.CheckLockout(ctx, p.Name) {
+			return
+		}
+		data, err := loadCeremony(session, webAuthnLoginKey)
+		if err != nil {
+			ctx.StatusCode(iris.StatusBadRequest)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		u, err := m.userService.GetByNameOrEmail(p.Name, common.DBOptions{})
+		if err != nil {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		w, err := newWebAuthn(ctx)
+		if err != nil {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		parsed, err := protocol.ParseCredentialRequestResponseBody(ctx.Request().Body)
+		if err != nil {
+			ctx.StatusCode(iris.StatusBadRequest)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		credential, err := w.ValidateLogin(&webAuthnUser{u: u}, *data, parsed)
+		if err != nil {
+			m.sessionHandler.LoginFailed(ctx, p.Name, sessionAuth.FailureReasonMfa)
+			ctx.StatusCode(iris.StatusBadRequest)
+			ctx.Values().Set("message", "security key verification failed")
+			return
+		}
+		// 更新签名计数,用于发现被复制的密钥
+		um := u.Mfa
+		for i := range um.WebAuthnCredentials {
+			if string(um.WebAuthnCredentials[i].Credential.ID) == string(credential.ID) {
+				um.WebAuthnCredentials[i].Credential.Authenticator = credential.Authenticator
+				um.WebAuthnCredentials[i].LastUsedAt = time.Now()
+			}
+		}
+		if err := m.userService.UpdateMfa(p.Name, um, common.DBOptions{}); err != nil {
+			server.Logger().Errorf("can not update security key of user %s: %s", p.Name, err)
+		}
+		m.approve(ctx, session, p)
+	}
+}
+
+// ListWebAuthnCredentials
+// @Tags mfa
+// @Summary List security keys of current user
+// @Description List security keys of current user
+// @Accept  json
+// @Produce  json
+// @Success 200 {object} []WebAuthnCredential
+// @Router /mfa/webauthn/credentials [get]
+func (m *Handler) ListWebAuthnCredentials() iris.Handler {
+	return func(ctx *context.Context) {
+		_, p, ok := m.sessionProfile(ctx)
+		if !ok {
+			return
+		}
+		if !authenticated(p) {
+			ctx.StatusCode(iris.StatusUnauthorized)
+			ctx.Values().Set("message", "no login user")
+			return
+		}
+		u, err := m.userService.GetByNameOrEmail(p.Name, common.DBOptions{})
+		if err != nil {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		cs := make([]WebAuthnCredential, 0)
+		for _, c := range u.Mfa.WebAuthnCredentials {
+			cs = append(cs, WebAuthnCredential{
+				Name:         c.Name,
+				CreateAt:     c.CreateAt,
+				LastUsedAt:   c.LastUsedAt,
+				CloneWarning: c.Credential.Authenticator.CloneWarning,
+			})
+		}
+		ctx.Values().Set("data", cs)
+	}
+}
+
+// DeleteWebAuthnCredential
+// @Tags mfa
+// @Summary Delete security key of current user
+// @Description Delete security key of current user
+// @Accept  json
+// @Produce  json
+// @Param name path string true "安全密钥名称"
+// @Success 200 {number} 200
+// @Router /mfa/webauthn/credentials/{name} [delete]
+func (m *Handler) DeleteWebAuthnCredential() iris.Handler {
+	return func(ctx *context.Context) {
+		session, p, ok := m.sessionProfile(ctx)
+		if !ok {
+			return
+		}
+		if !authenticated(p) {
+			ctx.StatusCode(iris.StatusUnauthorized)
+			ctx.Values().Set("message", "no login user")
+			return
+		}
+		name := ctx.Params().GetString("name")
+		u, err := m.userService.GetByNameOrEmail(p.Name, common.DBOptions{})
+		if err != nil {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		um := u.Mfa
+		um.WebAuthnCredentials = make([]v1User.WebAuthnCredential, 0)
+		for _, c := range u.Mfa.WebAuthnCredentials {
+			if c.Name != name {
+				um.WebAuthnCredentials = append(um.WebAuthnCredentials, c)
+			}
+		}
+		if len(um.WebAuthnCredentials) == len(u.Mfa.WebAuthnCredentials) {
+			ctx.StatusCode(iris.StatusNotFound)
+			ctx.Values().Set("message", fmt.Sprintf("security key %s not found", name))
+			return
+		}
+		if err := m.userService.UpdateMfa(p.Name, um, common.DBOptions{}); err != nil {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		p.Mfa.WebAuthn = len(um.WebAuthnCredentials) > 0
+		session.Set("profile", p)
+	}
+}
@@ -0,0 +1,253 @@
+package v1
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/KubeOperator/kubepi/internal/api/v1/session"
+	v1Role "github.com/KubeOperator/kubepi/internal/model/v1/role"
+	v1User "github.com/KubeOperator/kubepi/internal/model/v1/user"
+	v1ClusterService "github.com/KubeOperator/kubepi/internal/service/v1/cluster"
+	v1ClusterBindingService "github.com/KubeOperator/kubepi/internal/service/v1/clusterbinding"
+	"github.com/KubeOperator/kubepi/internal/service/v1/common"
+	v1RoleService "github.com/KubeOperator/kubepi/internal/service/v1/role"
+	v1RoleBindingService "github.com/KubeOperator/kubepi/internal/service/v1/rolebinding"
+	v1UserService "github.com/KubeOperator/kubepi/internal/service/v1/user"
+	"github.com/KubeOperator/kubepi/pkg/collectons"
+	"github.com/KubeOperator/kubepi/pkg/kubernetes"
+	"github.com/asdine/storm/v3"
+	"github.com/kataras/iris/v12"
+	"github.com/kataras/iris/v12/context"
+	authV1 "k8s.io/api/authorization/v1"
+)
+
+type PermissionExplanation struct {
+	User    string `json:"user"`
+	Allowed bool   `json:"allowed"`
+	Reason  string `json:"reason"`
+	// Permissions 用户对该资源汇总后的操作
+	Permissions        []string                       `json:"permissions,omitempty"`
+	RoleBindings       []RoleBindingExplanation       `json:"roleBindings,omitempty"`
+	ClusterBinding     string                         `json:"clusterBinding,omitempty"`
+	KubernetesBindings []KubernetesBindingExplanation `json:"kubernetesBindings,omitempty"`
+}
+
+type RoleBindingExplanation struct {
+	Binding string              `json:"binding"`
+	Subject v1Role.Subject      `json:"subject"`
+	Role    string              `json:"role"`
+	Rules   []v1Role.PolicyRule `json:"rules"`
+	Matched bool                `json:"matched"`
+}
+
+type KubernetesBindingExplanation struct {
+	kubernetes.ManagedRoleBinding
+	Matched bool `json:"matched"`
+}
+
+// Explain User Permission
+// @Tags users
+// @Summary Explain effective permission of user
+// @Description 指定 cluster 时检查 kubernetes 资源权限,否则检查 KubePi 资源权限
+// @Accept  json
+// @Produce  json
+// @Param name path string true "用户名称"
+// @Param resource query string true "资源"
+// @Param verb query string true "操作"
+// @Param resourceName query string false "资源名称"
+// @Param cluster query string false "集群名称"
+// @Param namespace query string false "命名空间"
+// @Param group query string false "api group"
+// @Param subresource query string false "子资源"
+// @Success 200 {object} PermissionExplanation
+// @Security ApiKeyAuth
+// @Router /users/{name}/permissions [get]
+func explainPermissionHandler() iris.Handler {
+	return func(ctx *context.Context) {
+		userName := ctx.Params().GetString("name")
+		resource := ctx.URLParam("resource")
+		verb := ctx.URLParam("verb")
+		if resource == "" || verb == "" {
+			ctx.StatusCode(iris.StatusBadRequest)
+			ctx.Values().Set("message", "resource and verb can not be none")
+			return
+		}
+		u, err := v1UserService.NewService().GetByNameOrEmail(userName, common.DBOptions{})
+		if err != nil {
+			if errors.Is(err, storm.ErrNotFound) {
+				ctx.StatusCode(iris.StatusNotFound)
+				ctx.Values().Set("message", fmt.Sprintf("user %s not found", userName))
+				return
+			}
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		var explanation *PermissionExplanation
+		if clusterName := ctx.URLParam("cluster"); clusterName != "" {
+			explanation, err = explainKubernetesPermission(u, clusterName, authV1.ResourceAttributes{
+				Namespace:   ctx.URLParam("namespace"),
+				Verb:        verb,
+				Group:       ctx.URLParam("group"),
+				Resource:    resource,
+				Subresource: ctx.URLParam("subresource"),
+				Name:        ctx.URLParam("resourceName"),
+			})
+		} else {
+			explanation, err = explainKubePiPermission(u, resource, verb, ctx.URLParam("resourceName"))
+		}
+		if err != nil {
+			if errors.Is(err, storm.ErrNotFound) {
+				ctx.StatusCode(iris.StatusNotFound)
+			} else {
+				ctx.StatusCode(iris.StatusInternalServerError)
+			}
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		ctx.Values().Set("data", explanation)
+	}
+}
+
+// explainKubePiPermission 与 roleAccessHandler 的判断方式一致
+func explainKubePiPermission(u *v1User.User, resource, verb, resourceName string) (*PermissionExplanation, error) {
+	explanation := &PermissionExplanation{User: u.Name}
+	switch {
+	case u.Disabled:
+		explanation.Reason = "user is disabled"
+		return explanation, nil
+	case u.IsAdmin:
+		explanation.Allowed = true
+		explanation.Reason = "user is administrator"
+		return explanation, nil
+	case inResourceWhiteList(resource):
+		explanation.Allowed = true
+		explanation.Reason = fmt.Sprintf("resource %s is not restricted by roles", resource)
+		return explanation, nil
+	}
+
+	permissions, err := session.NewHandler().AggregateResourcePermissions(u.Name)
+	if err != nil {
+		return nil, err
+	}
+	verbs := collectons.NewStringSet()
+	for _, key := range []string{resource, "*"} {
+		for _, v := range permissions[key] {
+			verbs.Add(v)
+		}
+	}
+	explanation.Permissions = verbs.ToSlice()
+
+	rbs, err := v1RoleBindingService.NewService().GetRoleBindingsByUser(u.Name, common.DBOptions{})
+	if err != nil {
+		return nil, err
+	}
+	var roleNames []string
+	for i := range rbs {
+		roleNames = append(roleNames, rbs[i].RoleRef)
+	}
+	roles, err := v1RoleService.NewService().GetByNames(roleNames, common.DBOptions{})
+	if err != nil && !errors.Is(err, storm.ErrNotFound) {
+		return nil, err
+	}
+	roleMap := map[string]v1Role.Role{}
+	for i := range roles {
+		roleMap[roles[i].Name] = roles[i]
+	}
+	for i := range rbs {
+		role, ok := roleMap[rbs[i].RoleRef]
+		if !ok {
+			continue
+		}
+		var rules []v1Role.PolicyRule
+		for _, rule := range role.Rules {
+			if collectons.IndexOfStringSlice(rule.Resource, resource) != -1 || collectons.IndexOfStringSlice(rule.Resource, "*") != -1 {
+				rules = append(rules, rule)
+			}
+		}
+		resourceMatched, verbMatched, _ := matchRoles(resource, verb, []v1Role.Role{role})
+		explanation.RoleBindings = append(explanation.RoleBindings, RoleBindingExplanation{
+			Binding: rbs[i].Name,
+			Subject: rbs[i].Subject,
+			Role:    role.Name,
+			Rules:   rules,
+			Matched: resourceMatched && verbMatched,
+		})
+	}
+
+	resourceMatched, verbMatched, resourceNames := matchRoles(resource, verb, roles)
+	switch {
+	case !resourceMatched:
+		explanation.Reason = fmt.Sprintf("no role grants resource %s", resource)
+	case !verbMatched:
+		explanation.Reason = fmt.Sprintf("no role grants verb %s on resource %s", verb, resource)
+	case resourceNames == nil:
+		explanation.Allowed = true
+		explanation.Reason = fmt.Sprintf("allowed by roles %s", strings.Join(matchedRoles(explanation.RoleBindings), ", "))
+	case resourceName != "" && collectons.IndexOfStringSlice(resourceNames, resourceName) != -1:
+		explanation.Allowed = true
+		explanation.Reason = fmt.Sprintf("allowed by roles %s for %s", strings.Join(matchedRoles(explanation.RoleBindings), ", "), resourceName)
+	case resourceName == "" && verb == "list":
+		explanation.Allowed = true
+		explanation.Reason = fmt.Sprintf("list is limited to %s", strings.Join(resourceNames, ", "))
+	default:
+		explanation.Reason = fmt.Sprintf("roles only grant %s on %s", verb, strings.Join(resourceNames, ", "))
+	}
+	return explanation, nil
+}
+
+// explainKubernetesPermission 通过 SubjectAccessReview 判断,并标出 kubepi 创建的哪些绑定允许该请求
+func explainKubernetesPermission(u *v1User.User, clusterName string, attributes authV1.ResourceAttributes) (*PermissionExplanation, error) {
+	explanation := &PermissionExplanation{User: u.Name}
+	c, err := v1ClusterService.NewService().Get(clusterName, common.DBOptions{})
+	if err != nil {
+		return nil, err
+	}
+	if u.IsAdmin {
+		explanation.Allowed = true
+		explanation.Reason = "administrator accesses the cluster with the cluster credentials"
+		return explanation, nil
+	}
+	binding, err := v1ClusterBindingService.NewService().GetCredentialBinding(clusterName, u.Name, common.DBOptions{})
+	if err != nil {
+		if errors.Is(err, storm.ErrNotFound) {
+			explanation.Reason = fmt.Sprintf("user %s is not a member of cluster %s", u.Name, clusterName)
+			return explanation, nil
+		}
+		return nil, err
+	}
+	explanation.ClusterBinding = binding.Name
+
+	k := kubernetes.NewKubernetes(c)
+	result, err := k.ReviewPermission(u.Name, attributes)
+	if err != nil {
+		return nil, err
+	}
+	explanation.Allowed = result.Allowed
+	explanation.Reason = result.Reason
+	bindings, err := k.ManagedRoleBindings(u.Name)
+	if err != nil {
+		return nil, err
+	}
+	for i := range bindings {
+		explanation.KubernetesBindings = append(explanation.KubernetesBindings, KubernetesBindingExplanation{
+			ManagedRoleBinding: bindings[i],
+			Matched:            bindings[i].Allows(attributes),
+		})
+	}
+	if explanation.Reason == "" && !explanation.Allowed {
+		explanation.Reason = "no kubernetes binding allows this request"
+	}
+	return explanation, nil
+}
+
+func matchedRoles(bindings []RoleBindingExplanation) []string {
+	roles := collectons.NewStringSet()
+	for i := range bindings {
+		if bindings[i].Matched {
+			roles.Add(bindings[i].Role)
+		}
+	}
+	return roles.ToSlice()
+}
@@ -70,6 +70,11 @@ func (h *Handler) KubernetesAPIProxy() iris.Handler {
 		profile := u.(session.UserProfile)
 		// 生成transport
 		ts, err := h.generateTLSTransport(c, profile)
+		if errors.Is(err, clusterbinding.ErrMemberExpired) {
+			ctx.StatusCode(iris.StatusForbidden)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
 		if err != nil {
 			ctx.StatusCode(iris.StatusInternalServerError)
 			ctx.Values().Set("message", err)
@@ -115,7 +120,13 @@ func (h *Handler) KubernetesAPIProxy() iris.Handler {
 				return
 			}
 		}
-		apiUrl, err := url.Parse(fmt.Sprintf("%s%s", c.Spec.Connect.Forward.ApiServer, proxyPath))
+		apiServer, err := k.ApiServer()
+		if err != nil {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		apiUrl, err := url.Parse(fmt.Sprintf("%s%s", apiServer, proxyPath))
 		if err != nil {
 			ctx.StatusCode(iris.StatusInternalServerError)
 			ctx.Values().Set("message", err)
@@ -427,17 +438,13 @@ func (h *Handler) generateTLSTransport(c *v1Cluster.Cluster, profile session.Use
 
 	}
 
-	binding, err := h.clusterBindingService.GetBindingByClusterNameAndUserName(c.Name, profile.Name, common.DBOptions{})
+	binding, err := h.clusterBindingService.GetActiveCredentialBinding(c.Name, profile.Name, common.DBOptions{})
 	if err != nil {
 		return nil, err
 	}
-	kubeConf := &rest.Config{
-		Host: c.Spec.Connect.Forward.ApiServer,
-		TLSClientConfig: rest.TLSClientConfig{
-			Insecure: true,
-			CertData: binding.Certificate,
-			KeyData:  pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: c.PrivateKey}),
-		},
+	kubeConf, err := kubernetes.NewKubernetes(c).NewUserConfig(binding.Certificate, pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: c.PrivateKey}))
+	if err != nil {
+		return nil, err
 	}
 	return rest.TransportFor(kubeConf)
 }
@@ -0,0 +1,153 @@
+package session
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	v1 "github.com/KubeOperator/kubepi/internal/model/v1"
+	v1System "github.com/KubeOperator/kubepi/internal/model/v1/system"
+	"github.com/KubeOperator/kubepi/internal/server"
+	"github.com/KubeOperator/kubepi/internal/service/v1/common"
+	"github.com/asdine/storm/v3"
+	"github.com/google/uuid"
+	"github.com/kataras/iris/v12"
+	"github.com/kataras/iris/v12/context"
+	"github.com/kataras/iris/v12/middleware/jwt"
+)
+
+func (h *Handler) newSession(ctx *context.Context, userName, authMethod string, expireAt time.Time) *v1System.Session {
+	return &v1System.Session{
+		BaseModel: v1.BaseModel{
+			ApiVersion: "v1",
+			Kind:       "Session",
+		},
+		Metadata: v1.Metadata{
+			Name: uuid.New().String(),
+		},
+		UserName:   userName,
+		Ip:         ctx.RemoteAddr(),
+		UserAgent:  ctx.GetHeader("User-Agent"),
+		AuthMethod: authMethod,
+		ExpireAt:   expireAt,
+	}
+}
+
+// RegisterCookieSession 登记 cookie 会话,登录、sso 回调和 mfa 验证通过后调用
+func (h *Handler) RegisterCookieSession(ctx *context.Context, cookieID, userName, authMethod string) error {
+	if s, err := h.systemService.GetSessionByCookie(cookieID, common.DBOptions{}); err == nil {
+		_ = h.systemService.DeleteSession(s.Name, common.DBOptions{})
+	}
+	s := h.newSession(ctx, userName, authMethod, time.Now().Add(time.Duration(server.Config().Spec.Session.Expires)*time.Hour))
+	s.CookieID = cookieID
+	return h.systemService.CreateSession(s, common.DBOptions{})
+}
+
+func (h *Handler) registerJwtSession(ctx *context.Context, userName string) (string, error) {
+	s := h.newSession(ctx, userName, v1System.AuthMethodJwt, time.Now().Add(refreshMaxAge()))
+	if err := h.systemService.CreateSession(s, common.DBOptions{}); err != nil {
+		return "", err
+	}
+	return s.Name, nil
+}
+
+// TerminateSession 强制下线: cookie 会话直接销毁,jwt 会话吊销其 access token 并使 refresh token 失效
+func (h *Handler) TerminateSession(s *v1System.Session) error {
+	if s.CookieID != "" {
+		server.SessionMgr.DestroyByID(s.CookieID)
+	} else {
+		claims := jwt.Claims{ID: s.Name, Expiry: time.Now().Add(JwtMaxAge).Unix()}
+		if err := h.blocklist.InvalidateToken([]byte(s.Name), claims); err != nil {
+			return err
+		}
+	}
+	if err := h.systemService.DeleteSession(s.Name, common.DBOptions{}); err != nil && !errors.Is(err, storm.ErrNotFound) {
+		return err
+	}
+	return nil
+}
+
+// TerminateUserSessions 用户被禁用或删除后强制下线其全部会话
+func (h *Handler) TerminateUserSessions(userName string) error {
+	ss, err := h.systemService.ListSessions(userName, common.DBOptions{})
+	if err != nil {
+		return err
+	}
+	for i := range ss {
+		if err := h.TerminateSession(&ss[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (h *Handler) onSessionDestroy(sid string) {
+	s, err := h.systemService.GetSessionByCookie(sid, common.DBOptions{})
+	if err != nil {
+		return
+	}
+	if err := h.systemService.DeleteSession(s.Name, common.DBOptions{}); err != nil && !errors.Is(err, storm.ErrNotFound) {
+		server.Logger().Errorf("can not delete session of user %s: %s", s.UserName, err)
+	}
+}
+
+// ListActiveSessions
+// @Tags activesessions
+// @Summary List active sessions of current user
+// @Description List active sessions of current user
+// @Accept  json
+// @Produce  json
+// @Success 200 {object} []v1System.Session
+// @Security ApiKeyAuth
+// @Router /activesessions [get]
+func (h *Handler) ListActiveSessions() iris.Handler {
+	return func(ctx *context.Context) {
+		profile := ctx.Values().Get("profile").(UserProfile)
+		ss, err := h.systemService.ListSessions(profile.Name, common.DBOptions{})
+		if err != nil {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		for i := range ss {
+			ss[i].CookieID = ""
+		}
+		ctx.Values().Set("data", ss)
+	}
+}
+
+// TerminateActiveSession
+// @Tags activesessions
+// @Summary Terminate active session of current user
+// @Description Terminate active session of current user
+// @Accept  json
+// @Produce  json
+// @Param name path string true "会话ID"
+// @Success 200 {number} 200
+// @Security ApiKeyAuth
+// @Router /activesessions/{name} [delete]
+func (h *Handler) TerminateActiveSession() iris.Handler {
+	return func(ctx *context.Context) {
+		name := ctx.Params().GetString("name")
+		profile := ctx.Values().Get("profile").(UserProfile)
+		s, err := h.systemService.GetSession(name, common.DBOptions{})
+		if err != nil || s.UserName != profile.Name {
+			ctx.StatusCode(iris.StatusNotFound)
+			ctx.Values().Set("message", fmt.Sprintf("session %s not found", name))
+			return
+		}
+		if err := h.TerminateSession(s); err != nil {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+	}
+}
+
+// InstallActive 当前用户的会话管理,需要登录后访问
+func InstallActive(parent iris.Party) {
+	handler := NewHandler()
+	sp := parent.Party("/activesessions")
+	sp.Get("/", handler.ListActiveSessions())
+	sp.Delete("/:name", handler.TerminateActiveSession())
+}
@@ -0,0 +1,80 @@
+package session
+
+import (
+	"errors"
+	"time"
+
+	v1System "github.com/KubeOperator/kubepi/internal/model/v1/system"
+	"github.com/KubeOperator/kubepi/internal/server"
+	"github.com/KubeOperator/kubepi/internal/service/v1/common"
+	"github.com/asdine/storm/v3"
+	"github.com/kataras/iris/v12"
+	"github.com/kataras/iris/v12/context"
+)
+
+const (
+	FailureReasonCredential = "invalid credential"
+	FailureReasonMfa        = "invalid mfa code"
+)
+
+// CheckLockout 检查来源 IP 和用户是否被锁定,被锁定时设置响应并返回 true
+func (h *Handler) CheckLockout(ctx *context.Context, userName string) bool {
+	c := server.Config().Spec.Lockout
+	if !c.Enable {
+		return false
+	}
+	checks := [][]string{{v1System.LockoutKindIp, ctx.RemoteAddr()}}
+	if userName != "" {
+		checks = append(checks, []string{v1System.LockoutKindUser, userName})
+	}
+	for _, check := range checks {
+		l, err := h.systemService.GetLockout(check[0], check[1], common.DBOptions{})
+		if err != nil {
+			if !errors.Is(err, storm.ErrNotFound) {
+				server.Logger().Errorf("can not get lockout of %s %s: %s", check[0], check[1], err)
+			}
+			continue
+		}
+		if l.Locked() {
+			ctx.StatusCode(iris.StatusTooManyRequests)
+			ctx.Values().Set("message", []string{"too many failed attempts, locked until %s", l.LockedUntil.Format("2006-01-02 15:04:05")})
+			return true
+		}
+	}
+	return false
+}
+
+// LoginFailed 累计用户和来源 IP 的失败次数,并记录失败的登录日志
+func (h *Handler) LoginFailed(ctx *context.Context, userName, reason string) {
+	ip := ctx.RemoteAddr()
+	go h.saveLoginLog(ip, userName, reason)
+	c := server.Config().Spec.Lockout
+	if !c.Enable {
+		return
+	}
+	window := time.Duration(c.Window) * time.Minute
+	duration := time.Duration(c.Duration) * time.Minute
+	if _, err := h.systemService.RecordLoginFailure(v1System.LockoutKindIp, ip, c.IpMaxAttempts, window, duration, common.DBOptions{}); err != nil {
+		server.Logger().Errorf("can not record login failure of ip %s: %s", ip, err)
+	}
+	if userName == "" {
+		return
+	}
+	// 不存在的用户名只按来源 IP 计数,避免锁定记录无限增长
+	if _, err := h.userService.GetByNameOrEmail(userName, common.DBOptions{}); err != nil {
+		if !errors.Is(err, storm.ErrNotFound) {
+			server.Logger().Errorf("can not get user %s: %s", userName, err)
+		}
+		return
+	}
+	if _, err := h.systemService.RecordLoginFailure(v1System.LockoutKindUser, userName, c.UserMaxAttempts, window, duration, common.DBOptions{}); err != nil {
+		server.Logger().Errorf("can not record login failure of user %s: %s", userName, err)
+	}
+}
+
+// LoginSucceeded 登录成功后清除用户的失败次数
+func (h *Handler) LoginSucceeded(userName string) {
+	if err := h.systemService.ResetLockout(v1System.LockoutKindUser, userName, common.DBOptions{}); err != nil {
+		server.Logger().Errorf("can not reset lockout of user %s: %s", userName, err)
+	}
+}
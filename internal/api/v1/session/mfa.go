@@ -0,0 +1,51 @@
+package session
+
+import (
+	v1Config "github.com/KubeOperator/kubepi/internal/model/v1/config"
+	v1User "github.com/KubeOperator/kubepi/internal/model/v1/user"
+	"github.com/KubeOperator/kubepi/internal/server"
+	"github.com/KubeOperator/kubepi/internal/service/v1/common"
+	mfaUtil "github.com/KubeOperator/kubepi/pkg/util/mfa"
+)
+
+// MfaSecretMask 会话和响应中不保存真实的 mfa 密钥,只标识是否已经绑定
+const MfaSecretMask = "******"
+
+// mfaRequired 用户自身开启或全局配置要求绑定 mfa
+func mfaRequired(u *v1User.User) bool {
+	if u.Mfa.Enable {
+		return true
+	}
+	switch server.Config().Spec.Mfa.Mandatory {
+	case v1Config.MfaMandatoryAll:
+		return true
+	case v1Config.MfaMandatoryAdmin:
+		return u.IsAdmin
+	}
+	return false
+}
+
+// NewMfa 构造会话中的 mfa 状态,密钥使用掩码代替
+func NewMfa(u *v1User.User) Mfa {
+	m := Mfa{Enable: mfaRequired(u), WebAuthn: len(u.Mfa.WebAuthnCredentials) > 0}
+	if u.Mfa.Secret != "" {
+		m.Secret = MfaSecretMask
+	}
+	return m
+}
+
+// ValidateMfaCode 校验动态验证码,不通过时尝试作为恢复码校验
+// 只绑定了 WebAuthn 的用户也可以使用恢复码
+func (h *Handler) ValidateMfaCode(userName string, code string) (bool, error) {
+	u, err := h.userService.GetByNameOrEmail(userName, common.DBOptions{})
+	if err != nil {
+		return false, err
+	}
+	if !u.Mfa.Bound() {
+		return false, nil
+	}
+	if u.Mfa.Secret != "" && mfaUtil.ValidCode(code, u.Mfa.Secret) {
+		return true, nil
+	}
+	return h.userService.UseRecoveryCode(u.Name, code, common.DBOptions{})
+}
@@ -1,8 +1,11 @@
 package session
 
 import (
+	"errors"
+
 	"github.com/KubeOperator/kubepi/internal/server"
 	"github.com/KubeOperator/kubepi/internal/service/v1/common"
+	"github.com/KubeOperator/kubepi/pkg/util/password"
 	"github.com/kataras/iris/v12"
 	"github.com/kataras/iris/v12/context"
 )
@@ -45,6 +48,8 @@ func (h *Handler) UpdateProfile() iris.Handler {
 			Language:            user.Language,
 			ResourcePermissions: profile.ResourcePermissions,
 			IsAdministrator:     user.IsAdmin,
+			PasswordExpired:     profile.PasswordExpired,
+			Mfa:                 profile.Mfa,
 		}
 		session.Set("profile", profile)
 		ctx.Values().Set("data", "ok")
@@ -62,10 +67,20 @@ func (h *Handler) UpdatePassword() iris.Handler {
 		u := session.Get("profile")
 		profile := u.(UserProfile)
 		if err := h.userService.UpdatePassword(profile.Name, pass.OldPassword, pass.NewPassword, common.DBOptions{}); err != nil {
+			var pe *password.ViolationError
+			if errors.As(err, &pe) {
+				ctx.StatusCode(iris.StatusBadRequest)
+				ctx.Values().Set("message", pe.Message())
+				return
+			}
 			ctx.StatusCode(iris.StatusInternalServerError)
 			ctx.Values().Set("message", "can not match original password")
 			return
 		}
+		if profile.PasswordExpired {
+			profile.PasswordExpired = false
+			session.Set("profile", profile)
+		}
 		ctx.Values().Set("data", "ok")
 	}
 }
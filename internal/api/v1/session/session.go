@@ -9,6 +9,7 @@ import (
 
 	v1Role "github.com/KubeOperator/kubepi/internal/model/v1/role"
 	v1System "github.com/KubeOperator/kubepi/internal/model/v1/system"
+	v1Token "github.com/KubeOperator/kubepi/internal/model/v1/token"
 	v1User "github.com/KubeOperator/kubepi/internal/model/v1/user"
 	"github.com/KubeOperator/kubepi/internal/server"
 	"github.com/KubeOperator/kubepi/internal/service/v1/cluster"
@@ -17,6 +18,7 @@ import (
 	"github.com/KubeOperator/kubepi/internal/service/v1/role"
 	"github.com/KubeOperator/kubepi/internal/service/v1/rolebinding"
 	v1SystemService "github.com/KubeOperator/kubepi/internal/service/v1/system"
+	"github.com/KubeOperator/kubepi/internal/service/v1/token"
 	"github.com/KubeOperator/kubepi/internal/service/v1/user"
 	"github.com/KubeOperator/kubepi/pkg/collectons"
 	"github.com/KubeOperator/kubepi/pkg/kubernetes"
@@ -24,6 +26,7 @@ import (
 	"github.com/KubeOperator/kubepi/pkg/network/ip"
 	"github.com/KubeOperator/kubepi/pkg/terminal"
 	"github.com/asdine/storm/v3"
+	"github.com/google/uuid"
 	"github.com/kataras/iris/v12"
 	"github.com/kataras/iris/v12/context"
 	"github.com/kataras/iris/v12/middleware/jwt"
@@ -32,7 +35,11 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
-var jwtMaxAge = 10 * time.Minute
+// JwtMaxAge access token 的有效期
+var JwtMaxAge = 10 * time.Minute
+
+// RefreshTokenHeader 登录和刷新时通过该响应头返回 refresh token,注销时可携带以一并吊销
+const RefreshTokenHeader = "X-Refresh-Token"
 
 type Handler struct {
 	userService        user.Service
@@ -40,7 +47,12 @@ type Handler struct {
 	clusterService     cluster.Service
 	rolebindingService rolebinding.Service
 	ldapService        ldap.Service
+	systemService      v1SystemService.Service
 	jwtSigner          *jwt.Signer
+	jwtVerifier        *jwt.Verifier
+	refreshSigner      *jwt.Signer
+	refreshVerifier    *jwt.Verifier
+	blocklist          jwt.Blocklist
 }
 
 func NewHandler() *Handler {
@@ -50,10 +62,25 @@ func NewHandler() *Handler {
 		roleService:        role.NewService(),
 		rolebindingService: rolebinding.NewService(),
 		ldapService:        ldap.NewService(),
-		jwtSigner:          jwt.NewSigner(jwt.HS256, server.Config().Spec.Jwt.Key, jwtMaxAge),
+		systemService:      v1SystemService.NewService(),
+		jwtSigner:          jwt.NewSigner(jwt.HS256, server.Config().Spec.Jwt.Key, JwtMaxAge),
+		jwtVerifier:        jwt.NewVerifier(jwt.HS256, server.Config().Spec.Jwt.Key),
+		refreshSigner:      jwt.NewSigner(jwt.HS256, refreshKey(), refreshMaxAge()),
+		refreshVerifier:    jwt.NewVerifier(jwt.HS256, refreshKey()),
+		blocklist:          token.NewBlocklist(JwtMaxAge),
 	}
 }
 
+// refresh token 使用独立的密钥签发,避免和 access token 混用
+func refreshKey() string {
+	return server.Config().Spec.Jwt.Key + "-refresh"
+}
+
+// refresh token 有效期与 cookie 会话保持一致
+func refreshMaxAge() time.Duration {
+	return time.Duration(server.Config().Spec.Session.Expires) * time.Hour
+}
+
 func (h *Handler) IsLogin() iris.Handler {
 	return func(ctx *context.Context) {
 		session := server.SessionMgr.Start(ctx)
@@ -98,9 +125,13 @@ func (h *Handler) Login() iris.Handler {
 			ctx.Values().Set("message", err.Error())
 			return
 		}
+		if h.CheckLockout(ctx, "") {
+			return
+		}
 		u, err := h.userService.GetByNameOrEmail(loginCredential.Username, common.DBOptions{})
 		if err != nil {
 			if errors.Is(err, storm.ErrNotFound) {
+				h.LoginFailed(ctx, loginCredential.Username, FailureReasonCredential)
 				ctx.StatusCode(iris.StatusBadRequest)
 				ctx.Values().Set("message", "username or password error")
 				return
@@ -110,56 +141,89 @@ func (h *Handler) Login() iris.Handler {
 			return
 		}
 
+		if h.CheckLockout(ctx, u.Name) {
+			return
+		}
+		if u.Disabled {
+			ctx.StatusCode(iris.StatusForbidden)
+			ctx.Values().Set("message", "user is disabled")
+			return
+		}
+		if u.Type == v1User.SSO {
+			ctx.StatusCode(iris.StatusBadRequest)
+			ctx.Values().Set("message", "sso user can only login with sso")
+			return
+		}
 		if u.Type == v1User.LDAP {
-			if !h.ldapService.CheckStatus() {
+			if !h.ldapService.CheckStatus(u.LdapID) {
 				ctx.StatusCode(iris.StatusInternalServerError)
 				ctx.Values().Set("message", "ldap is not enable!")
 				return
 			}
 			if err := h.ldapService.Login(*u, loginCredential.Password, common.DBOptions{}); err != nil {
+				h.LoginFailed(ctx, u.Name, FailureReasonCredential)
 				ctx.StatusCode(iris.StatusInternalServerError)
 				ctx.Values().Set("message", "username or password error")
 				return
 			}
 		} else {
 			if err := bcrypt.CompareHashAndPassword([]byte(u.Authenticate.Password), []byte(loginCredential.Password)); err != nil {
+				h.LoginFailed(ctx, u.Name, FailureReasonCredential)
 				ctx.StatusCode(iris.StatusBadRequest)
 				ctx.Values().Set("message", "username or password error")
 				return
 			}
 		}
 
-		permissions, err := h.AggregateResourcePermissions(loginCredential.Username)
+		profile, err := h.newProfile(u)
 		if err != nil {
 			ctx.StatusCode(iris.StatusInternalServerError)
 			ctx.Values().Set("message", err.Error())
 			return
 		}
-		profile := UserProfile{
-			Name:                u.Name,
-			NickName:            u.NickName,
-			Email:               u.Email,
-			Language:            u.Language,
-			ResourcePermissions: permissions,
-			IsAdministrator:     u.IsAdmin,
-			Mfa: Mfa{
-				Secret:   u.Mfa.Secret,
-				Enable:   u.Mfa.Enable,
-				Approved: false,
-			},
+
+		// 开启 mfa 的用户在验证码通过后才清除失败次数
+		if !profile.Mfa.Enable {
+			h.LoginSucceeded(u.Name)
 		}
 
 		authMethod := loginCredential.AuthMethod
 
 		switch authMethod {
 		case "jwt":
-			token, err := h.jwtSigner.Sign(profile)
+			// jwt 登录没有二次验证的页面,验证码随登录请求一起提交
+			if profile.Mfa.Enable {
+				if !u.Mfa.Bound() {
+					ctx.StatusCode(iris.StatusBadRequest)
+					ctx.Values().Set("message", "please bind mfa before using jwt login")
+					return
+				}
+				ok, err := h.ValidateMfaCode(u.Name, loginCredential.Code)
+				if err != nil {
+					ctx.StatusCode(iris.StatusInternalServerError)
+					ctx.Values().Set("message", err.Error())
+					return
+				}
+				if !ok {
+					h.LoginFailed(ctx, u.Name, FailureReasonMfa)
+					ctx.StatusCode(iris.StatusBadRequest)
+					ctx.Values().Set("message", "code is invalid")
+					return
+				}
+				h.LoginSucceeded(u.Name)
+				profile.Mfa.Approved = true
+			}
+			sessionID, err := h.registerJwtSession(ctx, profile.Name)
 			if err != nil {
 				ctx.StatusCode(iris.StatusInternalServerError)
 				ctx.Values().Set("message", err.Error())
+				return
+			}
+			if err := h.signTokens(ctx, profile, sessionID); err != nil {
+				ctx.StatusCode(iris.StatusInternalServerError)
+				ctx.Values().Set("message", err.Error())
+				return
 			}
-			ctx.StatusCode(iris.StatusOK)
-			ctx.Values().Set("token", token)
 			return
 		default:
 			sId := ctx.GetCookie(server.SessionCookieName)
@@ -170,6 +234,12 @@ func (h *Handler) Login() iris.Handler {
 			sess := server.SessionMgr.Start(ctx)
 			ctx.SetCookieKV(server.SessionCookieName, sess.ID())
 			sess.Set("profile", profile)
+			// 开启 mfa 的用户在验证通过后才登记会话
+			if !profile.Mfa.Enable {
+				if err := h.RegisterCookieSession(ctx, sess.ID(), profile.Name, v1System.AuthMethodCookie); err != nil {
+					server.Logger().Errorf("can not register session of user %s: %s", profile.Name, err)
+				}
+			}
 		}
 
 		ctx.StatusCode(iris.StatusOK)
@@ -178,25 +248,162 @@ func (h *Handler) Login() iris.Handler {
 	}
 }
 
+func (h *Handler) newProfile(u *v1User.User) (UserProfile, error) {
+	permissions, err := h.AggregateResourcePermissions(u.Name)
+	if err != nil {
+		return UserProfile{}, err
+	}
+	return UserProfile{
+		Name:                u.Name,
+		NickName:            u.NickName,
+		Email:               u.Email,
+		Language:            u.Language,
+		ResourcePermissions: permissions,
+		IsAdministrator:     u.IsAdmin,
+		PasswordExpired:     h.userService.IsPasswordExpired(u),
+		Mfa:                 NewMfa(u),
+	}, nil
+}
+
+// signTokens 签发 access token 和 refresh token,access token 作为响应体,refresh token 放在响应头
+// access token 的 jti 为会话 ID,吊销后该会话签发的所有 access token 均失效
+func (h *Handler) signTokens(ctx *context.Context, profile UserProfile, sessionID string) error {
+	accessToken, err := h.jwtSigner.Sign(profile, jwt.Claims{ID: sessionID})
+	if err != nil {
+		return err
+	}
+	refreshToken, err := h.refreshSigner.Sign(jwt.Claims{Subject: profile.Name, ID: uuid.New().String(), OriginID: sessionID})
+	if err != nil {
+		return err
+	}
+	ctx.Header(RefreshTokenHeader, string(refreshToken))
+	ctx.StatusCode(iris.StatusOK)
+	ctx.Values().Set("token", accessToken)
+	return nil
+}
+
+// Refresh
+// @Tags sessions
+// @Summary Refresh jwt
+// @Description Exchange the refresh token in X-Refresh-Token header for a new token pair
+// @Accept  json
+// @Produce  json
+// @Router /sessions/refresh [post]
+func (h *Handler) Refresh() iris.Handler {
+	return func(ctx *context.Context) {
+		raw := ctx.GetHeader(RefreshTokenHeader)
+		if raw == "" {
+			ctx.StatusCode(iris.StatusUnauthorized)
+			ctx.Values().Set("message", "please login")
+			return
+		}
+		verified, err := h.refreshVerifier.VerifyToken([]byte(raw), h.blocklist)
+		if err != nil || verified.StandardClaims.Subject == "" {
+			ctx.StatusCode(iris.StatusUnauthorized)
+			ctx.Values().Set("message", "please login")
+			return
+		}
+		sessionID := verified.StandardClaims.OriginID
+		if _, err := h.systemService.GetSession(sessionID, common.DBOptions{}); err != nil {
+			ctx.StatusCode(iris.StatusUnauthorized)
+			ctx.Values().Set("message", "please login")
+			return
+		}
+		u, err := h.userService.GetByNameOrEmail(verified.StandardClaims.Subject, common.DBOptions{})
+		if err != nil {
+			if errors.Is(err, storm.ErrNotFound) {
+				ctx.StatusCode(iris.StatusUnauthorized)
+				ctx.Values().Set("message", "please login")
+				return
+			}
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		if u.Disabled {
+			ctx.StatusCode(iris.StatusUnauthorized)
+			ctx.Values().Set("message", "please login")
+			return
+		}
+		// refresh token 只能使用一次
+		if err := h.blocklist.InvalidateToken(verified.Token, verified.StandardClaims); err != nil {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		profile, err := h.newProfile(u)
+		if err != nil {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		// 会话在 mfa 验证通过后才会登记
+		profile.Mfa.Approved = profile.Mfa.Enable
+		if err := h.systemService.UpdateSessionExpire(sessionID, time.Now().Add(refreshMaxAge()), common.DBOptions{}); err != nil {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		if err := h.signTokens(ctx, profile, sessionID); err != nil {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+	}
+}
+
+// revokeTokens 吊销请求中携带的 refresh token 和 access token,refresh token 不依赖 access token 是否有效
+func (h *Handler) revokeTokens(ctx *context.Context, refreshToken string) (bool, error) {
+	revoked := false
+	if refreshToken != "" {
+		if verified, err := h.refreshVerifier.VerifyToken([]byte(refreshToken), h.blocklist); err == nil {
+			if err := h.blocklist.InvalidateToken(verified.Token, verified.StandardClaims); err != nil {
+				return false, err
+			}
+			if err := h.systemService.DeleteSession(verified.StandardClaims.OriginID, common.DBOptions{}); err != nil && !errors.Is(err, storm.ErrNotFound) {
+				return false, err
+			}
+			revoked = true
+		}
+	}
+	if raw := jwt.FromHeader(ctx); raw != "" && !strings.HasPrefix(raw, v1Token.Prefix) {
+		verified, err := h.jwtVerifier.VerifyToken([]byte(raw), h.blocklist)
+		if err != nil {
+			return revoked, nil
+		}
+		if err := h.blocklist.InvalidateToken(verified.Token, verified.StandardClaims); err != nil {
+			return false, err
+		}
+		if err := h.systemService.DeleteSession(verified.StandardClaims.ID, common.DBOptions{}); err != nil && !errors.Is(err, storm.ErrNotFound) {
+			return false, err
+		}
+		revoked = true
+	}
+	return revoked, nil
+}
+
 func (h *Handler) SaveLoginLog(ctx *context.Context, userName string) {
+	h.saveLoginLog(ctx.RemoteAddr(), userName, "")
+}
+
+// saveLoginLog reason 不为空时记录为失败的登录
+func (h *Handler) saveLoginLog(remoteAddr, userName, reason string) {
 	var logItem v1System.LoginLog
 	logItem.UserName = userName
-	logItem.Ip = ctx.RemoteAddr()
+	logItem.Ip = remoteAddr
+	logItem.Failed = reason != ""
+	logItem.Reason = reason
 	qqWry, err := ip.NewQQwry()
 	if err != nil {
 		server.Logger().Errorf("load qqwry datas failed: %s", err)
 	}
 	res := qqWry.Find(logItem.Ip)
 	logItem.City = res.Area
-	systemService := v1SystemService.NewService()
-	systemService.CreateLoginLog(&logItem, common.DBOptions{})
+	h.systemService.CreateLoginLog(&logItem, common.DBOptions{})
 }
 
 func (h *Handler) AggregateResourcePermissions(name string) (map[string][]string, error) {
-	userRoleBindings, err := h.rolebindingService.GetRoleBindingBySubject(v1Role.Subject{
-		Kind: "User",
-		Name: name,
-	}, common.DBOptions{})
+	userRoleBindings, err := h.rolebindingService.GetRoleBindingsByUser(name, common.DBOptions{})
 	if err != nil && !errors.As(err, &storm.ErrNotFound) {
 		return nil, err
 	}
@@ -242,6 +449,23 @@ func (h *Handler) AggregateResourcePermissions(name string) (map[string][]string
 
 func (h *Handler) Logout() iris.Handler {
 	return func(ctx *context.Context) {
+		// refresh token 可以放在请求体或者请求头中
+		var req LogoutRequest
+		_ = ctx.ReadJSON(&req)
+		if req.RefreshToken == "" {
+			req.RefreshToken = ctx.GetHeader(RefreshTokenHeader)
+		}
+		revoked, err := h.revokeTokens(ctx, req.RefreshToken)
+		if err != nil {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		if revoked {
+			ctx.StatusCode(iris.StatusOK)
+			ctx.Values().Set("data", "logout success")
+			return
+		}
 		session := server.SessionMgr.Start(ctx)
 		loginUser := session.Get("profile")
 		if loginUser == nil {
@@ -250,6 +474,9 @@ func (h *Handler) Logout() iris.Handler {
 			return
 		}
 		session.Delete("profile")
+		if s, err := h.systemService.GetSessionByCookie(session.ID(), common.DBOptions{}); err == nil {
+			_ = h.systemService.DeleteSession(s.Name, common.DBOptions{})
+		}
 		logging.LogSessions.Clean()
 		terminal.TerminalSessions.Clean()
 		ctx.StatusCode(iris.StatusOK)
@@ -285,6 +512,8 @@ func (h *Handler) GetProfile() iris.Handler {
 			Email:           user.Email,
 			Language:        user.Language,
 			IsAdministrator: user.IsAdmin,
+			PasswordExpired: h.userService.IsPasswordExpired(user),
+			Mfa:             p.Mfa,
 		}
 		if !user.IsAdmin {
 			permissions, err := h.AggregateResourcePermissions(p.Name)
@@ -419,10 +648,12 @@ func Install(parent iris.Party) {
 	sp := parent.Party("/sessions")
 	sp.Post("", handler.Login())
 	sp.Delete("", handler.Logout())
+	sp.Post("/refresh", handler.Refresh())
 	sp.Get("", handler.GetProfile())
 	sp.Get("/:cluster_name", handler.GetClusterProfile())
 	sp.Get("/status", handler.IsLogin())
 	sp.Get("/:cluster_name/namespaces", handler.ListUserNamespace())
 	sp.Put("", handler.UpdateProfile())
 	sp.Put("/password", handler.UpdatePassword())
+	server.SessionMgr.OnDestroy(handler.onSessionDestroy)
 }
@@ -1,12 +1,23 @@
 package session
 
-import v1 "k8s.io/api/rbac/v1"
+import (
+	"encoding/gob"
+
+	v1 "k8s.io/api/rbac/v1"
+)
+
+func init() {
+	// 会话持久化时使用 gob 编码,需要注册保存在会话中的类型
+	gob.Register(UserProfile{})
+}
 
 type LoginCredential struct {
 	Username   string `json:"username"`
 	Email      string `json:"email"`
 	Password   string `json:"password"`
 	AuthMethod string `json:"authMethod"`
+	// Code jwt 登录时的 mfa 验证码或恢复码
+	Code string `json:"code"`
 }
 type MfaCredential struct {
 	Username string `json:"username"`
@@ -14,6 +25,10 @@ type MfaCredential struct {
 	Code     string `json:"code"`
 }
 
+type LogoutRequest struct {
+	RefreshToken string `json:"refreshToken"`
+}
+
 type PasswordSetter struct {
 	NewPassword string `json:"newPassword"`
 	OldPassword string `json:"oldPassword"`
@@ -33,6 +48,9 @@ type UserProfile struct {
 	ResourcePermissions map[string][]string `json:"resourcePermissions"`
 	IsAdministrator     bool                `json:"isAdministrator"`
 	Mfa                 Mfa                 `json:"mfa"`
+	Scopes              []string            `json:"scopes,omitempty"`
+	// PasswordExpired 密码已过期,修改密码前只能访问会话相关接口
+	PasswordExpired bool `json:"passwordExpired"`
 }
 
 type ClusterUserProfile struct {
@@ -44,4 +62,6 @@ type Mfa struct {
 	Enable   bool   `json:"enable"`
 	Secret   string `json:"secret"`
 	Approved bool   `json:"approved"`
+	// WebAuthn 已经注册了安全密钥
+	WebAuthn bool `json:"webAuthn"`
 }
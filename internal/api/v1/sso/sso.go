@@ -1,11 +1,18 @@
 package sso
 
 import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+
 	v1Session "github.com/KubeOperator/kubepi/internal/api/v1/session"
 	v1Sso "github.com/KubeOperator/kubepi/internal/model/v1/sso"
+	v1System "github.com/KubeOperator/kubepi/internal/model/v1/system"
 	"github.com/KubeOperator/kubepi/internal/server"
 	"github.com/KubeOperator/kubepi/internal/service/v1/common"
 	"github.com/KubeOperator/kubepi/internal/service/v1/sso"
+	ssoClient "github.com/KubeOperator/kubepi/pkg/util/sso"
+	"github.com/crewjam/saml"
 	"github.com/kataras/iris/v12"
 	"github.com/kataras/iris/v12/context"
 	"strings"
@@ -48,6 +55,9 @@ func (h *Handler) ListSso() iris.Handler {
 			ctx.Values().Set("message", err.Error())
 			return
 		}
+		for i := range ssos {
+			ssos[i].SpPrivateKey = ""
+		}
 		ctx.Values().Set("data", ssos)
 	}
 }
@@ -85,13 +95,33 @@ func (h *Handler) LoginSso() iris.Handler {
 		// 目前只支持OpenID
 		switch ssos[0].Protocol {
 		case "openid":
-			oauth2Config, err = h.ssoService.OpenIDConfig(ssos[0].ClientId, ssos[0].ClientSecret, ssos[0].InterfaceAddress, redirectURL)
+			oauth2Config, err = h.ssoService.OpenIDConfig(ssos[0].ClientId, ssos[0].ClientSecret, ssos[0].InterfaceAddress, redirectURL, ssos[0].Scopes...)
 			if err != nil {
 				ctx.StatusCode(iris.StatusInternalServerError)
 				ctx.Values().Set("message", err.Error())
 				return
 			}
 			ctx.Redirect(oauth2Config.Oauth2Config.AuthCodeURL("state"), iris.StatusFound)
+		case v1Sso.ProtocolSaml:
+			sp, err := h.ssoService.SamlServiceProvider(&ssos[0], samlBaseURL(ctx))
+			if err != nil {
+				ctx.StatusCode(iris.StatusInternalServerError)
+				ctx.Values().Set("message", err.Error())
+				return
+			}
+			req, err := sp.MakeAuthenticationRequest(sp.GetSSOBindingLocation(saml.HTTPRedirectBinding), saml.HTTPRedirectBinding, saml.HTTPPostBinding)
+			if err != nil {
+				ctx.StatusCode(iris.StatusInternalServerError)
+				ctx.Values().Set("message", err.Error())
+				return
+			}
+			u, err := req.Redirect(ssoClient.SignRelayState(req.ID, server.Config().Spec.Jwt.Key), sp)
+			if err != nil {
+				ctx.StatusCode(iris.StatusInternalServerError)
+				ctx.Values().Set("message", err.Error())
+				return
+			}
+			ctx.Redirect(u.String(), iris.StatusFound)
 		default:
 			ctx.StatusCode(iris.StatusInternalServerError)
 			ctx.Values().Set("message", "目前只支持OpenID")
@@ -129,26 +159,7 @@ func (h *Handler) CallbackSso() iris.Handler {
 				ctx.Values().Set("message", err.Error())
 				return
 			}
-			// 默认为Session
-			sId := ctx.GetCookie(server.SessionCookieName)
-			if sId != "" {
-				ctx.RemoveCookie(server.SessionCookieName)
-				ctx.Request().Header.Del("Cookie")
-			}
-			sess := server.SessionMgr.Start(ctx)
-			ctx.SetCookieKV(server.SessionCookieName, sess.ID())
-			sess.Set("profile", userProfile)
-
-			redirectURL := ""
-			if strings.HasPrefix(strings.ToLower(r.Proto), "https") {
-				redirectURL = "https://" + r.Host
-			} else if strings.HasPrefix(strings.ToLower(r.Proto), "http") {
-				redirectURL = "http://" + r.Host
-			}
-			ctx.Redirect(redirectURL, iris.StatusFound)
-			handler := v1Session.NewHandler()
-			go handler.SaveLoginLog(ctx, userProfile.Name)
-			//ctx.Values().Set("data", userProfile)
+			startSession(ctx, userProfile)
 		default:
 			ctx.StatusCode(iris.StatusInternalServerError)
 			ctx.Values().Set("message", "目前只支持OpenID")
@@ -157,6 +168,114 @@ func (h *Handler) CallbackSso() iris.Handler {
 	}
 }
 
+// startSession 默认为Session,登记会话后跳转到首页
+func startSession(ctx *context.Context, userProfile v1Session.UserProfile) {
+	r := ctx.Request()
+	sId := ctx.GetCookie(server.SessionCookieName)
+	if sId != "" {
+		ctx.RemoveCookie(server.SessionCookieName)
+		ctx.Request().Header.Del("Cookie")
+	}
+	sess := server.SessionMgr.Start(ctx)
+	ctx.SetCookieKV(server.SessionCookieName, sess.ID())
+	sess.Set("profile", userProfile)
+	handler := v1Session.NewHandler()
+	if err := handler.RegisterCookieSession(ctx, sess.ID(), userProfile.Name, v1System.AuthMethodSso); err != nil {
+		server.Logger().Errorf("can not register session of user %s: %s", userProfile.Name, err)
+	}
+
+	redirectURL := ""
+	if strings.HasPrefix(strings.ToLower(r.Proto), "https") {
+		redirectURL = "https://" + r.Host
+	} else if strings.HasPrefix(strings.ToLower(r.Proto), "http") {
+		redirectURL = "http://" + r.Host
+	}
+	ctx.Redirect(redirectURL, iris.StatusFound)
+	go handler.SaveLoginLog(ctx, userProfile.Name)
+}
+
+// samlBaseURL 根据当前请求地址得到 /sso/saml 的完整地址
+func samlBaseURL(ctx *context.Context) string {
+	r := ctx.Request()
+	scheme := "http"
+	if r.TLS != nil || ctx.GetHeader("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	path := r.URL.Path
+	if i := strings.LastIndex(path, "/sso/"); i >= 0 {
+		path = path[:i+len("/sso")]
+	}
+	return fmt.Sprintf("%s://%s%s/saml", scheme, r.Host, path)
+}
+
+func (h *Handler) samlServiceProvider(ctx *context.Context) (*saml.ServiceProvider, error) {
+	ssos, err := h.ssoService.List(common.DBOptions{})
+	if err != nil {
+		return nil, err
+	}
+	if len(ssos) == 0 || ssos[0].Protocol != v1Sso.ProtocolSaml {
+		return nil, errors.New("saml is not configured")
+	}
+	return h.ssoService.SamlServiceProvider(&ssos[0], samlBaseURL(ctx))
+}
+
+// SamlMetadata 服务提供方的元数据,导入到身份提供方
+func (h *Handler) SamlMetadata() iris.Handler {
+	return func(ctx *context.Context) {
+		sp, err := h.samlServiceProvider(ctx)
+		if err != nil {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		buf, err := xml.MarshalIndent(sp.Metadata(), "", "  ")
+		if err != nil {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		ctx.ContentType("application/samlmetadata+xml")
+		_, _ = ctx.Write(buf)
+	}
+}
+
+// SamlAcs 接收身份提供方 POST 的断言
+func (h *Handler) SamlAcs() iris.Handler {
+	return func(ctx *context.Context) {
+		sp, err := h.samlServiceProvider(ctx)
+		if err != nil {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		r := ctx.Request()
+		if err := r.ParseForm(); err != nil {
+			ctx.StatusCode(iris.StatusBadRequest)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		requestID, ok := ssoClient.VerifyRelayState(r.PostForm.Get("RelayState"), server.Config().Spec.Jwt.Key)
+		if !ok {
+			ctx.StatusCode(iris.StatusBadRequest)
+			ctx.Values().Set("message", "invalid saml relay state")
+			return
+		}
+		language := ctx.GetHeader("Accept-Language")
+		if strings.Contains(language, "zh-CN") {
+			language = "zh-CN"
+		} else {
+			language = "en-US"
+		}
+		userProfile, err := h.ssoService.Saml(sp, r, []string{requestID}, language, common.DBOptions{})
+		if err != nil {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		startSession(ctx, userProfile)
+	}
+}
+
 func (h *Handler) TestConnect() iris.Handler {
 	return func(ctx *context.Context) {
 		var req v1Sso.Sso
@@ -183,14 +302,18 @@ func (h *Handler) StatusSso() iris.Handler {
 	}
 }
 
-func Install(parent iris.Party) {
+// Install 配置相关的接口需要登录并授权,登录流程的接口无需认证
+func Install(parent iris.Party, noAuthParty iris.Party) {
 	handler := NewHandler()
 	sp := parent.Party("/sso")
 	sp.Get("/", handler.ListSso())
 	sp.Post("/", handler.AddSso())
 	sp.Put("/", handler.UpdateSso())
-	sp.Get("/login", handler.LoginSso())
-	sp.Get("/callback", handler.CallbackSso())
 	sp.Post("/test/connect", handler.TestConnect())
-	sp.Get("/status", handler.StatusSso())
+	np := noAuthParty.Party("/sso")
+	np.Get("/login", handler.LoginSso())
+	np.Get("/callback", handler.CallbackSso())
+	np.Get("/status", handler.StatusSso())
+	np.Get("/saml/metadata", handler.SamlMetadata())
+	np.Post("/saml/acs", handler.SamlAcs())
 }
@@ -4,6 +4,7 @@ import (
 	"errors"
 
 	"github.com/KubeOperator/kubepi/internal/api/v1/commons"
+	"github.com/KubeOperator/kubepi/internal/api/v1/session"
 	"github.com/KubeOperator/kubepi/internal/service/v1/common"
 	"github.com/KubeOperator/kubepi/internal/service/v1/system"
 	pkgV1 "github.com/KubeOperator/kubepi/pkg/api/v1"
@@ -13,12 +14,14 @@ import (
 )
 
 type Handler struct {
-	systemService system.Service
+	systemService  system.Service
+	sessionHandler *session.Handler
 }
 
 func NewHandler() *Handler {
 	return &Handler{
-		systemService: system.NewService(),
+		systemService:  system.NewService(),
+		sessionHandler: session.NewHandler(),
 	}
 }
 
@@ -73,4 +76,8 @@ func Install(parent iris.Party) {
 	sp := parent.Party("/systems")
 	sp.Post("/login/logs/search", handler.LoginLogsSearch())
 	sp.Post("/operation/logs/search", handler.OperationLogsSearch())
+	sp.Get("/sessions", handler.ListSessions())
+	sp.Delete("/sessions/:name", handler.DeleteSession())
+	sp.Get("/lockouts", handler.ListLockouts())
+	sp.Delete("/lockouts/:name", handler.DeleteLockout())
 }
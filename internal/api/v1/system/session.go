@@ -0,0 +1,115 @@
+package system
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/KubeOperator/kubepi/internal/service/v1/common"
+	"github.com/asdine/storm/v3"
+	"github.com/kataras/iris/v12"
+	"github.com/kataras/iris/v12/context"
+)
+
+// List Sessions
+// @Tags systems
+// @Summary List active sessions
+// @Description List active sessions of all users
+// @Accept  json
+// @Produce  json
+// @Param user query string false "用户名称"
+// @Success 200 {object} []v1System.Session
+// @Security ApiKeyAuth
+// @Router /systems/sessions [get]
+func (h *Handler) ListSessions() iris.Handler {
+	return func(ctx *context.Context) {
+		ss, err := h.systemService.ListSessions(ctx.URLParam("user"), common.DBOptions{})
+		if err != nil {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		for i := range ss {
+			ss[i].CookieID = ""
+		}
+		ctx.Values().Set("data", ss)
+	}
+}
+
+// Delete Session
+// @Tags systems
+// @Summary Terminate session
+// @Description Force logout an active session
+// @Accept  json
+// @Produce  json
+// @Param name path string true "会话ID"
+// @Success 200 {number} 200
+// @Security ApiKeyAuth
+// @Router /systems/sessions/{name} [delete]
+func (h *Handler) DeleteSession() iris.Handler {
+	return func(ctx *context.Context) {
+		name := ctx.Params().GetString("name")
+		s, err := h.systemService.GetSession(name, common.DBOptions{})
+		if err != nil {
+			if errors.Is(err, storm.ErrNotFound) {
+				ctx.StatusCode(iris.StatusNotFound)
+				ctx.Values().Set("message", fmt.Sprintf("session %s not found", name))
+				return
+			}
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		if err := h.sessionHandler.TerminateSession(s); err != nil {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+	}
+}
+
+// List Lockouts
+// @Tags systems
+// @Summary List login lockouts
+// @Description List failed login counters and locked users or ips
+// @Accept  json
+// @Produce  json
+// @Success 200 {object} []v1System.Lockout
+// @Security ApiKeyAuth
+// @Router /systems/lockouts [get]
+func (h *Handler) ListLockouts() iris.Handler {
+	return func(ctx *context.Context) {
+		ls, err := h.systemService.ListLockouts(common.DBOptions{})
+		if err != nil {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		ctx.Values().Set("data", ls)
+	}
+}
+
+// Unlock
+// @Tags systems
+// @Summary Unlock user or ip
+// @Description Clear failed login counter and unlock
+// @Accept  json
+// @Produce  json
+// @Param name path string true "锁定名称"
+// @Success 200 {number} 200
+// @Security ApiKeyAuth
+// @Router /systems/lockouts/{name} [delete]
+func (h *Handler) DeleteLockout() iris.Handler {
+	return func(ctx *context.Context) {
+		name := ctx.Params().GetString("name")
+		if err := h.systemService.DeleteLockout(name, common.DBOptions{}); err != nil {
+			if errors.Is(err, storm.ErrNotFound) {
+				ctx.StatusCode(iris.StatusNotFound)
+				ctx.Values().Set("message", fmt.Sprintf("lockout %s not found", name))
+				return
+			}
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+	}
+}
@@ -0,0 +1,216 @@
+package token
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/KubeOperator/kubepi/internal/api/v1/session"
+	v1Token "github.com/KubeOperator/kubepi/internal/model/v1/token"
+	"github.com/KubeOperator/kubepi/internal/service/v1/common"
+	"github.com/KubeOperator/kubepi/internal/service/v1/role"
+	"github.com/KubeOperator/kubepi/internal/service/v1/rolebinding"
+	"github.com/KubeOperator/kubepi/internal/service/v1/token"
+	"github.com/KubeOperator/kubepi/pkg/collectons"
+	"github.com/asdine/storm/v3"
+	"github.com/kataras/iris/v12"
+	"github.com/kataras/iris/v12/context"
+)
+
+type Handler struct {
+	tokenService       token.Service
+	roleService        role.Service
+	roleBindingService rolebinding.Service
+}
+
+func NewHandler() *Handler {
+	return &Handler{
+		tokenService:       token.NewService(),
+		roleService:        role.NewService(),
+		roleBindingService: rolebinding.NewService(),
+	}
+}
+
+// List Tokens
+// @Tags tokens
+// @Summary List personal access tokens
+// @Description List personal access tokens of current user
+// @Accept  json
+// @Produce  json
+// @Success 200 {object} []v1Token.Token
+// @Security ApiKeyAuth
+// @Router /tokens [get]
+func (h *Handler) ListTokens() iris.Handler {
+	return func(ctx *context.Context) {
+		profile := ctx.Values().Get("profile").(session.UserProfile)
+		ts, err := h.tokenService.ListByUser(profile.Name, common.DBOptions{})
+		if err != nil && !errors.Is(err, storm.ErrNotFound) {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		for i := range ts {
+			ts[i].Hash = ""
+		}
+		ctx.Values().Set("data", ts)
+	}
+}
+
+// Create Token
+// @Tags tokens
+// @Summary Create personal access token
+// @Description Create personal access token, the token is only returned once
+// @Accept  json
+// @Produce  json
+// @Param request body CreateRequest true "request"
+// @Success 200 {object} CreatedToken
+// @Security ApiKeyAuth
+// @Router /tokens [post]
+func (h *Handler) CreateToken() iris.Handler {
+	return func(ctx *context.Context) {
+		var req CreateRequest
+		if err := ctx.ReadJSON(&req); err != nil {
+			ctx.StatusCode(iris.StatusBadRequest)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		profile := ctx.Values().Get("profile").(session.UserProfile)
+		if req.Name == "" {
+			ctx.StatusCode(iris.StatusBadRequest)
+			ctx.Values().Set("message", "token name can not be none")
+			return
+		}
+		if !req.ExpireAt.IsZero() && req.ExpireAt.Before(time.Now()) {
+			ctx.StatusCode(iris.StatusBadRequest)
+			ctx.Values().Set("message", "token expire time must be in the future")
+			return
+		}
+		if err := h.validateScopes(profile, req.Scopes); err != nil {
+			ctx.StatusCode(iris.StatusBadRequest)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		t := v1Token.Token{
+			UserRef:  profile.Name,
+			Scopes:   req.Scopes,
+			ExpireAt: req.ExpireAt,
+		}
+		t.Name = fmt.Sprintf("%s-%s", profile.Name, req.Name)
+		t.Kind = "Token"
+		t.ApiVersion = "v1"
+		t.CreatedBy = profile.Name
+		raw, err := h.tokenService.Create(&t, common.DBOptions{})
+		if err != nil {
+			if errors.Is(err, storm.ErrAlreadyExists) {
+				ctx.StatusCode(iris.StatusBadRequest)
+				ctx.Values().Set("message", "already exists")
+				return
+			}
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		t.Hash = ""
+		ctx.Values().Set("data", CreatedToken{Token: t, AccessToken: raw})
+	}
+}
+
+// Delete Token
+// @Tags tokens
+// @Summary Delete personal access token
+// @Description Delete personal access token by name
+// @Accept  json
+// @Produce  json
+// @Param name path string true "令牌名称"
+// @Success 200 {number} 200
+// @Security ApiKeyAuth
+// @Router /tokens/{name} [delete]
+func (h *Handler) DeleteToken() iris.Handler {
+	return func(ctx *context.Context) {
+		name := ctx.Params().GetString("name")
+		profile := ctx.Values().Get("profile").(session.UserProfile)
+		t, err := h.tokenService.Get(name, common.DBOptions{})
+		if err != nil {
+			if errors.Is(err, storm.ErrNotFound) {
+				ctx.StatusCode(iris.StatusNotFound)
+				ctx.Values().Set("message", fmt.Sprintf("token %s not found", name))
+				return
+			}
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		if t.UserRef != profile.Name && !profile.IsAdministrator {
+			ctx.StatusCode(iris.StatusForbidden)
+			ctx.Values().Set("message", []string{"user %s can not access resource %s %s", profile.Name, "tokens", "delete"})
+			return
+		}
+		if err := h.tokenService.Delete(name, common.DBOptions{}); err != nil {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+	}
+}
+
+// validateScopes 令牌权限不能超出用户自身拥有的角色
+func (h *Handler) validateScopes(profile session.UserProfile, scopes []string) error {
+	if len(scopes) == 0 {
+		return errors.New("must select one scope")
+	}
+	requested := collectons.NewStringSet()
+	for i := range scopes {
+		if scopes[i] == v1Token.ScopeAll {
+			continue
+		}
+		requested.Add(scopes[i])
+	}
+	if len(requested.ToSlice()) == 0 {
+		return nil
+	}
+	if profile.IsAdministrator {
+		rs, err := h.roleService.GetByNames(requested.ToSlice(), common.DBOptions{})
+		if err != nil && !errors.Is(err, storm.ErrNotFound) {
+			return err
+		}
+		if len(rs) != len(requested.ToSlice()) {
+			return errors.New("invalid token scopes")
+		}
+		return nil
+	}
+	rbs, err := h.roleBindingService.GetRoleBindingsByUser(profile.Name, common.DBOptions{})
+	if err != nil && !errors.Is(err, storm.ErrNotFound) {
+		return err
+	}
+	owned := collectons.NewStringSet()
+	for i := range rbs {
+		owned.Add(rbs[i].RoleRef)
+	}
+	for _, s := range requested.ToSlice() {
+		if !owned.Exists(s) {
+			return errors.New("invalid token scopes")
+		}
+	}
+	return nil
+}
+
+// accessTokenGuard 不允许使用个人访问令牌管理令牌
+func accessTokenGuard() iris.Handler {
+	return func(ctx *context.Context) {
+		if ctx.Values().Get("accessToken") != nil {
+			ctx.StatusCode(iris.StatusForbidden)
+			ctx.Values().Set("message", "can not manage tokens with access token")
+			return
+		}
+		ctx.Next()
+	}
+}
+
+func Install(parent iris.Party) {
+	handler := NewHandler()
+	sp := parent.Party("/tokens")
+	sp.Use(accessTokenGuard())
+	sp.Get("/", handler.ListTokens())
+	sp.Post("/", handler.CreateToken())
+	sp.Delete("/:name", handler.DeleteToken())
+}
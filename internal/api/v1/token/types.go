@@ -0,0 +1,18 @@
+package token
+
+import (
+	"time"
+
+	v1Token "github.com/KubeOperator/kubepi/internal/model/v1/token"
+)
+
+type CreateRequest struct {
+	Name     string    `json:"name"`
+	Scopes   []string  `json:"scopes"`
+	ExpireAt time.Time `json:"expireAt"`
+}
+
+type CreatedToken struct {
+	v1Token.Token
+	AccessToken string `json:"token"`
+}
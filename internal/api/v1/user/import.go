@@ -0,0 +1,353 @@
+package user
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	clusterApi "github.com/KubeOperator/kubepi/internal/api/v1/cluster"
+	"github.com/KubeOperator/kubepi/internal/api/v1/session"
+	v1 "github.com/KubeOperator/kubepi/internal/model/v1"
+	v1Cluster "github.com/KubeOperator/kubepi/internal/model/v1/cluster"
+	v1User "github.com/KubeOperator/kubepi/internal/model/v1/user"
+	"github.com/KubeOperator/kubepi/internal/service/v1/common"
+	"github.com/KubeOperator/kubepi/pkg/collectons"
+	"github.com/KubeOperator/kubepi/pkg/util/password"
+	"github.com/asdine/storm/v3"
+	"github.com/kataras/iris/v12"
+	"github.com/kataras/iris/v12/context"
+)
+
+// csv 的列,roles 以分号分隔;clusters 的每一项为 cluster:role|role 或 cluster/namespace:role|role,以分号分隔
+var importColumns = []string{"name", "nickName", "email", "password", "roles", "clusters"}
+
+// Import Users
+// @Tags users
+// @Summary Import local users
+// @Description 从 csv 或 json 批量导入本地用户,dryRun 时只返回校验结果
+// @Accept  json
+// @Produce  json
+// @Param request body ImportRequest true "request"
+// @Success 200 {object} ImportResult
+// @Security ApiKeyAuth
+// @Router /users/import [post]
+func (h *Handler) ImportUsers() iris.Handler {
+	return func(ctx *context.Context) {
+		var req ImportRequest
+		if err := ctx.ReadJSON(&req); err != nil {
+			ctx.StatusCode(iris.StatusBadRequest)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		users := req.Users
+		switch req.Format {
+		case importFormatCSV:
+			parsed, err := parseImportCSV(req.Content)
+			if err != nil {
+				ctx.StatusCode(iris.StatusBadRequest)
+				ctx.Values().Set("message", []string{"invalid csv: %s", err.Error()})
+				return
+			}
+			users = parsed
+		case "", importFormatJSON:
+		default:
+			ctx.StatusCode(iris.StatusBadRequest)
+			ctx.Values().Set("message", []string{"unsupported import format %s", req.Format})
+			return
+		}
+		if len(users) == 0 {
+			ctx.StatusCode(iris.StatusBadRequest)
+			ctx.Values().Set("message", "no users to import")
+			return
+		}
+		u := ctx.Values().Get("profile")
+		profile := u.(session.UserProfile)
+		ctx.Values().Set("data", h.importUsers(users, req.DryRun, req.GeneratePassword, profile))
+	}
+}
+
+func (h *Handler) importUsers(users []ImportUser, dryRun, generatePassword bool, profile session.UserProfile) ImportResult {
+	result := ImportResult{DryRun: dryRun, Rows: make([]ImportRow, 0, len(users))}
+	privileges := h.importPrivileges(profile)
+	seen := map[string]int{}
+	for i := range users {
+		imp := users[i]
+		row := ImportRow{Row: i + 1, Name: imp.Name}
+		if err := h.validateImportUser(&imp, generatePassword, seen, privileges); err != nil {
+			row.Msg = err.Error()
+		} else {
+			// 只记录校验通过的行,校验失败的行不影响后面的行
+			for _, key := range []string{imp.Name, imp.Email} {
+				seen[strings.ToLower(key)] = row.Row
+			}
+			if dryRun {
+				row.Success = true
+			} else {
+				row.Password, row.Success, row.Msg = h.createImportUser(&imp, profile)
+			}
+		}
+		if !row.Success {
+			result.Failures = append(result.Failures, imp.Name)
+		}
+		result.Rows = append(result.Rows, row)
+	}
+	result.Success = len(result.Failures) == 0
+	return result
+}
+
+// importPrivileges 非管理员只能授予自己拥有的角色,并且只能添加自己管理的集群的成员
+type importPrivileges struct {
+	admin        bool
+	roles        *collectons.StringSet
+	rolesErr     error
+	clusterAdmin func(clusterName string) (bool, error)
+}
+
+func (h *Handler) importPrivileges(profile session.UserProfile) *importPrivileges {
+	p := &importPrivileges{admin: profile.IsAdministrator, roles: collectons.NewStringSet()}
+	if p.admin {
+		return p
+	}
+	bindings, err := h.roleBindingService.GetRoleBindingsByUser(profile.Name, common.DBOptions{})
+	if err != nil && !errors.Is(err, storm.ErrNotFound) {
+		p.rolesErr = err
+	}
+	for i := range bindings {
+		p.roles.Add(bindings[i].RoleRef)
+	}
+	clusterAdmin := map[string]bool{}
+	p.clusterAdmin = func(clusterName string) (bool, error) {
+		if ok, cached := clusterAdmin[clusterName]; cached {
+			return ok, nil
+		}
+		c, err := h.clusterService.Get(clusterName, common.DBOptions{})
+		if err != nil {
+			return false, err
+		}
+		ok, err := h.clusterHandler.IsClusterAdmin(c, profile)
+		if err != nil {
+			return false, err
+		}
+		clusterAdmin[clusterName] = ok
+		return ok, nil
+	}
+	return p
+}
+
+func (p *importPrivileges) check(imp *ImportUser) error {
+	if p.admin {
+		return nil
+	}
+	if p.rolesErr != nil {
+		return p.rolesErr
+	}
+	for _, role := range imp.Roles {
+		if !p.roles.Exists(role) {
+			return fmt.Errorf("can not grant role %s which you do not have", role)
+		}
+	}
+	for _, m := range imp.Clusters {
+		ok, err := p.clusterAdmin(m.Cluster)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("you are not an administrator of cluster %s", m.Cluster)
+		}
+	}
+	return nil
+}
+
+// validateImportUser 校验一行数据,seen 记录文件中已出现的用户名和邮箱
+func (h *Handler) validateImportUser(imp *ImportUser, generatePassword bool, seen map[string]int, privileges *importPrivileges) error {
+	if imp.Name == "" {
+		return errors.New("username can not be none")
+	}
+	if imp.Email == "" {
+		return errors.New("email can not be none")
+	}
+	for _, key := range []string{imp.Name, imp.Email} {
+		if row, ok := seen[strings.ToLower(key)]; ok {
+			return fmt.Errorf("%s is duplicated with row %d", key, row)
+		}
+	}
+	if _, err := h.userService.GetByNameOrEmail(imp.Name, common.DBOptions{}); err == nil {
+		return errors.New("username already exists")
+	} else if !errors.Is(err, storm.ErrNotFound) {
+		return err
+	}
+	if _, err := h.userService.GetByNameOrEmail(imp.Email, common.DBOptions{}); err == nil {
+		return errors.New("email already exists")
+	} else if !errors.Is(err, storm.ErrNotFound) {
+		return err
+	}
+	if imp.Password == "" && !generatePassword {
+		return errors.New("password can not be none")
+	}
+	if imp.Password != "" {
+		if err := h.userService.ValidatePassword(imp.Password); err != nil {
+			var pe *password.ViolationError
+			if errors.As(err, &pe) {
+				return pe
+			}
+			return err
+		}
+	}
+	for _, role := range imp.Roles {
+		if _, err := h.roleService.Get(role, common.DBOptions{}); err != nil {
+			if errors.Is(err, storm.ErrNotFound) {
+				return fmt.Errorf("role %s not found", role)
+			}
+			return err
+		}
+	}
+	for _, m := range imp.Clusters {
+		if _, err := h.clusterService.Get(m.Cluster, common.DBOptions{}); err != nil {
+			if errors.Is(err, storm.ErrNotFound) {
+				return fmt.Errorf("cluster %s not found", m.Cluster)
+			}
+			return err
+		}
+		if len(m.ClusterRoles) == 0 && len(m.NamespaceRoles) == 0 {
+			return fmt.Errorf("cluster %s: must select one role", m.Cluster)
+		}
+	}
+	return privileges.check(imp)
+}
+
+// createImportUser 返回生成的初始密码;集群成员创建失败时用户仍然保留
+func (h *Handler) createImportUser(imp *ImportUser, profile session.UserProfile) (string, bool, string) {
+	us := v1User.User{
+		Metadata: v1.Metadata{
+			Name: imp.Name,
+		},
+		NickName: imp.NickName,
+		Email:    imp.Email,
+		Language: profile.Language,
+		Authenticate: v1User.Authenticate{
+			Password: imp.Password,
+		},
+	}
+	if us.NickName == "" {
+		us.NickName = us.Name
+	}
+	var generated string
+	if imp.Password == "" {
+		pw, err := h.userService.GeneratePassword()
+		if err != nil {
+			return "", false, err.Error()
+		}
+		generated = pw
+		us.Authenticate.Password = pw
+		us.Authenticate.ForceChange = true
+	}
+	if err := h.createUser(&us, imp.Roles, profile.Name); err != nil {
+		return "", false, err.Error()
+	}
+	var failures []string
+	for _, m := range imp.Clusters {
+		member := clusterApi.Member{
+			Name:           imp.Name,
+			Kind:           "User",
+			ClusterRoles:   m.ClusterRoles,
+			NamespaceRoles: m.NamespaceRoles,
+		}
+		if err := h.clusterHandler.CreateMember(m.Cluster, &member, time.Time{}, profile.Name); err != nil {
+			failures = append(failures, fmt.Sprintf("cluster %s: %s", m.Cluster, err.Error()))
+		}
+	}
+	if len(failures) > 0 {
+		return generated, false, fmt.Sprintf("user created, but %s", strings.Join(failures, "; "))
+	}
+	return generated, true, ""
+}
+
+// parseImportCSV 第一行为表头,列的顺序不限
+func parseImportCSV(content string) ([]ImportUser, error) {
+	r := csv.NewReader(strings.NewReader(content))
+	r.TrimLeadingSpace = true
+	header, err := r.Read()
+	if err != nil {
+		return nil, err
+	}
+	index := map[string]int{}
+	for i := range header {
+		for _, c := range importColumns {
+			if strings.EqualFold(strings.TrimSpace(header[i]), c) {
+				index[c] = i
+			}
+		}
+	}
+	if _, ok := index["name"]; !ok {
+		return nil, errors.New("column name is required")
+	}
+	var users []ImportUser
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		get := func(column string) string {
+			if i, ok := index[column]; ok && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+		clusters, err := parseImportClusters(get("clusters"))
+		if err != nil {
+			return nil, fmt.Errorf("user %s: %s", get("name"), err.Error())
+		}
+		users = append(users, ImportUser{
+			Name:     get("name"),
+			NickName: get("nickName"),
+			Email:    get("email"),
+			Password: get("password"),
+			Roles:    splitValues(get("roles"), ";"),
+			Clusters: clusters,
+		})
+	}
+	return users, nil
+}
+
+func parseImportClusters(value string) ([]ImportClusterMember, error) {
+	var members []ImportClusterMember
+	index := map[string]int{}
+	for _, item := range splitValues(value, ";") {
+		target, roles, ok := strings.Cut(item, ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid cluster member %s", item)
+		}
+		clusterName, namespace, _ := strings.Cut(target, "/")
+		i, ok := index[clusterName]
+		if !ok {
+			members = append(members, ImportClusterMember{Cluster: clusterName})
+			i = len(members) - 1
+			index[clusterName] = i
+		}
+		if namespace == "" {
+			members[i].ClusterRoles = append(members[i].ClusterRoles, splitValues(roles, "|")...)
+			continue
+		}
+		members[i].NamespaceRoles = append(members[i].NamespaceRoles, v1Cluster.NamespaceRoles{
+			Namespace: namespace,
+			Roles:     splitValues(roles, "|"),
+		})
+	}
+	return members, nil
+}
+
+func splitValues(value, sep string) []string {
+	var values []string
+	for _, v := range strings.Split(value, sep) {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
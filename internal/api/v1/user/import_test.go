@@ -0,0 +1,70 @@
+package user
+
+import (
+	"testing"
+
+	"github.com/KubeOperator/kubepi/pkg/collectons"
+)
+
+func TestImportPrivilegesCheck(t *testing.T) {
+	roles := collectons.NewStringSet()
+	roles.Add("Common User")
+	importer := &importPrivileges{
+		roles: roles,
+		clusterAdmin: func(clusterName string) (bool, error) {
+			return clusterName == "owned", nil
+		},
+	}
+	admin := &importPrivileges{admin: true}
+	tests := []struct {
+		name       string
+		privileges *importPrivileges
+		user       ImportUser
+		wantErr    bool
+	}{
+		{name: "held role", privileges: importer, user: ImportUser{Roles: []string{"Common User"}}},
+		{name: "role not held", privileges: importer, user: ImportUser{Roles: []string{"Administrator"}}, wantErr: true},
+		{name: "administered cluster", privileges: importer, user: ImportUser{Clusters: []ImportClusterMember{{Cluster: "owned", ClusterRoles: []string{"cluster-owner"}}}}},
+		{name: "other cluster", privileges: importer, user: ImportUser{Clusters: []ImportClusterMember{{Cluster: "other", ClusterRoles: []string{"cluster-owner"}}}}, wantErr: true},
+		{name: "admin", privileges: admin, user: ImportUser{Roles: []string{"Administrator"}, Clusters: []ImportClusterMember{{Cluster: "other", ClusterRoles: []string{"cluster-owner"}}}}},
+	}
+	for _, tt := range tests {
+		err := tt.privileges.check(&tt.user)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: got error %v, want error %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestParseImportCSV(t *testing.T) {
+	content := "email, name,roles,clusters\n" +
+		"a@example.com,a,Common User;ReadOnly,\"c1:cluster-viewer;c1/default:namespace-owner|namespace-viewer\"\n" +
+		"b@example.com,b,,\n"
+	users, err := parseImportCSV(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 2 {
+		t.Fatalf("got %d users, want 2", len(users))
+	}
+	a := users[0]
+	if a.Name != "a" || a.Email != "a@example.com" || len(a.Roles) != 2 || a.Roles[1] != "ReadOnly" {
+		t.Errorf("unexpected user %+v", a)
+	}
+	if len(a.Clusters) != 1 || len(a.Clusters[0].ClusterRoles) != 1 || len(a.Clusters[0].NamespaceRoles) != 1 {
+		t.Fatalf("unexpected clusters %+v", a.Clusters)
+	}
+	if ns := a.Clusters[0].NamespaceRoles[0]; ns.Namespace != "default" || len(ns.Roles) != 2 {
+		t.Errorf("unexpected namespace roles %+v", ns)
+	}
+	if b := users[1]; b.Name != "b" || len(b.Roles) != 0 || len(b.Clusters) != 0 {
+		t.Errorf("unexpected user %+v", b)
+	}
+
+	if _, err := parseImportCSV("email\na@example.com\n"); err == nil {
+		t.Error("expected error without name column")
+	}
+	if _, err := parseImportCSV("name,clusters\na,c1\n"); err == nil {
+		t.Error("expected error for cluster member without roles")
+	}
+}
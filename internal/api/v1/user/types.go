@@ -1,6 +1,9 @@
 package user
 
-import v1User "github.com/KubeOperator/kubepi/internal/model/v1/user"
+import (
+	v1Cluster "github.com/KubeOperator/kubepi/internal/model/v1/cluster"
+	v1User "github.com/KubeOperator/kubepi/internal/model/v1/user"
+)
 
 type User struct {
 	v1User.User
@@ -8,3 +11,51 @@ type User struct {
 	OldPassword string   `json:"oldPassword"`
 	Password    string   `json:"password"`
 }
+
+const (
+	importFormatCSV  = "csv"
+	importFormatJSON = "json"
+)
+
+// ImportRequest Format 为 csv 时解析 Content,否则使用 Users
+type ImportRequest struct {
+	Format  string       `json:"format"`
+	Content string       `json:"content"`
+	Users   []ImportUser `json:"users"`
+	// DryRun 只校验不创建
+	DryRun bool `json:"dryRun"`
+	// GeneratePassword 未填写密码的用户生成随机初始密码,首次登录时必须修改
+	GeneratePassword bool `json:"generatePassword"`
+}
+
+type ImportUser struct {
+	Name     string                `json:"name"`
+	NickName string                `json:"nickName"`
+	Email    string                `json:"email"`
+	Password string                `json:"password"`
+	Roles    []string              `json:"roles"`
+	Clusters []ImportClusterMember `json:"clusters"`
+}
+
+type ImportClusterMember struct {
+	Cluster        string                     `json:"cluster"`
+	ClusterRoles   []string                   `json:"clusterRoles"`
+	NamespaceRoles []v1Cluster.NamespaceRoles `json:"namespaceRoles"`
+}
+
+// ImportResult 与 ldap 导入的结果一致,Rows 为每一行的结果
+type ImportResult struct {
+	Success  bool        `json:"success"`
+	DryRun   bool        `json:"dryRun"`
+	Failures []string    `json:"failures"`
+	Rows     []ImportRow `json:"rows"`
+}
+
+type ImportRow struct {
+	Row     int    `json:"row"`
+	Name    string `json:"name"`
+	Success bool   `json:"success"`
+	Msg     string `json:"msg"`
+	// Password 生成的初始密码,只在导入完成时返回一次
+	Password string `json:"password,omitempty"`
+}
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 
+	clusterApi "github.com/KubeOperator/kubepi/internal/api/v1/cluster"
 	"github.com/KubeOperator/kubepi/internal/api/v1/commons"
 	"github.com/KubeOperator/kubepi/internal/api/v1/session"
 	v1 "github.com/KubeOperator/kubepi/internal/model/v1"
@@ -13,11 +14,15 @@ import (
 	"github.com/KubeOperator/kubepi/internal/service/v1/cluster"
 	"github.com/KubeOperator/kubepi/internal/service/v1/clusterbinding"
 	"github.com/KubeOperator/kubepi/internal/service/v1/common"
+	"github.com/KubeOperator/kubepi/internal/service/v1/group"
+	"github.com/KubeOperator/kubepi/internal/service/v1/role"
 	"github.com/KubeOperator/kubepi/internal/service/v1/rolebinding"
+	"github.com/KubeOperator/kubepi/internal/service/v1/token"
 	"github.com/KubeOperator/kubepi/internal/service/v1/user"
 	pkgV1 "github.com/KubeOperator/kubepi/pkg/api/v1"
 	"github.com/KubeOperator/kubepi/pkg/collectons"
 	"github.com/KubeOperator/kubepi/pkg/kubernetes"
+	"github.com/KubeOperator/kubepi/pkg/util/password"
 	"github.com/asdine/storm/v3"
 	"github.com/kataras/iris/v12"
 	"github.com/kataras/iris/v12/context"
@@ -28,6 +33,11 @@ type Handler struct {
 	roleBindingService    rolebinding.Service
 	clusterBindingService clusterbinding.Service
 	clusterService        cluster.Service
+	tokenService          token.Service
+	groupService          group.Service
+	roleService           role.Service
+	sessionHandler        *session.Handler
+	clusterHandler        *clusterApi.Handler
 }
 
 func NewHandler() *Handler {
@@ -36,6 +46,11 @@ func NewHandler() *Handler {
 		roleBindingService:    rolebinding.NewService(),
 		clusterBindingService: clusterbinding.NewService(),
 		clusterService:        cluster.NewService(),
+		tokenService:          token.NewService(),
+		groupService:          group.NewService(),
+		roleService:           role.NewService(),
+		sessionHandler:        session.NewHandler(),
+		clusterHandler:        clusterApi.NewHandler(),
 	}
 }
 
@@ -70,9 +85,9 @@ func (h *Handler) SearchUsers() iris.Handler {
 		}
 		us := make([]User, 0)
 		for i := range users {
-			users[i].Authenticate = v1User.Authenticate{}
+			clearSecrets(&users[i])
 			bindings, err := h.roleBindingService.GetRoleBindingBySubject(v1Role.Subject{Kind: "User", Name: users[i].Name}, common.DBOptions{})
-			if err != nil && !errors.As(err, &storm.ErrNotFound) {
+			if err != nil && !errors.Is(err, storm.ErrNotFound) {
 				ctx.StatusCode(iris.StatusInternalServerError)
 				ctx.Values().Set("message", err.Error())
 				return
@@ -108,22 +123,20 @@ func (h *Handler) CreateUser() iris.Handler {
 			ctx.Values().Set("message", err.Error())
 			return
 		}
+		if err := h.userService.ValidatePassword(req.Authenticate.Password); err != nil {
+			var pe *password.ViolationError
+			if errors.As(err, &pe) {
+				ctx.StatusCode(iris.StatusBadRequest)
+				ctx.Values().Set("message", pe.Message())
+				return
+			}
+		}
 		u := ctx.Values().Get("profile")
 		profile := u.(session.UserProfile)
-		//tx
-		tx, err := server.DB().Begin(true)
-		if err != nil {
-			ctx.StatusCode(iris.StatusInternalServerError)
-			ctx.Values().Set("message", err.Error())
-			return
-		}
-		req.CreatedBy = profile.Name
 		if req.Language == "" {
 			req.Language = profile.Language
 		}
-		req.Type = v1User.LOCAL
-		if err := h.userService.Create(&req.User, common.DBOptions{DB: tx}); err != nil {
-			_ = tx.Rollback()
+		if err := h.createUser(&req.User, req.Roles, profile.Name); err != nil {
 			if errors.Is(err, storm.ErrAlreadyExists) {
 				u, _ := h.userService.GetByNameOrEmail(req.User.Name, common.DBOptions{})
 				if u != nil {
@@ -142,38 +155,48 @@ func (h *Handler) CreateUser() iris.Handler {
 			ctx.Values().Set("message", err.Error())
 			return
 		}
-		if len(req.Roles) > 0 {
-			for i := range req.Roles {
-				roleName := req.Roles[i]
-				binding := v1Role.Binding{
-					BaseModel: v1.BaseModel{
-						Kind:       "RoleBind",
-						ApiVersion: "v1",
-						CreatedBy:  profile.Name,
-					},
-					Metadata: v1.Metadata{
-						Name: fmt.Sprintf("role-binding-%s-%s", roleName, req.Name),
-					},
-					Subject: v1Role.Subject{
-						Kind: "User",
-						Name: req.Name,
-					},
-					RoleRef: roleName,
-				}
-				if err := h.roleBindingService.CreateRoleBinding(&binding, common.DBOptions{DB: tx}); err != nil {
-					_ = tx.Rollback()
-					ctx.StatusCode(iris.StatusInternalServerError)
-					ctx.Values().Set("message", err.Error())
-					return
-				}
-			}
-		}
-		_ = tx.Commit()
 		req.Authenticate = v1User.Authenticate{}
 		ctx.Values().Set("data", req)
 	}
 }
 
+// createUser 在同一个事务中创建本地用户及其角色绑定
+func (h *Handler) createUser(us *v1User.User, roles []string, createdBy string) error {
+	tx, err := server.DB().Begin(true)
+	if err != nil {
+		return err
+	}
+	us.CreatedBy = createdBy
+	us.Type = v1User.LOCAL
+	if err := h.userService.Create(us, common.DBOptions{DB: tx}); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	for i := range roles {
+		roleName := roles[i]
+		binding := v1Role.Binding{
+			BaseModel: v1.BaseModel{
+				Kind:       "RoleBind",
+				ApiVersion: "v1",
+				CreatedBy:  createdBy,
+			},
+			Metadata: v1.Metadata{
+				Name: fmt.Sprintf("role-binding-%s-%s", roleName, us.Name),
+			},
+			Subject: v1Role.Subject{
+				Kind: "User",
+				Name: us.Name,
+			},
+			RoleRef: roleName,
+		}
+		if err := h.roleBindingService.CreateRoleBinding(&binding, common.DBOptions{DB: tx}); err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
 // Delete User
 // @Tags users
 // @Summary Delete user by name
@@ -196,6 +219,11 @@ func (h *Handler) DeleteUser() iris.Handler {
 			ctx.Values().Set("message", fmt.Errorf("can not delete yourself"))
 			return
 		}
+		if err := h.groupService.RemoveUser(userName); err != nil {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
 		tx, _ := server.DB().Begin(true)
 		txOptions := common.DBOptions{DB: tx}
 
@@ -203,7 +231,7 @@ func (h *Handler) DeleteUser() iris.Handler {
 			Kind: "User",
 			Name: userName,
 		}, txOptions)
-		if err != nil && !errors.As(err, &storm.ErrNotFound) {
+		if err != nil && !errors.Is(err, storm.ErrNotFound) {
 			_ = tx.Rollback()
 			ctx.StatusCode(iris.StatusInternalServerError)
 			ctx.Values().Set("message", err.Error())
@@ -218,7 +246,7 @@ func (h *Handler) DeleteUser() iris.Handler {
 			}
 		}
 		cbs, err := h.clusterBindingService.GetBindingsByUserName(userName, txOptions)
-		if err != nil && !errors.As(err, &storm.ErrNotFound) {
+		if err != nil && !errors.Is(err, storm.ErrNotFound) {
 			_ = tx.Rollback()
 			ctx.StatusCode(iris.StatusInternalServerError)
 			ctx.Values().Set("message", err.Error())
@@ -247,6 +275,12 @@ func (h *Handler) DeleteUser() iris.Handler {
 				return
 			}
 		}
+		if err := h.tokenService.DeleteByUser(userName, txOptions); err != nil {
+			_ = tx.Rollback()
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
 		if err := h.userService.Delete(userName, txOptions); err != nil {
 			_ = tx.Rollback()
 			ctx.StatusCode(iris.StatusInternalServerError)
@@ -276,9 +310,9 @@ func (h *Handler) GetUser() iris.Handler {
 			ctx.Values().Set("message", err.Error())
 			return
 		}
-		u.Authenticate = v1User.Authenticate{}
+		clearSecrets(u)
 		bindings, err := h.roleBindingService.GetRoleBindingBySubject(v1Role.Subject{Kind: "User", Name: u.Name}, common.DBOptions{})
-		if err != nil && !errors.As(err, &storm.ErrNotFound) {
+		if err != nil && !errors.Is(err, storm.ErrNotFound) {
 			ctx.StatusCode(iris.StatusInternalServerError)
 			ctx.Values().Set("message", err.Error())
 			return
@@ -308,10 +342,20 @@ func (h *Handler) GetUsers() iris.Handler {
 			ctx.Values().Set("message", err.Error())
 			return
 		}
+		for i := range us {
+			clearSecrets(&us[i])
+		}
 		ctx.Values().Set("data", us)
 	}
 }
 
+// clearSecrets 返回用户信息前清除密码和 mfa 密钥
+func clearSecrets(u *v1User.User) {
+	u.Authenticate = v1User.Authenticate{}
+	u.Mfa.Secret = ""
+	u.Mfa.RecoveryCodes = nil
+}
+
 // Update User
 // @Tags users
 // @Summary Update user by name
@@ -331,8 +375,23 @@ func (h *Handler) UpdateUser() iris.Handler {
 			ctx.Values().Set("message", err.Error())
 			return
 		}
+		u := ctx.Values().Get("profile")
+		profile := u.(session.UserProfile)
 		if req.Password != "" {
-			if err := h.userService.UpdatePassword(userName, req.OldPassword, req.Password, common.DBOptions{}); err != nil {
+			var err error
+			// 管理员不提供原密码时为重置密码,用户下次登录需要修改
+			if req.OldPassword == "" && profile.IsAdministrator && userName != profile.Name {
+				err = h.userService.ResetPassword(userName, req.Password, common.DBOptions{})
+			} else {
+				err = h.userService.UpdatePassword(userName, req.OldPassword, req.Password, common.DBOptions{})
+			}
+			if err != nil {
+				var pe *password.ViolationError
+				if errors.As(err, &pe) {
+					ctx.StatusCode(iris.StatusBadRequest)
+					ctx.Values().Set("message", pe.Message())
+					return
+				}
 				ctx.StatusCode(iris.StatusInternalServerError)
 				ctx.Values().Set("message", "can not match original password")
 				return
@@ -340,8 +399,11 @@ func (h *Handler) UpdateUser() iris.Handler {
 			ctx.Values().Set("data", "ok")
 			return
 		}
-		u := ctx.Values().Get("profile")
-		profile := u.(session.UserProfile)
+		if req.Disabled && userName == profile.Name {
+			ctx.StatusCode(iris.StatusBadRequest)
+			ctx.Values().Set("message", "can not disable yourself")
+			return
+		}
 		tx, err := server.DB().Begin(true)
 		if err != nil {
 			ctx.StatusCode(iris.StatusInternalServerError)
@@ -355,7 +417,7 @@ func (h *Handler) UpdateUser() iris.Handler {
 			return
 		}
 		bindings, err := h.roleBindingService.GetRoleBindingBySubject(v1Role.Subject{Kind: "User", Name: userName}, common.DBOptions{})
-		if err != nil && !errors.As(err, &storm.ErrNotFound) {
+		if err != nil && !errors.Is(err, storm.ErrNotFound) {
 			ctx.StatusCode(iris.StatusInternalServerError)
 			ctx.Values().Set("message", err.Error())
 			return
@@ -408,17 +470,50 @@ func (h *Handler) UpdateUser() iris.Handler {
 		}
 
 		_ = tx.Commit()
+		if req.Disabled {
+			if err := h.sessionHandler.TerminateUserSessions(userName); err != nil {
+				server.Logger().Errorf("can not terminate sessions of user %s: %s", userName, err)
+			}
+		}
 		ctx.Values().Set("data", &req)
 	}
 }
 
+// Reset User Mfa
+// @Tags users
+// @Summary Reset mfa binding of user
+// @Description Reset mfa binding of user, the user binds again on next login
+// @Accept  json
+// @Produce  json
+// @Param name path string true "用户名称"
+// @Success 200 {number} 200
+// @Security ApiKeyAuth
+// @Router /users/{name}/mfa [delete]
+func (h *Handler) ResetUserMfa() iris.Handler {
+	return func(ctx *context.Context) {
+		userName := ctx.Params().GetString("name")
+		if err := h.userService.ResetMfa(userName, common.DBOptions{}); err != nil {
+			if errors.Is(err, storm.ErrNotFound) {
+				ctx.StatusCode(iris.StatusNotFound)
+				ctx.Values().Set("message", fmt.Sprintf("user %s not found", userName))
+				return
+			}
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+	}
+}
+
 func Install(parent iris.Party) {
 	handler := NewHandler()
 	sp := parent.Party("/users")
 	sp.Post("/search", handler.SearchUsers())
 	sp.Post("/", handler.CreateUser())
+	sp.Post("/import", handler.ImportUsers())
 	sp.Delete("/:name", handler.DeleteUser())
 	sp.Get("/:name", handler.GetUser())
 	sp.Put("/:name", handler.UpdateUser())
 	sp.Get("/", handler.GetUsers())
+	sp.Delete("/:name/mfa", handler.ResetUserMfa())
 }
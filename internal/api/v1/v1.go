@@ -13,26 +13,33 @@ import (
 	"github.com/KubeOperator/kubepi/internal/server"
 
 	"github.com/KubeOperator/kubepi/internal/api/v1/file"
+	"github.com/KubeOperator/kubepi/internal/api/v1/group"
 	"github.com/kataras/iris/v12/middleware/jwt"
 
+	"github.com/KubeOperator/kubepi/internal/api/v1/accessrequest"
 	"github.com/KubeOperator/kubepi/internal/api/v1/chart"
 	"github.com/KubeOperator/kubepi/internal/api/v1/cluster"
+	"github.com/KubeOperator/kubepi/internal/api/v1/commons"
 	"github.com/KubeOperator/kubepi/internal/api/v1/imagerepo"
 	"github.com/KubeOperator/kubepi/internal/api/v1/ldap"
 	"github.com/KubeOperator/kubepi/internal/api/v1/proxy"
 	"github.com/KubeOperator/kubepi/internal/api/v1/role"
 	"github.com/KubeOperator/kubepi/internal/api/v1/session"
 	"github.com/KubeOperator/kubepi/internal/api/v1/system"
+	"github.com/KubeOperator/kubepi/internal/api/v1/token"
 	"github.com/KubeOperator/kubepi/internal/api/v1/user"
 	"github.com/KubeOperator/kubepi/internal/api/v1/webkubectl"
 	"github.com/KubeOperator/kubepi/internal/api/v1/ws"
 	v1 "github.com/KubeOperator/kubepi/internal/model/v1"
 	v1Role "github.com/KubeOperator/kubepi/internal/model/v1/role"
 	v1System "github.com/KubeOperator/kubepi/internal/model/v1/system"
+	v1Token "github.com/KubeOperator/kubepi/internal/model/v1/token"
 	"github.com/KubeOperator/kubepi/internal/service/v1/common"
 	v1RoleService "github.com/KubeOperator/kubepi/internal/service/v1/role"
 	v1RoleBindingService "github.com/KubeOperator/kubepi/internal/service/v1/rolebinding"
 	v1SystemService "github.com/KubeOperator/kubepi/internal/service/v1/system"
+	v1TokenService "github.com/KubeOperator/kubepi/internal/service/v1/token"
+	v1UserService "github.com/KubeOperator/kubepi/internal/service/v1/user"
 	pkgV1 "github.com/KubeOperator/kubepi/pkg/api/v1"
 	"github.com/KubeOperator/kubepi/pkg/collectons"
 	"github.com/KubeOperator/kubepi/pkg/i18n"
@@ -42,7 +49,7 @@ import (
 	"github.com/kataras/iris/v12/core/router"
 )
 
-var resourceWhiteList = WhiteList{"sessions", "proxy", "ws", "charts", "webkubectl", "apps", "mfa", "pod"}
+var resourceWhiteList = WhiteList{"sessions", "proxy", "ws", "charts", "webkubectl", "apps", "mfa", "pod", "tokens", "activesessions", "accessrequests"}
 
 type WhiteList []string
 
@@ -55,10 +62,17 @@ func (w WhiteList) In(name string) bool {
 	return false
 }
 
+// inResourceWhiteList 按 resourceExtractHandler 取得的资源名精确匹配,sessions 下的接口仍需要校验角色
+func inResourceWhiteList(resource string) bool {
+	return resource != "sessions" && resourceWhiteList.In(resource)
+}
+
 func authHandler() iris.Handler {
 	return func(ctx *context.Context) {
 		var p session.UserProfile
-		if ctx.GetHeader("Authorization") != "" {
+		if pr, ok := ctx.Values().Get("profile").(session.UserProfile); ok {
+			p = pr
+		} else if ctx.GetHeader("Authorization") != "" {
 			pr := jwt.Get(ctx).(*session.UserProfile)
 			p = *pr
 
@@ -70,11 +84,43 @@ func authHandler() iris.Handler {
 			ctx.StopWithStatus(iris.StatusUnauthorized)
 			return
 		}
+		if p.Mfa.Enable && !p.Mfa.Approved {
+			ctx.Values().Set("message", "please login")
+			ctx.StopWithStatus(iris.StatusUnauthorized)
+			return
+		}
+		if p.PasswordExpired {
+			ctx.Values().Set("message", "password expired, please change it")
+			ctx.StopWithStatus(iris.StatusForbidden)
+			return
+		}
 		ctx.Values().Set("profile", p)
 		ctx.Next()
 	}
 }
 
+// sessionActivityHandler 刷新登录会话的最后活跃时间
+func sessionActivityHandler() iris.Handler {
+	systemService := v1SystemService.NewService()
+	return func(ctx *context.Context) {
+		var (
+			s   *v1System.Session
+			err error
+		)
+		if vt := jwt.GetVerifiedToken(ctx); vt != nil {
+			s, err = systemService.GetSession(vt.StandardClaims.ID, common.DBOptions{})
+		} else if ctx.Values().Get("accessToken") == nil {
+			s, err = systemService.GetSessionByCookie(server.SessionMgr.Start(ctx).ID(), common.DBOptions{})
+		}
+		if err == nil && s != nil {
+			if err := systemService.TouchSession(s, common.DBOptions{}); err != nil {
+				server.Logger().Errorf("can not update session activity of user %s: %s", s.UserName, err)
+			}
+		}
+		ctx.Next()
+	}
+}
+
 func langHandler() iris.Handler {
 	return func(ctx *context.Context) {
 		p := ctx.Values().Get("profile")
@@ -235,12 +281,9 @@ func roleHandler() iris.Handler {
 			return
 		}
 		roleBindingService := v1RoleBindingService.NewService()
-		rbs, err := roleBindingService.GetRoleBindingBySubject(v1Role.Subject{
-			Kind: "User",
-			Name: u.Name,
-		}, common.DBOptions{})
+		rbs, err := roleBindingService.GetRoleBindingsByUser(u.Name, common.DBOptions{})
 		if err != nil {
-			if !errors.As(err, &storm.ErrNotFound) {
+			if !errors.Is(err, storm.ErrNotFound) {
 				ctx.StatusCode(iris.StatusInternalServerError)
 				ctx.Values().Set("message", err.Error())
 				return
@@ -255,6 +298,10 @@ func roleHandler() iris.Handler {
 		for key := range roleNameHash {
 			roleNames = append(roleNames, key)
 		}
+		// 个人访问令牌只拥有其授权范围内的角色
+		if ctx.Values().Get("accessToken") != nil && collectons.IndexOfStringSlice(u.Scopes, v1Token.ScopeAll) == -1 {
+			roleNames = u.Scopes
+		}
 
 		roleService := v1RoleService.NewService()
 		rs, err := roleService.GetByNames(roleNames, common.DBOptions{})
@@ -334,14 +381,7 @@ func roleAccessHandler() iris.Handler {
 		//// 通过api resource 过滤出来资源主体,method 过滤操作
 		p := ctx.Values().Get("profile")
 		u := p.(session.UserProfile)
-		isInWhiteList := false
-		for _, path := range resourceWhiteList {
-			if strings.Contains(ctx.Request().URL.Path, fmt.Sprintf("/%s", path)) && path != "sessions" {
-				isInWhiteList = true
-				break
-			}
-		}
-		if !isInWhiteList {
+		if !inResourceWhiteList(ctx.Values().GetString("resource")) {
 			// 放通admin权限
 			if u.IsAdministrator {
 				ctx.Next()
@@ -353,12 +393,29 @@ func roleAccessHandler() iris.Handler {
 			if requestResource != "" {
 				currentRoute := ctx.GetCurrentRoute()
 				requestVerb := getVerbByRoute(currentRoute.Path(), currentRoute.Method())
-				resourceMatched, methodMatch := matchRoles(requestResource, requestVerb, roles)
+				resourceMatched, methodMatch, resourceNames := matchRoles(requestResource, requestVerb, roles)
 				if !(resourceMatched && methodMatch) {
 					ctx.StopWithStatus(iris.StatusForbidden)
 					ctx.Values().Set("message", []string{"user %s can not access resource %s %s", u.Name, requestResource, requestVerb})
 					return
 				}
+				// 角色限定了资源名称时,单个资源校验名称,列表只允许访问会按名称过滤的接口,其余操作不允许
+				if resourceNames != nil {
+					if strings.Contains(currentRoute.Path(), "/:name") {
+						name := ctx.Params().GetString("name")
+						if collectons.IndexOfStringSlice(resourceNames, name) == -1 {
+							ctx.StopWithStatus(iris.StatusForbidden)
+							ctx.Values().Set("message", []string{"user %s can not access %s %s", u.Name, requestResource, name})
+							return
+						}
+					} else if requestVerb == "list" && commons.FiltersResourceNames(ctx) {
+						ctx.Values().Set(commons.ResourceNamesKey, resourceNames)
+					} else {
+						ctx.StopWithStatus(iris.StatusForbidden)
+						ctx.Values().Set("message", []string{"user %s can not access resource %s %s", u.Name, requestResource, requestVerb})
+						return
+					}
+				}
 			}
 		}
 
@@ -366,9 +423,12 @@ func roleAccessHandler() iris.Handler {
 	}
 }
 
-func matchRoles(requestResource, requestMethod string, rs []v1Role.Role) (bool, bool) {
+// matchRoles 返回资源和操作是否匹配,以及允许访问的资源名称,名称为 nil 时不限制
+func matchRoles(requestResource, requestMethod string, rs []v1Role.Role) (bool, bool, []string) {
 	resourceMatch := false
 	methodMatch := false
+	unlimited := false
+	var resourceNames []string
 	for i := range rs {
 		for j := range rs[i].Rules {
 			for k := range rs[i].Rules[j].Resource {
@@ -377,13 +437,21 @@ func matchRoles(requestResource, requestMethod string, rs []v1Role.Role) (bool,
 					for x := range rs[i].Rules[j].Verbs {
 						if rs[i].Rules[j].Verbs[x] == requestMethod || rs[i].Rules[j].Verbs[x] == "*" {
 							methodMatch = true
+							if len(rs[i].Rules[j].ResourceNames) == 0 {
+								unlimited = true
+							} else {
+								resourceNames = append(resourceNames, rs[i].Rules[j].ResourceNames...)
+							}
 						}
 					}
 				}
 			}
 		}
 	}
-	return resourceMatch, methodMatch
+	if unlimited {
+		resourceNames = nil
+	}
+	return resourceMatch, methodMatch, resourceNames
 }
 
 func resourceNameInvalidHandler() iris.Handler {
@@ -403,7 +471,7 @@ func resourceNameInvalidHandler() iris.Handler {
 
 func WarpedJwtHandler() iris.Handler {
 	verifier := jwt.NewVerifier(jwt.HS256, server.Config().Spec.Jwt.Key)
-	verifier.WithDefaultBlocklist()
+	verifier.Blocklist = v1TokenService.NewBlocklist(session.JwtMaxAge)
 	verifyMiddleware := verifier.Verify(func() interface{} {
 		return new(session.UserProfile)
 	})
@@ -413,23 +481,77 @@ func WarpedJwtHandler() iris.Handler {
 			ctx.Next()
 			return
 		}
+		if raw := jwt.FromHeader(ctx); strings.HasPrefix(raw, v1Token.Prefix) {
+			accessTokenHandler(ctx, raw)
+			return
+		}
 		verifyMiddleware(ctx)
 	}
 }
 
+// accessTokenHandler 使用个人访问令牌认证,令牌权限为其授权范围与用户当前角色的交集
+func accessTokenHandler(ctx *context.Context, raw string) {
+	t, err := v1TokenService.NewService().Authenticate(raw, common.DBOptions{})
+	if err != nil {
+		ctx.Values().Set("message", err.Error())
+		ctx.StopWithStatus(iris.StatusUnauthorized)
+		return
+	}
+	u, err := v1UserService.NewService().GetByNameOrEmail(t.UserRef, common.DBOptions{})
+	if err != nil {
+		ctx.Values().Set("message", err.Error())
+		ctx.StopWithStatus(iris.StatusUnauthorized)
+		return
+	}
+	if u.Disabled {
+		ctx.Values().Set("message", "user is disabled")
+		ctx.StopWithStatus(iris.StatusForbidden)
+		return
+	}
+	scopeAll := collectons.IndexOfStringSlice(t.Scopes, v1Token.ScopeAll) != -1
+	var scopes []string
+	if scopeAll {
+		scopes = []string{v1Token.ScopeAll}
+	} else if u.IsAdmin {
+		scopes = t.Scopes
+	} else {
+		rbs, err := v1RoleBindingService.NewService().GetRoleBindingsByUser(u.Name, common.DBOptions{})
+		if err != nil && !errors.Is(err, storm.ErrNotFound) {
+			ctx.Values().Set("message", err.Error())
+			ctx.StopWithStatus(iris.StatusInternalServerError)
+			return
+		}
+		for i := range rbs {
+			if collectons.IndexOfStringSlice(t.Scopes, rbs[i].RoleRef) != -1 {
+				scopes = append(scopes, rbs[i].RoleRef)
+			}
+		}
+	}
+	ctx.Values().Set("accessToken", t)
+	ctx.Values().Set("profile", session.UserProfile{
+		Name:            u.Name,
+		NickName:        u.NickName,
+		Email:           u.Email,
+		Language:        u.Language,
+		IsAdministrator: u.IsAdmin && scopeAll,
+		Scopes:          scopes,
+	})
+	ctx.Next()
+}
+
 func AddV1Route(app iris.Party) {
 
 	v1Party := app.Party("/v1")
 
 	session.Install(v1Party)
 	mfa.Install(v1Party)
-	sso.Install(v1Party)
 	v1Party.Use(langHandler())
 	v1Party.Use(pageHandler())
 
 	authParty := v1Party.Party("")
 	authParty.Use(WarpedJwtHandler())
 	authParty.Use(authHandler())
+	authParty.Use(sessionActivityHandler())
 	authParty.Use(resourceExtractHandler())
 	authParty.Use(roleHandler())
 	authParty.Use(roleAccessHandler())
@@ -437,7 +559,11 @@ func AddV1Route(app iris.Party) {
 	authParty.Use(logHandler())
 	authParty.Get("/", apiResourceHandler(authParty))
 	user.Install(authParty)
-	cluster.Install(authParty)
+	authParty.Get("/users/:name/permissions", explainPermissionHandler())
+	group.Install(authParty)
+	accessrequest.Install(authParty)
+	cluster.Install(authParty, v1Party)
+	sso.Install(authParty, v1Party)
 	role.Install(authParty)
 	system.Install(authParty)
 	proxy.Install(authParty)
@@ -447,4 +573,6 @@ func AddV1Route(app iris.Party) {
 	ldap.Install(authParty)
 	imagerepo.Install(authParty)
 	file.Install(authParty)
+	token.Install(authParty)
+	session.InstallActive(authParty)
 }
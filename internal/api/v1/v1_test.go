@@ -0,0 +1,60 @@
+package v1
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/KubeOperator/kubepi/internal/api/v1/commons"
+	"github.com/KubeOperator/kubepi/internal/api/v1/session"
+	v1Role "github.com/KubeOperator/kubepi/internal/model/v1/role"
+	"github.com/kataras/iris/v12"
+	"github.com/kataras/iris/v12/context"
+)
+
+func TestRoleAccessHandlerResourceNames(t *testing.T) {
+	roles := []v1Role.Role{{
+		Rules: []v1Role.PolicyRule{{
+			Resource:      []string{"clusters", "users"},
+			ResourceNames: []string{"c1"},
+			Verbs:         []string{"get", "list"},
+		}},
+	}}
+	app := iris.New()
+	party := app.Party("/kubepi/api/v1")
+	party.Use(func(ctx *context.Context) {
+		ctx.Values().Set("profile", session.UserProfile{Name: "restricted"})
+		ctx.Values().Set("roles", roles)
+		ctx.Next()
+	}, resourceExtractHandler(), roleAccessHandler())
+	ok := func(ctx *context.Context) {
+		if _, limited := commons.AllowedResourceNames(ctx); !limited {
+			ctx.StatusCode(iris.StatusInternalServerError)
+		}
+	}
+	commons.FilterResourceNames(party.Post("/clusters/search", ok))
+	party.Post("/users/search", ok)
+	party.Get("/clusters/:name", func(ctx *context.Context) {})
+	if err := app.Build(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		method string
+		path   string
+		want   int
+	}{
+		{method: http.MethodPost, path: "/kubepi/api/v1/clusters/search", want: http.StatusOK},
+		// 未声明按名称过滤的列表接口默认拒绝
+		{method: http.MethodPost, path: "/kubepi/api/v1/users/search", want: http.StatusForbidden},
+		{method: http.MethodGet, path: "/kubepi/api/v1/clusters/c1", want: http.StatusOK},
+		{method: http.MethodGet, path: "/kubepi/api/v1/clusters/c2", want: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		app.ServeHTTP(rec, httptest.NewRequest(tt.method, tt.path, nil))
+		if rec.Code != tt.want {
+			t.Errorf("%s %s: got status %d, want %d", tt.method, tt.path, rec.Code, tt.want)
+		}
+	}
+}
@@ -3,9 +3,11 @@ package webkubectl
 import "k8s.io/client-go/rest"
 
 type Session struct {
-	User    string
-	config  *rest.Config
-	Cluster string `json:"cluster"`
+	User   string
+	config *rest.Config
+	// proxyURL 集群配置的代理,写入 kubeconfig 的 proxy-url
+	proxyURL string
+	Cluster  string `json:"cluster"`
 }
 
 type SessionResponse struct {
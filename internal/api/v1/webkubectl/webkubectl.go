@@ -2,6 +2,7 @@ package webkubectl
 
 import (
 	"encoding/pem"
+	"errors"
 	"fmt"
 	"github.com/KubeOperator/kubepi/internal/api/v1/session"
 	"github.com/KubeOperator/kubepi/internal/service/v1/cluster"
@@ -67,6 +68,7 @@ func toCmdConfig(sess *Session) *clientcmdapi.Config {
 	cc.Clusters[sess.Cluster] = &clientcmdapi.Cluster{
 		Server:                sess.config.Host,
 		InsecureSkipTLSVerify: true,
+		ProxyURL:              sess.proxyURL,
 	}
 	cc.AuthInfos[sess.User] = &clientcmdapi.AuthInfo{
 		ClientCertificateData: sess.config.CertData,
@@ -107,7 +109,12 @@ func (h *Handler) CreateSession() iris.Handler {
 			return
 		}
 		if !profile.IsAdministrator {
-			rb, err := h.clusterBindingService.GetBindingByClusterNameAndUserName(sess.Cluster, profile.Name, common.DBOptions{})
+			rb, err := h.clusterBindingService.GetActiveCredentialBinding(sess.Cluster, profile.Name, common.DBOptions{})
+			if errors.Is(err, clusterbinding.ErrMemberExpired) {
+				ctx.StatusCode(iris.StatusForbidden)
+				ctx.Values().Set("message", err.Error())
+				return
+			}
 			if err != nil {
 				ctx.StatusCode(iris.StatusInternalServerError)
 				ctx.Values().Set("message", err.Error())
@@ -117,6 +124,15 @@ func (h *Handler) CreateSession() iris.Handler {
 			cfg.KeyData =pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: c.PrivateKey})
 		}
 		sess.config = cfg
+		proxyURL, err := kubernetes.ProxyURL(c.Spec.Connect.Forward.Proxy)
+		if err != nil {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		if proxyURL != nil {
+			sess.proxyURL = proxyURL.String()
+		}
 		sess.User = profile.Name
 		sessionId := uuid.New().String()
 		h.sessionCache.Put(sessionId, &sess)
@@ -0,0 +1,49 @@
+package accessrequest
+
+import (
+	"time"
+
+	v1 "github.com/KubeOperator/kubepi/internal/model/v1"
+	v1Cluster "github.com/KubeOperator/kubepi/internal/model/v1/cluster"
+)
+
+const (
+	StatusPending  = "Pending"
+	StatusApproved = "Approved"
+	StatusRejected = "Rejected"
+	StatusCanceled = "Canceled"
+)
+
+const (
+	ActionSubmit        = "submit"
+	ActionApprove       = "approve"
+	ActionApproveFailed = "approveFailed"
+	ActionReject        = "reject"
+	ActionCancel        = "cancel"
+)
+
+// AccessRequest 用户申请成为集群成员,审批通过后按申请的角色创建集群绑定
+type AccessRequest struct {
+	v1.BaseModel   `storm:"inline"`
+	v1.Metadata    `storm:"inline"`
+	Cluster        string                     `json:"cluster" storm:"index"`
+	Requester      string                     `json:"requester" storm:"index"`
+	ClusterRoles   []string                   `json:"clusterRoles"`
+	NamespaceRoles []v1Cluster.NamespaceRoles `json:"namespaceRoles"`
+	// Duration 授权时长(秒),为 0 时永久有效
+	Duration      int64     `json:"duration"`
+	Reason        string    `json:"reason"`
+	Status        string    `json:"status" storm:"index"`
+	Reviewer      string    `json:"reviewer"`
+	ReviewComment string    `json:"reviewComment"`
+	ReviewAt      time.Time `json:"reviewAt"`
+	Events        []Event   `json:"events"`
+}
+
+// Event 记录申请的每一步操作
+type Event struct {
+	Action   string    `json:"action"`
+	Operator string    `json:"operator"`
+	Comment  string    `json:"comment"`
+	Time     time.Time `json:"time"`
+}
@@ -1,11 +1,41 @@
 package cluster
 
-import v1 "github.com/KubeOperator/kubepi/internal/model/v1"
+import (
+	"time"
 
+	v1 "github.com/KubeOperator/kubepi/internal/model/v1"
+)
+
+// Binding 集群成员,GroupRef 不为空时为用户组成员,同时设置 UserRef 时为用户通过用户组获得的访问凭证
 type Binding struct {
-	v1.BaseModel `storm:"inline"`
-	v1.Metadata  `storm:"inline"`
-	UserRef      string `json:"UserRef" storm:"inline"`
-	ClusterRef   string `json:"clusterRef" storm:"index"`
-	Certificate  []byte `json:"certificate"`
+	v1.BaseModel   `storm:"inline"`
+	v1.Metadata    `storm:"inline"`
+	UserRef        string           `json:"UserRef" storm:"inline"`
+	GroupRef       string           `json:"groupRef" storm:"index"`
+	ClusterRef     string           `json:"clusterRef" storm:"index"`
+	Certificate    []byte           `json:"certificate"`
+	ClusterRoles   []string         `json:"clusterRoles"`
+	NamespaceRoles []NamespaceRoles `json:"namespaceRoles"`
+	// ExpireAt 为零值时永久有效
+	ExpireAt time.Time `json:"expireAt" storm:"index"`
+}
+
+type NamespaceRoles struct {
+	Namespace string   `json:"namespace"`
+	Roles     []string `json:"roles"`
+}
+
+// IsGroup 用户组成员
+func (b *Binding) IsGroup() bool {
+	return b.GroupRef != "" && b.UserRef == ""
+}
+
+// IsUser 直接添加的用户成员
+func (b *Binding) IsUser() bool {
+	return b.GroupRef == ""
+}
+
+// Expired 成员已过期,过期的成员由定时任务移除
+func (b *Binding) Expired(now time.Time) bool {
+	return !b.ExpireAt.IsZero() && !b.ExpireAt.After(now)
 }
@@ -1,9 +1,17 @@
 package cluster
 
 import (
+	"time"
+
 	v1 "github.com/KubeOperator/kubepi/internal/model/v1"
 )
 
+const (
+	DirectionForward = "forward"
+	// DirectionReverse 集群内的 agent 主动连接 kubepi,用于 kubepi 无法直接访问的集群
+	DirectionReverse = "reverse"
+)
+
 type Cluster struct {
 	v1.BaseModel  `storm:"inline"`
 	v1.Metadata   `storm:"inline"`
@@ -23,6 +31,14 @@ type Spec struct {
 type Connect struct {
 	Direction string  `json:"direction"`
 	Forward   Forward `json:"forward" storm:"inline"`
+	Reverse   Reverse `json:"reverse" storm:"inline"`
+}
+
+// Reverse 只保存令牌的摘要,加入令牌使用一次后失效
+type Reverse struct {
+	JoinTokenHash     string    `json:"joinTokenHash"`
+	JoinTokenExpireAt time.Time `json:"joinTokenExpireAt"`
+	AgentTokenHash    string    `json:"agentTokenHash"`
 }
 
 type Forward struct {
@@ -0,0 +1,21 @@
+package cluster
+
+import (
+	v1 "github.com/KubeOperator/kubepi/internal/model/v1"
+)
+
+// HealthRecord 后台健康检查的一次结果,CreateAt 为检查时间,Latency 为 apiserver 响应时间(毫秒)
+type HealthRecord struct {
+	v1.BaseModel      `storm:"inline"`
+	v1.Metadata       `storm:"inline"`
+	ClusterRef        string  `json:"clusterRef" storm:"index"`
+	Health            bool    `json:"health"`
+	Message           string  `json:"message"`
+	Latency           int64   `json:"latency"`
+	TotalNodeNum      int     `json:"totalNodeNum"`
+	ReadyNodeNum      int     `json:"readyNodeNum"`
+	CPUAllocatable    float64 `json:"cpuAllocatable"`
+	CPURequested      float64 `json:"cpuRequested"`
+	MemoryAllocatable float64 `json:"memoryAllocatable"`
+	MemoryRequested   float64 `json:"memoryRequested"`
+}
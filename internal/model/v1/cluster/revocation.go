@@ -0,0 +1,28 @@
+package cluster
+
+import (
+	"time"
+
+	v1 "github.com/KubeOperator/kubepi/internal/model/v1"
+)
+
+const (
+	// RevokeReasonRemoved 用户不再是集群成员
+	RevokeReasonRemoved = "removed"
+	// RevokeReasonRotated 集群私钥轮换后证书被重新签发,
+	// 新旧证书的 CN 相同,旧证书在过期前仍然可以使用用户的 rbac 绑定访问集群
+	RevokeReasonRotated = "rotated"
+	// RevokeReasonRenewed 证书即将过期被重新签发
+	RevokeReasonRenewed = "renewed"
+)
+
+// RevokedCertificate kubernetes 不支持吊销客户端证书,这里只记录已作废的证书,访问权限通过清理 rbac 绑定收回
+type RevokedCertificate struct {
+	v1.BaseModel `storm:"inline"`
+	v1.Metadata  `storm:"inline"`
+	ClusterRef   string    `json:"clusterRef" storm:"index"`
+	UserRef      string    `json:"userRef" storm:"index"`
+	Fingerprint  string    `json:"fingerprint"`
+	NotAfter     time.Time `json:"notAfter"`
+	Reason       string    `json:"reason"`
+}
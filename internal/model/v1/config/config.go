@@ -13,12 +13,19 @@ type Spec struct {
 	Session SessionConfig `json:"session"`
 	Logger  LoggerConfig  `json:"logger"`
 	Jwt     JwtConfig     `json:"jwt"`
-	AppId   string        `json:"appId"`
+	Lockout LockoutConfig `json:"lockout"`
+	// PasswordPolicy 本地用户的密码策略
+	PasswordPolicy PasswordPolicyConfig `json:"passwordPolicy"`
+	Mfa            MfaConfig            `json:"mfa"`
+	WebAuthn       WebAuthnConfig       `json:"webAuthn"`
+	AppId          string               `json:"appId"`
 }
 
 type ServerConfig struct {
 	Bind BindConfig `json:"bind"`
 	SSL  SSLConfig  `json:"ssl"`
+	// ExternalURL 外部访问 kubepi 的地址,用于生成 agent 的接入命令
+	ExternalURL string `json:"externalURL"`
 }
 
 type BindConfig struct {
@@ -40,8 +47,62 @@ type DBConfig struct {
 	Path string `json:"path"`
 }
 
+const (
+	SessionStoreMemory = "memory"
+	SessionStoreStorm  = "storm"
+	SessionStoreRedis  = "redis"
+)
+
 type SessionConfig struct {
 	Expires int `json:"expires"`
+	// Store 会话存储: memory | storm | redis
+	Store string      `json:"store"`
+	Redis RedisConfig `json:"redis"`
+}
+
+type RedisConfig struct {
+	Addr     string `json:"addr"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Database string `json:"database"`
+	Prefix   string `json:"prefix"`
+}
+
+// LockoutConfig 登录失败锁定,Window 和 Duration 的单位为分钟
+type LockoutConfig struct {
+	Enable          bool `json:"enable"`
+	UserMaxAttempts int  `json:"userMaxAttempts"`
+	IpMaxAttempts   int  `json:"ipMaxAttempts"`
+	Window          int  `json:"window"`
+	Duration        int  `json:"duration"`
+}
+
+const (
+	MfaMandatoryNone  = "none"
+	MfaMandatoryAdmin = "admin"
+	MfaMandatoryAll   = "all"
+)
+
+// MfaConfig Mandatory 为 admin 时管理员必须绑定 mfa,为 all 时所有用户必须绑定
+type MfaConfig struct {
+	Mandatory string `json:"mandatory"`
+}
+
+// WebAuthnConfig 为空时根据请求的域名生成 relying party
+type WebAuthnConfig struct {
+	RPID      string   `json:"rpId"`
+	RPOrigins []string `json:"rpOrigins"`
+}
+
+// PasswordPolicyConfig History 为不能重复使用的历史密码个数,MaxAge 为密码有效天数,0 表示不过期
+type PasswordPolicyConfig struct {
+	MinLength      int  `json:"minLength"`
+	RequireUpper   bool `json:"requireUpper"`
+	RequireLower   bool `json:"requireLower"`
+	RequireDigit   bool `json:"requireDigit"`
+	RequireSpecial bool `json:"requireSpecial"`
+	History        int  `json:"history"`
+	MaxAge         int  `json:"maxAge"`
 }
 
 type JwtConfig struct {
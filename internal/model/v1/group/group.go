@@ -0,0 +1,9 @@
+package group
+
+import v1 "github.com/KubeOperator/kubepi/internal/model/v1"
+
+type Group struct {
+	v1.BaseModel `storm:"inline"`
+	v1.Metadata  `storm:"inline"`
+	Members      []string `json:"members"`
+}
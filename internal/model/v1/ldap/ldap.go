@@ -19,8 +19,92 @@ type Ldap struct {
 	Enable       bool   `json:"enable"`
 	SizeLimit    int    `json:"sizeLimit"`
 	TimeLimit    int    `json:"timeLimit"`
+	// StartTLS 使用普通端口连接后升级为 TLS,不能与 TLS 同时开启
+	StartTLS bool `json:"startTLS"`
+	// CACert 校验服务端证书的 CA 证书,为空时使用系统 CA
+	CACert string `json:"caCert"`
+	// ServerName 校验证书时使用的服务器名称,为空时使用 Address
+	ServerName string `json:"serverName"`
+	// ClientCert ClientKey 双向认证使用的客户端证书和私钥
+	ClientCert string `json:"clientCert"`
+	ClientKey  string `json:"clientKey"`
+	// InsecureSkipVerify 不校验服务端证书,仅用于测试环境
+	InsecureSkipVerify bool `json:"insecureSkipVerify"`
+	// SyncSchedule 定时同步的 cron 表达式,为空时只能手动同步
+	SyncSchedule string `json:"syncSchedule"`
+	// SyncPolicy 目录中已不存在的用户的处理方式,disable 或 delete
+	SyncPolicy string `json:"syncPolicy"`
+	// Groups 用户组查询配置,BaseDn 为空时不查询用户组
+	Groups GroupSearch `json:"groups"`
+	// RoleMappings 用户组到角色的映射,配置后在同步和登录时调整用户的角色
+	RoleMappings []RoleMapping `json:"roleMappings"`
+	// DefaultRoles 没有匹配任何用户组时绑定的角色
+	DefaultRoles []string `json:"defaultRoles"`
+	// ClusterMappings 用户组到集群成员角色的映射
+	ClusterMappings []ClusterMapping `json:"clusterMappings"`
 }
 
+type GroupSearch struct {
+	BaseDn string `json:"baseDn"`
+	Filter string `json:"filter"`
+	// MemberAttribute 用户组中记录成员的属性,member 等属性的值为用户 DN,memberUid 的值为用户名
+	MemberAttribute string `json:"memberAttribute"`
+	NameAttribute   string `json:"nameAttribute"`
+	// Nested 是否解析嵌套的用户组
+	Nested bool `json:"nested"`
+}
+
+type RoleMapping struct {
+	Group string   `json:"group"`
+	Roles []string `json:"roles"`
+}
+
+type NamespaceRoles struct {
+	Namespace string   `json:"namespace"`
+	Roles     []string `json:"roles"`
+}
+
+// ClusterMapping 用户组成员加入集群时绑定的集群角色和命名空间角色
+type ClusterMapping struct {
+	Group          string           `json:"group"`
+	Cluster        string           `json:"cluster"`
+	ClusterRoles   []string         `json:"clusterRoles"`
+	NamespaceRoles []NamespaceRoles `json:"namespaceRoles"`
+}
+
+const (
+	DefaultGroupFilter          = "(|(objectClass=group)(objectClass=groupOfNames)(objectClass=groupOfUniqueNames)(objectClass=posixGroup))"
+	DefaultGroupMemberAttribute = "member"
+	DefaultGroupNameAttribute   = "cn"
+
+	// BindingCreator 由 ldap 用户组同步的角色绑定和集群成员,同步时只调整这些绑定
+	BindingCreator = "ldap"
+)
+
+// WithDefaults 补全未配置的用户组查询参数
+func (g GroupSearch) WithDefaults() GroupSearch {
+	if g.Filter == "" {
+		g.Filter = DefaultGroupFilter
+	}
+	if g.MemberAttribute == "" {
+		g.MemberAttribute = DefaultGroupMemberAttribute
+	}
+	if g.NameAttribute == "" {
+		g.NameAttribute = DefaultGroupNameAttribute
+	}
+	return g
+}
+
+// HasGroupMappings 配置了用户组映射时才需要查询用户组
+func (l *Ldap) HasGroupMappings() bool {
+	return l.Groups.BaseDn != "" && (len(l.RoleMappings) > 0 || len(l.ClusterMappings) > 0)
+}
+
+const (
+	SyncPolicyDisable = "disable"
+	SyncPolicyDelete  = "delete"
+)
+
 func (l *Ldap) GetAttributes() ([]string, error) {
 	m := make(map[string]string)
 	err := json.Unmarshal([]byte(l.Mapping), &m)
@@ -0,0 +1,34 @@
+package ldap
+
+import (
+	"time"
+
+	v1 "github.com/KubeOperator/kubepi/internal/model/v1"
+)
+
+const (
+	SyncTriggerManual   = "manual"
+	SyncTriggerSchedule = "schedule"
+
+	SyncStatusRunning = "Running"
+	SyncStatusSuccess = "Success"
+	SyncStatusFailed  = "Failed"
+)
+
+// SyncReport 一次 ldap 用户同步的结果
+type SyncReport struct {
+	v1.BaseModel `storm:"inline"`
+	v1.Metadata  `storm:"inline"`
+	LdapID       string    `json:"ldapId" storm:"index"`
+	Trigger      string    `json:"trigger"`
+	Status       string    `json:"status"`
+	Message      string    `json:"message"`
+	StartAt      time.Time `json:"startAt" storm:"index"`
+	EndAt        time.Time `json:"endAt"`
+	Total        int       `json:"total"`
+	Added        []string  `json:"added"`
+	Updated      []string  `json:"updated"`
+	Disabled     []string  `json:"disabled"`
+	Deleted      []string  `json:"deleted"`
+	Errors       []string  `json:"errors"`
+}
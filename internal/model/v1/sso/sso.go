@@ -2,6 +2,8 @@ package sso
 
 import (
 	"context"
+	"time"
+
 	v1 "github.com/KubeOperator/kubepi/internal/model/v1"
 	"github.com/coreos/go-oidc"
 	"golang.org/x/oauth2"
@@ -15,6 +17,87 @@ type Sso struct {
 	InterfaceAddress string `json:"interfaceAddress"`
 	ClientId         string `json:"clientId"`
 	ClientSecret     string `json:"clientSecret"`
+	// Scopes 额外申请的 scope,如 groups
+	Scopes []string `json:"scopes"`
+	// Claims OIDC 声明映射,为空时使用默认的声明
+	Claims ClaimMapping `json:"claims"`
+	// RoleMappings IdP 用户组到角色的映射,配置后每次登录时同步用户的角色
+	RoleMappings []RoleMapping `json:"roleMappings"`
+	// DefaultRoles 没有匹配任何用户组时绑定的角色
+	DefaultRoles []string `json:"defaultRoles"`
+	// IdpMetadata SAML 身份提供方的元数据,为空时从 InterfaceAddress 导入
+	IdpMetadata string `json:"idpMetadata"`
+	// SpCertificate SpPrivateKey SAML 服务提供方签名使用的证书和私钥,为空时自动生成
+	SpCertificate string `json:"spCertificate"`
+	SpPrivateKey  string `json:"spPrivateKey"`
+}
+
+const (
+	ProtocolOpenID = "openid"
+	ProtocolSaml   = "saml"
+)
+
+// ClaimMapping 声明名称,支持使用 . 访问嵌套的声明,如 realm_access.roles
+type ClaimMapping struct {
+	Username string `json:"username"`
+	Email    string `json:"email"`
+	NickName string `json:"nickName"`
+	Groups   string `json:"groups"`
+}
+
+const (
+	DefaultUsernameClaim = "preferred_username"
+	DefaultEmailClaim    = "email"
+	DefaultNickNameClaim = "name"
+	DefaultGroupsClaim   = "groups"
+
+	// RoleBindingCreator 由 SSO 同步的角色绑定,登录时只调整这些绑定
+	RoleBindingCreator = "sso"
+)
+
+// WithDefaults 补全未配置的声明名称
+func (c ClaimMapping) WithDefaults() ClaimMapping {
+	if c.Username == "" {
+		c.Username = DefaultUsernameClaim
+	}
+	if c.Email == "" {
+		c.Email = DefaultEmailClaim
+	}
+	if c.NickName == "" {
+		c.NickName = DefaultNickNameClaim
+	}
+	if c.Groups == "" {
+		c.Groups = DefaultGroupsClaim
+	}
+	return c
+}
+
+// WithSamlDefaults 补全未配置的 SAML 属性名称,用户名为空时使用 NameID
+func (c ClaimMapping) WithSamlDefaults() ClaimMapping {
+	if c.Email == "" {
+		c.Email = DefaultEmailClaim
+	}
+	if c.NickName == "" {
+		c.NickName = "displayName"
+	}
+	if c.Groups == "" {
+		c.Groups = DefaultGroupsClaim
+	}
+	return c
+}
+
+type RoleMapping struct {
+	Group string   `json:"group"`
+	Roles []string `json:"roles"`
+}
+
+// Identity 从 IdP 获取的用户信息,OIDC 和 SAML 使用相同的方式创建本地用户
+type Identity struct {
+	Username string
+	Email    string
+	NickName string
+	Groups   []string
+	Language string
 }
 
 type OpenID struct {
@@ -24,3 +107,10 @@ type OpenID struct {
 	OidcProvider *oidc.Provider
 	Ctx          context.Context
 }
+
+// UsedSamlID 已经使用过的 SAML 断言 ID 和认证请求 ID,ExpireAt 之前不能再次使用
+type UsedSamlID struct {
+	v1.BaseModel `storm:"inline"`
+	v1.Metadata  `storm:"inline"`
+	ExpireAt     time.Time `json:"expireAt" storm:"index"`
+}
@@ -0,0 +1,27 @@
+package system
+
+import (
+	"time"
+
+	v1 "github.com/KubeOperator/kubepi/internal/model/v1"
+)
+
+const (
+	LockoutKindUser = "user"
+	LockoutKindIp   = "ip"
+)
+
+// Lockout 登录失败计数,Name 为 <kind>-<subject>
+type Lockout struct {
+	v1.BaseModel   `storm:"inline"`
+	v1.Metadata    `storm:"inline"`
+	LockKind       string    `json:"lockKind" storm:"index"`
+	Subject        string    `json:"subject"`
+	Failures       int       `json:"failures"`
+	FirstFailureAt time.Time `json:"firstFailureAt"`
+	LockedUntil    time.Time `json:"lockedUntil"`
+}
+
+func (l *Lockout) Locked() bool {
+	return l.LockedUntil.After(time.Now())
+}
@@ -8,4 +8,6 @@ type LoginLog struct {
 	UserName     string `json:"userName"`
 	Ip           string `json:"ip"`
 	City         string `json:"city"`
+	Failed       bool   `json:"failed"`
+	Reason       string `json:"reason"`
 }
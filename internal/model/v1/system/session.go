@@ -0,0 +1,27 @@
+package system
+
+import (
+	"time"
+
+	v1 "github.com/KubeOperator/kubepi/internal/model/v1"
+)
+
+const (
+	AuthMethodCookie = "cookie"
+	AuthMethodJwt    = "jwt"
+	AuthMethodSso    = "sso"
+)
+
+// Session 登录会话,Name 为会话 ID,jwt 会话中同时作为 access token 的 jti
+type Session struct {
+	v1.BaseModel `storm:"inline"`
+	v1.Metadata  `storm:"inline"`
+	UserName     string    `json:"userName" storm:"index"`
+	Ip           string    `json:"ip"`
+	UserAgent    string    `json:"userAgent"`
+	AuthMethod   string    `json:"authMethod"`
+	CookieID     string    `json:"cookieId" storm:"index"`
+	LoginAt      time.Time `json:"loginAt"`
+	LastActiveAt time.Time `json:"lastActiveAt"`
+	ExpireAt     time.Time `json:"expireAt"`
+}
@@ -0,0 +1,35 @@
+package token
+
+import (
+	"time"
+
+	v1 "github.com/KubeOperator/kubepi/internal/model/v1"
+)
+
+const (
+	// Prefix 个人访问令牌前缀,用于和 jwt 区分
+	Prefix = "kubepi_"
+	// ScopeAll 继承令牌所有者的全部权限
+	ScopeAll = "*"
+)
+
+type Token struct {
+	v1.BaseModel `storm:"inline"`
+	v1.Metadata  `storm:"inline"`
+	UserRef      string    `json:"userRef" storm:"index"`
+	Hash         string    `json:"hash" storm:"unique"`
+	Scopes       []string  `json:"scopes"`
+	ExpireAt     time.Time `json:"expireAt"`
+	LastUsedAt   time.Time `json:"lastUsedAt"`
+}
+
+func (t *Token) Expired() bool {
+	return !t.ExpireAt.IsZero() && time.Now().After(t.ExpireAt)
+}
+
+// RevokedToken 已吊销但尚未过期的 jwt,Name 为令牌 ID
+type RevokedToken struct {
+	v1.BaseModel `storm:"inline"`
+	v1.Metadata  `storm:"inline"`
+	ExpireAt     time.Time `json:"expireAt" storm:"index"`
+}
@@ -1,6 +1,11 @@
 package user
 
-import v1 "github.com/KubeOperator/kubepi/internal/model/v1"
+import (
+	"time"
+
+	v1 "github.com/KubeOperator/kubepi/internal/model/v1"
+	"github.com/go-webauthn/webauthn/webauthn"
+)
 
 type User struct {
 	v1.BaseModel `storm:"inline"`
@@ -12,21 +17,51 @@ type User struct {
 	Authenticate Authenticate `json:"authenticate"`
 	Type         string       `json:"type"`
 	Mfa          Mfa          `json:"mfa"`
+	// Disabled 禁用的用户不能登录,ldap 同步时目录中已不存在的用户会被禁用
+	Disabled bool `json:"disabled"`
+	// DisabledBySync 由 ldap 同步禁用,用户重新出现在目录中时只自动启用这类用户
+	DisabledBySync bool `json:"disabledBySync"`
+	// LdapID ldap 用户所属的目录,登录时使用该目录校验密码
+	LdapID string `json:"ldapId" storm:"index"`
 }
 
 type Authenticate struct {
 	Password string `json:"password"`
 	Token    string `json:"token"`
+	// History 历史密码哈希,最近的在前
+	History           []string  `json:"history"`
+	PasswordChangedAt time.Time `json:"passwordChangedAt"`
+	// ForceChange 下次登录时必须修改密码,管理员重置密码后设置
+	ForceChange bool `json:"forceChange"`
 }
 
 type Mfa struct {
 	Enable bool   `json:"enable"`
 	Secret string `json:"secret"`
+	// RecoveryCodes 未使用的恢复码哈希
+	RecoveryCodes []string `json:"recoveryCodes"`
+	// WebAuthnCredentials 用户注册的安全密钥,可以注册多个
+	WebAuthnCredentials []WebAuthnCredential `json:"webAuthnCredentials"`
+}
+
+// WebAuthnCredential 安全密钥的名称和 webauthn 凭证
+type WebAuthnCredential struct {
+	Name       string              `json:"name"`
+	Credential webauthn.Credential `json:"credential"`
+	CreateAt   time.Time           `json:"createAt"`
+	LastUsedAt time.Time           `json:"lastUsedAt"`
+}
+
+// Bound 已经绑定了动态验证码或安全密钥
+func (m Mfa) Bound() bool {
+	return m.Secret != "" || len(m.WebAuthnCredentials) > 0
 }
 
 const (
 	LDAP  = "LDAP"
 	LOCAL = "LOCAL"
+	// SSO 通过单点登录创建的用户,没有密码,只能通过 SSO 登录
+	SSO = "SSO"
 )
 
 type ImportUser struct {
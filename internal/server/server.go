@@ -153,6 +153,11 @@ func (e *KubePiServer) setUpStaticFile() {
 
 func (e *KubePiServer) setUpSession() {
 	SessionMgr = sessions.New(sessions.Config{Cookie: SessionCookieName, AllowReclaim: true, Expires: time.Duration(e.config.Spec.Session.Expires) * time.Hour})
+	if db := e.newSessionDatabase(); db != nil {
+		// 持久化的会话需要还原出原始类型,会话中保存的类型需要通过 gob.Register 注册
+		sessions.DefaultTranscoder = sessions.GobTranscoder{}
+		SessionMgr.UseDatabase(db)
+	}
 	e.rootRoute.Use(SessionMgr.Handler())
 }
 
@@ -344,9 +349,29 @@ func getDefaultConfig() *v1Config.Config {
 			},
 			Session: v1Config.SessionConfig{
 				Expires: 72,
+				Store:   v1Config.SessionStoreStorm,
+				Redis: v1Config.RedisConfig{
+					Addr:   "127.0.0.1:6379",
+					Prefix: "kubepi-session-",
+				},
 			},
 			Logger: v1Config.LoggerConfig{Level: "debug"},
 			Jwt:    v1Config.JwtConfig{},
+			Lockout: v1Config.LockoutConfig{
+				Enable:          true,
+				UserMaxAttempts: 5,
+				IpMaxAttempts:   20,
+				Window:          15,
+				Duration:        15,
+			},
+			PasswordPolicy: v1Config.PasswordPolicyConfig{
+				MinLength:    8,
+				RequireLower: true,
+				RequireDigit: true,
+			},
+			Mfa: v1Config.MfaConfig{
+				Mandatory: v1Config.MfaMandatoryNone,
+			},
 		},
 	}
 }
@@ -0,0 +1,238 @@
+package server
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	v1Config "github.com/KubeOperator/kubepi/internal/model/v1/config"
+	"github.com/asdine/storm/v3"
+	"github.com/asdine/storm/v3/q"
+	"github.com/kataras/golog"
+	"github.com/kataras/iris/v12/sessions"
+	"github.com/kataras/iris/v12/sessions/sessiondb/redis"
+	"github.com/robfig/cron/v3"
+)
+
+// newSessionDatabase 根据配置返回会话存储,memory 时返回 nil 使用 iris 默认的内存存储
+func (e *KubePiServer) newSessionDatabase() sessions.Database {
+	c := e.config.Spec.Session
+	switch c.Store {
+	case v1Config.SessionStoreMemory:
+		return nil
+	case v1Config.SessionStoreRedis:
+		return redis.New(redis.Config{
+			Network:   redis.DefaultRedisNetwork,
+			Addr:      c.Redis.Addr,
+			Username:  c.Redis.Username,
+			Password:  c.Redis.Password,
+			Database:  c.Redis.Database,
+			Prefix:    c.Redis.Prefix,
+			MaxActive: 10,
+			Timeout:   redis.DefaultRedisTimeout,
+		})
+	default:
+		return newStormSessionDatabase(e.db)
+	}
+}
+
+type sessionRecord struct {
+	ID       string            `json:"id" storm:"id"`
+	ExpireAt time.Time         `json:"expireAt" storm:"index"`
+	Values   map[string][]byte `json:"values"`
+}
+
+// stormSessionDatabase 将会话保存在 kubepi 自身的 storm 数据库中
+type stormSessionDatabase struct {
+	db     *storm.DB
+	logger *golog.Logger
+	mu     sync.Mutex
+	cron   *cron.Cron
+}
+
+var _ sessions.Database = (*stormSessionDatabase)(nil)
+
+// sessionGCSchedule 清理过期会话的周期
+const sessionGCSchedule = "@every 1h"
+
+func newStormSessionDatabase(db *storm.DB) *stormSessionDatabase {
+	s := &stormSessionDatabase{db: db, logger: golog.Default, cron: cron.New()}
+	// 清理服务停止期间已经过期的会话
+	s.gc()
+	if _, err := s.cron.AddFunc(sessionGCSchedule, s.gc); err != nil {
+		s.logger.Errorf("can not start session gc: %v", err)
+	} else {
+		s.cron.Start()
+	}
+	return s
+}
+
+func (s *stormSessionDatabase) gc() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err := s.db.Select(q.Gt("ExpireAt", time.Time{}), q.Lt("ExpireAt", time.Now())).Delete(&sessionRecord{})
+	if err != nil && !errors.Is(err, storm.ErrNotFound) {
+		s.logger.Errorf("can not clean expired sessions: %v", err)
+	}
+}
+
+func (s *stormSessionDatabase) SetLogger(logger *golog.Logger) {
+	s.logger = logger
+}
+
+func (s *stormSessionDatabase) get(sid string) (*sessionRecord, error) {
+	var r sessionRecord
+	if err := s.db.One("ID", sid, &r); err != nil {
+		return nil, err
+	}
+	if r.Values == nil {
+		r.Values = map[string][]byte{}
+	}
+	return &r, nil
+}
+
+func (s *stormSessionDatabase) Acquire(sid string, expires time.Duration) sessions.LifeTime {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r, err := s.get(sid)
+	if err == nil {
+		if r.ExpireAt.IsZero() || r.ExpireAt.After(time.Now()) {
+			return sessions.LifeTime{Time: r.ExpireAt}
+		}
+	} else if !errors.Is(err, storm.ErrNotFound) {
+		s.logger.Debug(err)
+	}
+	r = &sessionRecord{ID: sid, Values: map[string][]byte{}}
+	if expires > 0 {
+		r.ExpireAt = time.Now().Add(expires)
+	}
+	if err := s.db.Save(r); err != nil {
+		s.logger.Debug(err)
+	}
+	return sessions.LifeTime{}
+}
+
+func (s *stormSessionDatabase) OnUpdateExpiration(sid string, newExpires time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r, err := s.get(sid)
+	if err != nil {
+		return err
+	}
+	r.ExpireAt = time.Now().Add(newExpires)
+	return s.db.Save(r)
+}
+
+func (s *stormSessionDatabase) Set(sid string, key string, value interface{}, _ time.Duration, _ bool) error {
+	valueBytes, err := sessions.DefaultTranscoder.Marshal(value)
+	if err != nil {
+		s.logger.Error(err)
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r, err := s.get(sid)
+	if err != nil {
+		if !errors.Is(err, storm.ErrNotFound) {
+			return err
+		}
+		r = &sessionRecord{ID: sid, Values: map[string][]byte{}}
+	}
+	r.Values[key] = valueBytes
+	return s.db.Save(r)
+}
+
+func (s *stormSessionDatabase) Get(sid string, key string) (value interface{}) {
+	if err := s.Decode(sid, key, &value); err == nil {
+		return value
+	}
+	return nil
+}
+
+func (s *stormSessionDatabase) Decode(sid, key string, outPtr interface{}) error {
+	r, err := s.get(sid)
+	if err != nil {
+		return err
+	}
+	data, ok := r.Values[key]
+	if !ok {
+		return storm.ErrNotFound
+	}
+	if err := sessions.DefaultTranscoder.Unmarshal(data, outPtr); err != nil {
+		s.logger.Debugf("unable to unmarshal value of key: '%s%s': %v", sid, key, err)
+		return err
+	}
+	return nil
+}
+
+func (s *stormSessionDatabase) Visit(sid string, cb func(key string, value interface{})) error {
+	r, err := s.get(sid)
+	if err != nil {
+		return err
+	}
+	for k, v := range r.Values {
+		var value interface{}
+		if err := sessions.DefaultTranscoder.Unmarshal(v, &value); err != nil {
+			s.logger.Debugf("unable to decode %s:%s: %v", sid, k, err)
+			return err
+		}
+		cb(k, value)
+	}
+	return nil
+}
+
+func (s *stormSessionDatabase) Len(sid string) int {
+	r, err := s.get(sid)
+	if err != nil {
+		return 0
+	}
+	return len(r.Values)
+}
+
+func (s *stormSessionDatabase) Delete(sid string, key string) (deleted bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r, err := s.get(sid)
+	if err != nil {
+		return false
+	}
+	if _, ok := r.Values[key]; !ok {
+		return false
+	}
+	delete(r.Values, key)
+	if err := s.db.Save(r); err != nil {
+		s.logger.Error(err)
+		return false
+	}
+	return true
+}
+
+func (s *stormSessionDatabase) Clear(sid string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r, err := s.get(sid)
+	if err != nil {
+		return err
+	}
+	r.Values = map[string][]byte{}
+	return s.db.Save(r)
+}
+
+func (s *stormSessionDatabase) Release(sid string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r, err := s.get(sid)
+	if err != nil {
+		if errors.Is(err, storm.ErrNotFound) {
+			return nil
+		}
+		return err
+	}
+	return s.db.DeleteStruct(r)
+}
+
+// Close 数据库由 server 统一关闭
+func (s *stormSessionDatabase) Close() error {
+	s.cron.Stop()
+	return nil
+}
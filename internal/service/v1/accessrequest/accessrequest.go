@@ -0,0 +1,126 @@
+package accessrequest
+
+import (
+	"errors"
+	"time"
+
+	v1AccessRequest "github.com/KubeOperator/kubepi/internal/model/v1/accessrequest"
+	"github.com/KubeOperator/kubepi/internal/service/v1/common"
+	"github.com/asdine/storm/v3/q"
+	"github.com/google/uuid"
+)
+
+type Service interface {
+	common.DBService
+	Create(request *v1AccessRequest.AccessRequest, options common.DBOptions) error
+	Get(name string, options common.DBOptions) (*v1AccessRequest.AccessRequest, error)
+	List(cluster, requester, status string, options common.DBOptions) ([]v1AccessRequest.AccessRequest, error)
+	AddEvent(name string, event v1AccessRequest.Event, options common.DBOptions) error
+	Review(name string, status string, event v1AccessRequest.Event, options common.DBOptions) error
+}
+
+// ErrNotPending 申请已经被审批、驳回或撤销
+var ErrNotPending = errors.New("access request is not pending")
+
+func NewService() Service {
+	return &service{}
+}
+
+type service struct {
+	common.DefaultDBService
+}
+
+func (s *service) Create(request *v1AccessRequest.AccessRequest, options common.DBOptions) error {
+	db := s.GetDB(options)
+	request.UUID = uuid.New().String()
+	if request.Name == "" {
+		request.Name = request.UUID
+	}
+	request.Status = v1AccessRequest.StatusPending
+	request.CreateAt = time.Now()
+	request.UpdateAt = time.Now()
+	request.Events = append(request.Events, v1AccessRequest.Event{
+		Action:   v1AccessRequest.ActionSubmit,
+		Operator: request.Requester,
+		Comment:  request.Reason,
+		Time:     request.CreateAt,
+	})
+	return db.Save(request)
+}
+
+func (s *service) Get(name string, options common.DBOptions) (*v1AccessRequest.AccessRequest, error) {
+	db := s.GetDB(options)
+	var request v1AccessRequest.AccessRequest
+	if err := db.One("Name", name, &request); err != nil {
+		return nil, err
+	}
+	return &request, nil
+}
+
+// List 参数为空时不作为过滤条件
+func (s *service) List(cluster, requester, status string, options common.DBOptions) ([]v1AccessRequest.AccessRequest, error) {
+	db := s.GetDB(options)
+	var ms []q.Matcher
+	if cluster != "" {
+		ms = append(ms, q.Eq("Cluster", cluster))
+	}
+	if requester != "" {
+		ms = append(ms, q.Eq("Requester", requester))
+	}
+	if status != "" {
+		ms = append(ms, q.Eq("Status", status))
+	}
+	requests := make([]v1AccessRequest.AccessRequest, 0)
+	if err := db.Select(ms...).OrderBy("CreateAt").Reverse().Find(&requests); err != nil {
+		return requests, err
+	}
+	return requests, nil
+}
+
+func (s *service) AddEvent(name string, event v1AccessRequest.Event, options common.DBOptions) error {
+	db := s.GetDB(options)
+	request, err := s.Get(name, options)
+	if err != nil {
+		return err
+	}
+	event.Time = time.Now()
+	if err := db.UpdateField(request, "Events", append(request.Events, event)); err != nil {
+		return err
+	}
+	return db.UpdateField(request, "UpdateAt", event.Time)
+}
+
+// Review 审批、驳回或撤销申请,同时记录操作,申请不是待审批状态时返回 ErrNotPending
+func (s *service) Review(name string, status string, event v1AccessRequest.Event, options common.DBOptions) error {
+	tx, err := s.GetDB(options).Begin(true)
+	if err != nil {
+		return err
+	}
+	request, err := s.Get(name, common.DBOptions{DB: tx})
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	if request.Status != v1AccessRequest.StatusPending {
+		_ = tx.Rollback()
+		return ErrNotPending
+	}
+	event.Time = time.Now()
+	fields := map[string]interface{}{
+		"Status":   status,
+		"Events":   append(request.Events, event),
+		"UpdateAt": event.Time,
+	}
+	if status == v1AccessRequest.StatusApproved || status == v1AccessRequest.StatusRejected {
+		fields["Reviewer"] = event.Operator
+		fields["ReviewComment"] = event.Comment
+		fields["ReviewAt"] = event.Time
+	}
+	for k, v := range fields {
+		if err := tx.UpdateField(request, k, v); err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
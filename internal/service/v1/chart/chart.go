@@ -316,7 +316,7 @@ func NewHelmClient(clusterName, namespace string) (*helm.Client, error) {
 		return nil, err
 	}
 	helmClient, err := helm.NewClient(&helm.Config{
-		Host:        clu.Spec.Connect.Forward.ApiServer,
+		Host:        kubeConfig.Host,
 		ClusterName: clusterName,
 		KubeConfig:  kubeConfig,
 		Namespace:   namespace,
@@ -7,6 +7,7 @@ import (
 	"github.com/KubeOperator/kubepi/pkg/util/lang"
 	"github.com/asdine/storm/v3/q"
 	"github.com/google/uuid"
+	"strings"
 	"time"
 )
 
@@ -14,6 +15,7 @@ type Service interface {
 	common.DBService
 	Create(cluster *v1Cluster.Cluster, options common.DBOptions) error
 	Update(name string, cluster *v1Cluster.Cluster, options common.DBOptions) error
+	UpdateStatus(name string, status v1Cluster.Status, options common.DBOptions) error
 	Get(name string, options common.DBOptions) (*v1Cluster.Cluster, error)
 	List(options common.DBOptions) ([]v1Cluster.Cluster, error)
 	Delete(name string, options common.DBOptions) error
@@ -42,6 +44,16 @@ func (c *cluster) Update(name string, cluster *v1Cluster.Cluster, options common
 	return db.Update(cluster)
 }
 
+// UpdateStatus 只更新集群状态,不覆盖其他字段
+func (c *cluster) UpdateStatus(name string, status v1Cluster.Status, options common.DBOptions) error {
+	db := c.GetDB(options)
+	r, err := c.Get(name, options)
+	if err != nil {
+		return err
+	}
+	return db.UpdateField(r, "Status", status)
+}
+
 func (c *cluster) Create(cluster *v1Cluster.Cluster, options common.DBOptions) error {
 	db := c.GetDB(options)
 	cluster.UUID = uuid.New().String()
@@ -97,6 +109,8 @@ func (c *cluster) Search(num, size int, conditions common.Conditions, options co
 				ms = append(ms, storm.Like(field, conditions[k].Value))
 			case "not like":
 				ms = append(ms, q.Not(storm.Like(field, conditions[k].Value)))
+			case "in":
+				ms = append(ms, q.In(field, strings.Split(conditions[k].Value, ",")))
 			}
 		}
 	}
@@ -1,12 +1,21 @@
 package clusterbinding
 
 import (
+	"bytes"
 	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	v1 "github.com/KubeOperator/kubepi/internal/model/v1"
 	v1Cluster "github.com/KubeOperator/kubepi/internal/model/v1/cluster"
+	"github.com/KubeOperator/kubepi/internal/server"
 	"github.com/KubeOperator/kubepi/internal/service/v1/common"
+	"github.com/KubeOperator/kubepi/pkg/certificate"
+	"github.com/asdine/storm/v3"
 	"github.com/asdine/storm/v3/q"
 	"github.com/google/uuid"
-	"time"
 )
 
 type Service interface {
@@ -16,9 +25,21 @@ type Service interface {
 	UpdateClusterBinding(name string, binding *v1Cluster.Binding, options common.DBOptions) error
 	GetBindingByClusterNameAndUserName(clusterName string, userName string, options common.DBOptions) (*v1Cluster.Binding, error)
 	GetBindingsByUserName(userName string, options common.DBOptions) ([]v1Cluster.Binding, error)
+	GetCredentialBinding(clusterName string, userName string, options common.DBOptions) (*v1Cluster.Binding, error)
+	GetActiveCredentialBinding(clusterName string, userName string, options common.DBOptions) (*v1Cluster.Binding, error)
+	GetBindingByClusterNameAndGroupName(clusterName string, groupName string, options common.DBOptions) (*v1Cluster.Binding, error)
+	GetBindingsByGroupName(groupName string, options common.DBOptions) ([]v1Cluster.Binding, error)
+	ListExpired(now time.Time, options common.DBOptions) ([]v1Cluster.Binding, error)
+	UpdateExpireAt(name string, expireAt time.Time, options common.DBOptions) error
 	Delete(name string, options common.DBOptions) error
+	RevokeCertificate(clusterName string, userName string, cert []byte, reason string, options common.DBOptions) error
+	ListRevokedCertificates(clusterName string, options common.DBOptions) ([]v1Cluster.RevokedCertificate, error)
+	DeleteRevokedCertificates(clusterName string, options common.DBOptions) error
 }
 
+// ErrMemberExpired 成员已过期但还未被清理
+var ErrMemberExpired = errors.New("cluster member has expired")
+
 func NewService() Service {
 	return &service{}
 }
@@ -55,8 +76,66 @@ func (s *service) GetBindingsByUserName(userName string, options common.DBOption
 }
 
 func (s *service) GetBindingByClusterNameAndUserName(clusterName string, userName string, options common.DBOptions) (*v1Cluster.Binding, error) {
+	db := s.GetDB(options)
+	query := db.Select(q.And(q.Eq("ClusterRef", clusterName), q.Eq("UserRef", userName), q.Eq("GroupRef", "")))
+	var rb v1Cluster.Binding
+	if err := query.First(&rb); err != nil {
+		return nil, err
+	}
+	return &rb, nil
+}
+
+// GetCredentialBinding 返回保存用户集群证书的绑定,用户直接是成员或者通过用户组成为成员
+func (s *service) GetCredentialBinding(clusterName string, userName string, options common.DBOptions) (*v1Cluster.Binding, error) {
+	rb, err := s.GetBindingByClusterNameAndUserName(clusterName, userName, options)
+	if err == nil || !errors.Is(err, storm.ErrNotFound) {
+		return rb, err
+	}
 	db := s.GetDB(options)
 	query := db.Select(q.And(q.Eq("ClusterRef", clusterName), q.Eq("UserRef", userName)))
+	var b v1Cluster.Binding
+	if err := query.First(&b); err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
+
+// GetActiveCredentialBinding 同 GetCredentialBinding,优先返回未过期的绑定,直接添加的成员优先于用户组成员,
+// 全部绑定都已过期时返回 ErrMemberExpired,过期的成员在被定时清理之前不能再访问集群
+func (s *service) GetActiveCredentialBinding(clusterName string, userName string, options common.DBOptions) (*v1Cluster.Binding, error) {
+	db := s.GetDB(options)
+	var bindings []v1Cluster.Binding
+	if err := db.Select(q.And(q.Eq("ClusterRef", clusterName), q.Eq("UserRef", userName))).Find(&bindings); err != nil {
+		return nil, err
+	}
+	sort.SliceStable(bindings, func(i, j int) bool {
+		return bindings[i].GroupRef == "" && bindings[j].GroupRef != ""
+	})
+	now := time.Now()
+	for i := range bindings {
+		if bindings[i].Expired(now) {
+			continue
+		}
+		if bindings[i].GroupRef != "" {
+			gb, err := s.GetBindingByClusterNameAndGroupName(clusterName, bindings[i].GroupRef, options)
+			if errors.Is(err, storm.ErrNotFound) {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			if gb.Expired(now) {
+				continue
+			}
+		}
+		return &bindings[i], nil
+	}
+	return nil, ErrMemberExpired
+}
+
+func (s *service) GetBindingByClusterNameAndGroupName(clusterName string, groupName string, options common.DBOptions) (*v1Cluster.Binding, error) {
+	db := s.GetDB(options)
+	query := db.Select(q.And(q.Eq("ClusterRef", clusterName), q.Eq("GroupRef", groupName), q.Eq("UserRef", "")))
 	var rb v1Cluster.Binding
 	if err := query.First(&rb); err != nil {
 		return nil, err
@@ -64,6 +143,17 @@ func (s *service) GetBindingByClusterNameAndUserName(clusterName string, userNam
 	return &rb, nil
 }
 
+// GetBindingsByGroupName 返回用户组成员及其用户的访问凭证
+func (s *service) GetBindingsByGroupName(groupName string, options common.DBOptions) ([]v1Cluster.Binding, error) {
+	db := s.GetDB(options)
+	query := db.Select(q.Eq("GroupRef", groupName))
+	var rbs []v1Cluster.Binding
+	if err := query.Find(&rbs); err != nil {
+		return rbs, err
+	}
+	return rbs, nil
+}
+
 func (s *service) CreateClusterBinding(binding *v1Cluster.Binding, options common.DBOptions) error {
 	db := s.GetDB(options)
 	binding.UUID = uuid.New().String()
@@ -91,5 +181,95 @@ func (s *service) Delete(name string, options common.DBOptions) error {
 	if binding.BuiltIn {
 		return errors.New("can not delete this resource,because it created by system")
 	}
-	return db.DeleteStruct(&binding)
+	if err := db.DeleteStruct(&binding); err != nil {
+		return err
+	}
+	if len(binding.Certificate) == 0 || binding.UserRef == "" {
+		return nil
+	}
+	// 直接添加的成员和用户组成员共用同一张证书,证书不再被使用时才吊销
+	var rest []v1Cluster.Binding
+	if err := db.Select(q.Eq("ClusterRef", binding.ClusterRef), q.Eq("UserRef", binding.UserRef)).Find(&rest); err != nil && !errors.Is(err, storm.ErrNotFound) {
+		return err
+	}
+	for i := range rest {
+		if bytes.Equal(rest[i].Certificate, binding.Certificate) {
+			return nil
+		}
+	}
+	return s.RevokeCertificate(binding.ClusterRef, binding.UserRef, binding.Certificate, v1Cluster.RevokeReasonRemoved, options)
+}
+
+// RevokeCertificate 记录作废的用户证书,无法解析的证书记录日志后跳过,不影响删除成员等操作
+func (s *service) RevokeCertificate(clusterName string, userName string, cert []byte, reason string, options common.DBOptions) error {
+	db := s.GetDB(options)
+	c, err := certificate.ParseX509Certificate(cert)
+	if err != nil {
+		server.Logger().Warnf("skip revoking certificate of user %s in cluster %s: %s", userName, clusterName, err)
+		return nil
+	}
+	fingerprint := certificate.Fingerprint(c)
+	name := fmt.Sprintf("%s-%s", clusterName, strings.ReplaceAll(fingerprint, ":", ""))
+	var exists v1Cluster.RevokedCertificate
+	if err := db.One("Name", name, &exists); err == nil {
+		return nil
+	} else if !errors.Is(err, storm.ErrNotFound) {
+		return err
+	}
+	now := time.Now()
+	return db.Save(&v1Cluster.RevokedCertificate{
+		BaseModel: v1.BaseModel{
+			Kind:     "RevokedCertificate",
+			CreateAt: now,
+			UpdateAt: now,
+		},
+		Metadata: v1.Metadata{
+			Name: name,
+			UUID: uuid.New().String(),
+		},
+		ClusterRef:  clusterName,
+		UserRef:     userName,
+		Fingerprint: fingerprint,
+		NotAfter:    c.NotAfter,
+		Reason:      reason,
+	})
+}
+
+func (s *service) ListRevokedCertificates(clusterName string, options common.DBOptions) ([]v1Cluster.RevokedCertificate, error) {
+	db := s.GetDB(options)
+	var rcs []v1Cluster.RevokedCertificate
+	if err := db.Select(q.Eq("ClusterRef", clusterName)).OrderBy("CreateAt").Reverse().Find(&rcs); err != nil && !errors.Is(err, storm.ErrNotFound) {
+		return nil, err
+	}
+	return rcs, nil
+}
+
+func (s *service) DeleteRevokedCertificates(clusterName string, options common.DBOptions) error {
+	db := s.GetDB(options)
+	err := db.Select(q.Eq("ClusterRef", clusterName)).Delete(&v1Cluster.RevokedCertificate{})
+	if err != nil && !errors.Is(err, storm.ErrNotFound) {
+		return err
+	}
+	return nil
+}
+
+// ListExpired 返回到期的集群成员
+func (s *service) ListExpired(now time.Time, options common.DBOptions) ([]v1Cluster.Binding, error) {
+	db := s.GetDB(options)
+	query := db.Select(q.Gt("ExpireAt", time.Time{}), q.Lte("ExpireAt", now))
+	var rbs []v1Cluster.Binding
+	if err := query.Find(&rbs); err != nil {
+		return rbs, err
+	}
+	return rbs, nil
+}
+
+// UpdateExpireAt expireAt 为零值时取消过期时间
+func (s *service) UpdateExpireAt(name string, expireAt time.Time, options common.DBOptions) error {
+	db := s.GetDB(options)
+	var b v1Cluster.Binding
+	if err := db.One("Name", name, &b); err != nil {
+		return err
+	}
+	return db.UpdateField(&b, "ExpireAt", expireAt)
 }
@@ -0,0 +1,78 @@
+package clusterhealth
+
+import (
+	"errors"
+	"time"
+
+	v1Cluster "github.com/KubeOperator/kubepi/internal/model/v1/cluster"
+	"github.com/KubeOperator/kubepi/internal/service/v1/common"
+	"github.com/asdine/storm/v3"
+	"github.com/asdine/storm/v3/q"
+	"github.com/google/uuid"
+)
+
+type Service interface {
+	common.DBService
+	Create(record *v1Cluster.HealthRecord, options common.DBOptions) error
+	List(clusterName string, since time.Time, options common.DBOptions) ([]v1Cluster.HealthRecord, error)
+	Latest(clusterName string, options common.DBOptions) (*v1Cluster.HealthRecord, error)
+	DeleteBefore(t time.Time, options common.DBOptions) error
+	DeleteByCluster(clusterName string, options common.DBOptions) error
+}
+
+func NewService() Service {
+	return &service{}
+}
+
+type service struct {
+	common.DefaultDBService
+}
+
+func (s *service) Create(record *v1Cluster.HealthRecord, options common.DBOptions) error {
+	db := s.GetDB(options)
+	record.UUID = uuid.New().String()
+	record.Name = record.UUID
+	if record.CreateAt.IsZero() {
+		record.CreateAt = time.Now()
+	}
+	record.UpdateAt = record.CreateAt
+	return db.Save(record)
+}
+
+// List 按检查时间升序返回 since 之后的记录
+func (s *service) List(clusterName string, since time.Time, options common.DBOptions) ([]v1Cluster.HealthRecord, error) {
+	db := s.GetDB(options)
+	var records []v1Cluster.HealthRecord
+	query := db.Select(q.Eq("ClusterRef", clusterName), q.Gte("CreateAt", since)).OrderBy("CreateAt")
+	if err := query.Find(&records); err != nil && !errors.Is(err, storm.ErrNotFound) {
+		return nil, err
+	}
+	return records, nil
+}
+
+func (s *service) Latest(clusterName string, options common.DBOptions) (*v1Cluster.HealthRecord, error) {
+	db := s.GetDB(options)
+	var record v1Cluster.HealthRecord
+	if err := db.Select(q.Eq("ClusterRef", clusterName)).OrderBy("CreateAt").Reverse().First(&record); err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+func (s *service) DeleteBefore(t time.Time, options common.DBOptions) error {
+	db := s.GetDB(options)
+	err := db.Select(q.Lt("CreateAt", t)).Delete(&v1Cluster.HealthRecord{})
+	if err != nil && !errors.Is(err, storm.ErrNotFound) {
+		return err
+	}
+	return nil
+}
+
+func (s *service) DeleteByCluster(clusterName string, options common.DBOptions) error {
+	db := s.GetDB(options)
+	err := db.Select(q.Eq("ClusterRef", clusterName)).Delete(&v1Cluster.HealthRecord{})
+	if err != nil && !errors.Is(err, storm.ErrNotFound) {
+		return err
+	}
+	return nil
+}
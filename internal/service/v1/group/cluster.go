@@ -0,0 +1,211 @@
+package group
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	v1 "github.com/KubeOperator/kubepi/internal/model/v1"
+	v1Cluster "github.com/KubeOperator/kubepi/internal/model/v1/cluster"
+	"github.com/KubeOperator/kubepi/internal/service/v1/common"
+	"github.com/KubeOperator/kubepi/pkg/collectons"
+	"github.com/KubeOperator/kubepi/pkg/kubernetes"
+	"github.com/asdine/storm/v3"
+)
+
+// CreateClusterMember 将用户组添加为集群成员。
+// 用户证书中不包含用户组信息,所以为每个组成员分别创建集群访问凭证和 rbac 绑定
+func (s *service) CreateClusterMember(binding *v1Cluster.Binding) error {
+	if len(binding.ClusterRoles) == 0 && len(binding.NamespaceRoles) == 0 {
+		return errors.New("must select one role")
+	}
+	group, err := s.Get(binding.GroupRef, common.DBOptions{})
+	if err != nil {
+		if errors.Is(err, storm.ErrNotFound) {
+			return fmt.Errorf("group %s not found", binding.GroupRef)
+		}
+		return err
+	}
+	if _, err := s.clusterBindingService.GetBindingByClusterNameAndGroupName(binding.ClusterRef, group.Name, common.DBOptions{}); err == nil {
+		return fmt.Errorf("group %s is already a member of cluster %s", group.Name, binding.ClusterRef)
+	} else if !errors.Is(err, storm.ErrNotFound) {
+		return err
+	}
+	binding.Kind = "ClusterBinding"
+	binding.Name = fmt.Sprintf("%s-%s-group-binding", binding.ClusterRef, group.Name)
+	binding.UserRef = ""
+	if err := s.clusterBindingService.CreateClusterBinding(binding, common.DBOptions{}); err != nil {
+		return err
+	}
+	return s.syncCluster(binding, group.Members, true)
+}
+
+func (s *service) UpdateClusterMember(clusterName string, groupName string, clusterRoles []string, namespaceRoles []v1Cluster.NamespaceRoles, expireAt time.Time) error {
+	if len(clusterRoles) == 0 && len(namespaceRoles) == 0 {
+		return errors.New("must select one role")
+	}
+	binding, err := s.clusterBindingService.GetBindingByClusterNameAndGroupName(clusterName, groupName, common.DBOptions{})
+	if err != nil {
+		return err
+	}
+	group, err := s.Get(groupName, common.DBOptions{})
+	if err != nil {
+		return err
+	}
+	db := s.GetDB(common.DBOptions{})
+	fields := map[string]interface{}{
+		"ClusterRoles":   clusterRoles,
+		"NamespaceRoles": namespaceRoles,
+		"ExpireAt":       expireAt,
+		"UpdateAt":       time.Now(),
+	}
+	for field, value := range fields {
+		if err := db.UpdateField(binding, field, value); err != nil {
+			return err
+		}
+	}
+	binding.ClusterRoles = clusterRoles
+	binding.NamespaceRoles = namespaceRoles
+	return s.syncCluster(binding, group.Members, true)
+}
+
+func (s *service) DeleteClusterMember(clusterName string, groupName string) error {
+	if _, err := s.clusterBindingService.GetBindingByClusterNameAndGroupName(clusterName, groupName, common.DBOptions{}); err != nil {
+		return err
+	}
+	c, err := s.clusterService.Get(clusterName, common.DBOptions{})
+	if err != nil {
+		return err
+	}
+	bindings, err := s.clusterBindingService.GetBindingsByGroupName(groupName, common.DBOptions{})
+	if err != nil {
+		return err
+	}
+	k := kubernetes.NewKubernetes(c)
+	if err := k.CleanGroupRoleBinding(groupName, ""); err != nil {
+		return err
+	}
+	for i := range bindings {
+		if bindings[i].ClusterRef != clusterName {
+			continue
+		}
+		if err := s.clusterBindingService.Delete(bindings[i].Name, common.DBOptions{}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// syncClusters 成员变化后,为新成员创建绑定并移除已退出成员的绑定
+func (s *service) syncClusters(groupName string, members []string) error {
+	bindings, err := s.clusterBindingService.GetBindingsByGroupName(groupName, common.DBOptions{})
+	if err != nil && !errors.Is(err, storm.ErrNotFound) {
+		return err
+	}
+	var errs []error
+	for i := range bindings {
+		if !bindings[i].IsGroup() {
+			continue
+		}
+		if err := s.syncCluster(&bindings[i], members, false); err != nil {
+			errs = append(errs, fmt.Errorf("cluster %s: %s", bindings[i].ClusterRef, err.Error()))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// syncCluster rebuild 为 true 时重建所有成员的 rbac 绑定,否则只处理新增和退出的成员
+func (s *service) syncCluster(binding *v1Cluster.Binding, members []string, rebuild bool) error {
+	c, err := s.clusterService.Get(binding.ClusterRef, common.DBOptions{})
+	if err != nil {
+		return err
+	}
+	k := kubernetes.NewKubernetes(c)
+	bindings, err := s.clusterBindingService.GetBindingsByGroupName(binding.GroupRef, common.DBOptions{})
+	if err != nil && !errors.Is(err, storm.ErrNotFound) {
+		return err
+	}
+	current := map[string]*v1Cluster.Binding{}
+	for i := range bindings {
+		if bindings[i].ClusterRef == binding.ClusterRef && !bindings[i].IsGroup() {
+			current[bindings[i].UserRef] = &bindings[i]
+		}
+	}
+	var errs []error
+	for userName, b := range current {
+		if collectons.IndexOfStringSlice(members, userName) != -1 {
+			continue
+		}
+		if err := k.CleanGroupRoleBinding(binding.GroupRef, userName); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if err := s.clusterBindingService.Delete(b.Name, common.DBOptions{}); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	for _, userName := range members {
+		if _, ok := current[userName]; ok {
+			if !rebuild {
+				continue
+			}
+			if err := k.CleanGroupRoleBinding(binding.GroupRef, userName); err != nil {
+				errs = append(errs, err)
+				continue
+			}
+		} else if err := s.createCredential(k, binding, userName); err != nil {
+			errs = append(errs, fmt.Errorf("user %s: %s", userName, err.Error()))
+			continue
+		}
+		if err := applyRoles(k, binding, userName); err != nil {
+			errs = append(errs, fmt.Errorf("user %s: %s", userName, err.Error()))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// createCredential 用户已经是集群成员时复用其证书
+func (s *service) createCredential(k kubernetes.Interface, binding *v1Cluster.Binding, userName string) error {
+	var cert []byte
+	if b, err := s.clusterBindingService.GetCredentialBinding(binding.ClusterRef, userName, common.DBOptions{}); err == nil {
+		cert = b.Certificate
+	} else if !errors.Is(err, storm.ErrNotFound) {
+		return err
+	}
+	if cert == nil {
+		var err error
+		cert, err = k.CreateCommonUser(userName)
+		if err != nil {
+			return fmt.Errorf("create common user failed: %s", err.Error())
+		}
+	}
+	return s.clusterBindingService.CreateClusterBinding(&v1Cluster.Binding{
+		BaseModel: v1.BaseModel{
+			Kind:      "ClusterBinding",
+			CreatedBy: binding.CreatedBy,
+		},
+		Metadata: v1.Metadata{
+			Name: fmt.Sprintf("%s-%s-%s-group-member-binding", binding.ClusterRef, binding.GroupRef, userName),
+		},
+		UserRef:     userName,
+		GroupRef:    binding.GroupRef,
+		ClusterRef:  binding.ClusterRef,
+		Certificate: cert,
+	}, common.DBOptions{})
+}
+
+func applyRoles(k kubernetes.Interface, binding *v1Cluster.Binding, userName string) error {
+	for _, role := range binding.ClusterRoles {
+		if err := k.CreateOrUpdateGroupClusterRoleBinding(role, binding.GroupRef, userName); err != nil {
+			return err
+		}
+	}
+	for _, nr := range binding.NamespaceRoles {
+		for _, role := range nr.Roles {
+			if err := k.CreateOrUpdateGroupRolebinding(nr.Namespace, role, binding.GroupRef, userName); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,285 @@
+package group
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	v1Cluster "github.com/KubeOperator/kubepi/internal/model/v1/cluster"
+	v1Group "github.com/KubeOperator/kubepi/internal/model/v1/group"
+	v1Role "github.com/KubeOperator/kubepi/internal/model/v1/role"
+	"github.com/KubeOperator/kubepi/internal/server"
+	"github.com/KubeOperator/kubepi/internal/service/v1/cluster"
+	"github.com/KubeOperator/kubepi/internal/service/v1/clusterbinding"
+	"github.com/KubeOperator/kubepi/internal/service/v1/common"
+	"github.com/KubeOperator/kubepi/internal/service/v1/rolebinding"
+	"github.com/KubeOperator/kubepi/internal/service/v1/user"
+	"github.com/KubeOperator/kubepi/pkg/collectons"
+	costomStorm "github.com/KubeOperator/kubepi/pkg/storm"
+	"github.com/KubeOperator/kubepi/pkg/util/lang"
+	"github.com/asdine/storm/v3"
+	"github.com/asdine/storm/v3/q"
+	"github.com/google/uuid"
+)
+
+type Service interface {
+	common.DBService
+	Create(group *v1Group.Group, options common.DBOptions) error
+	Get(name string, options common.DBOptions) (*v1Group.Group, error)
+	List(options common.DBOptions) ([]v1Group.Group, error)
+	Search(num, size int, conditions common.Conditions, options common.DBOptions) ([]v1Group.Group, int, error)
+	Update(name string, group *v1Group.Group) error
+	Delete(name string) error
+	ListByMember(userName string, options common.DBOptions) ([]v1Group.Group, error)
+	AddMembers(name string, members []string) error
+	RemoveMember(name string, userName string) error
+	RemoveUser(userName string) error
+	CreateClusterMember(binding *v1Cluster.Binding) error
+	UpdateClusterMember(clusterName string, groupName string, clusterRoles []string, namespaceRoles []v1Cluster.NamespaceRoles, expireAt time.Time) error
+	DeleteClusterMember(clusterName string, groupName string) error
+}
+
+func NewService() Service {
+	return &service{
+		userService:           user.NewService(),
+		clusterService:        cluster.NewService(),
+		clusterBindingService: clusterbinding.NewService(),
+		roleBindingService:    rolebinding.NewService(),
+	}
+}
+
+type service struct {
+	common.DefaultDBService
+	userService           user.Service
+	clusterService        cluster.Service
+	clusterBindingService clusterbinding.Service
+	roleBindingService    rolebinding.Service
+}
+
+func (s *service) Create(group *v1Group.Group, options common.DBOptions) error {
+	db := s.GetDB(options)
+	if group.Name == "" {
+		return errors.New("group name can not be none")
+	}
+	members, err := s.validateMembers(group.Members)
+	if err != nil {
+		return err
+	}
+	group.Members = members
+	group.UUID = uuid.New().String()
+	group.CreateAt = time.Now()
+	group.UpdateAt = time.Now()
+	return db.Save(group)
+}
+
+func (s *service) Get(name string, options common.DBOptions) (*v1Group.Group, error) {
+	db := s.GetDB(options)
+	var group v1Group.Group
+	if err := db.One("Name", name, &group); err != nil {
+		return nil, err
+	}
+	return &group, nil
+}
+
+func (s *service) List(options common.DBOptions) ([]v1Group.Group, error) {
+	db := s.GetDB(options)
+	groups := make([]v1Group.Group, 0)
+	if err := db.All(&groups); err != nil {
+		return groups, err
+	}
+	return groups, nil
+}
+
+func (s *service) Search(num, size int, conditions common.Conditions, options common.DBOptions) ([]v1Group.Group, int, error) {
+	db := s.GetDB(options)
+	var ms []q.Matcher
+	for k := range conditions {
+		if conditions[k].Field == "quick" {
+			ms = append(ms, costomStorm.Like("Name", conditions[k].Value))
+		} else {
+			field := lang.FirstToUpper(conditions[k].Field)
+			value := lang.ParseValueType(conditions[k].Value)
+
+			switch conditions[k].Operator {
+			case "eq":
+				ms = append(ms, q.Eq(field, value))
+			case "ne":
+				ms = append(ms, q.Not(q.Eq(field, value)))
+			case "like":
+				ms = append(ms, costomStorm.Like(field, value.(string)))
+			case "not like":
+				ms = append(ms, q.Not(costomStorm.Like(field, value.(string))))
+			}
+		}
+	}
+	query := db.Select(ms...).OrderBy("Name")
+	count, err := query.Count(&v1Group.Group{})
+	if err != nil {
+		return nil, 0, err
+	}
+	if size != 0 {
+		query.Limit(size).Skip((num - 1) * size)
+	}
+	groups := make([]v1Group.Group, 0)
+	if err := query.Find(&groups); err != nil {
+		return nil, 0, err
+	}
+	return groups, count, nil
+}
+
+// Update 更新描述和成员,成员变化后同步用户组所在集群的角色绑定
+func (s *service) Update(name string, group *v1Group.Group) error {
+	old, err := s.Get(name, common.DBOptions{})
+	if err != nil {
+		return err
+	}
+	members, err := s.validateMembers(group.Members)
+	if err != nil {
+		return err
+	}
+	if err := s.saveMembers(old, group.Description, members); err != nil {
+		return err
+	}
+	return s.syncClusters(old.Name, members)
+}
+
+// Delete 删除用户组的角色绑定和集群成员
+func (s *service) Delete(name string) error {
+	group, err := s.Get(name, common.DBOptions{})
+	if err != nil {
+		return err
+	}
+	bindings, err := s.clusterBindingService.GetBindingsByGroupName(name, common.DBOptions{})
+	if err != nil && !errors.Is(err, storm.ErrNotFound) {
+		return err
+	}
+	for i := range bindings {
+		if !bindings[i].IsGroup() {
+			continue
+		}
+		if err := s.DeleteClusterMember(bindings[i].ClusterRef, name); err != nil {
+			return err
+		}
+	}
+	tx, err := server.DB().Begin(true)
+	if err != nil {
+		return err
+	}
+	txOptions := common.DBOptions{DB: tx}
+	rbs, err := s.roleBindingService.GetRoleBindingBySubject(v1Role.Subject{Kind: "Group", Name: name}, txOptions)
+	if err != nil && !errors.Is(err, storm.ErrNotFound) {
+		_ = tx.Rollback()
+		return err
+	}
+	for i := range rbs {
+		if err := s.roleBindingService.Delete(rbs[i].Name, txOptions); err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+	}
+	if err := tx.DeleteStruct(group); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *service) ListByMember(userName string, options common.DBOptions) ([]v1Group.Group, error) {
+	db := s.GetDB(options)
+	groups := make([]v1Group.Group, 0)
+	if err := db.Select(costomStorm.ArrayValueEq("Members", userName)).Find(&groups); err != nil {
+		return groups, err
+	}
+	return groups, nil
+}
+
+func (s *service) AddMembers(name string, members []string) error {
+	group, err := s.Get(name, common.DBOptions{})
+	if err != nil {
+		return err
+	}
+	added, err := s.validateMembers(members)
+	if err != nil {
+		return err
+	}
+	all := collectons.NewStringSet()
+	for i := range group.Members {
+		all.Add(group.Members[i])
+	}
+	for i := range added {
+		all.Add(added[i])
+	}
+	if err := s.saveMembers(group, group.Description, all.ToSlice()); err != nil {
+		return err
+	}
+	return s.syncClusters(name, all.ToSlice())
+}
+
+func (s *service) RemoveMember(name string, userName string) error {
+	group, err := s.Get(name, common.DBOptions{})
+	if err != nil {
+		return err
+	}
+	if collectons.IndexOfStringSlice(group.Members, userName) == -1 {
+		return fmt.Errorf("user %s is not a member of group %s", userName, name)
+	}
+	members := make([]string, 0, len(group.Members))
+	for i := range group.Members {
+		if group.Members[i] != userName {
+			members = append(members, group.Members[i])
+		}
+	}
+	if err := s.saveMembers(group, group.Description, members); err != nil {
+		return err
+	}
+	return s.syncClusters(name, members)
+}
+
+// RemoveUser 删除用户前将其从所有用户组中移除
+func (s *service) RemoveUser(userName string) error {
+	groups, err := s.ListByMember(userName, common.DBOptions{})
+	if err != nil && !errors.Is(err, storm.ErrNotFound) {
+		return err
+	}
+	for i := range groups {
+		if err := s.RemoveMember(groups[i].Name, userName); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateMembers 检查用户是否存在,以邮箱添加的成员转换为用户名后去重
+func (s *service) validateMembers(members []string) ([]string, error) {
+	set := collectons.NewStringSet()
+	for i := range members {
+		if members[i] == "" {
+			continue
+		}
+		u, err := s.userService.GetByNameOrEmail(members[i], common.DBOptions{})
+		if err != nil {
+			if errors.Is(err, storm.ErrNotFound) {
+				return nil, fmt.Errorf("user %s not found", members[i])
+			}
+			return nil, err
+		}
+		set.Add(u.Name)
+	}
+	return set.ToSlice(), nil
+}
+
+// saveMembers 成员为空时 db.Update 不会清空字段
+func (s *service) saveMembers(group *v1Group.Group, description string, members []string) error {
+	db := s.GetDB(common.DBOptions{})
+	fields := map[string]interface{}{
+		"Description": description,
+		"Members":     members,
+		"UpdateAt":    time.Now(),
+	}
+	for field, value := range fields {
+		if err := db.UpdateField(group, field, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -2,6 +2,7 @@ package imagerepo
 
 import (
 	"errors"
+	"strings"
 	"time"
 
 	V1ClusterRepo "github.com/KubeOperator/kubepi/internal/model/v1/clusterrepo"
@@ -171,6 +172,8 @@ func (s *service) Search(num, size int, conditions common.Conditions, options co
 				ms = append(ms, costomStorm.Like(field, value.(string)))
 			case "not like":
 				ms = append(ms, q.Not(costomStorm.Like(field, value.(string))))
+			case "in":
+				ms = append(ms, q.In(field, strings.Split(conditions[k].Value, ",")))
 			}
 		}
 	}
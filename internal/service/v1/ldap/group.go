@@ -0,0 +1,284 @@
+package ldap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	v1 "github.com/KubeOperator/kubepi/internal/model/v1"
+	v1Cluster "github.com/KubeOperator/kubepi/internal/model/v1/cluster"
+	v1Ldap "github.com/KubeOperator/kubepi/internal/model/v1/ldap"
+	"github.com/KubeOperator/kubepi/internal/service/v1/common"
+	"github.com/KubeOperator/kubepi/pkg/collectons"
+	"github.com/KubeOperator/kubepi/pkg/kubernetes"
+	ldapClient "github.com/KubeOperator/kubepi/pkg/util/ldap"
+	"github.com/asdine/storm/v3"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// validateMappings 检查映射中的角色和集群是否存在
+func (l *service) validateMappings(ldap *v1Ldap.Ldap) error {
+	if (len(ldap.RoleMappings) > 0 || len(ldap.ClusterMappings) > 0) && ldap.Groups.BaseDn == "" {
+		return errors.New("group base dn can not be none when group mappings are configured")
+	}
+	roles := collectons.NewStringSet()
+	for _, m := range ldap.RoleMappings {
+		if m.Group == "" {
+			return errors.New("group of role mapping can not be none")
+		}
+		for i := range m.Roles {
+			roles.Add(m.Roles[i])
+		}
+	}
+	for i := range ldap.DefaultRoles {
+		roles.Add(ldap.DefaultRoles[i])
+	}
+	for _, name := range roles.ToSlice() {
+		if _, err := l.roleService.Get(name, common.DBOptions{}); err != nil {
+			if errors.Is(err, storm.ErrNotFound) {
+				return fmt.Errorf("role %s not found", name)
+			}
+			return err
+		}
+	}
+	for _, m := range ldap.ClusterMappings {
+		if m.Group == "" {
+			return errors.New("group of cluster mapping can not be none")
+		}
+		if len(m.ClusterRoles) == 0 && len(m.NamespaceRoles) == 0 {
+			return errors.New("must select one role")
+		}
+		if _, err := l.clusterService.Get(m.Cluster, common.DBOptions{}); err != nil {
+			if errors.Is(err, storm.ErrNotFound) {
+				return fmt.Errorf("cluster %s not found", m.Cluster)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// loadGroups 查询全部用户组,同步时只查询一次
+func (l *service) loadGroups(ldap *v1Ldap.Ldap) ([]ldapClient.Group, error) {
+	conf := ldap.Groups.WithDefaults()
+	lc := newClient(ldap).UsePool(ldap.UUID)
+	if err := lc.Connect(); err != nil {
+		return nil, err
+	}
+	return lc.SearchGroups(conf.BaseDn, conf.Filter, conf.MemberAttribute, conf.NameAttribute, ldap.SizeLimit, ldap.TimeLimit)
+}
+
+// userGroups memberUid 等属性记录的是用户名而不是 DN
+func userGroups(ldap *v1Ldap.Ldap, groups []ldapClient.Group, userName, userDN string) []string {
+	conf := ldap.Groups.WithDefaults()
+	member := userDN
+	if strings.EqualFold(conf.MemberAttribute, "memberUid") {
+		member = userName
+	}
+	return ldapClient.ResolveGroups(groups, member, conf.Nested)
+}
+
+// applyGroupMappings 根据用户所属的用户组调整角色和集群成员,手动添加的绑定不受影响
+func (l *service) applyGroupMappings(ldap *v1Ldap.Ldap, userName string, groups []string) error {
+	var errs []error
+	if len(ldap.RoleMappings) > 0 {
+		desired := collectons.NewStringSet()
+		for _, m := range ldap.RoleMappings {
+			if collectons.IndexOfStringSlice(groups, m.Group) == -1 {
+				continue
+			}
+			for i := range m.Roles {
+				desired.Add(m.Roles[i])
+			}
+		}
+		if len(desired.ToSlice()) == 0 {
+			for i := range ldap.DefaultRoles {
+				desired.Add(ldap.DefaultRoles[i])
+			}
+		}
+		if err := l.roleBindingService.SyncUserRoleBindings(userName, desired.ToSlice(), v1Ldap.BindingCreator); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	type memberRoles struct {
+		clusterRoles   *collectons.StringSet
+		namespaceRoles map[string]*collectons.StringSet
+	}
+	clusters := map[string]*memberRoles{}
+	// 映射中已删除的集群也需要移除由 ldap 添加的成员
+	bindings, err := l.clusterBindingService.GetBindingsByUserName(userName, common.DBOptions{})
+	if err != nil && !errors.Is(err, storm.ErrNotFound) {
+		return err
+	}
+	for i := range bindings {
+		if bindings[i].CreatedBy == v1Ldap.BindingCreator {
+			clusters[bindings[i].ClusterRef] = &memberRoles{clusterRoles: collectons.NewStringSet(), namespaceRoles: map[string]*collectons.StringSet{}}
+		}
+	}
+	for _, m := range ldap.ClusterMappings {
+		r, ok := clusters[m.Cluster]
+		if !ok {
+			r = &memberRoles{clusterRoles: collectons.NewStringSet(), namespaceRoles: map[string]*collectons.StringSet{}}
+			clusters[m.Cluster] = r
+		}
+		if collectons.IndexOfStringSlice(groups, m.Group) == -1 {
+			continue
+		}
+		for i := range m.ClusterRoles {
+			r.clusterRoles.Add(m.ClusterRoles[i])
+		}
+		for _, nr := range m.NamespaceRoles {
+			if _, ok := r.namespaceRoles[nr.Namespace]; !ok {
+				r.namespaceRoles[nr.Namespace] = collectons.NewStringSet()
+			}
+			for i := range nr.Roles {
+				r.namespaceRoles[nr.Namespace].Add(nr.Roles[i])
+			}
+		}
+	}
+	for clusterName, r := range clusters {
+		if err := l.syncClusterMember(clusterName, userName, r.clusterRoles, r.namespaceRoles); err != nil {
+			errs = append(errs, fmt.Errorf("cluster %s: %s", clusterName, err.Error()))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// syncClusterMember 角色为空时移除由 ldap 添加的成员,角色变化时重建 rbac 绑定
+func (l *service) syncClusterMember(clusterName, userName string, clusterRoles *collectons.StringSet, namespaceRoles map[string]*collectons.StringSet) error {
+	c, err := l.clusterService.Get(clusterName, common.DBOptions{})
+	if err != nil {
+		return err
+	}
+	// 集群导入者始终是集群管理员
+	if c.CreatedBy == userName {
+		return nil
+	}
+	binding, err := l.clusterBindingService.GetBindingByClusterNameAndUserName(clusterName, userName, common.DBOptions{})
+	if err != nil {
+		if !errors.Is(err, storm.ErrNotFound) {
+			return err
+		}
+		binding = nil
+	}
+	if binding != nil && binding.CreatedBy != v1Ldap.BindingCreator {
+		return nil
+	}
+	empty := len(clusterRoles.ToSlice()) == 0 && len(namespaceRoles) == 0
+	if binding == nil && empty {
+		return nil
+	}
+	k := kubernetes.NewKubernetes(c)
+	if binding != nil {
+		current, currentNs, err := memberRoleBindings(k, c.UUID, userName)
+		if err != nil {
+			return err
+		}
+		if !empty && sameRoles(clusterRoles, current) && len(namespaceRoles) == len(currentNs) {
+			same := true
+			for ns := range namespaceRoles {
+				if !sameRoles(namespaceRoles[ns], currentNs[ns]) {
+					same = false
+					break
+				}
+			}
+			if same {
+				return nil
+			}
+		}
+		if err := k.CleanManagedClusterRoleBinding(userName); err != nil {
+			return err
+		}
+		if err := k.CleanManagedRoleBinding(userName); err != nil {
+			return err
+		}
+		if empty {
+			return l.clusterBindingService.Delete(binding.Name, common.DBOptions{})
+		}
+	} else {
+		cert, err := k.CreateCommonUser(userName)
+		if err != nil {
+			return fmt.Errorf("create common user failed: %s", err.Error())
+		}
+		binding = &v1Cluster.Binding{
+			BaseModel: v1.BaseModel{
+				Kind:      "ClusterBinding",
+				CreatedBy: v1Ldap.BindingCreator,
+			},
+			Metadata: v1.Metadata{
+				Name: fmt.Sprintf("%s-%s-cluster-binding", clusterName, userName),
+			},
+			UserRef:     userName,
+			ClusterRef:  clusterName,
+			Certificate: cert,
+		}
+		if err := l.clusterBindingService.CreateClusterBinding(binding, common.DBOptions{}); err != nil {
+			return err
+		}
+	}
+	for _, role := range clusterRoles.ToSlice() {
+		if err := k.CreateOrUpdateClusterRoleBinding(role, userName, false); err != nil {
+			return err
+		}
+	}
+	for ns, roles := range namespaceRoles {
+		for _, role := range roles.ToSlice() {
+			if err := k.CreateOrUpdateRolebinding(ns, role, userName, false); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// memberRoleBindings 查询集群中 kubepi 为用户创建的集群角色和命名空间角色
+func memberRoleBindings(k kubernetes.Interface, clusterID, userName string) (*collectons.StringSet, map[string]*collectons.StringSet, error) {
+	client, err := k.Client()
+	if err != nil {
+		return nil, nil, err
+	}
+	selector := metav1.ListOptions{
+		LabelSelector: strings.Join([]string{
+			fmt.Sprintf("%s=%s", kubernetes.LabelManageKey, "kubepi"),
+			fmt.Sprintf("%s=%s", kubernetes.LabelClusterId, clusterID),
+			fmt.Sprintf("%s=%s", kubernetes.LabelUsername, userName),
+			fmt.Sprintf("!%s", kubernetes.LabelGroupname),
+		}, ","),
+	}
+	crbs, err := client.RbacV1().ClusterRoleBindings().List(context.TODO(), selector)
+	if err != nil {
+		return nil, nil, err
+	}
+	clusterRoles := collectons.NewStringSet()
+	for i := range crbs.Items {
+		clusterRoles.Add(crbs.Items[i].RoleRef.Name)
+	}
+	rbs, err := client.RbacV1().RoleBindings("").List(context.TODO(), selector)
+	if err != nil {
+		return nil, nil, err
+	}
+	namespaceRoles := map[string]*collectons.StringSet{}
+	for i := range rbs.Items {
+		ns := rbs.Items[i].Namespace
+		if _, ok := namespaceRoles[ns]; !ok {
+			namespaceRoles[ns] = collectons.NewStringSet()
+		}
+		namespaceRoles[ns].Add(rbs.Items[i].RoleRef.Name)
+	}
+	return clusterRoles, namespaceRoles, nil
+}
+
+func sameRoles(a, b *collectons.StringSet) bool {
+	as, bs := a.ToSlice(), b.ToSlice()
+	if len(as) != len(bs) {
+		return false
+	}
+	for i := range as {
+		if !b.Exists(as[i]) {
+			return false
+		}
+	}
+	return true
+}
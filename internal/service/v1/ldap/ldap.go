@@ -6,11 +6,15 @@ import (
 	"fmt"
 	v1 "github.com/KubeOperator/kubepi/internal/model/v1"
 	v1Ldap "github.com/KubeOperator/kubepi/internal/model/v1/ldap"
-	v1Role "github.com/KubeOperator/kubepi/internal/model/v1/role"
 	v1User "github.com/KubeOperator/kubepi/internal/model/v1/user"
 	"github.com/KubeOperator/kubepi/internal/server"
+	"github.com/KubeOperator/kubepi/internal/service/v1/cluster"
+	"github.com/KubeOperator/kubepi/internal/service/v1/clusterbinding"
 	"github.com/KubeOperator/kubepi/internal/service/v1/common"
+	"github.com/KubeOperator/kubepi/internal/service/v1/group"
+	"github.com/KubeOperator/kubepi/internal/service/v1/role"
 	"github.com/KubeOperator/kubepi/internal/service/v1/rolebinding"
+	"github.com/KubeOperator/kubepi/internal/service/v1/token"
 	"github.com/KubeOperator/kubepi/internal/service/v1/user"
 	ldapClient "github.com/KubeOperator/kubepi/pkg/util/ldap"
 	"github.com/asdine/storm/v3"
@@ -18,6 +22,7 @@ import (
 	"github.com/google/uuid"
 	"reflect"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -28,26 +33,38 @@ type Service interface {
 	Update(id string, ldap *v1Ldap.Ldap, options common.DBOptions) error
 	GetById(id string, options common.DBOptions) (*v1Ldap.Ldap, error)
 	Delete(id string, options common.DBOptions) error
-	Sync(id string, options common.DBOptions) error
+	Sync(id string, trigger string, options common.DBOptions) (*v1Ldap.SyncReport, error)
+	ListSyncReports(ldapID string, options common.DBOptions) ([]v1Ldap.SyncReport, error)
+	GetSyncReport(name string, options common.DBOptions) (*v1Ldap.SyncReport, error)
 	Login(user v1User.User, password string, options common.DBOptions) error
 	TestConnect(ldap *v1Ldap.Ldap) (int, error)
-	TestLogin(username string, password string) error
-	ImportUsers(users []v1User.ImportUser) (v1User.ImportResult, error)
-	CheckStatus() bool
-	GetLdapUser() ([]v1User.ImportUser, error)
+	TestLogin(id string, username string, password string) error
+	ImportUsers(id string, users []v1User.ImportUser) (v1User.ImportResult, error)
+	CheckStatus(id string) bool
+	GetLdapUser(id string) ([]v1User.ImportUser, error)
 }
 
 func NewService() Service {
 	return &service{
-		userService:        user.NewService(),
-		roleBindingService: rolebinding.NewService(),
+		userService:           user.NewService(),
+		roleBindingService:    rolebinding.NewService(),
+		roleService:           role.NewService(),
+		clusterService:        cluster.NewService(),
+		clusterBindingService: clusterbinding.NewService(),
+		tokenService:          token.NewService(),
+		groupService:          group.NewService(),
 	}
 }
 
 type service struct {
 	common.DefaultDBService
-	userService        user.Service
-	roleBindingService rolebinding.Service
+	userService           user.Service
+	roleBindingService    rolebinding.Service
+	roleService           role.Service
+	clusterService        cluster.Service
+	clusterBindingService clusterbinding.Service
+	tokenService          token.Service
+	groupService          group.Service
 }
 
 func (l *service) Create(ldap *v1Ldap.Ldap, options common.DBOptions) error {
@@ -56,11 +73,24 @@ func (l *service) Create(ldap *v1Ldap.Ldap, options common.DBOptions) error {
 	if err != nil {
 		return err
 	}
-	lc := ldapClient.NewLdapClient(ldap.Address, ldap.Port, ldap.Username, ldap.Password, ldap.TLS)
+	if err := validateSync(ldap); err != nil {
+		return err
+	}
+	if err := l.validateMappings(ldap); err != nil {
+		return err
+	}
+	if err := l.validateName(ldap, options); err != nil {
+		return err
+	}
+	if err := validateTLS(ldap); err != nil {
+		return err
+	}
+	lc := newClient(ldap)
 	err = lc.Connect()
 	if err != nil {
 		return err
 	}
+	lc.Close()
 	db := l.GetDB(options)
 	ldap.UUID = uuid.New().String()
 	ldap.CreateAt = time.Now()
@@ -83,17 +113,37 @@ func (l *service) Update(id string, ldap *v1Ldap.Ldap, options common.DBOptions)
 	if err != nil {
 		return err
 	}
-	lc := ldapClient.NewLdapClient(ldap.Address, ldap.Port, ldap.Username, ldap.Password, ldap.TLS)
-	if err := lc.Connect(); err != nil {
+	if err := validateSync(ldap); err != nil {
+		return err
+	}
+	if err := l.validateMappings(ldap); err != nil {
 		return err
 	}
 	old, err := l.GetById(id, options)
 	if err != nil {
 		return err
 	}
+	// 列表接口不返回客户端私钥,未修改证书时沿用原来的私钥
+	if ldap.ClientCert != "" && ldap.ClientKey == "" {
+		ldap.ClientKey = old.ClientKey
+	}
+	if err := validateTLS(ldap); err != nil {
+		return err
+	}
+	lc := newClient(ldap)
+	if err := lc.Connect(); err != nil {
+		return err
+	}
+	lc.Close()
 	ldap.UUID = old.UUID
 	ldap.CreateAt = old.CreateAt
 	ldap.UpdateAt = time.Now()
+	if ldap.Name == "" {
+		ldap.Name = old.Name
+	}
+	if err := l.validateName(ldap, options); err != nil {
+		return err
+	}
 	db := l.GetDB(options)
 	if ldap.Enable != old.Enable {
 		err = db.UpdateField(ldap, "Enable", ldap.Enable)
@@ -107,6 +157,36 @@ func (l *service) Update(id string, ldap *v1Ldap.Ldap, options common.DBOptions)
 			return err
 		}
 	}
+	if ldap.SyncSchedule != old.SyncSchedule {
+		err = db.UpdateField(ldap, "SyncSchedule", ldap.SyncSchedule)
+		if err != nil {
+			return err
+		}
+	}
+	// 映射可能被清空,需要单独更新
+	for field, value := range map[string]interface{}{
+		"Groups":          ldap.Groups,
+		"RoleMappings":    ldap.RoleMappings,
+		"DefaultRoles":    ldap.DefaultRoles,
+		"ClusterMappings": ldap.ClusterMappings,
+	} {
+		if err := db.UpdateField(ldap, field, value); err != nil {
+			return err
+		}
+	}
+	// 证书配置可能被清空
+	for field, value := range map[string]interface{}{
+		"StartTLS":           ldap.StartTLS,
+		"CACert":             ldap.CACert,
+		"ServerName":         ldap.ServerName,
+		"ClientCert":         ldap.ClientCert,
+		"ClientKey":          ldap.ClientKey,
+		"InsecureSkipVerify": ldap.InsecureSkipVerify,
+	} {
+		if err := db.UpdateField(ldap, field, value); err != nil {
+			return err
+		}
+	}
 	return db.Update(ldap)
 }
 
@@ -126,23 +206,91 @@ func (l *service) Delete(id string, options common.DBOptions) error {
 	if err != nil {
 		return err
 	}
-	return db.DeleteStruct(ldap)
+	var users []v1User.User
+	if err := db.Select(q.Eq("LdapID", ldap.UUID)).Limit(1).Find(&users); err != nil && !errors.Is(err, storm.ErrNotFound) {
+		return err
+	}
+	if len(users) > 0 {
+		return errors.New("please delete the users of this ldap first")
+	}
+	if err := db.Select(q.Eq("LdapID", ldap.UUID)).Delete(&v1Ldap.SyncReport{}); err != nil && !errors.Is(err, storm.ErrNotFound) {
+		return err
+	}
+	if err := db.DeleteStruct(ldap); err != nil {
+		return err
+	}
+	ldapClient.ClosePool(ldap.UUID)
+	return nil
 }
 
-func (l *service) GetLdapUser() ([]v1User.ImportUser, error) {
-	users := []v1User.ImportUser{}
-	ldaps, err := l.List(common.DBOptions{})
+// newClient 按照目录的 TLS 配置创建客户端
+func newClient(ldap *v1Ldap.Ldap) *ldapClient.Ldap {
+	lc := ldapClient.NewLdapClient(ldap.Address, ldap.Port, ldap.Username, ldap.Password, ldap.TLS)
+	lc.StartTLS = ldap.StartTLS
+	lc.TLSOptions = ldapClient.TLSOptions{
+		CACert:             ldap.CACert,
+		ServerName:         ldap.ServerName,
+		ClientCert:         ldap.ClientCert,
+		ClientKey:          ldap.ClientKey,
+		InsecureSkipVerify: ldap.InsecureSkipVerify,
+	}
+	return lc
+}
+
+func validateTLS(ldap *v1Ldap.Ldap) error {
+	if ldap.TLS && ldap.StartTLS {
+		return errors.New("tls and starttls can not be enabled at the same time")
+	}
+	if ldap.TLS || ldap.StartTLS {
+		if _, err := newClient(ldap).TLSOptions.Config(ldap.Address); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getLdap id 为空时返回第一个配置,兼容只有一个目录时的调用方式
+func (l *service) getLdap(id string, options common.DBOptions) (*v1Ldap.Ldap, error) {
+	if id != "" {
+		return l.GetById(id, options)
+	}
+	ldaps, err := l.List(options)
 	if err != nil {
-		return users, err
+		return nil, err
 	}
 	if len(ldaps) == 0 {
-		return users, errors.New("请先保存LDAP配置")
+		return nil, errors.New("请先保存LDAP配置")
+	}
+	return &ldaps[0], nil
+}
+
+// validateName 目录名称不能为空且不能重复
+func (l *service) validateName(ldap *v1Ldap.Ldap, options common.DBOptions) error {
+	if ldap.Name == "" {
+		return errors.New("ldap name can not be none")
+	}
+	ldaps, err := l.List(options)
+	if err != nil {
+		return err
+	}
+	for i := range ldaps {
+		if ldaps[i].Name == ldap.Name && ldaps[i].UUID != ldap.UUID {
+			return fmt.Errorf("ldap %s already exists", ldap.Name)
+		}
+	}
+	return nil
+}
+
+func (l *service) GetLdapUser(id string) ([]v1User.ImportUser, error) {
+	users := []v1User.ImportUser{}
+	ldap, err := l.getLdap(id, common.DBOptions{})
+	if err != nil {
+		return users, err
 	}
-	ldap := ldaps[0]
 	if !ldap.Enable {
 		return users, errors.New("请先启用LDAP")
 	}
-	lc := ldapClient.NewLdapClient(ldap.Address, ldap.Port, ldap.Username, ldap.Password, ldap.TLS)
+	lc := newClient(ldap).UsePool(ldap.UUID)
 	if err := lc.Connect(); err != nil {
 		return users, err
 	}
@@ -193,7 +341,7 @@ func (l *service) TestConnect(ldap *v1Ldap.Ldap) (int, error) {
 		return users, errors.New("请先启用LDAP")
 	}
 
-	lc := ldapClient.NewLdapClient(ldap.Address, ldap.Port, ldap.Username, ldap.Password, ldap.TLS)
+	lc := newClient(ldap)
 	if err := lc.Connect(); err != nil {
 		return users, err
 	}
@@ -212,24 +360,19 @@ func (l *service) TestConnect(ldap *v1Ldap.Ldap) (int, error) {
 	return len(entries), nil
 }
 
-func (l *service) CheckStatus() bool {
-	ldaps, err := l.List(common.DBOptions{})
-	if err != nil || len(ldaps) == 0 {
+func (l *service) CheckStatus(id string) bool {
+	ldap, err := l.getLdap(id, common.DBOptions{})
+	if err != nil {
 		return false
 	}
-	ldap := ldaps[0]
 	return ldap.Enable
 }
 
-func (l *service) TestLogin(username string, password string) error {
-	ldaps, err := l.List(common.DBOptions{})
+func (l *service) TestLogin(id string, username string, password string) error {
+	ldap, err := l.getLdap(id, common.DBOptions{})
 	if err != nil {
 		return err
 	}
-	if len(ldaps) == 0 {
-		return errors.New("请先保存LDAP配置")
-	}
-	ldap := ldaps[0]
 
 	mappings, err := ldap.GetMappings()
 	if err != nil {
@@ -241,19 +384,20 @@ func (l *service) TestLogin(username string, password string) error {
 			userFilter = "(" + v + "=" + username + ")"
 		}
 	}
-	lc := ldapClient.NewLdapClient(ldap.Address, ldap.Port, ldap.Username, ldap.Password, ldap.TLS)
+	lc := newClient(ldap).UsePool(ldap.UUID)
 	if err := lc.Connect(); err != nil {
 		return err
 	}
-	return lc.Login(ldap.Dn, userFilter, password, ldap.SizeLimit, ldap.TimeLimit)
+	_, err = lc.Login(ldap.Dn, userFilter, password, ldap.SizeLimit, ldap.TimeLimit)
+	return err
 }
 
+// Login 使用用户所属的目录校验密码
 func (l *service) Login(user v1User.User, password string, options common.DBOptions) error {
-	ldaps, err := l.List(options)
+	ldap, err := l.getLdap(user.LdapID, options)
 	if err != nil {
 		return err
 	}
-	ldap := ldaps[0]
 
 	mappings, err := ldap.GetMappings()
 	if err != nil {
@@ -265,23 +409,57 @@ func (l *service) Login(user v1User.User, password string, options common.DBOpti
 			userFilter = "(" + v + "=" + user.Name + ")"
 		}
 	}
-	lc := ldapClient.NewLdapClient(ldap.Address, ldap.Port, ldap.Username, ldap.Password, ldap.TLS)
+	lc := newClient(ldap).UsePool(ldap.UUID)
 	if err := lc.Connect(); err != nil {
 		return err
 	}
-	return lc.Login(ldap.Dn, userFilter, password, ldap.SizeLimit, ldap.TimeLimit)
+	userDN, err := lc.Login(ldap.Dn, userFilter, password, ldap.SizeLimit, ldap.TimeLimit)
+	if err != nil {
+		return err
+	}
+	if ldap.HasGroupMappings() {
+		go l.applyLoginGroupMappings(ldap, user.Name, userDN)
+	}
+	return nil
+}
+
+// loginGroupSyncing 正在同步用户组的用户,同一用户并发登录时只同步一次
+var loginGroupSyncing sync.Map
+
+// applyLoginGroupMappings 登录后异步同步用户组,查询全部用户组和更新集群 rbac 不阻塞登录
+// 失败时保留现有的绑定,由定时同步重试
+func (l *service) applyLoginGroupMappings(ldap *v1Ldap.Ldap, userName, userDN string) {
+	if _, loaded := loginGroupSyncing.LoadOrStore(userName, struct{}{}); loaded {
+		return
+	}
+	defer loginGroupSyncing.Delete(userName)
+	groups, err := l.loadGroups(ldap)
+	if err != nil {
+		server.Logger().Errorf("can not search ldap groups of user %s: %s", userName, err)
+		return
+	}
+	if err := l.applyGroupMappings(ldap, userName, userGroups(ldap, groups, userName, userDN)); err != nil {
+		server.Logger().Errorf("can not apply ldap group mappings of user %s: %s", userName, err)
+	}
 }
 
-func (l *service) ImportUsers(users []v1User.ImportUser) (v1User.ImportResult, error) {
+func (l *service) ImportUsers(id string, users []v1User.ImportUser) (v1User.ImportResult, error) {
 	var result v1User.ImportResult
+	ldap, err := l.getLdap(id, common.DBOptions{})
+	if err != nil {
+		return result, err
+	}
+	// 配置了角色映射时由用户组决定角色,用户登录或同步时绑定
+	commonRole := len(ldap.RoleMappings) == 0
 	for _, imp := range users {
 		us := &v1User.User{
 			NickName: imp.NickName,
 			Metadata: v1.Metadata{
 				Name: imp.Name,
 			},
-			Type:  v1User.LDAP,
-			Email: imp.Email,
+			Type:   v1User.LDAP,
+			Email:  imp.Email,
+			LdapID: ldap.UUID,
 		}
 		if us.Email == "" {
 			us.Email = us.Name + "@example.com"
@@ -290,136 +468,14 @@ func (l *service) ImportUsers(users []v1User.ImportUser) (v1User.ImportResult, e
 			us.NickName = us.Name
 		}
 
-		result.Failures = append(result.Failures, us.Name)
-		tx, err := server.DB().Begin(true)
-		if err != nil {
-			server.Logger().Errorf("create tx err:  %s", err)
+		if err := l.createUser(us, commonRole); err != nil {
+			server.Logger().Errorf("can not import user %s , err:  %s", us.Name, err)
+			result.Failures = append(result.Failures, us.Name)
 			continue
 		}
-		err = l.userService.Create(us, common.DBOptions{DB: tx})
-		if err != nil {
-			_ = tx.Rollback()
-			server.Logger().Errorf("can not insert user %s , err:  %s", us.Name, err)
-			continue
-		}
-		roleName := "Common User"
-		binding := v1Role.Binding{
-			BaseModel: v1.BaseModel{
-				Kind:       "RoleBind",
-				ApiVersion: "v1",
-				CreatedBy:  "admin",
-			},
-			Metadata: v1.Metadata{
-				Name: fmt.Sprintf("role-binding-%s-%s", roleName, us.Name),
-			},
-			Subject: v1Role.Subject{
-				Kind: "User",
-				Name: us.Name,
-			},
-			RoleRef: roleName,
-		}
-		if err := l.roleBindingService.CreateRoleBinding(&binding, common.DBOptions{DB: tx}); err != nil {
-			_ = tx.Rollback()
-			server.Logger().Errorf("can not create  user role %s , err:  %s", us.Name, err)
-			continue
-		}
-		_ = tx.Commit()
-		result.Failures = result.Failures[:len(result.Failures)-1]
 	}
 	if len(result.Failures) == 0 {
 		result.Success = true
 	}
 	return result, nil
 }
-
-func (l *service) Sync(id string, options common.DBOptions) error {
-	ldap, err := l.GetById(id, options)
-	if err != nil {
-		return err
-	}
-	lc := ldapClient.NewLdapClient(ldap.Address, ldap.Port, ldap.Username, ldap.Password, ldap.TLS)
-	if err := lc.Connect(); err != nil {
-		return err
-	}
-	go func() {
-		server.Logger().Info("start sync ldap user")
-		insertCount := 0
-		attributes, err := ldap.GetAttributes()
-		if err != nil {
-			server.Logger().Errorf("can not get ldap map attributes")
-			return
-		}
-		mappings, err := ldap.GetMappings()
-		if err != nil {
-			server.Logger().Errorf("can not get ldap mappings")
-			return
-		}
-		entries, err := lc.Search(ldap.Dn, ldap.Filter, ldap.SizeLimit, ldap.TimeLimit, attributes)
-		if err != nil {
-			server.Logger().Errorf(err.Error())
-			return
-		}
-		for _, entry := range entries {
-			us := new(v1User.User)
-			rv := reflect.ValueOf(&us).Elem().Elem()
-
-			for _, at := range entry.Attributes {
-				for k, v := range mappings {
-					if v == at.Name && len(at.Values) > 0 {
-						fv := rv.FieldByName(k)
-						if fv.IsValid() {
-							fv.Set(reflect.ValueOf(strings.Trim(at.Values[0], " ")))
-						}
-					}
-				}
-			}
-			if us.Email == "" || us.Name == "" {
-				continue
-			}
-			if us.NickName == "" {
-				us.NickName = us.Name
-			}
-			us.Type = v1User.LDAP
-			_, err := l.userService.GetByNameOrEmail(us.Name, options)
-			if errors.Is(err, storm.ErrNotFound) {
-				tx, err := server.DB().Begin(true)
-				if err != nil {
-					server.Logger().Errorf("create tx err:  %s", err)
-				}
-				err = l.userService.Create(us, common.DBOptions{DB: tx})
-				if err != nil {
-					_ = tx.Rollback()
-					server.Logger().Errorf("can not insert user %s , err:  %s", us.Name, err)
-					continue
-				}
-				roleName := "Common User"
-				binding := v1Role.Binding{
-					BaseModel: v1.BaseModel{
-						Kind:       "RoleBind",
-						ApiVersion: "v1",
-						CreatedBy:  "admin",
-					},
-					Metadata: v1.Metadata{
-						Name: fmt.Sprintf("role-binding-%s-%s", roleName, us.Name),
-					},
-					Subject: v1Role.Subject{
-						Kind: "User",
-						Name: us.Name,
-					},
-					RoleRef: roleName,
-				}
-				if err := l.roleBindingService.CreateRoleBinding(&binding, common.DBOptions{DB: tx}); err != nil {
-					_ = tx.Rollback()
-					server.Logger().Errorf("can not create  user role %s , err:  %s", us.Name, err)
-					continue
-				}
-				_ = tx.Commit()
-				insertCount++
-			}
-		}
-
-		server.Logger().Infof("sync ldap user %d , insert user %d", len(entries), insertCount)
-	}()
-
-	return nil
-}
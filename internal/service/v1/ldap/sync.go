@@ -0,0 +1,380 @@
+package ldap
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	v1 "github.com/KubeOperator/kubepi/internal/model/v1"
+	v1Ldap "github.com/KubeOperator/kubepi/internal/model/v1/ldap"
+	v1Role "github.com/KubeOperator/kubepi/internal/model/v1/role"
+	v1User "github.com/KubeOperator/kubepi/internal/model/v1/user"
+	"github.com/KubeOperator/kubepi/internal/server"
+	"github.com/KubeOperator/kubepi/internal/service/v1/common"
+	"github.com/KubeOperator/kubepi/pkg/kubernetes"
+	ldapClient "github.com/KubeOperator/kubepi/pkg/util/ldap"
+	"github.com/asdine/storm/v3"
+	"github.com/asdine/storm/v3/q"
+	goldap "github.com/go-ldap/ldap/v3"
+	"github.com/google/uuid"
+	"github.com/robfig/cron/v3"
+)
+
+// 每个 ldap 配置保留的同步记录数量
+const maxSyncReports = 50
+
+// 同一时间只允许一个同步任务,避免定时任务和手动同步同时修改用户
+var syncLock sync.Mutex
+
+func validateSync(ldap *v1Ldap.Ldap) error {
+	if ldap.SyncSchedule != "" {
+		if _, err := cron.ParseStandard(ldap.SyncSchedule); err != nil {
+			return fmt.Errorf("invalid sync schedule %s: %s", ldap.SyncSchedule, err.Error())
+		}
+	}
+	switch ldap.SyncPolicy {
+	case "":
+		ldap.SyncPolicy = v1Ldap.SyncPolicyDisable
+	case v1Ldap.SyncPolicyDisable, v1Ldap.SyncPolicyDelete:
+	default:
+		return fmt.Errorf("invalid sync policy %s", ldap.SyncPolicy)
+	}
+	return nil
+}
+
+// Sync 同步目录中的用户: 新增不存在的用户,更新变化的属性,按照 SyncPolicy 处理目录中已不存在的用户
+func (l *service) Sync(id string, trigger string, options common.DBOptions) (*v1Ldap.SyncReport, error) {
+	ldap, err := l.GetById(id, options)
+	if err != nil {
+		return nil, err
+	}
+	if !ldap.Enable {
+		return nil, errors.New("请先启用LDAP")
+	}
+	if !syncLock.TryLock() {
+		return nil, errors.New("ldap sync is already running")
+	}
+	defer syncLock.Unlock()
+
+	reportID := uuid.New().String()
+	report := &v1Ldap.SyncReport{
+		BaseModel: v1.BaseModel{
+			ApiVersion: "v1",
+			Kind:       "LdapSyncReport",
+			CreateAt:   time.Now(),
+			UpdateAt:   time.Now(),
+		},
+		Metadata: v1.Metadata{
+			Name: reportID,
+			UUID: reportID,
+		},
+		LdapID:  ldap.UUID,
+		Trigger: trigger,
+		Status:  v1Ldap.SyncStatusRunning,
+		StartAt: time.Now(),
+	}
+	db := l.GetDB(options)
+	if err := db.Save(report); err != nil {
+		return nil, err
+	}
+	server.Logger().Infof("start sync ldap user, trigger: %s", trigger)
+	if err := l.sync(ldap, report, options); err != nil {
+		report.Status = v1Ldap.SyncStatusFailed
+		report.Message = err.Error()
+		server.Logger().Errorf("sync ldap user failed: %s", err)
+	} else {
+		report.Status = v1Ldap.SyncStatusSuccess
+	}
+	report.EndAt = time.Now()
+	report.UpdateAt = report.EndAt
+	if err := db.Update(report); err != nil {
+		return nil, err
+	}
+	server.Logger().Infof("sync ldap user %d, added %d, updated %d, disabled %d, deleted %d, errors %d",
+		report.Total, len(report.Added), len(report.Updated), len(report.Disabled), len(report.Deleted), len(report.Errors))
+	l.pruneSyncReports(ldap.UUID, options)
+	return report, nil
+}
+
+func (l *service) sync(ldap *v1Ldap.Ldap, report *v1Ldap.SyncReport, options common.DBOptions) error {
+	attributes, err := ldap.GetAttributes()
+	if err != nil {
+		return err
+	}
+	mappings, err := ldap.GetMappings()
+	if err != nil {
+		return err
+	}
+	lc := newClient(ldap).UsePool(ldap.UUID)
+	if err := lc.Connect(); err != nil {
+		return err
+	}
+	// 查询失败时直接返回,避免把所有用户当作已离开目录处理
+	entries, err := lc.Search(ldap.Dn, ldap.Filter, ldap.SizeLimit, ldap.TimeLimit, attributes)
+	if err != nil {
+		return err
+	}
+	report.Total = len(entries)
+	var groups []ldapClient.Group
+	if ldap.HasGroupMappings() {
+		if groups, err = l.loadGroups(ldap); err != nil {
+			return fmt.Errorf("can not search ldap groups: %s", err.Error())
+		}
+	}
+
+	users, err := l.userService.List(options)
+	if err != nil {
+		return err
+	}
+	existing := make(map[string]v1User.User, len(users))
+	for i := range users {
+		existing[users[i].Name] = users[i]
+	}
+
+	seen := make(map[string]bool, len(entries))
+	for _, entry := range entries {
+		us := mapEntry(entry, mappings)
+		if us.Name == "" {
+			report.Errors = append(report.Errors, fmt.Sprintf("entry %s has no username attribute", entry.DN))
+			continue
+		}
+		seen[us.Name] = true
+		old, ok := existing[us.Name]
+		if !ok {
+			if us.Email == "" {
+				report.Errors = append(report.Errors, fmt.Sprintf("user %s has no email attribute", us.Name))
+				continue
+			}
+			us.LdapID = ldap.UUID
+			if err := l.createUser(us, len(ldap.RoleMappings) == 0); err != nil {
+				report.Errors = append(report.Errors, fmt.Sprintf("can not create user %s: %s", us.Name, err.Error()))
+				continue
+			}
+			report.Added = append(report.Added, us.Name)
+			l.syncGroups(ldap, groups, us.Name, entry.DN, report)
+			continue
+		}
+		if old.Type != v1User.LDAP {
+			report.Errors = append(report.Errors, fmt.Sprintf("user %s already exists as a local user", us.Name))
+			continue
+		}
+		if old.LdapID != ldap.UUID {
+			report.Errors = append(report.Errors, fmt.Sprintf("user %s already exists in another ldap", us.Name))
+			continue
+		}
+		// 只自动启用由同步禁用的用户,管理员手动禁用的保持不变
+		changed := old.Disabled && old.DisabledBySync
+		if us.Email != "" && us.Email != old.Email {
+			old.Email = us.Email
+			changed = true
+		}
+		if us.NickName != "" && us.NickName != old.NickName {
+			old.NickName = us.NickName
+			changed = true
+		}
+		l.syncGroups(ldap, groups, us.Name, entry.DN, report)
+		if !changed {
+			continue
+		}
+		if old.DisabledBySync {
+			old.Disabled = false
+		}
+		if err := l.userService.Update(old.Name, &old, options); err != nil {
+			report.Errors = append(report.Errors, fmt.Sprintf("can not update user %s: %s", old.Name, err.Error()))
+			continue
+		}
+		report.Updated = append(report.Updated, old.Name)
+	}
+
+	for i := range users {
+		us := users[i]
+		if us.Type != v1User.LDAP || us.LdapID != ldap.UUID || us.BuiltIn || seen[us.Name] {
+			continue
+		}
+		if ldap.SyncPolicy == v1Ldap.SyncPolicyDelete {
+			if err := l.deleteUser(us.Name); err != nil {
+				report.Errors = append(report.Errors, fmt.Sprintf("can not delete user %s: %s", us.Name, err.Error()))
+				continue
+			}
+			report.Deleted = append(report.Deleted, us.Name)
+			continue
+		}
+		if us.Disabled {
+			continue
+		}
+		us.Disabled = true
+		if err := l.userService.Update(us.Name, &us, options); err != nil {
+			report.Errors = append(report.Errors, fmt.Sprintf("can not disable user %s: %s", us.Name, err.Error()))
+			continue
+		}
+		if err := l.userService.GetDB(options).UpdateField(&us, "DisabledBySync", true); err != nil {
+			report.Errors = append(report.Errors, fmt.Sprintf("can not disable user %s: %s", us.Name, err.Error()))
+			continue
+		}
+		report.Disabled = append(report.Disabled, us.Name)
+	}
+	return nil
+}
+
+func (l *service) syncGroups(ldap *v1Ldap.Ldap, groups []ldapClient.Group, userName, userDN string, report *v1Ldap.SyncReport) {
+	if !ldap.HasGroupMappings() {
+		return
+	}
+	if err := l.applyGroupMappings(ldap, userName, userGroups(ldap, groups, userName, userDN)); err != nil {
+		report.Errors = append(report.Errors, fmt.Sprintf("can not apply group mappings of user %s: %s", userName, err.Error()))
+	}
+}
+
+// mapEntry 按照属性映射把 ldap 条目转换为用户
+func mapEntry(entry *goldap.Entry, mappings map[string]string) *v1User.User {
+	us := new(v1User.User)
+	rv := reflect.ValueOf(us).Elem()
+	for _, at := range entry.Attributes {
+		for k, v := range mappings {
+			if v == at.Name && len(at.Values) > 0 {
+				fv := rv.FieldByName(k)
+				if fv.IsValid() && fv.Kind() == reflect.String {
+					fv.SetString(strings.Trim(at.Values[0], " "))
+				}
+			}
+		}
+	}
+	if us.NickName == "" {
+		us.NickName = us.Name
+	}
+	us.Type = v1User.LDAP
+	return us
+}
+
+// createUser 创建 ldap 用户,commonRole 为 true 时绑定普通用户角色
+func (l *service) createUser(us *v1User.User, commonRole bool) error {
+	tx, err := server.DB().Begin(true)
+	if err != nil {
+		return err
+	}
+	if err := l.userService.Create(us, common.DBOptions{DB: tx}); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	if !commonRole {
+		return tx.Commit()
+	}
+	roleName := "Common User"
+	binding := v1Role.Binding{
+		BaseModel: v1.BaseModel{
+			Kind:       "RoleBind",
+			ApiVersion: "v1",
+			CreatedBy:  "admin",
+		},
+		Metadata: v1.Metadata{
+			Name: fmt.Sprintf("role-binding-%s-%s", roleName, us.Name),
+		},
+		Subject: v1Role.Subject{
+			Kind: "User",
+			Name: us.Name,
+		},
+		RoleRef: roleName,
+	}
+	if err := l.roleBindingService.CreateRoleBinding(&binding, common.DBOptions{DB: tx}); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// deleteUser 删除用户及其角色、集群成员和 api token,与删除用户接口一致
+func (l *service) deleteUser(userName string) error {
+	if err := l.groupService.RemoveUser(userName); err != nil {
+		return err
+	}
+	tx, err := server.DB().Begin(true)
+	if err != nil {
+		return err
+	}
+	txOptions := common.DBOptions{DB: tx}
+	rbs, err := l.roleBindingService.GetRoleBindingBySubject(v1Role.Subject{
+		Kind: "User",
+		Name: userName,
+	}, txOptions)
+	if err != nil && !errors.Is(err, storm.ErrNotFound) {
+		_ = tx.Rollback()
+		return err
+	}
+	for i := range rbs {
+		if err := l.roleBindingService.Delete(rbs[i].Name, txOptions); err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+	}
+	cbs, err := l.clusterBindingService.GetBindingsByUserName(userName, txOptions)
+	if err != nil && !errors.Is(err, storm.ErrNotFound) {
+		_ = tx.Rollback()
+		return err
+	}
+	for i := range cbs {
+		c, err := l.clusterService.Get(cbs[i].ClusterRef, common.DBOptions{})
+		if err != nil {
+			_ = tx.Rollback()
+			return fmt.Errorf("get cluster failed: %s", err.Error())
+		}
+		k := kubernetes.NewKubernetes(c)
+		if err := k.CleanManagedClusterRoleBinding(cbs[i].UserRef); err != nil {
+			server.Logger().Errorf("can not delete cluster member %s : %s", cbs[i].UserRef, err)
+		}
+		if err := k.CleanManagedRoleBinding(cbs[i].UserRef); err != nil {
+			server.Logger().Errorf("can not delete cluster member %s : %s", cbs[i].UserRef, err)
+		}
+		if err := l.clusterBindingService.Delete(cbs[i].Name, txOptions); err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+	}
+	if err := l.tokenService.DeleteByUser(userName, txOptions); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	if err := l.userService.Delete(userName, txOptions); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// ListSyncReports 按照开始时间倒序返回同步记录,ldapID 为空时返回全部
+func (l *service) ListSyncReports(ldapID string, options common.DBOptions) ([]v1Ldap.SyncReport, error) {
+	db := l.GetDB(options)
+	reports := make([]v1Ldap.SyncReport, 0)
+	var ms []q.Matcher
+	if ldapID != "" {
+		ms = append(ms, q.Eq("LdapID", ldapID))
+	}
+	if err := db.Select(ms...).OrderBy("StartAt").Reverse().Find(&reports); err != nil && !errors.Is(err, storm.ErrNotFound) {
+		return nil, err
+	}
+	return reports, nil
+}
+
+func (l *service) GetSyncReport(name string, options common.DBOptions) (*v1Ldap.SyncReport, error) {
+	db := l.GetDB(options)
+	var report v1Ldap.SyncReport
+	if err := db.One("Name", name, &report); err != nil {
+		return nil, err
+	}
+	return &report, nil
+}
+
+func (l *service) pruneSyncReports(ldapID string, options common.DBOptions) {
+	reports, err := l.ListSyncReports(ldapID, options)
+	if err != nil || len(reports) <= maxSyncReports {
+		return
+	}
+	db := l.GetDB(options)
+	for i := maxSyncReports; i < len(reports); i++ {
+		if err := db.DeleteStruct(&reports[i]); err != nil {
+			server.Logger().Errorf("can not delete ldap sync report %s: %s", reports[i].Name, err)
+		}
+	}
+}
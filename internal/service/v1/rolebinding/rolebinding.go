@@ -2,11 +2,19 @@ package rolebinding
 
 import (
 	"errors"
+	"fmt"
+	"time"
+
+	v1 "github.com/KubeOperator/kubepi/internal/model/v1"
+	v1Group "github.com/KubeOperator/kubepi/internal/model/v1/group"
 	v1Role "github.com/KubeOperator/kubepi/internal/model/v1/role"
+	"github.com/KubeOperator/kubepi/internal/server"
 	"github.com/KubeOperator/kubepi/internal/service/v1/common"
+	"github.com/KubeOperator/kubepi/pkg/collectons"
+	costomStorm "github.com/KubeOperator/kubepi/pkg/storm"
+	"github.com/asdine/storm/v3"
 	"github.com/asdine/storm/v3/q"
 	"github.com/google/uuid"
-	"time"
 )
 
 type Service interface {
@@ -15,11 +23,13 @@ type Service interface {
 	GetRoleBindingsByRoleName(roleName string, options common.DBOptions) ([]v1Role.Binding, error)
 	CreateRoleBinding(binding *v1Role.Binding, options common.DBOptions) error
 	Delete(name string, options common.DBOptions) error
+	GetRoleBindingsByUser(userName string, options common.DBOptions) ([]v1Role.Binding, error)
+	SyncUserRoleBindings(userName string, roles []string, createdBy string) error
+	SyncGroupRoleBindings(groupName string, roles []string, createdBy string) error
 }
 
 func NewService() Service {
-	return &service{
-	}
+	return &service{}
 }
 
 type service struct {
@@ -64,3 +74,83 @@ func (s *service) Delete(name string, options common.DBOptions) error {
 	}
 	return db.DeleteStruct(&binding)
 }
+
+// GetRoleBindingsByUser 返回用户自身及其所属用户组的角色绑定
+func (s *service) GetRoleBindingsByUser(userName string, options common.DBOptions) ([]v1Role.Binding, error) {
+	rbs, err := s.GetRoleBindingBySubject(v1Role.Subject{Kind: "User", Name: userName}, options)
+	if err != nil && !errors.Is(err, storm.ErrNotFound) {
+		return nil, err
+	}
+	db := s.GetDB(options)
+	var groups []v1Group.Group
+	if err := db.Select(costomStorm.ArrayValueEq("Members", userName)).Find(&groups); err != nil && !errors.Is(err, storm.ErrNotFound) {
+		return nil, err
+	}
+	for i := range groups {
+		grbs, err := s.GetRoleBindingBySubject(v1Role.Subject{Kind: "Group", Name: groups[i].Name}, options)
+		if err != nil && !errors.Is(err, storm.ErrNotFound) {
+			return nil, err
+		}
+		rbs = append(rbs, grbs...)
+	}
+	return rbs, nil
+}
+
+// SyncUserRoleBindings 将 createdBy 创建的角色绑定调整为 roles,其他来源的绑定不受影响
+func (s *service) SyncUserRoleBindings(userName string, roles []string, createdBy string) error {
+	return s.syncRoleBindings(v1Role.Subject{Kind: "User", Name: userName}, "role-binding-%s-%s", roles, createdBy, true)
+}
+
+// SyncGroupRoleBindings 将用户组的角色绑定调整为 roles,不论由谁创建,新建的绑定记录为 createdBy 创建
+func (s *service) SyncGroupRoleBindings(groupName string, roles []string, createdBy string) error {
+	return s.syncRoleBindings(v1Role.Subject{Kind: "Group", Name: groupName}, "group-role-binding-%s-%s", roles, createdBy, false)
+}
+
+// syncRoleBindings ownedOnly 为 true 时只删除 createdBy 创建的绑定
+func (s *service) syncRoleBindings(subject v1Role.Subject, nameFormat string, roles []string, createdBy string, ownedOnly bool) error {
+	desired := collectons.NewStringSet()
+	for i := range roles {
+		desired.Add(roles[i])
+	}
+	bindings, err := s.GetRoleBindingBySubject(subject, common.DBOptions{})
+	if err != nil && !errors.Is(err, storm.ErrNotFound) {
+		return err
+	}
+	tx, err := server.DB().Begin(true)
+	if err != nil {
+		return err
+	}
+	current := collectons.NewStringSet()
+	for i := range bindings {
+		if (!ownedOnly || bindings[i].CreatedBy == createdBy) && !desired.Exists(bindings[i].RoleRef) {
+			if err := s.Delete(bindings[i].Name, common.DBOptions{DB: tx}); err != nil {
+				_ = tx.Rollback()
+				return err
+			}
+			continue
+		}
+		current.Add(bindings[i].RoleRef)
+	}
+	for _, roleName := range desired.ToSlice() {
+		if current.Exists(roleName) {
+			continue
+		}
+		binding := v1Role.Binding{
+			BaseModel: v1.BaseModel{
+				Kind:       "RoleBind",
+				ApiVersion: "v1",
+				CreatedBy:  createdBy,
+			},
+			Metadata: v1.Metadata{
+				Name: fmt.Sprintf(nameFormat, roleName, subject.Name),
+			},
+			Subject: subject,
+			RoleRef: roleName,
+		}
+		if err := s.CreateRoleBinding(&binding, common.DBOptions{DB: tx}); err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
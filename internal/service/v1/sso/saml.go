@@ -0,0 +1,205 @@
+package sso
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	v1Session "github.com/KubeOperator/kubepi/internal/api/v1/session"
+	v1 "github.com/KubeOperator/kubepi/internal/model/v1"
+	v1Sso "github.com/KubeOperator/kubepi/internal/model/v1/sso"
+	"github.com/KubeOperator/kubepi/internal/service/v1/common"
+	ssoClient "github.com/KubeOperator/kubepi/pkg/util/sso"
+	"github.com/asdine/storm/v3"
+	"github.com/asdine/storm/v3/q"
+	"github.com/crewjam/saml"
+	"github.com/crewjam/saml/samlsp"
+	"github.com/google/uuid"
+)
+
+// prepareSaml 导入身份提供方的元数据,并在首次配置时生成服务提供方的证书
+func (s *service) prepareSaml(sso *v1Sso.Sso) error {
+	if sso.Protocol != v1Sso.ProtocolSaml {
+		return nil
+	}
+	if sso.IdpMetadata == "" {
+		if sso.InterfaceAddress == "" {
+			return errors.New("saml idp metadata can not be none")
+		}
+		resp, err := http.Get(sso.InterfaceAddress)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return errors.New("请求SSO接口失败,当前状态码为: " + resp.Status)
+		}
+		data, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return err
+		}
+		sso.IdpMetadata = string(data)
+	}
+	if _, err := samlsp.ParseMetadata([]byte(sso.IdpMetadata)); err != nil {
+		return fmt.Errorf("invalid saml idp metadata: %s", err.Error())
+	}
+	if sso.SpCertificate == "" || sso.SpPrivateKey == "" {
+		cert, key, err := ssoClient.GenerateKeyPair("kubepi")
+		if err != nil {
+			return err
+		}
+		sso.SpCertificate, sso.SpPrivateKey = cert, key
+	}
+	return nil
+}
+
+// SamlServiceProvider baseURL 为 sso/saml 接口的完整地址,元数据和 ACS 地址都在其下
+func (s *service) SamlServiceProvider(sso *v1Sso.Sso, baseURL string) (*saml.ServiceProvider, error) {
+	idp, err := samlsp.ParseMetadata([]byte(sso.IdpMetadata))
+	if err != nil {
+		return nil, err
+	}
+	cert, key, err := ssoClient.ParseKeyPair(sso.SpCertificate, sso.SpPrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	metadataURL, err := url.Parse(baseURL + "/metadata")
+	if err != nil {
+		return nil, err
+	}
+	acsURL, err := url.Parse(baseURL + "/acs")
+	if err != nil {
+		return nil, err
+	}
+	return &saml.ServiceProvider{
+		EntityID:    metadataURL.String(),
+		Key:         key,
+		Certificate: cert,
+		MetadataURL: *metadataURL,
+		AcsURL:      *acsURL,
+		IDPMetadata: idp,
+	}, nil
+}
+
+// Saml 校验身份提供方返回的断言,签名和 InResponseTo 由 ServiceProvider 校验
+func (s *service) Saml(sp *saml.ServiceProvider, r *http.Request, requestIDs []string, language string, options common.DBOptions) (v1Session.UserProfile, error) {
+	ssos, err := s.List(options)
+	if err != nil {
+		return v1Session.UserProfile{}, err
+	}
+	if len(ssos) == 0 {
+		return v1Session.UserProfile{}, errors.New("sso is not configured")
+	}
+	conf := ssos[0]
+	assertion, err := sp.ParseResponse(r, requestIDs)
+	if err != nil {
+		var ie *saml.InvalidResponseError
+		if errors.As(err, &ie) {
+			return v1Session.UserProfile{}, errors.New("校验SAML断言失败: " + ie.PrivateErr.Error())
+		}
+		return v1Session.UserProfile{}, err
+	}
+	if err := s.useSamlIDs(assertion, requestIDs, options); err != nil {
+		return v1Session.UserProfile{}, err
+	}
+	mapping := conf.Claims.WithSamlDefaults()
+	id := v1Sso.Identity{
+		Email:    firstValue(samlAttribute(assertion, mapping.Email)),
+		NickName: firstValue(samlAttribute(assertion, mapping.NickName)),
+		Groups:   samlAttribute(assertion, mapping.Groups),
+		Language: language,
+	}
+	if mapping.Username != "" {
+		id.Username = firstValue(samlAttribute(assertion, mapping.Username))
+	} else if assertion.Subject != nil && assertion.Subject.NameID != nil {
+		id.Username = assertion.Subject.NameID.Value
+	}
+	if id.Username == "" {
+		return v1Session.UserProfile{}, errors.New("username not found in saml assertion")
+	}
+	return s.provision(&conf, id, options)
+}
+
+// useSamlIDs 记录断言 ID 和 InResponseTo 对应的请求 ID,有效期内重复提交的响应视为重放
+func (s *service) useSamlIDs(assertion *saml.Assertion, requestIDs []string, options common.DBOptions) error {
+	now := time.Now()
+	expireAt := samlExpireAt(assertion, now)
+	names := []string{"assertion-" + assertion.ID}
+	for i := range requestIDs {
+		names = append(names, "request-"+requestIDs[i])
+	}
+	tx, err := s.GetDB(options).Begin(true)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+	// 顺便清理已经过期的记录
+	if err := tx.Select(q.Lt("ExpireAt", now)).Delete(&v1Sso.UsedSamlID{}); err != nil && !errors.Is(err, storm.ErrNotFound) {
+		return err
+	}
+	for _, name := range names {
+		var used v1Sso.UsedSamlID
+		err := tx.One("Name", name, &used)
+		if err == nil {
+			return errors.New("校验SAML断言失败: assertion has already been used")
+		}
+		if !errors.Is(err, storm.ErrNotFound) {
+			return err
+		}
+		used = v1Sso.UsedSamlID{
+			BaseModel: v1.BaseModel{Kind: "UsedSamlID", CreateAt: now},
+			Metadata:  v1.Metadata{Name: name, UUID: uuid.New().String()},
+			ExpireAt:  expireAt,
+		}
+		if err := tx.Save(&used); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// samlExpireAt 取断言中最晚的 NotOnOrAfter,至少保留到签发时间校验的窗口之后
+func samlExpireAt(assertion *saml.Assertion, now time.Time) time.Time {
+	expireAt := now.Add(saml.MaxIssueDelay + saml.MaxClockSkew)
+	if assertion.Conditions != nil && assertion.Conditions.NotOnOrAfter.After(expireAt) {
+		expireAt = assertion.Conditions.NotOnOrAfter
+	}
+	if assertion.Subject != nil {
+		for _, c := range assertion.Subject.SubjectConfirmations {
+			if c.SubjectConfirmationData != nil && c.SubjectConfirmationData.NotOnOrAfter.After(expireAt) {
+				expireAt = c.SubjectConfirmationData.NotOnOrAfter
+			}
+		}
+	}
+	return expireAt.Add(saml.MaxClockSkew)
+}
+
+// samlAttribute 按照属性的 Name 或 FriendlyName 查找
+func samlAttribute(assertion *saml.Assertion, name string) []string {
+	var values []string
+	for _, statement := range assertion.AttributeStatements {
+		for _, attr := range statement.Attributes {
+			if attr.Name != name && attr.FriendlyName != name {
+				continue
+			}
+			for _, v := range attr.Values {
+				if v.Value != "" {
+					values = append(values, v.Value)
+				}
+			}
+		}
+	}
+	return values
+}
+
+func firstValue(values []string) string {
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
@@ -11,14 +11,18 @@ import (
 	v1User "github.com/KubeOperator/kubepi/internal/model/v1/user"
 	"github.com/KubeOperator/kubepi/internal/server"
 	"github.com/KubeOperator/kubepi/internal/service/v1/common"
+	"github.com/KubeOperator/kubepi/internal/service/v1/role"
 	"github.com/KubeOperator/kubepi/internal/service/v1/rolebinding"
 	"github.com/KubeOperator/kubepi/internal/service/v1/user"
+	"github.com/KubeOperator/kubepi/pkg/collectons"
 	ssoClient "github.com/KubeOperator/kubepi/pkg/util/sso"
 	"github.com/asdine/storm/v3"
 	"github.com/asdine/storm/v3/q"
 	"github.com/coreos/go-oidc"
+	"github.com/crewjam/saml"
 	"github.com/google/uuid"
 	"golang.org/x/oauth2"
+	"net/http"
 	"time"
 )
 
@@ -30,12 +34,15 @@ type Service interface {
 	Update(id string, sso *v1Sso.Sso, options common.DBOptions) error
 	Status(options common.DBOptions) bool
 	OpenID(openid *v1Sso.OpenID, options common.DBOptions) (v1Session.UserProfile, error)
-	OpenIDConfig(clientId, clientSecret, issuerURL, redirectURL string) (*v1Sso.OpenID, error)
+	OpenIDConfig(clientId, clientSecret, issuerURL, redirectURL string, scopes ...string) (*v1Sso.OpenID, error)
+	SamlServiceProvider(sso *v1Sso.Sso, baseURL string) (*saml.ServiceProvider, error)
+	Saml(sp *saml.ServiceProvider, r *http.Request, requestIDs []string, language string, options common.DBOptions) (v1Session.UserProfile, error)
 }
 
 func NewService() Service {
 	return &service{
 		userService:        user.NewService(),
+		roleService:        role.NewService(),
 		roleBindingService: rolebinding.NewService(),
 	}
 }
@@ -43,9 +50,35 @@ func NewService() Service {
 type service struct {
 	common.DefaultDBService
 	userService        user.Service
+	roleService        role.Service
 	roleBindingService rolebinding.Service
 }
 
+// validateRoles 用户组映射和默认角色中的角色必须存在
+func (s *service) validateRoles(sso *v1Sso.Sso) error {
+	names := collectons.NewStringSet()
+	for _, m := range sso.RoleMappings {
+		if m.Group == "" {
+			return errors.New("group of role mapping can not be none")
+		}
+		for i := range m.Roles {
+			names.Add(m.Roles[i])
+		}
+	}
+	for i := range sso.DefaultRoles {
+		names.Add(sso.DefaultRoles[i])
+	}
+	for _, name := range names.ToSlice() {
+		if _, err := s.roleService.Get(name, common.DBOptions{}); err != nil {
+			if errors.Is(err, storm.ErrNotFound) {
+				return fmt.Errorf("role %s not found", name)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
 func (s *service) TestConnect(sso *v1Sso.Sso) error {
 	// 测试连接，理论上不应强制用户开启SSO认证
 	//if !sso.Enable {
@@ -62,8 +95,16 @@ func (s *service) TestConnect(sso *v1Sso.Sso) error {
 
 func (s *service) Create(sso *v1Sso.Sso, options common.DBOptions) error {
 	sc := ssoClient.NewSsoClient(sso.Protocol, sso.InterfaceAddress, sso.ClientId, sso.ClientSecret, sso.Enable)
-	// 当用户进行SSO配置时，应该为用户检测目标是否可连接
-	if err := sc.TestConnect(sso.InterfaceAddress); err != nil {
+	// 当用户进行SSO配置时，应该为用户检测目标是否可连接,直接导入元数据的 SAML 配置没有接口地址
+	if sso.Protocol != v1Sso.ProtocolSaml || sso.InterfaceAddress != "" {
+		if err := sc.TestConnect(sso.InterfaceAddress); err != nil {
+			return err
+		}
+	}
+	if err := s.validateRoles(sso); err != nil {
+		return err
+	}
+	if err := s.prepareSaml(sso); err != nil {
 		return err
 	}
 
@@ -77,7 +118,13 @@ func (s *service) Create(sso *v1Sso.Sso, options common.DBOptions) error {
 func (s *service) Update(id string, sso *v1Sso.Sso, options common.DBOptions) error {
 	sc := ssoClient.NewSsoClient(sso.Protocol, sso.InterfaceAddress, sso.ClientId, sso.ClientSecret, sso.Enable)
 	// 当用户进行SSO配置时，应该为用户检测目标是否可连接
-	if err := sc.TestConnect(sso.InterfaceAddress); err != nil {
+	if sso.Protocol != v1Sso.ProtocolSaml || sso.InterfaceAddress != "" {
+		if err := sc.TestConnect(sso.InterfaceAddress); err != nil {
+			return err
+		}
+	}
+
+	if err := s.validateRoles(sso); err != nil {
 		return err
 	}
 
@@ -85,6 +132,13 @@ func (s *service) Update(id string, sso *v1Sso.Sso, options common.DBOptions) er
 	if err != nil {
 		return err
 	}
+	// 私钥不会返回给前端,沿用已有的证书
+	if sso.SpCertificate == "" || sso.SpPrivateKey == "" {
+		sso.SpCertificate, sso.SpPrivateKey = old.SpCertificate, old.SpPrivateKey
+	}
+	if err := s.prepareSaml(sso); err != nil {
+		return err
+	}
 	sso.UUID = old.UUID
 	sso.CreateAt = old.CreateAt
 	sso.UpdateAt = time.Now()
@@ -95,7 +149,51 @@ func (s *service) Update(id string, sso *v1Sso.Sso, options common.DBOptions) er
 			return err
 		}
 	}
-	return db.Update(sso)
+	// 映射可能被清空,需要单独更新
+	for field, value := range map[string]interface{}{
+		"Claims":       sso.Claims,
+		"Scopes":       sso.Scopes,
+		"RoleMappings": sso.RoleMappings,
+		"DefaultRoles": sso.DefaultRoles,
+	} {
+		if err := db.UpdateField(sso, field, value); err != nil {
+			return err
+		}
+	}
+	if err := db.Update(sso); err != nil {
+		return err
+	}
+	return s.pruneRoleBindings(sso, options)
+}
+
+// pruneRoleBindings 删除 SSO 同步的、已不在任何映射和默认角色中的角色绑定,其余的在用户下次登录时调整
+func (s *service) pruneRoleBindings(sso *v1Sso.Sso, options common.DBOptions) error {
+	roles := collectons.NewStringSet()
+	for _, m := range sso.RoleMappings {
+		for i := range m.Roles {
+			roles.Add(m.Roles[i])
+		}
+	}
+	for i := range sso.DefaultRoles {
+		roles.Add(sso.DefaultRoles[i])
+	}
+	db := s.GetDB(options)
+	var bindings []v1Role.Binding
+	if err := db.Select(q.Eq("CreatedBy", v1Sso.RoleBindingCreator)).Find(&bindings); err != nil {
+		if errors.Is(err, storm.ErrNotFound) {
+			return nil
+		}
+		return err
+	}
+	for i := range bindings {
+		if roles.Exists(bindings[i].RoleRef) {
+			continue
+		}
+		if err := s.roleBindingService.Delete(bindings[i].Name, options); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func (s *service) List(options common.DBOptions) ([]v1Sso.Sso, error) {
@@ -132,44 +230,66 @@ func (s *service) GetById(id string, options common.DBOptions) (*v1Sso.Sso, erro
 }
 
 func (s *service) OpenID(openid *v1Sso.OpenID, options common.DBOptions) (v1Session.UserProfile, error) {
+	ssos, err := s.List(options)
+	if err != nil {
+		return v1Session.UserProfile{}, err
+	}
+	if len(ssos) == 0 {
+		return v1Session.UserProfile{}, errors.New("sso is not configured")
+	}
+	conf := ssos[0]
+
 	token, err := openid.Oauth2Config.Exchange(openid.Ctx, openid.Code)
 	if err != nil {
 		return v1Session.UserProfile{}, errors.New("交换Token失败: " + err.Error())
 	}
-
-	userInfo, err := openid.OidcProvider.UserInfo(openid.Ctx, oauth2.StaticTokenSource(token))
+	claims, err := s.openIDClaims(openid, token)
 	if err != nil {
-		return v1Session.UserProfile{}, errors.New("获取用户信息失败: " + err.Error())
+		return v1Session.UserProfile{}, err
 	}
-	// 获取用户名
-	var claims struct {
-		PreferredUsername string `json:"preferred_username"`
+	mapping := conf.Claims.WithDefaults()
+	id := v1Sso.Identity{
+		Username: ssoClient.ClaimString(claims, mapping.Username),
+		Email:    ssoClient.ClaimString(claims, mapping.Email),
+		NickName: ssoClient.ClaimString(claims, mapping.NickName),
+		Groups:   ssoClient.ClaimStrings(claims, mapping.Groups),
+		Language: openid.Language,
 	}
-	if err = userInfo.Claims(&claims); err != nil {
-		return v1Session.UserProfile{}, err
+	if id.Username == "" {
+		return v1Session.UserProfile{}, fmt.Errorf("claim %s not found in id token or userinfo", mapping.Username)
+	}
+	// 未经验证的邮箱可以被任意设置,不能用于关联本地用户
+	if !emailVerified(claims) {
+		id.Email = ""
+	}
+	return s.provision(&conf, id, options)
+}
+
+// provision 查找或创建本地用户,配置了用户组映射时同步角色,返回登录的 profile
+func (s *service) provision(conf *v1Sso.Sso, id v1Sso.Identity, options common.DBOptions) (v1Session.UserProfile, error) {
+	username, email, nickName := id.Username, id.Email, id.NickName
+	if nickName == "" {
+		nickName = username
 	}
 
 	// 初始化用户
-	_, err = s.userService.GetByNameOrEmail(userInfo.Email, options)
+	u, err := s.findUser(username, email, options)
 	if err != nil {
 		if errors.Is(err, storm.ErrNotFound) {
-			// 创建本地账号，密码默认设置为`@=7kvi-$l*Pj+,s`，默认不开启MFA
+			// 创建 SSO 账号,不设置密码,只能通过 SSO 登录,默认不开启MFA
 			userProfile := &v1User.User{
 				BaseModel: v1.BaseModel{
 					ApiVersion: "v1",
 					Kind:       "User",
 				},
 				Metadata: v1.Metadata{
-					Name: claims.PreferredUsername,
+					Name: username,
 				},
-				NickName: claims.PreferredUsername,
-				Email:    userInfo.Email,
-				Language: openid.Language,
+				NickName: nickName,
+				Email:    email,
+				Language: id.Language,
 				IsAdmin:  false,
-				Authenticate: v1User.Authenticate{
-					Password: `@=7kvi-$l*Pj+,s`,
-				},
-				Type: v1User.LOCAL,
+				Type:     v1User.SSO,
 				Mfa: v1User.Mfa{
 					Enable: false,
 				},
@@ -183,38 +303,136 @@ func (s *service) OpenID(openid *v1Sso.OpenID, options common.DBOptions) (v1Sess
 				return v1Session.UserProfile{}, err
 			}
 
-			// 用户角色默认为ReadOnly
-			binding := v1Role.Binding{
-				BaseModel: v1.BaseModel{
-					Kind:       "RoleBind",
-					ApiVersion: "v1",
-					CreatedBy:  "admin",
-				},
-				Metadata: v1.Metadata{
-					Name: fmt.Sprintf("role-binding-%s-%s", "ReadOnly", claims.PreferredUsername),
-				},
-				Subject: v1Role.Subject{
-					Kind: "User",
-					Name: claims.PreferredUsername,
-				},
-				RoleRef: "ReadOnly",
-			}
-			if err = s.roleBindingService.CreateRoleBinding(&binding, common.DBOptions{DB: tx}); err != nil {
-				_ = tx.Rollback()
-				return v1Session.UserProfile{}, err
+			// 未配置用户组映射和默认角色时用户角色默认为ReadOnly
+			if !roleSyncEnabled(conf) {
+				binding := v1Role.Binding{
+					BaseModel: v1.BaseModel{
+						Kind:       "RoleBind",
+						ApiVersion: "v1",
+						CreatedBy:  "admin",
+					},
+					Metadata: v1.Metadata{
+						Name: fmt.Sprintf("role-binding-%s-%s", "ReadOnly", username),
+					},
+					Subject: v1Role.Subject{
+						Kind: "User",
+						Name: username,
+					},
+					RoleRef: "ReadOnly",
+				}
+				if err = s.roleBindingService.CreateRoleBinding(&binding, common.DBOptions{DB: tx}); err != nil {
+					_ = tx.Rollback()
+					return v1Session.UserProfile{}, err
+				}
 			}
 			_ = tx.Commit()
-			fmt.Println("SSO用户" + claims.PreferredUsername + "不存在，已自动创建本地账号")
+			fmt.Println("SSO用户" + username + "不存在，已自动创建账号")
+			u = userProfile
 		} else {
-			return v1Session.UserProfile{}, errors.New(fmt.Sprintf("query user %s failed ,: %s", claims.PreferredUsername, err.Error()))
+			return v1Session.UserProfile{}, err
 		}
 	}
+	if u.Disabled {
+		return v1Session.UserProfile{}, errors.New("user is disabled")
+	}
+
+	// 映射全部移除后仍需同步,清除之前由 SSO 创建的绑定
+	if err := s.syncRoles(u.Name, id.Groups, conf); err != nil {
+		return v1Session.UserProfile{}, err
+	}
 
 	// 设置profile
-	return s.localProfile(claims.PreferredUsername, userInfo.Email)
+	return s.localProfile(u.Name)
+}
+
+// findUser 经过验证的邮箱可以关联已有用户,只有用户名时只关联由 SSO 创建的用户,
+// 避免通过 IdP 中的同名用户登录本地或 ldap 账号
+func (s *service) findUser(username, email string, options common.DBOptions) (*v1User.User, error) {
+	if email != "" {
+		u, err := s.userService.GetByNameOrEmail(email, options)
+		if err == nil {
+			return u, nil
+		}
+		if !errors.Is(err, storm.ErrNotFound) {
+			return nil, fmt.Errorf("query user %s failed ,: %s", email, err.Error())
+		}
+	}
+	u, err := s.userService.GetByNameOrEmail(username, options)
+	if err != nil {
+		if errors.Is(err, storm.ErrNotFound) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("query user %s failed ,: %s", username, err.Error())
+	}
+	if u.Type != v1User.SSO {
+		return nil, fmt.Errorf("user %s already exists and is not created by sso", username)
+	}
+	return u, nil
+}
+
+// openIDClaims 合并 id token 和 userinfo 中的声明,userinfo 优先
+func (s *service) openIDClaims(openid *v1Sso.OpenID, token *oauth2.Token) (map[string]interface{}, error) {
+	claims := map[string]interface{}{}
+	if raw, ok := token.Extra("id_token").(string); ok && raw != "" {
+		verifier := openid.OidcProvider.Verifier(&oidc.Config{ClientID: openid.Oauth2Config.ClientID})
+		idToken, err := verifier.Verify(openid.Ctx, raw)
+		if err != nil {
+			return nil, errors.New("校验ID Token失败: " + err.Error())
+		}
+		if err := idToken.Claims(&claims); err != nil {
+			return nil, err
+		}
+	}
+	userInfo, err := openid.OidcProvider.UserInfo(openid.Ctx, oauth2.StaticTokenSource(token))
+	if err != nil {
+		return nil, errors.New("获取用户信息失败: " + err.Error())
+	}
+	infoClaims := map[string]interface{}{}
+	if err := userInfo.Claims(&infoClaims); err != nil {
+		return nil, err
+	}
+	for k, v := range infoClaims {
+		claims[k] = v
+	}
+	return claims, nil
 }
 
-func (s *service) OpenIDConfig(clientId, clientSecret, issuerURL, redirectURL string) (*v1Sso.OpenID, error) {
+// syncRoles 根据用户组同步由 SSO 创建的角色绑定,手动添加的绑定不受影响
+func (s *service) syncRoles(userName string, groups []string, conf *v1Sso.Sso) error {
+	desired := collectons.NewStringSet()
+	for _, m := range conf.RoleMappings {
+		if collectons.IndexOfStringSlice(groups, m.Group) == -1 {
+			continue
+		}
+		for i := range m.Roles {
+			desired.Add(m.Roles[i])
+		}
+	}
+	if len(desired.ToSlice()) == 0 {
+		for i := range conf.DefaultRoles {
+			desired.Add(conf.DefaultRoles[i])
+		}
+	}
+	return s.roleBindingService.SyncUserRoleBindings(userName, desired.ToSlice(), v1Sso.RoleBindingCreator)
+}
+
+// roleSyncEnabled 配置了用户组映射或默认角色时由 SSO 管理用户的角色
+func roleSyncEnabled(conf *v1Sso.Sso) bool {
+	return len(conf.RoleMappings) > 0 || len(conf.DefaultRoles) > 0
+}
+
+// emailVerified 兼容 email_verified 为字符串的 IdP
+func emailVerified(claims map[string]interface{}) bool {
+	switch v := claims["email_verified"].(type) {
+	case bool:
+		return v
+	case string:
+		return v == "true"
+	}
+	return false
+}
+
+func (s *service) OpenIDConfig(clientId, clientSecret, issuerURL, redirectURL string, scopes ...string) (*v1Sso.OpenID, error) {
 	ctx := context.Background()
 	provider, err := oidc.NewProvider(ctx, issuerURL)
 	if err != nil {
@@ -227,15 +445,15 @@ func (s *service) OpenIDConfig(clientId, clientSecret, issuerURL, redirectURL st
 			ClientSecret: clientSecret,
 			Endpoint:     provider.Endpoint(),
 			RedirectURL:  redirectURL,
-			Scopes:       []string{oidc.ScopeOpenID, "profile", "email"},
+			Scopes:       append([]string{oidc.ScopeOpenID, "profile", "email"}, scopes...),
 		},
 		OidcProvider: provider,
 		Ctx:          ctx,
 	}, nil
 }
 
-func (s *service) localProfile(username, email string) (v1Session.UserProfile, error) {
-	u, err := s.userService.GetByNameOrEmail(email, common.DBOptions{})
+func (s *service) localProfile(username string) (v1Session.UserProfile, error) {
+	u, err := s.userService.GetByNameOrEmail(username, common.DBOptions{})
 	if err != nil {
 		if errors.Is(err, storm.ErrNotFound) {
 			return v1Session.UserProfile{}, errors.New("username or password error")
@@ -244,7 +462,7 @@ func (s *service) localProfile(username, email string) (v1Session.UserProfile, e
 	}
 
 	handler := v1Session.NewHandler()
-	permissions, err := handler.AggregateResourcePermissions(username)
+	permissions, err := handler.AggregateResourcePermissions(u.Name)
 	if err != nil {
 		return v1Session.UserProfile{}, errors.New(err.Error())
 	}
@@ -255,10 +473,6 @@ func (s *service) localProfile(username, email string) (v1Session.UserProfile, e
 		Language:            u.Language,
 		ResourcePermissions: permissions,
 		IsAdministrator:     u.IsAdmin,
-		Mfa: v1Session.Mfa{
-			Secret:   u.Mfa.Secret,
-			Enable:   u.Mfa.Enable,
-			Approved: false,
-		},
+		Mfa:                 v1Session.NewMfa(u),
 	}, nil
 }
@@ -0,0 +1,92 @@
+package system
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	v1System "github.com/KubeOperator/kubepi/internal/model/v1/system"
+	"github.com/KubeOperator/kubepi/internal/service/v1/common"
+	"github.com/asdine/storm/v3"
+	"github.com/google/uuid"
+)
+
+func lockoutName(kind, subject string) string {
+	return fmt.Sprintf("%s-%s", kind, subject)
+}
+
+func (s *service) GetLockout(kind, subject string, options common.DBOptions) (*v1System.Lockout, error) {
+	db := s.GetDB(options)
+	var l v1System.Lockout
+	if err := db.One("Name", lockoutName(kind, subject), &l); err != nil {
+		return nil, err
+	}
+	return &l, nil
+}
+
+// RecordLoginFailure 记录一次失败,window 内失败次数达到 maxAttempts 时锁定 duration
+// 读取和保存在同一个写事务中完成,并发的失败请求不会少计次数
+func (s *service) RecordLoginFailure(kind, subject string, maxAttempts int, window, duration time.Duration, options common.DBOptions) (*v1System.Lockout, error) {
+	db := s.GetDB(options)
+	tx, err := db.Begin(true)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	l, err := s.GetLockout(kind, subject, common.DBOptions{DB: tx})
+	if err != nil {
+		if !errors.Is(err, storm.ErrNotFound) {
+			_ = tx.Rollback()
+			return nil, err
+		}
+		l = &v1System.Lockout{LockKind: kind, Subject: subject}
+		l.Name = lockoutName(kind, subject)
+		l.UUID = uuid.New().String()
+		l.CreateAt = now
+	}
+	if l.FirstFailureAt.IsZero() || now.Sub(l.FirstFailureAt) > window {
+		l.Failures = 0
+		l.FirstFailureAt = now
+	}
+	l.Failures++
+	if maxAttempts > 0 && l.Failures >= maxAttempts {
+		l.LockedUntil = now.Add(duration)
+		l.Failures = 0
+		l.FirstFailureAt = time.Time{}
+	}
+	l.UpdateAt = now
+	if err := tx.Save(l); err != nil {
+		_ = tx.Rollback()
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (s *service) ResetLockout(kind, subject string, options common.DBOptions) error {
+	err := s.DeleteLockout(lockoutName(kind, subject), options)
+	if err != nil && !errors.Is(err, storm.ErrNotFound) {
+		return err
+	}
+	return nil
+}
+
+func (s *service) ListLockouts(options common.DBOptions) ([]v1System.Lockout, error) {
+	db := s.GetDB(options)
+	ls := make([]v1System.Lockout, 0)
+	if err := db.All(&ls); err != nil && !errors.Is(err, storm.ErrNotFound) {
+		return nil, err
+	}
+	return ls, nil
+}
+
+func (s *service) DeleteLockout(name string, options common.DBOptions) error {
+	db := s.GetDB(options)
+	var l v1System.Lockout
+	if err := db.One("Name", name, &l); err != nil {
+		return err
+	}
+	return db.DeleteStruct(&l)
+}
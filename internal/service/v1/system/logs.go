@@ -18,6 +18,18 @@ type Service interface {
 	CreateLoginLog(log *v1System.LoginLog, options common.DBOptions)
 	SearchOperationLogs(num, size int, conditions common.Conditions, options common.DBOptions) ([]v1System.OperationLog, int, error)
 	SearchLoginLogs(num, size int, conditions common.Conditions, options common.DBOptions) ([]v1System.LoginLog, int, error)
+	CreateSession(session *v1System.Session, options common.DBOptions) error
+	GetSession(name string, options common.DBOptions) (*v1System.Session, error)
+	GetSessionByCookie(cookieID string, options common.DBOptions) (*v1System.Session, error)
+	ListSessions(userName string, options common.DBOptions) ([]v1System.Session, error)
+	UpdateSessionExpire(name string, expireAt time.Time, options common.DBOptions) error
+	TouchSession(session *v1System.Session, options common.DBOptions) error
+	DeleteSession(name string, options common.DBOptions) error
+	GetLockout(kind, subject string, options common.DBOptions) (*v1System.Lockout, error)
+	RecordLoginFailure(kind, subject string, maxAttempts int, window, duration time.Duration, options common.DBOptions) (*v1System.Lockout, error)
+	ResetLockout(kind, subject string, options common.DBOptions) error
+	ListLockouts(options common.DBOptions) ([]v1System.Lockout, error)
+	DeleteLockout(name string, options common.DBOptions) error
 }
 
 func NewService() Service {
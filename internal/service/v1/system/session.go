@@ -0,0 +1,93 @@
+package system
+
+import (
+	"errors"
+	"time"
+
+	v1System "github.com/KubeOperator/kubepi/internal/model/v1/system"
+	"github.com/KubeOperator/kubepi/internal/service/v1/common"
+	"github.com/asdine/storm/v3"
+	"github.com/asdine/storm/v3/q"
+	"github.com/google/uuid"
+)
+
+// 最后活跃时间的刷新间隔,避免每个请求都写库
+const sessionTouchInterval = time.Minute
+
+func (s *service) CreateSession(session *v1System.Session, options common.DBOptions) error {
+	db := s.GetDB(options)
+	session.UUID = uuid.New().String()
+	session.CreateAt = time.Now()
+	session.UpdateAt = time.Now()
+	session.LoginAt = session.CreateAt
+	session.LastActiveAt = session.CreateAt
+	return db.Save(session)
+}
+
+func (s *service) GetSession(name string, options common.DBOptions) (*v1System.Session, error) {
+	db := s.GetDB(options)
+	var session v1System.Session
+	if err := db.One("Name", name, &session); err != nil {
+		return nil, err
+	}
+	if session.ExpireAt.Before(time.Now()) {
+		_ = db.DeleteStruct(&session)
+		return nil, storm.ErrNotFound
+	}
+	return &session, nil
+}
+
+func (s *service) GetSessionByCookie(cookieID string, options common.DBOptions) (*v1System.Session, error) {
+	db := s.GetDB(options)
+	var session v1System.Session
+	if err := db.One("CookieID", cookieID, &session); err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// ListSessions 查询未过期的会话,userName 为空时返回全部
+func (s *service) ListSessions(userName string, options common.DBOptions) ([]v1System.Session, error) {
+	db := s.GetDB(options)
+	if err := db.Select(q.Lt("ExpireAt", time.Now())).Delete(&v1System.Session{}); err != nil && !errors.Is(err, storm.ErrNotFound) {
+		return nil, err
+	}
+	var ms []q.Matcher
+	if userName != "" {
+		ms = append(ms, q.Eq("UserName", userName))
+	}
+	sessions := make([]v1System.Session, 0)
+	if err := db.Select(ms...).OrderBy("LoginAt").Reverse().Find(&sessions); err != nil && !errors.Is(err, storm.ErrNotFound) {
+		return nil, err
+	}
+	return sessions, nil
+}
+
+func (s *service) UpdateSessionExpire(name string, expireAt time.Time, options common.DBOptions) error {
+	db := s.GetDB(options)
+	session, err := s.GetSession(name, options)
+	if err != nil {
+		return err
+	}
+	session.ExpireAt = expireAt
+	session.LastActiveAt = time.Now()
+	session.UpdateAt = time.Now()
+	return db.Update(session)
+}
+
+func (s *service) TouchSession(session *v1System.Session, options common.DBOptions) error {
+	if time.Since(session.LastActiveAt) < sessionTouchInterval {
+		return nil
+	}
+	db := s.GetDB(options)
+	return db.UpdateField(session, "LastActiveAt", time.Now())
+}
+
+func (s *service) DeleteSession(name string, options common.DBOptions) error {
+	db := s.GetDB(options)
+	var session v1System.Session
+	if err := db.One("Name", name, &session); err != nil {
+		return err
+	}
+	return db.DeleteStruct(&session)
+}
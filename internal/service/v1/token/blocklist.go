@@ -0,0 +1,126 @@
+package token
+
+import (
+	"errors"
+	"time"
+
+	v1Token "github.com/KubeOperator/kubepi/internal/model/v1/token"
+	"github.com/KubeOperator/kubepi/internal/service/v1/common"
+	"github.com/asdine/storm/v3"
+	"github.com/asdine/storm/v3/q"
+	"github.com/google/uuid"
+	"github.com/kataras/iris/v12/middleware/jwt"
+)
+
+var _ jwt.Blocklist = (*Blocklist)(nil)
+
+// Blocklist 持久化的 jwt 吊销列表,服务重启后依然生效
+type Blocklist struct {
+	common.DefaultDBService
+	// maxAge access token 的最长有效期,以会话 ID 吊销时该会话之后签发的 token 都需要被拦截
+	maxAge time.Duration
+}
+
+func NewBlocklist(maxAge time.Duration) *Blocklist {
+	return &Blocklist{maxAge: maxAge}
+}
+
+// ValidateToken 过期的 token 直接拒绝,不删除吊销记录,同一会话的其他 token 可能仍未过期
+func (b *Blocklist) ValidateToken(token []byte, c jwt.Claims, err error) error {
+	if err != nil {
+		return err
+	}
+	has, e := b.Has(blocklistKey(token, c))
+	if e != nil {
+		return e
+	}
+	if has {
+		return jwt.ErrBlocked
+	}
+	return nil
+}
+
+// InvalidateToken 吊销记录保留到 token 本身和该会话所有 access token 都过期之后,重复吊销时延长保留时间
+func (b *Blocklist) InvalidateToken(token []byte, c jwt.Claims) error {
+	if len(token) == 0 {
+		return jwt.ErrMissing
+	}
+	db := b.GetDB(common.DBOptions{})
+	key := blocklistKey(token, c)
+	if err := b.GC(); err != nil {
+		return err
+	}
+	expireAt := c.ExpiresAt()
+	if latest := time.Now().Add(b.maxAge); latest.After(expireAt) {
+		expireAt = latest
+	}
+	var exists v1Token.RevokedToken
+	if err := db.One("Name", key, &exists); err == nil {
+		if !expireAt.After(exists.ExpireAt) {
+			return nil
+		}
+		exists.ExpireAt = expireAt
+		exists.UpdateAt = time.Now()
+		return db.Update(&exists)
+	} else if !errors.Is(err, storm.ErrNotFound) {
+		return err
+	}
+	r := v1Token.RevokedToken{ExpireAt: expireAt}
+	r.Name = key
+	r.UUID = uuid.New().String()
+	r.Kind = "RevokedToken"
+	r.ApiVersion = "v1"
+	r.CreateAt = time.Now()
+	r.UpdateAt = time.Now()
+	return db.Save(&r)
+}
+
+func (b *Blocklist) Del(key string) error {
+	db := b.GetDB(common.DBOptions{})
+	var r v1Token.RevokedToken
+	if err := db.One("Name", key, &r); err != nil {
+		if errors.Is(err, storm.ErrNotFound) {
+			return nil
+		}
+		return err
+	}
+	return db.DeleteStruct(&r)
+}
+
+func (b *Blocklist) Has(key string) (bool, error) {
+	if key == "" {
+		return false, jwt.ErrMissing
+	}
+	db := b.GetDB(common.DBOptions{})
+	var r v1Token.RevokedToken
+	if err := db.One("Name", key, &r); err != nil {
+		if errors.Is(err, storm.ErrNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+func (b *Blocklist) Count() (int64, error) {
+	db := b.GetDB(common.DBOptions{})
+	n, err := db.Count(&v1Token.RevokedToken{})
+	return int64(n), err
+}
+
+// GC 清理已经过期的吊销记录
+func (b *Blocklist) GC() error {
+	db := b.GetDB(common.DBOptions{})
+	err := db.Select(q.Lt("ExpireAt", time.Now())).Delete(&v1Token.RevokedToken{})
+	if err != nil && !errors.Is(err, storm.ErrNotFound) {
+		return err
+	}
+	return nil
+}
+
+func blocklistKey(token []byte, c jwt.Claims) string {
+	if c.ID != "" {
+		return c.ID
+	}
+	return string(token)
+}
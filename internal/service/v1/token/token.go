@@ -0,0 +1,117 @@
+package token
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	v1Token "github.com/KubeOperator/kubepi/internal/model/v1/token"
+	"github.com/KubeOperator/kubepi/internal/server"
+	"github.com/KubeOperator/kubepi/internal/service/v1/common"
+	"github.com/asdine/storm/v3"
+	"github.com/asdine/storm/v3/q"
+	"github.com/google/uuid"
+)
+
+var (
+	ErrInvalidToken = errors.New("invalid access token")
+	ErrTokenExpired = errors.New("access token is expired")
+)
+
+type Service interface {
+	common.DBService
+	Create(t *v1Token.Token, options common.DBOptions) (string, error)
+	Get(name string, options common.DBOptions) (*v1Token.Token, error)
+	ListByUser(userName string, options common.DBOptions) ([]v1Token.Token, error)
+	Delete(name string, options common.DBOptions) error
+	DeleteByUser(userName string, options common.DBOptions) error
+	Authenticate(raw string, options common.DBOptions) (*v1Token.Token, error)
+}
+
+func NewService() Service {
+	return &service{}
+}
+
+type service struct {
+	common.DefaultDBService
+}
+
+// Create 生成令牌并返回明文,明文只在创建时返回一次,库中仅保存哈希
+func (s *service) Create(t *v1Token.Token, options common.DBOptions) (string, error) {
+	db := s.GetDB(options)
+	buf := make([]byte, 20)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	raw := v1Token.Prefix + hex.EncodeToString(buf)
+	t.UUID = uuid.New().String()
+	t.Hash = hashToken(raw)
+	t.CreateAt = time.Now()
+	t.UpdateAt = time.Now()
+	if err := db.Save(t); err != nil {
+		return "", err
+	}
+	return raw, nil
+}
+
+func (s *service) Get(name string, options common.DBOptions) (*v1Token.Token, error) {
+	db := s.GetDB(options)
+	var t v1Token.Token
+	if err := db.One("Name", name, &t); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+func (s *service) ListByUser(userName string, options common.DBOptions) ([]v1Token.Token, error) {
+	db := s.GetDB(options)
+	ts := make([]v1Token.Token, 0)
+	if err := db.Select(q.Eq("UserRef", userName)).OrderBy("CreateAt").Reverse().Find(&ts); err != nil {
+		return ts, err
+	}
+	return ts, nil
+}
+
+func (s *service) Delete(name string, options common.DBOptions) error {
+	db := s.GetDB(options)
+	t, err := s.Get(name, options)
+	if err != nil {
+		return err
+	}
+	return db.DeleteStruct(t)
+}
+
+func (s *service) DeleteByUser(userName string, options common.DBOptions) error {
+	db := s.GetDB(options)
+	if err := db.Select(q.Eq("UserRef", userName)).Delete(&v1Token.Token{}); err != nil && !errors.Is(err, storm.ErrNotFound) {
+		return err
+	}
+	return nil
+}
+
+// Authenticate 校验明文令牌,成功后刷新最后使用时间
+func (s *service) Authenticate(raw string, options common.DBOptions) (*v1Token.Token, error) {
+	db := s.GetDB(options)
+	var t v1Token.Token
+	if err := db.One("Hash", hashToken(raw), &t); err != nil {
+		if errors.Is(err, storm.ErrNotFound) {
+			return nil, ErrInvalidToken
+		}
+		return nil, err
+	}
+	if t.Expired() {
+		return nil, ErrTokenExpired
+	}
+	t.LastUsedAt = time.Now()
+	if err := db.UpdateField(&t, "LastUsedAt", t.LastUsedAt); err != nil {
+		server.Logger().Errorf("can not update last used time of token %s: %s", t.Name, err)
+	}
+	return &t, nil
+}
+
+func hashToken(raw string) string {
+	sum := sha256.Sum256([]byte(raw))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,49 @@
+package user
+
+import (
+	v1User "github.com/KubeOperator/kubepi/internal/model/v1/user"
+	"github.com/KubeOperator/kubepi/internal/service/v1/common"
+	mfaUtil "github.com/KubeOperator/kubepi/pkg/util/mfa"
+)
+
+// UpdateMfa 只更新 mfa 绑定信息
+func (u *service) UpdateMfa(name string, mfa v1User.Mfa, options common.DBOptions) error {
+	cu, err := u.GetByNameOrEmail(name, options)
+	if err != nil {
+		return err
+	}
+	db := u.GetDB(options)
+	return db.UpdateField(cu, "Mfa", mfa)
+}
+
+// ResetMfa 清除 mfa 绑定,开启了 mfa 的用户下次登录时重新绑定
+func (u *service) ResetMfa(name string, options common.DBOptions) error {
+	cu, err := u.GetByNameOrEmail(name, options)
+	if err != nil {
+		return err
+	}
+	db := u.GetDB(options)
+	return db.UpdateField(cu, "Mfa", v1User.Mfa{Enable: cu.Mfa.Enable})
+}
+
+// UseRecoveryCode 校验恢复码,校验通过后该恢复码失效
+func (u *service) UseRecoveryCode(name string, code string, options common.DBOptions) (bool, error) {
+	cu, err := u.GetByNameOrEmail(name, options)
+	if err != nil {
+		return false, err
+	}
+	hash := mfaUtil.HashRecoveryCode(code)
+	for i := range cu.Mfa.RecoveryCodes {
+		if cu.Mfa.RecoveryCodes[i] != hash {
+			continue
+		}
+		mfa := cu.Mfa
+		mfa.RecoveryCodes = append(append([]string{}, cu.Mfa.RecoveryCodes[:i]...), cu.Mfa.RecoveryCodes[i+1:]...)
+		db := u.GetDB(options)
+		if err := db.UpdateField(cu, "Mfa", mfa); err != nil {
+			return false, err
+		}
+		return true, nil
+	}
+	return false, nil
+}
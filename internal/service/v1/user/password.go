@@ -0,0 +1,89 @@
+package user
+
+import (
+	"strconv"
+	"time"
+
+	v1User "github.com/KubeOperator/kubepi/internal/model/v1/user"
+	"github.com/KubeOperator/kubepi/internal/server"
+	"github.com/KubeOperator/kubepi/pkg/util/password"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func passwordPolicy() password.Policy {
+	c := server.Config().Spec.PasswordPolicy
+	return password.Policy{
+		MinLength:      c.MinLength,
+		RequireUpper:   c.RequireUpper,
+		RequireLower:   c.RequireLower,
+		RequireDigit:   c.RequireDigit,
+		RequireSpecial: c.RequireSpecial,
+	}
+}
+
+// ValidatePassword 按照配置的密码策略校验明文密码
+func (u *service) ValidatePassword(pw string) error {
+	return password.Validate(pw, passwordPolicy())
+}
+
+// GeneratePassword 生成符合密码策略的随机初始密码
+func (u *service) GeneratePassword() (string, error) {
+	return password.Generate(passwordPolicy())
+}
+
+// setPassword 校验新密码并检查历史密码,通过后更新哈希和修改时间
+func (u *service) setPassword(cu *v1User.User, newPassword string) error {
+	if err := u.ValidatePassword(newPassword); err != nil {
+		return err
+	}
+	history := server.Config().Spec.PasswordPolicy.History
+	if history > 0 {
+		used := append([]string{cu.Authenticate.Password}, cu.Authenticate.History...)
+		if len(used) > history {
+			used = used[:history]
+		}
+		for i := range used {
+			if used[i] == "" {
+				continue
+			}
+			if bcrypt.CompareHashAndPassword([]byte(used[i]), []byte(newPassword)) == nil {
+				return password.NewViolationError("password can not be the same as the last %s passwords", strconv.Itoa(history))
+			}
+		}
+	}
+	bs, err := bcrypt.GenerateFromPassword([]byte(newPassword), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	if history > 0 && cu.Authenticate.Password != "" {
+		cu.Authenticate.History = append([]string{cu.Authenticate.Password}, cu.Authenticate.History...)
+		if len(cu.Authenticate.History) > history {
+			cu.Authenticate.History = cu.Authenticate.History[:history]
+		}
+	} else {
+		cu.Authenticate.History = nil
+	}
+	cu.Authenticate.Password = string(bs)
+	cu.Authenticate.PasswordChangedAt = time.Now()
+	cu.Authenticate.ForceChange = false
+	return nil
+}
+
+// IsPasswordExpired 管理员重置或超过最长有效期时需要修改密码,ldap 用户不受影响
+func (u *service) IsPasswordExpired(us *v1User.User) bool {
+	if us.Type == v1User.LDAP || us.Authenticate.Password == "" {
+		return false
+	}
+	if us.Authenticate.ForceChange {
+		return true
+	}
+	maxAge := server.Config().Spec.PasswordPolicy.MaxAge
+	if maxAge <= 0 {
+		return false
+	}
+	changedAt := us.Authenticate.PasswordChangedAt
+	if changedAt.IsZero() {
+		changedAt = us.CreateAt
+	}
+	return time.Since(changedAt) > time.Duration(maxAge)*24*time.Hour
+}
@@ -25,6 +25,12 @@ type Service interface {
 	Update(name string, u *v1User.User, options common.DBOptions) error
 	UpdatePassword(name string, oldPassword string, newPassword string, options common.DBOptions) error
 	ResetPassword(name string, newPassword string, options common.DBOptions) error
+	ValidatePassword(pw string) error
+	GeneratePassword() (string, error)
+	IsPasswordExpired(u *v1User.User) bool
+	UpdateMfa(name string, mfa v1User.Mfa, options common.DBOptions) error
+	ResetMfa(name string, options common.DBOptions) error
+	UseRecoveryCode(name string, code string, options common.DBOptions) (bool, error)
 }
 
 func NewService() Service {
@@ -42,11 +48,11 @@ func (u *service) ResetPassword(name string, newPassword string, options common.
 	if err != nil {
 		return err
 	}
-	bs, err := bcrypt.GenerateFromPassword([]byte(newPassword), bcrypt.DefaultCost)
-	if err != nil {
+	if err := u.setPassword(cu, newPassword); err != nil {
 		return err
 	}
-	cu.Authenticate.Password = string(bs)
+	// 管理员重置后用户需要在下次登录时修改密码
+	cu.Authenticate.ForceChange = true
 	cu.UpdateAt = time.Now()
 	db := u.GetDB(options)
 	return db.Update(cu)
@@ -60,11 +66,9 @@ func (u *service) UpdatePassword(name string, oldPassword string, newPassword st
 	if err := bcrypt.CompareHashAndPassword([]byte(cu.Authenticate.Password), []byte(oldPassword)); err != nil {
 		return err
 	}
-	bs, err := bcrypt.GenerateFromPassword([]byte(newPassword), bcrypt.DefaultCost)
-	if err != nil {
+	if err := u.setPassword(cu, newPassword); err != nil {
 		return err
 	}
-	cu.Authenticate.Password = string(bs)
 	cu.UpdateAt = time.Now()
 	db := u.GetDB(options)
 	return db.Update(cu)
@@ -82,15 +86,32 @@ func (u *service) Update(name string, us *v1User.User, options common.DBOptions)
 	us.UUID = cu.UUID
 	us.IsAdmin = cu.IsAdmin
 	us.Type = cu.Type
+	us.LdapID = cu.LdapID
 	us.CreateAt = cu.CreateAt
 	us.UpdateAt = time.Now()
-	if !us.Mfa.Enable {
-		us.Mfa.Secret = ""
+	if us.Mfa.Enable {
+		// 绑定信息只能通过 mfa 接口修改
+		us.Mfa.Secret = cu.Mfa.Secret
+		us.Mfa.RecoveryCodes = cu.Mfa.RecoveryCodes
+		us.Mfa.WebAuthnCredentials = cu.Mfa.WebAuthnCredentials
+	} else {
+		us.Mfa = v1User.Mfa{}
 		err = db.UpdateField(us, "Mfa", us.Mfa)
 		if err != nil {
 			return err
 		}
 	}
+	// 禁用状态被修改后不再视为由同步禁用
+	us.DisabledBySync = cu.DisabledBySync
+	if us.Disabled != cu.Disabled {
+		if err := db.UpdateField(us, "Disabled", us.Disabled); err != nil {
+			return err
+		}
+		us.DisabledBySync = false
+		if err := db.UpdateField(us, "DisabledBySync", false); err != nil {
+			return err
+		}
+	}
 
 	return db.Update(us)
 }
@@ -177,6 +198,7 @@ func (u *service) Create(us *v1User.User, options common.DBOptions) error {
 	if us.Authenticate.Password != "" {
 		hash, _ := bcrypt.GenerateFromPassword([]byte(us.Authenticate.Password), bcrypt.DefaultCost) //加密处理
 		us.Authenticate.Password = string(hash)
+		us.Authenticate.PasswordChangedAt = us.CreateAt
 	}
 	return db.Save(us)
 }
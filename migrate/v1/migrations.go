@@ -1,13 +1,16 @@
 package v1
 
 import (
+	"errors"
 	"time"
 
 	v1 "github.com/KubeOperator/kubepi/internal/model/v1"
+	v1Ldap "github.com/KubeOperator/kubepi/internal/model/v1/ldap"
 	v1Role "github.com/KubeOperator/kubepi/internal/model/v1/role"
 	v1User "github.com/KubeOperator/kubepi/internal/model/v1/user"
 	"github.com/KubeOperator/kubepi/migrate/migrations"
 	"github.com/asdine/storm/v3"
+	"github.com/asdine/storm/v3/q"
 	"github.com/google/uuid"
 	"golang.org/x/crypto/bcrypt"
 )
@@ -15,6 +18,10 @@ import (
 var Migrations = []migrations.Migration{
 	CreateAdministrator,
 	AddRoleManagerRepo,
+	AssignLdapDirectory,
+	KeepLdapTLSInsecure,
+	AddGroupsToManageRBAC,
+	ConvertSsoUsers,
 }
 
 // 创建默认系统角色: Admin |Manage Cluster| Manage User|Read only|Common User | Manage Chart
@@ -172,3 +179,116 @@ var AddRoleManagerRepo = migrations.Migration{
 		return db.Save(&roleManageRepo)
 	},
 }
+
+// 支持多个 ldap 目录后,为原有的配置命名,并将已有的 ldap 用户归属到该目录
+var AssignLdapDirectory = migrations.Migration{
+	Version: 3,
+	Message: "Assign ldap users to directory",
+	Handler: func(db storm.Node) error {
+		var ldaps []v1Ldap.Ldap
+		if err := db.All(&ldaps); err != nil {
+			return err
+		}
+		if len(ldaps) == 0 {
+			return nil
+		}
+		ldap := ldaps[0]
+		if ldap.Name == "" {
+			if err := db.UpdateField(&ldap, "Name", "default"); err != nil {
+				return err
+			}
+		}
+		var users []v1User.User
+		if err := db.Select(q.Eq("Type", v1User.LDAP)).Find(&users); err != nil {
+			if errors.Is(err, storm.ErrNotFound) {
+				return nil
+			}
+			return err
+		}
+		for i := range users {
+			if users[i].LdapID != "" {
+				continue
+			}
+			if err := db.UpdateField(&users[i], "LdapID", ldap.UUID); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+}
+
+// 之前的 ldaps 连接不校验服务端证书,升级后保持原有行为,由管理员上传 CA 后关闭
+var KeepLdapTLSInsecure = migrations.Migration{
+	Version: 4,
+	Message: "Keep ldap tls connections insecure",
+	Handler: func(db storm.Node) error {
+		var ldaps []v1Ldap.Ldap
+		if err := db.All(&ldaps); err != nil {
+			return err
+		}
+		for i := range ldaps {
+			if !ldaps[i].TLS {
+				continue
+			}
+			if err := db.UpdateField(&ldaps[i], "InsecureSkipVerify", true); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+}
+
+// 用户组与用户、角色由同一个内置角色管理
+var AddGroupsToManageRBAC = migrations.Migration{
+	Version: 5,
+	Message: "Add groups to role Manage RBAC",
+	Handler: func(db storm.Node) error {
+		var role v1Role.Role
+		if err := db.One("Name", "Manage RBAC", &role); err != nil {
+			if errors.Is(err, storm.ErrNotFound) {
+				return nil
+			}
+			return err
+		}
+		for i := range role.Rules {
+			for _, resource := range role.Rules[i].Resource {
+				if resource == "groups" {
+					return nil
+				}
+			}
+		}
+		if len(role.Rules) == 0 {
+			return nil
+		}
+		role.Rules[0].Resource = append(role.Rules[0].Resource, "groups")
+		return db.UpdateField(&role, "Rules", role.Rules)
+	},
+}
+
+// 之前 SSO 自动创建的用户是使用固定密码的本地用户,转换为只能通过 SSO 登录的用户并清除密码
+var ConvertSsoUsers = migrations.Migration{
+	Version: 6,
+	Message: "Convert users created by sso",
+	Handler: func(db storm.Node) error {
+		var users []v1User.User
+		if err := db.Select(q.Eq("Type", v1User.LOCAL)).Find(&users); err != nil {
+			if errors.Is(err, storm.ErrNotFound) {
+				return nil
+			}
+			return err
+		}
+		for i := range users {
+			if bcrypt.CompareHashAndPassword([]byte(users[i].Authenticate.Password), []byte(`@=7kvi-$l*Pj+,s`)) != nil {
+				continue
+			}
+			users[i].Authenticate.Password = ""
+			if err := db.UpdateField(&users[i], "Authenticate", users[i].Authenticate); err != nil {
+				return err
+			}
+			if err := db.UpdateField(&users[i], "Type", v1User.SSO); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+}
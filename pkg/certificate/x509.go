@@ -3,11 +3,14 @@ package certificate
 import (
 	"crypto/rand"
 	"crypto/rsa"
+	"crypto/sha256"
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/asn1"
 	"encoding/pem"
 	"errors"
+	"fmt"
+	"strings"
 )
 
 func GeneratePrivateKey() ([]byte, error) {
@@ -57,3 +60,13 @@ func ParseX509Certificate(certPem []byte) (*x509.Certificate, error) {
 	}
 	return c, nil
 }
+
+// Fingerprint 证书的 sha256 指纹,格式与 openssl x509 -fingerprint -sha256 一致
+func Fingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	parts := make([]string, len(sum))
+	for i := range sum {
+		parts[i] = fmt.Sprintf("%02X", sum[i])
+	}
+	return strings.Join(parts, ":")
+}
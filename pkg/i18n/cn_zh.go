@@ -1,19 +1,81 @@
 package i18n
 
 var zhCNMapping = TextMapping{
-	"already exists":                        "资源已存在,请尝试修改资源名称",
-	"username or password error":            "登录失败,用户名或密码错误",
-	"Unauthorized":                          "认证失败",
-	"permission %s required":                "权限不被允许:%s",
-	"please login":                          "会话失效，请重新登录",
-	"can not delete yourself":               "无法删除您自己",
-	"username can not be none":              "用户名不能为空",
-	"must select one role":                  "请至少选择一个角色",
-	"must select one rule":                  "请至少创建一个规则",
-	"user %s can not access resource %s %s": "用户 %s 缺少资源 [%s - %s] 的权限, 无法完成此操作",
-	"can not match original password":       "无法匹配原密码",
-	"username already exists":               "用户名已存在",
-	"email already exists":                  "邮箱已存在",
-	"unable to complete authorization":      "无法完成授权，请检查用户名是否符合规范: /^[a-z0-9]([-a-z0-9]*[a-z0-9])?(\\.[a-z0-9]([-a-z0-9]*[a-z0-9])?)*$/",
-	"no login user":                         "用户未登录",
+	"already exists":                                                   "资源已存在,请尝试修改资源名称",
+	"username or password error":                                       "登录失败,用户名或密码错误",
+	"Unauthorized":                                                     "认证失败",
+	"permission %s required":                                           "权限不被允许:%s",
+	"please login":                                                     "会话失效，请重新登录",
+	"can not delete yourself":                                          "无法删除您自己",
+	"username can not be none":                                         "用户名不能为空",
+	"must select one role":                                             "请至少选择一个角色",
+	"must select one rule":                                             "请至少创建一个规则",
+	"user %s can not access resource %s %s":                            "用户 %s 缺少资源 [%s - %s] 的权限, 无法完成此操作",
+	"can not match original password":                                  "无法匹配原密码",
+	"username already exists":                                          "用户名已存在",
+	"email already exists":                                             "邮箱已存在",
+	"unable to complete authorization":                                 "无法完成授权，请检查用户名是否符合规范: /^[a-z0-9]([-a-z0-9]*[a-z0-9])?(\\.[a-z0-9]([-a-z0-9]*[a-z0-9])?)*$/",
+	"no login user":                                                    "用户未登录",
+	"token name can not be none":                                       "令牌名称不能为空",
+	"token expire time must be in the future":                          "令牌过期时间必须晚于当前时间",
+	"must select one scope":                                            "请至少选择一个授权范围",
+	"invalid token scopes":                                             "令牌授权范围必须是用户已拥有的角色",
+	"can not manage tokens with access token":                          "无法使用访问令牌管理令牌,请登录后操作",
+	"too many failed attempts, locked until %s":                        "失败次数过多,已被锁定至 %s",
+	"password must be at least %s characters":                          "密码长度至少为 %s 位",
+	"password must contain uppercase letters":                          "密码必须包含大写字母",
+	"password must contain lowercase letters":                          "密码必须包含小写字母",
+	"password must contain digits":                                     "密码必须包含数字",
+	"password must contain special characters":                         "密码必须包含特殊字符",
+	"password can not be the same as the last %s passwords":            "新密码不能与最近 %s 次使用的密码相同",
+	"password expired, please change it":                               "密码已过期,请修改密码",
+	"please bind mfa before using jwt login":                           "请先绑定 MFA 后再使用 jwt 登录",
+	"mfa is already bound":                                             "MFA 已绑定,请联系管理员重置",
+	"security key verification failed":                                 "安全密钥验证失败",
+	"no security key registered":                                       "未注册安全密钥",
+	"security key %s not found":                                        "安全密钥 %s 不存在",
+	"role %s not found":                                                "角色 %s 不存在",
+	"group of role mapping can not be none":                            "角色映射的用户组不能为空",
+	"invalid saml relay state":                                         "SAML RelayState 校验失败,请重新登录",
+	"saml is not configured":                                           "未配置 SAML 单点登录",
+	"user is disabled":                                                 "用户已被禁用",
+	"can not disable yourself":                                         "不能禁用自己",
+	"ldap is not enable!":                                              "LDAP未启用",
+	"ldap %s not found":                                                "LDAP配置 %s 不存在",
+	"sync report %s not found":                                         "同步记录 %s 不存在",
+	"group base dn can not be none when group mappings are configured": "配置用户组映射时用户组 DN 不能为空",
+	"group of cluster mapping can not be none":                         "集群映射的用户组不能为空",
+	"please delete the users of this ldap first":                       "请先删除该LDAP下的用户",
+	"ldap name can not be none":                                        "LDAP名称不能为空",
+	"tls and starttls can not be enabled at the same time":             "TLS 和 StartTLS 不能同时开启",
+	"invalid ldap ca certificate":                                      "LDAP CA 证书格式错误",
+	"user %s can not access %s %s":                                     "用户 %s 缺少 %s [%s] 的权限, 无法完成此操作",
+	"group %s not found":                                               "用户组 %s 不存在",
+	"group name already exists":                                        "用户组名称已存在",
+	"group name can not be none":                                       "用户组名称不能为空",
+	"resource and verb can not be none":                                "资源和操作不能为空",
+	"expire time must be later than now":                               "过期时间必须晚于当前时间",
+	"cluster %s not found":                                             "集群 %s 不存在",
+	"user %s is already a member of cluster %s":                        "用户 %s 已经是集群 %s 的成员",
+	"access request for cluster %s is already pending":                 "集群 %s 的访问申请正在等待审批",
+	"user %s can not cancel access request %s":                         "用户 %s 不能撤销访问申请 %s",
+	"access request %s not found":                                      "访问申请 %s 不存在",
+	"user %s is not an administrator of cluster %s":                    "用户 %s 不是集群 %s 的管理员",
+	"access request %s is %s":                                          "访问申请 %s 的状态为 %s",
+	"invalid csv: %s":                                                  "csv 格式错误: %s",
+	"unsupported import format %s":                                     "不支持的导入格式 %s",
+	"no users to import":                                               "没有需要导入的用户",
+	"can not update connection of reverse cluster":                     "反向连接的集群不能修改连接信息",
+	"cluster is not a reverse cluster":                                 "该集群不是反向连接的集群",
+	"join token is invalid or expired":                                 "加入令牌无效或已过期",
+	"service account token is required":                                "缺少 service account token",
+	"agent token is invalid":                                           "agent 令牌无效",
+	"unsupported authentication mode %s":                               "不支持的认证方式 %s",
+	"can not issue certificate for user %s: %s":                        "无法为用户 %s 签发证书: %s",
+	"duration must be between 0 and %s seconds":                        "授权时长必须在 0 到 %s 秒之间",
+	"access request %s is not pending":                                 "访问申请 %s 不是待审批状态",
+	"external url of kubepi is not configured":                         "未配置 kubepi 的外部访问地址",
+	"invalid ca certificate: %s":                                       "无效的 CA 证书: %s",
+	"ca certificate of agent does not match the cluster":               "agent 上报的 CA 证书与集群不一致",
+	"sso user can only login with sso":                                 "SSO 用户只能通过 SSO 登录",
 }
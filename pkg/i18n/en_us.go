@@ -1,19 +1,81 @@
 package i18n
 
 var enUSMapping = TextMapping{
-	"already exists":                        "resource already exists,please try changing the resource name",
-	"username or password error":            "login failed , username or password error",
-	"Unauthorized":                          "authorized error",
-	"permission %s required":                "permission forbidden: %s",
-	"please login":                          "session already  expired, please login",
-	"can not delete yourself":               "can not delete yourself",
-	"username can not be none":              "username can not be none",
-	"must select one role":                  "you must have one role",
-	"must select one rule":                  "you must create one rule",
-	"user %s can not access resource %s %s": "user %s can not access resource %s %s",
-	"can not match original password":       "can not match original password",
-	"username already exists":               "username already exists",
-	"email already exists":                  "email already exists",
-	"unable to complete authorization":      "Unable to complete authorization, please check whether the user name is valid:  /^[a-z0-9]([-a-z0-9]*[a-z0-9])?(\\.[a-z0-9]([-a-z0-9]*[a-z0-9])?)*$/",
-	"no login user":                         "no login user",
+	"already exists":                                                   "resource already exists,please try changing the resource name",
+	"username or password error":                                       "login failed , username or password error",
+	"Unauthorized":                                                     "authorized error",
+	"permission %s required":                                           "permission forbidden: %s",
+	"please login":                                                     "session already  expired, please login",
+	"can not delete yourself":                                          "can not delete yourself",
+	"username can not be none":                                         "username can not be none",
+	"must select one role":                                             "you must have one role",
+	"must select one rule":                                             "you must create one rule",
+	"user %s can not access resource %s %s":                            "user %s can not access resource %s %s",
+	"can not match original password":                                  "can not match original password",
+	"username already exists":                                          "username already exists",
+	"email already exists":                                             "email already exists",
+	"unable to complete authorization":                                 "Unable to complete authorization, please check whether the user name is valid:  /^[a-z0-9]([-a-z0-9]*[a-z0-9])?(\\.[a-z0-9]([-a-z0-9]*[a-z0-9])?)*$/",
+	"no login user":                                                    "no login user",
+	"token name can not be none":                                       "token name can not be none",
+	"token expire time must be in the future":                          "token expire time must be in the future",
+	"must select one scope":                                            "you must select one scope",
+	"invalid token scopes":                                             "token scopes must be roles owned by the user",
+	"can not manage tokens with access token":                          "can not manage tokens with access token, please login",
+	"too many failed attempts, locked until %s":                        "too many failed attempts, locked until %s",
+	"password must be at least %s characters":                          "password must be at least %s characters",
+	"password must contain uppercase letters":                          "password must contain uppercase letters",
+	"password must contain lowercase letters":                          "password must contain lowercase letters",
+	"password must contain digits":                                     "password must contain digits",
+	"password must contain special characters":                         "password must contain special characters",
+	"password can not be the same as the last %s passwords":            "password can not be the same as the last %s passwords",
+	"password expired, please change it":                               "password expired, please change it",
+	"please bind mfa before using jwt login":                           "please bind mfa before using jwt login",
+	"mfa is already bound":                                             "mfa is already bound",
+	"security key verification failed":                                 "security key verification failed",
+	"no security key registered":                                       "no security key registered",
+	"security key %s not found":                                        "security key %s not found",
+	"role %s not found":                                                "role %s not found",
+	"group of role mapping can not be none":                            "group of role mapping can not be none",
+	"invalid saml relay state":                                         "invalid saml relay state",
+	"saml is not configured":                                           "saml is not configured",
+	"user is disabled":                                                 "user is disabled",
+	"can not disable yourself":                                         "can not disable yourself",
+	"ldap is not enable!":                                              "ldap is not enable!",
+	"ldap %s not found":                                                "ldap %s not found",
+	"sync report %s not found":                                         "sync report %s not found",
+	"group base dn can not be none when group mappings are configured": "group base dn can not be none when group mappings are configured",
+	"group of cluster mapping can not be none":                         "group of cluster mapping can not be none",
+	"please delete the users of this ldap first":                       "please delete the users of this ldap first",
+	"ldap name can not be none":                                        "ldap name can not be none",
+	"tls and starttls can not be enabled at the same time":             "tls and starttls can not be enabled at the same time",
+	"invalid ldap ca certificate":                                      "invalid ldap ca certificate",
+	"user %s can not access %s %s":                                     "user %s can not access %s %s",
+	"group %s not found":                                               "group %s not found",
+	"group name already exists":                                        "group name already exists",
+	"group name can not be none":                                       "group name can not be none",
+	"resource and verb can not be none":                                "resource and verb can not be none",
+	"expire time must be later than now":                               "expire time must be later than now",
+	"cluster %s not found":                                             "cluster %s not found",
+	"user %s is already a member of cluster %s":                        "user %s is already a member of cluster %s",
+	"access request for cluster %s is already pending":                 "access request for cluster %s is already pending",
+	"user %s can not cancel access request %s":                         "user %s can not cancel access request %s",
+	"access request %s not found":                                      "access request %s not found",
+	"user %s is not an administrator of cluster %s":                    "user %s is not an administrator of cluster %s",
+	"access request %s is %s":                                          "access request %s is %s",
+	"invalid csv: %s":                                                  "invalid csv: %s",
+	"unsupported import format %s":                                     "unsupported import format %s",
+	"no users to import":                                               "no users to import",
+	"can not update connection of reverse cluster":                     "can not update connection of reverse cluster",
+	"cluster is not a reverse cluster":                                 "cluster is not a reverse cluster",
+	"join token is invalid or expired":                                 "join token is invalid or expired",
+	"service account token is required":                                "service account token is required",
+	"agent token is invalid":                                           "agent token is invalid",
+	"unsupported authentication mode %s":                               "unsupported authentication mode %s",
+	"can not issue certificate for user %s: %s":                        "can not issue certificate for user %s: %s",
+	"duration must be between 0 and %s seconds":                        "duration must be between 0 and %s seconds",
+	"access request %s is not pending":                                 "access request %s is not pending",
+	"external url of kubepi is not configured":                         "external url of kubepi is not configured",
+	"invalid ca certificate: %s":                                       "invalid ca certificate: %s",
+	"ca certificate of agent does not match the cluster":               "ca certificate of agent does not match the cluster",
+	"sso user can only login with sso":                                 "sso user can only login with sso",
 }
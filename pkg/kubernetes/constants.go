@@ -12,6 +12,7 @@ const (
 	LabelRoleTypeKey = "kubepi.org/role-type"
 	LabelClusterId   = "kubepi.org/cluster-id"
 	LabelUsername    = "kubepi.org/username"
+	LabelGroupname   = "kubepi.org/groupname"
 
 	RoleTypeCluster   = "cluster"
 	RoleTypeNamespace = "namespace"
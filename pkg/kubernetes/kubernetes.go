@@ -13,6 +13,7 @@ import (
 	v1Cluster "github.com/KubeOperator/kubepi/internal/model/v1/cluster"
 	"github.com/KubeOperator/kubepi/pkg/certificate"
 	"github.com/KubeOperator/kubepi/pkg/collectons"
+	"github.com/KubeOperator/kubepi/pkg/tunnel"
 	v1 "k8s.io/api/authorization/v1"
 	certv1 "k8s.io/api/certificates/v1"
 	certv1beta1 "k8s.io/api/certificates/v1beta1"
@@ -33,8 +34,12 @@ type Interface interface {
 	Version() (*version.Info, error)
 	VersionMinor() (int, error)
 	Config() (*rest.Config, error)
+	NewUserConfig(certData, keyData []byte) (*rest.Config, error)
+	ApiServer() (string, error)
 	Client() (*kubernetes.Clientset, error)
 	HasPermission(attributes v1.ResourceAttributes) (PermissionCheckResult, error)
+	ReviewPermission(username string, attributes v1.ResourceAttributes) (PermissionCheckResult, error)
+	ManagedRoleBindings(username string) ([]ManagedRoleBinding, error)
 	CreateCommonUser(commonName string) ([]byte, error)
 	CreateDefaultClusterRoles() error
 	GetUserNamespaceNames(username string, options ...interface{}) ([]string, error)
@@ -46,6 +51,10 @@ type Interface interface {
 	CleanAllRBACResource() error
 	CreateOrUpdateClusterRoleBinding(clusterRoleName string, username string, builtIn bool) error
 	CreateOrUpdateRolebinding(namespace string, clusterRoleName string, username string, builtIn bool) error
+	CreateOrUpdateGroupClusterRoleBinding(clusterRoleName string, groupName string, username string) error
+	CreateOrUpdateGroupRolebinding(namespace string, clusterRoleName string, groupName string, username string) error
+	CleanGroupRoleBinding(groupName string, username string) error
+	CleanUserRoleBinding(username string) error
 	CreateAppMarketCRD() error
 }
 
@@ -64,16 +73,32 @@ func (k *Kubernetes) VersionMinor() (int, error) {
 }
 
 func (k *Kubernetes) CreateOrUpdateClusterRoleBinding(clusterRoleName string, username string, builtIn bool) error {
-	client, err := k.Client()
-	if err != nil {
-		return err
-	}
 	name := fmt.Sprintf("%s:%s:%s", username, clusterRoleName, k.UUID)
 	labels := map[string]string{
 		LabelManageKey: "kubepi",
 		LabelClusterId: k.UUID,
 		LabelUsername:  username,
 	}
+	return k.createOrUpdateClusterRoleBinding(name, clusterRoleName, username, labels, builtIn)
+}
+
+// CreateOrUpdateGroupClusterRoleBinding 为用户组成员创建集群角色绑定,与用户自身的绑定互不影响
+func (k *Kubernetes) CreateOrUpdateGroupClusterRoleBinding(clusterRoleName string, groupName string, username string) error {
+	name := fmt.Sprintf("%s:%s:%s:%s", groupName, username, clusterRoleName, k.UUID)
+	labels := map[string]string{
+		LabelManageKey: "kubepi",
+		LabelClusterId: k.UUID,
+		LabelUsername:  username,
+		LabelGroupname: groupName,
+	}
+	return k.createOrUpdateClusterRoleBinding(name, clusterRoleName, username, labels, false)
+}
+
+func (k *Kubernetes) createOrUpdateClusterRoleBinding(name string, clusterRoleName string, username string, labels map[string]string, builtIn bool) error {
+	client, err := k.Client()
+	if err != nil {
+		return err
+	}
 	annotations := map[string]string{
 		"built-in":   strconv.FormatBool(builtIn),
 		"created-at": time.Now().Format("2006-01-02 15:04:05"),
@@ -116,20 +141,36 @@ func (k *Kubernetes) CreateOrUpdateClusterRoleBinding(clusterRoleName string, us
 }
 
 func (k *Kubernetes) CreateOrUpdateRolebinding(namespace string, clusterRoleName string, username string, builtIn bool) error {
-	client, err := k.Client()
-	if err != nil {
-		return err
+	labels := map[string]string{
+		LabelManageKey: "kubepi",
+		LabelClusterId: k.UUID,
+		LabelUsername:  username,
 	}
+	name := fmt.Sprintf("%s:%s:%s:%s", namespace, username, clusterRoleName, k.UUID)
+	return k.createOrUpdateRolebinding(namespace, name, clusterRoleName, username, labels, builtIn)
+}
+
+// CreateOrUpdateGroupRolebinding 为用户组成员创建命名空间角色绑定,与用户自身的绑定互不影响
+func (k *Kubernetes) CreateOrUpdateGroupRolebinding(namespace string, clusterRoleName string, groupName string, username string) error {
 	labels := map[string]string{
 		LabelManageKey: "kubepi",
 		LabelClusterId: k.UUID,
 		LabelUsername:  username,
+		LabelGroupname: groupName,
+	}
+	name := fmt.Sprintf("%s:%s:%s:%s:%s", namespace, groupName, username, clusterRoleName, k.UUID)
+	return k.createOrUpdateRolebinding(namespace, name, clusterRoleName, username, labels, false)
+}
+
+func (k *Kubernetes) createOrUpdateRolebinding(namespace string, name string, clusterRoleName string, username string, labels map[string]string, builtIn bool) error {
+	client, err := k.Client()
+	if err != nil {
+		return err
 	}
 	annotations := map[string]string{
 		"built-in":   strconv.FormatBool(builtIn),
 		"created-at": time.Now().Format("2006-01-02 15:04:05"),
 	}
-	name := fmt.Sprintf("%s:%s:%s:%s", namespace, username, clusterRoleName, k.UUID)
 	item := rbacV1.RoleBinding{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:        name,
@@ -193,7 +234,8 @@ func (k *Kubernetes) CleanManagedClusterRoleBinding(username string) error {
 		fmt.Sprintf("%s=%s", LabelClusterId, k.UUID),
 	}
 	if username != "" {
-		labels = append(labels, fmt.Sprintf("%s=%s", LabelUsername, username))
+		// 用户组成员的绑定由用户组管理
+		labels = append(labels, fmt.Sprintf("%s=%s", LabelUsername, username), fmt.Sprintf("!%s", LabelGroupname))
 	}
 	return client.RbacV1().ClusterRoleBindings().DeleteCollection(context.TODO(), metav1.DeleteOptions{}, metav1.ListOptions{
 		LabelSelector: strings.Join(labels, ","),
@@ -214,7 +256,7 @@ func (k *Kubernetes) CleanManagedRoleBinding(username string) error {
 		fmt.Sprintf("%s=%s", LabelClusterId, k.UUID),
 	}
 	if username != "" {
-		labels = append(labels, fmt.Sprintf("%s=%s", LabelUsername, username))
+		labels = append(labels, fmt.Sprintf("%s=%s", LabelUsername, username), fmt.Sprintf("!%s", LabelGroupname))
 	}
 	for i := range nss.Items {
 		if err := client.RbacV1().RoleBindings(nss.Items[i].Name).DeleteCollection(context.TODO(), metav1.DeleteOptions{}, metav1.ListOptions{
@@ -226,6 +268,70 @@ func (k *Kubernetes) CleanManagedRoleBinding(username string) error {
 	return nil
 }
 
+// CleanGroupRoleBinding 删除用户组成员的角色绑定,groupName 为空时删除用户通过所有用户组获得的绑定,username 为空时删除用户组全部成员的绑定
+func (k *Kubernetes) CleanGroupRoleBinding(groupName string, username string) error {
+	client, err := k.Client()
+	if err != nil {
+		return err
+	}
+	labels := []string{
+		fmt.Sprintf("%s=%s", LabelManageKey, "kubepi"),
+		fmt.Sprintf("%s=%s", LabelClusterId, k.UUID),
+		LabelGroupname,
+	}
+	if groupName != "" {
+		labels[2] = fmt.Sprintf("%s=%s", LabelGroupname, groupName)
+	}
+	if username != "" {
+		labels = append(labels, fmt.Sprintf("%s=%s", LabelUsername, username))
+	}
+	selector := metav1.ListOptions{
+		LabelSelector: strings.Join(labels, ","),
+	}
+	if err := client.RbacV1().ClusterRoleBindings().DeleteCollection(context.TODO(), metav1.DeleteOptions{}, selector); err != nil {
+		return err
+	}
+	nss, err := client.CoreV1().Namespaces().List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return err
+	}
+	for i := range nss.Items {
+		if err := client.RbacV1().RoleBindings(nss.Items[i].Name).DeleteCollection(context.TODO(), metav1.DeleteOptions{}, selector); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CleanUserRoleBinding 删除用户直接获得以及通过用户组获得的全部绑定,用于收回已吊销证书的访问权限
+func (k *Kubernetes) CleanUserRoleBinding(username string) error {
+	client, err := k.Client()
+	if err != nil {
+		return err
+	}
+	labels := []string{
+		fmt.Sprintf("%s=%s", LabelManageKey, "kubepi"),
+		fmt.Sprintf("%s=%s", LabelClusterId, k.UUID),
+		fmt.Sprintf("%s=%s", LabelUsername, username),
+	}
+	selector := metav1.ListOptions{
+		LabelSelector: strings.Join(labels, ","),
+	}
+	if err := client.RbacV1().ClusterRoleBindings().DeleteCollection(context.TODO(), metav1.DeleteOptions{}, selector); err != nil {
+		return err
+	}
+	nss, err := client.CoreV1().Namespaces().List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return err
+	}
+	for i := range nss.Items {
+		if err := client.RbacV1().RoleBindings(nss.Items[i].Name).DeleteCollection(context.TODO(), metav1.DeleteOptions{}, selector); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (k *Kubernetes) CleanAllRBACResource() error {
 	if err := k.CleanManagedClusterRole(); err != nil {
 		return err
@@ -343,6 +449,7 @@ func (k *Kubernetes) IsNamespacedResource(resourceName string) (bool, error) {
 type PermissionCheckResult struct {
 	Resource v1.ResourceAttributes
 	Allowed  bool
+	Reason   string
 }
 
 func NewKubernetes(cluster *v1Cluster.Cluster) Interface {
@@ -521,7 +628,22 @@ func (k *Kubernetes) Config() (*rest.Config, error) {
 	if k.Spec.Local {
 		return rest.InClusterConfig()
 	}
-	if k.Spec.Connect.Direction == "forward" {
+	if k.Spec.Connect.Direction == v1Cluster.DirectionReverse {
+		addr, err := k.reverseAddr()
+		if err != nil {
+			return nil, err
+		}
+		tlsConf, err := k.reverseTLSConfig()
+		if err != nil {
+			return nil, err
+		}
+		return &rest.Config{
+			Host:            addr,
+			BearerToken:     k.Spec.Authentication.BearerToken,
+			TLSClientConfig: tlsConf,
+		}, nil
+	}
+	if k.Spec.Connect.Direction == v1Cluster.DirectionForward {
 		kubeConf := &rest.Config{
 			Host: k.Spec.Connect.Forward.ApiServer,
 		}
@@ -545,11 +667,41 @@ func (k *Kubernetes) Config() (*rest.Config, error) {
 			}
 			kubeConf = cfg
 		}
+		if err := k.applyProxy(kubeConf); err != nil {
+			return nil, err
+		}
 		return kubeConf, nil
 	}
 	return nil, nil
 }
 
+// ApiServer 返回访问 apiserver 的地址,反向连接的集群为本机的隧道地址
+func (k *Kubernetes) ApiServer() (string, error) {
+	if k.Spec.Connect.Direction == v1Cluster.DirectionReverse {
+		return k.reverseAddr()
+	}
+	return k.Spec.Connect.Forward.ApiServer, nil
+}
+
+// reverseTLSConfig 经由隧道访问集群时校验固定的 ca,不允许跳过校验
+func (k *Kubernetes) reverseTLSConfig() (rest.TLSClientConfig, error) {
+	if len(k.CaCertificate.CertData) == 0 {
+		return rest.TLSClientConfig{}, fmt.Errorf("ca certificate of cluster %s is not pinned", k.Name)
+	}
+	return rest.TLSClientConfig{
+		CAData:     k.CaCertificate.CertData,
+		ServerName: tunnel.APIServerName,
+	}, nil
+}
+
+func (k *Kubernetes) reverseAddr() (string, error) {
+	addr, ok := tunnel.DefaultServer.Addr(k.UUID)
+	if !ok {
+		return "", fmt.Errorf("agent of cluster %s is not connected", k.Name)
+	}
+	return "https://" + addr, nil
+}
+
 func (k *Kubernetes) Client() (*kubernetes.Clientset, error) {
 	cfg, err := k.Config()
 	if err != nil {
@@ -0,0 +1,80 @@
+package kubernetes
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	v1Cluster "github.com/KubeOperator/kubepi/internal/model/v1/cluster"
+	"k8s.io/client-go/rest"
+)
+
+// ProxyURL 返回带认证信息的代理地址,支持 http、https 和 socks5,未配置代理时返回 nil
+func ProxyURL(p v1Cluster.Proxy) (*url.URL, error) {
+	raw := strings.TrimSpace(p.URL)
+	if raw == "" {
+		return nil, nil
+	}
+	if !strings.Contains(raw, "://") {
+		raw = "http://" + raw
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy url %s: %s", p.URL, err.Error())
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme %s", u.Scheme)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("invalid proxy url %s", p.URL)
+	}
+	if p.Username != "" {
+		u.User = url.UserPassword(p.Username, p.Password)
+	}
+	return u, nil
+}
+
+// applyProxy 为直连集群的配置设置代理,client-go 的 http、exec 和 port-forward 都会使用该代理
+func (k *Kubernetes) applyProxy(cfg *rest.Config) error {
+	u, err := ProxyURL(k.Spec.Connect.Forward.Proxy)
+	if err != nil {
+		return err
+	}
+	if u != nil {
+		cfg.Proxy = http.ProxyURL(u)
+	}
+	return nil
+}
+
+// NewUserConfig 使用 kubepi 签发的用户证书访问集群
+func (k *Kubernetes) NewUserConfig(certData, keyData []byte) (*rest.Config, error) {
+	host, err := k.ApiServer()
+	if err != nil {
+		return nil, err
+	}
+	cfg := &rest.Config{
+		Host: host,
+		TLSClientConfig: rest.TLSClientConfig{
+			Insecure: true,
+			CertData: certData,
+			KeyData:  keyData,
+		},
+	}
+	if k.Spec.Connect.Direction == v1Cluster.DirectionReverse {
+		tlsConf, err := k.reverseTLSConfig()
+		if err != nil {
+			return nil, err
+		}
+		tlsConf.CertData = certData
+		tlsConf.KeyData = keyData
+		cfg.TLSClientConfig = tlsConf
+		return cfg, nil
+	}
+	if err := k.applyProxy(cfg); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
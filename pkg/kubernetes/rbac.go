@@ -0,0 +1,145 @@
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/KubeOperator/kubepi/pkg/collectons"
+	v1 "k8s.io/api/authorization/v1"
+	rbacV1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ManagedRoleBinding kubepi 为用户创建的角色绑定,Namespace 为空时为集群角色绑定
+type ManagedRoleBinding struct {
+	Kind      string              `json:"kind"`
+	Name      string              `json:"name"`
+	Namespace string              `json:"namespace"`
+	Group     string              `json:"group"`
+	RoleRef   string              `json:"roleRef"`
+	Rules     []rbacV1.PolicyRule `json:"rules"`
+}
+
+// Allows 角色绑定在其作用范围内是否允许该请求
+func (b *ManagedRoleBinding) Allows(attributes v1.ResourceAttributes) bool {
+	if b.Namespace != "" && b.Namespace != attributes.Namespace {
+		return false
+	}
+	for i := range b.Rules {
+		if RuleAllows(b.Rules[i], attributes) {
+			return true
+		}
+	}
+	return false
+}
+
+// ReviewPermission 使用 SubjectAccessReview 检查用户的权限,Reason 为 apiserver 给出的授权依据
+func (k *Kubernetes) ReviewPermission(username string, attributes v1.ResourceAttributes) (PermissionCheckResult, error) {
+	client, err := k.Client()
+	if err != nil {
+		return PermissionCheckResult{}, err
+	}
+	resp, err := client.AuthorizationV1().SubjectAccessReviews().Create(context.TODO(), &v1.SubjectAccessReview{
+		Spec: v1.SubjectAccessReviewSpec{
+			ResourceAttributes: &attributes,
+			User:               username,
+			Groups:             []string{"system:authenticated"},
+		},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return PermissionCheckResult{}, err
+	}
+	reason := resp.Status.Reason
+	if resp.Status.EvaluationError != "" {
+		reason = strings.TrimSpace(fmt.Sprintf("%s %s", reason, resp.Status.EvaluationError))
+	}
+	return PermissionCheckResult{
+		Resource: attributes,
+		Allowed:  resp.Status.Allowed,
+		Reason:   reason,
+	}, nil
+}
+
+// ManagedRoleBindings 返回 kubepi 为用户创建的角色绑定及其角色规则,包括通过用户组获得的绑定
+func (k *Kubernetes) ManagedRoleBindings(username string) ([]ManagedRoleBinding, error) {
+	client, err := k.Client()
+	if err != nil {
+		return nil, err
+	}
+	selector := metav1.ListOptions{
+		LabelSelector: strings.Join([]string{
+			fmt.Sprintf("%s=%s", LabelManageKey, "kubepi"),
+			fmt.Sprintf("%s=%s", LabelClusterId, k.UUID),
+			fmt.Sprintf("%s=%s", LabelUsername, username),
+		}, ","),
+	}
+	crbs, err := client.RbacV1().ClusterRoleBindings().List(context.TODO(), selector)
+	if err != nil {
+		return nil, err
+	}
+	rbs, err := client.RbacV1().RoleBindings("").List(context.TODO(), selector)
+	if err != nil {
+		return nil, err
+	}
+	var bindings []ManagedRoleBinding
+	roleNames := collectons.NewStringSet()
+	for i := range crbs.Items {
+		roleNames.Add(crbs.Items[i].RoleRef.Name)
+		bindings = append(bindings, ManagedRoleBinding{
+			Kind:    "ClusterRoleBinding",
+			Name:    crbs.Items[i].Name,
+			Group:   crbs.Items[i].Labels[LabelGroupname],
+			RoleRef: crbs.Items[i].RoleRef.Name,
+		})
+	}
+	for i := range rbs.Items {
+		roleNames.Add(rbs.Items[i].RoleRef.Name)
+		bindings = append(bindings, ManagedRoleBinding{
+			Kind:      "RoleBinding",
+			Name:      rbs.Items[i].Name,
+			Namespace: rbs.Items[i].Namespace,
+			Group:     rbs.Items[i].Labels[LabelGroupname],
+			RoleRef:   rbs.Items[i].RoleRef.Name,
+		})
+	}
+	rules := map[string][]rbacV1.PolicyRule{}
+	for _, name := range roleNames.ToSlice() {
+		role, err := client.RbacV1().ClusterRoles().Get(context.TODO(), name, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		rules[name] = role.Rules
+	}
+	for i := range bindings {
+		bindings[i].Rules = rules[bindings[i].RoleRef]
+	}
+	return bindings, nil
+}
+
+// RuleAllows 与 kubernetes rbac 的规则匹配方式一致
+func RuleAllows(rule rbacV1.PolicyRule, attributes v1.ResourceAttributes) bool {
+	if !hasValue(rule.Verbs, attributes.Verb) || !hasValue(rule.APIGroups, attributes.Group) {
+		return false
+	}
+	resource := attributes.Resource
+	if attributes.Subresource != "" {
+		resource = fmt.Sprintf("%s/%s", attributes.Resource, attributes.Subresource)
+	}
+	if !hasValue(rule.Resources, resource) {
+		return false
+	}
+	if len(rule.ResourceNames) == 0 {
+		return true
+	}
+	return attributes.Name != "" && collectons.IndexOfStringSlice(rule.ResourceNames, attributes.Name) != -1
+}
+
+func hasValue(values []string, value string) bool {
+	for i := range values {
+		if values[i] == "*" || values[i] == value {
+			return true
+		}
+	}
+	return false
+}
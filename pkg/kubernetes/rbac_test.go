@@ -0,0 +1,41 @@
+package kubernetes
+
+import (
+	"testing"
+
+	v1 "k8s.io/api/authorization/v1"
+	rbacV1 "k8s.io/api/rbac/v1"
+)
+
+func TestRuleAllows(t *testing.T) {
+	rule := rbacV1.PolicyRule{
+		APIGroups: []string{"apps"},
+		Resources: []string{"deployments", "deployments/scale"},
+		Verbs:     []string{"get", "list"},
+	}
+	named := rbacV1.PolicyRule{
+		APIGroups:     []string{""},
+		Resources:     []string{"configmaps"},
+		ResourceNames: []string{"app-config"},
+		Verbs:         []string{"*"},
+	}
+	tests := []struct {
+		rule       rbacV1.PolicyRule
+		attributes v1.ResourceAttributes
+		want       bool
+	}{
+		{rule: rule, attributes: v1.ResourceAttributes{Verb: "get", Group: "apps", Resource: "deployments"}, want: true},
+		{rule: rule, attributes: v1.ResourceAttributes{Verb: "delete", Group: "apps", Resource: "deployments"}, want: false},
+		{rule: rule, attributes: v1.ResourceAttributes{Verb: "get", Group: "", Resource: "deployments"}, want: false},
+		{rule: rule, attributes: v1.ResourceAttributes{Verb: "get", Group: "apps", Resource: "deployments", Subresource: "scale"}, want: true},
+		{rule: rule, attributes: v1.ResourceAttributes{Verb: "get", Group: "apps", Resource: "deployments", Subresource: "status"}, want: false},
+		{rule: named, attributes: v1.ResourceAttributes{Verb: "update", Resource: "configmaps", Name: "app-config"}, want: true},
+		{rule: named, attributes: v1.ResourceAttributes{Verb: "update", Resource: "configmaps", Name: "other"}, want: false},
+		{rule: named, attributes: v1.ResourceAttributes{Verb: "list", Resource: "configmaps"}, want: false},
+	}
+	for _, tt := range tests {
+		if got := RuleAllows(tt.rule, tt.attributes); got != tt.want {
+			t.Errorf("RuleAllows(%v, %+v) = %v, want %v", tt.rule, tt.attributes, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,189 @@
+package tunnel
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Agent 运行在目标集群中,主动连接 kubepi 并把数据连接转发到集群的 apiserver
+type Agent struct {
+	// Server kubepi 的地址,例如 https://kubepi.example.com
+	Server     string
+	Cluster    string
+	AgentToken string
+	// Target apiserver 的地址 host:port
+	Target string
+	// TokenFile service account token 文件,变化时通知 kubepi 更新凭证
+	TokenFile string
+	Insecure  bool
+}
+
+// Register 使用一次性加入令牌注册 agent,返回后续连接使用的 agent 令牌
+func Register(server string, insecure bool, req RegisterRequest) (string, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return "", err
+	}
+	client := &http.Client{Timeout: 30 * time.Second, Transport: &http.Transport{
+		Proxy:           http.ProxyFromEnvironment,
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: insecure},
+	}}
+	resp, err := client.Post(strings.TrimSuffix(server, "/")+RegisterPath, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	var result struct {
+		Success bool             `json:"success"`
+		Message string           `json:"message"`
+		Data    RegisterResponse `json:"data"`
+	}
+	if err := json.Unmarshal(data, &result); err != nil {
+		return "", fmt.Errorf("register failed: %s", string(data))
+	}
+	if resp.StatusCode != http.StatusOK || result.Data.AgentToken == "" {
+		return "", fmt.Errorf("register failed: %s", result.Message)
+	}
+	return result.Data.AgentToken, nil
+}
+
+// Run 建立控制连接并处理 kubepi 的消息,连接断开或 ctx 结束时返回
+func (a *Agent) Run(ctx context.Context) error {
+	ws, err := a.dial(ctx, ConnectPath, nil)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var writeMu sync.Mutex
+	go func() {
+		<-ctx.Done()
+		_ = ws.Close()
+	}()
+	go a.keepalive(ctx, ws, &writeMu)
+
+	for {
+		var m Message
+		if err := ws.ReadJSON(&m); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+		if m.Type == MessageConnect && m.ID != "" {
+			go a.serve(ctx, m.ID)
+		}
+	}
+}
+
+// keepalive 定时发送心跳,并在 service account token 轮换后上报新的 token
+func (a *Agent) keepalive(ctx context.Context, ws *websocket.Conn, writeMu *sync.Mutex) {
+	var lastToken string
+	sendToken := func() {
+		if a.TokenFile == "" {
+			return
+		}
+		bs, err := os.ReadFile(a.TokenFile)
+		if err != nil {
+			return
+		}
+		token := strings.TrimSpace(string(bs))
+		if token == "" || token == lastToken {
+			return
+		}
+		writeMu.Lock()
+		err = ws.WriteJSON(Message{Type: MessageCredentials, Token: token})
+		writeMu.Unlock()
+		if err == nil {
+			lastToken = token
+		}
+	}
+	sendToken()
+	ticker := time.NewTicker(pingPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			writeMu.Lock()
+			err := ws.WriteControl(websocket.PingMessage, nil, time.Now().Add(10*time.Second))
+			writeMu.Unlock()
+			if err != nil {
+				_ = ws.Close()
+				return
+			}
+			sendToken()
+		}
+	}
+}
+
+func (a *Agent) serve(ctx context.Context, id string) {
+	var d net.Dialer
+	target, err := d.DialContext(ctx, "tcp", a.Target)
+	if err != nil {
+		return
+	}
+	ws, err := a.dial(ctx, DataPath, url.Values{"id": []string{id}})
+	if err != nil {
+		_ = target.Close()
+		return
+	}
+	pipe(newConn(ws), target)
+}
+
+func (a *Agent) dial(ctx context.Context, path string, query url.Values) (*websocket.Conn, error) {
+	u, err := url.Parse(strings.TrimSuffix(a.Server, "/") + path)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "https":
+		u.Scheme = "wss"
+	case "http":
+		u.Scheme = "ws"
+	default:
+		return nil, fmt.Errorf("unsupported kubepi url %s", a.Server)
+	}
+	if query == nil {
+		query = url.Values{}
+	}
+	query.Set("cluster", a.Cluster)
+	u.RawQuery = query.Encode()
+	dialer := websocket.Dialer{
+		Proxy:            http.ProxyFromEnvironment,
+		HandshakeTimeout: 30 * time.Second,
+		TLSClientConfig:  &tls.Config{InsecureSkipVerify: a.Insecure},
+	}
+	header := http.Header{}
+	header.Set("Authorization", "Bearer "+a.AgentToken)
+	ws, resp, err := dialer.DialContext(ctx, u.String(), header)
+	if err != nil {
+		if resp != nil && resp.StatusCode == http.StatusUnauthorized {
+			return nil, ErrUnauthorized
+		}
+		return nil, err
+	}
+	return ws, nil
+}
+
+// ErrUnauthorized agent 令牌无效,需要重新注册
+var ErrUnauthorized = errors.New("agent token is invalid")
@@ -0,0 +1,187 @@
+package tunnel
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+// DefaultServer kubepi 进程内的隧道,以集群 UUID 区分
+var DefaultServer = NewServer()
+
+// Server 为每个已连接的 agent 在本机回环地址上监听,
+// 每个 tcp 连接都通知 agent 建立一条数据连接并转发到集群的 apiserver
+type Server struct {
+	mu       sync.Mutex
+	sessions map[string]*session
+	upgrader websocket.Upgrader
+}
+
+type session struct {
+	control  *websocket.Conn
+	writeMu  sync.Mutex
+	listener net.Listener
+	mu       sync.Mutex
+	pending  map[string]chan net.Conn
+}
+
+func NewServer() *Server {
+	return &Server{
+		sessions: map[string]*session{},
+		upgrader: websocket.Upgrader{
+			// agent 不是浏览器,拒绝带 Origin 的跨站请求
+			CheckOrigin: func(r *http.Request) bool { return r.Header.Get("Origin") == "" },
+		},
+	}
+}
+
+// Addr 返回转发到集群 apiserver 的本机地址,agent 未连接时返回 false
+func (s *Server) Addr(key string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sess, ok := s.sessions[key]
+	if !ok {
+		return "", false
+	}
+	return sess.listener.Addr().String(), true
+}
+
+// Close 断开 agent 的控制连接
+func (s *Server) Close(key string) {
+	s.mu.Lock()
+	sess, ok := s.sessions[key]
+	delete(s.sessions, key)
+	s.mu.Unlock()
+	if ok {
+		sess.close()
+	}
+}
+
+// ServeControl 升级为控制连接并阻塞到连接断开,同一集群的旧连接会被替换
+func (s *Server) ServeControl(key string, w http.ResponseWriter, r *http.Request, onReady func(), onMessage func(Message)) error {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return err
+	}
+	ws, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		_ = l.Close()
+		return err
+	}
+	sess := &session{
+		control:  ws,
+		listener: l,
+		pending:  map[string]chan net.Conn{},
+	}
+	s.mu.Lock()
+	old := s.sessions[key]
+	s.sessions[key] = sess
+	s.mu.Unlock()
+	if old != nil {
+		old.close()
+	}
+	defer func() {
+		s.mu.Lock()
+		if s.sessions[key] == sess {
+			delete(s.sessions, key)
+		}
+		s.mu.Unlock()
+		sess.close()
+	}()
+
+	go sess.accept()
+	if onReady != nil {
+		go onReady()
+	}
+	ws.SetReadLimit(maxControlMessageSize)
+	_ = ws.SetReadDeadline(time.Now().Add(pongWait))
+	ws.SetPingHandler(func(data string) error {
+		_ = ws.SetReadDeadline(time.Now().Add(pongWait))
+		sess.writeMu.Lock()
+		defer sess.writeMu.Unlock()
+		return ws.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(time.Second))
+	})
+	for {
+		var m Message
+		if err := ws.ReadJSON(&m); err != nil {
+			return err
+		}
+		_ = ws.SetReadDeadline(time.Now().Add(pongWait))
+		if onMessage != nil {
+			onMessage(m)
+		}
+	}
+}
+
+// ServeData 升级为数据连接并交给等待中的 tcp 连接
+func (s *Server) ServeData(key, id string, w http.ResponseWriter, r *http.Request) error {
+	s.mu.Lock()
+	sess, ok := s.sessions[key]
+	s.mu.Unlock()
+	if !ok {
+		return errors.New("agent is not connected")
+	}
+	sess.mu.Lock()
+	ch, ok := sess.pending[id]
+	delete(sess.pending, id)
+	sess.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("unknown tunnel connection %s", id)
+	}
+	ws, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return err
+	}
+	ch <- newConn(ws)
+	return nil
+}
+
+func (sess *session) accept() {
+	for {
+		c, err := sess.listener.Accept()
+		if err != nil {
+			return
+		}
+		go sess.forward(c)
+	}
+}
+
+func (sess *session) forward(c net.Conn) {
+	id := uuid.New().String()
+	ch := make(chan net.Conn, 1)
+	sess.mu.Lock()
+	sess.pending[id] = ch
+	sess.mu.Unlock()
+
+	sess.writeMu.Lock()
+	err := sess.control.WriteJSON(Message{Type: MessageConnect, ID: id})
+	sess.writeMu.Unlock()
+	if err == nil {
+		select {
+		case dc := <-ch:
+			pipe(c, dc)
+			return
+		case <-time.After(dialTimeout):
+		}
+	}
+	sess.mu.Lock()
+	delete(sess.pending, id)
+	sess.mu.Unlock()
+	select {
+	case dc := <-ch:
+		_ = dc.Close()
+	default:
+	}
+	_ = c.Close()
+}
+
+func (sess *session) close() {
+	_ = sess.listener.Close()
+	_ = sess.control.Close()
+}
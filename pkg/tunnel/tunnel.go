@@ -0,0 +1,69 @@
+package tunnel
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"time"
+)
+
+const (
+	// APIServerName 集群内 apiserver 证书中包含的域名,通过隧道访问时用于校验证书
+	APIServerName = "kubernetes.default.svc"
+
+	MessageConnect     = "connect"
+	MessageCredentials = "credentials"
+
+	RegisterPath = "/kubepi/api/v1/tunnel/register"
+	ConnectPath  = "/kubepi/api/v1/tunnel/ws/connect"
+	DataPath     = "/kubepi/api/v1/tunnel/ws/data"
+
+	// pingPeriod agent 发送心跳的周期,超过 pongWait 没有收到消息时断开控制连接
+	pingPeriod = 30 * time.Second
+	pongWait   = 90 * time.Second
+	// dialTimeout 等待 agent 建立数据连接的时间
+	dialTimeout = 15 * time.Second
+	// maxControlMessageSize 控制连接上单条消息的大小上限
+	maxControlMessageSize = 64 << 10
+)
+
+// Message 控制连接上的消息,connect 由 kubepi 发送,credentials 由 agent 发送
+type Message struct {
+	Type  string `json:"type"`
+	ID    string `json:"id,omitempty"`
+	Token string `json:"token,omitempty"`
+}
+
+type RegisterRequest struct {
+	Cluster   string `json:"cluster"`
+	JoinToken string `json:"joinToken"`
+	// Token agent 的 service account token,kubepi 使用该 token 管理集群
+	Token  string `json:"token"`
+	CaData string `json:"caData"`
+}
+
+type RegisterResponse struct {
+	AgentToken string `json:"agentToken"`
+}
+
+// GenerateToken 生成随机令牌,kubepi 只保存令牌的摘要
+func GenerateToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func VerifyToken(token, hash string) bool {
+	if token == "" || hash == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(HashToken(token)), []byte(hash)) == 1
+}
@@ -0,0 +1,97 @@
+package tunnel
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestTunnel(t *testing.T) {
+	echo, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer echo.Close()
+	go func() {
+		for {
+			c, err := echo.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				_, _ = io.Copy(c, c)
+				_ = c.Close()
+			}()
+		}
+	}()
+
+	s := NewServer()
+	ready := make(chan struct{})
+	mux := http.NewServeMux()
+	mux.HandleFunc(ConnectPath, func(w http.ResponseWriter, r *http.Request) {
+		_ = s.ServeControl(r.URL.Query().Get("cluster"), w, r, func() { close(ready) }, nil)
+	})
+	mux.HandleFunc(DataPath, func(w http.ResponseWriter, r *http.Request) {
+		if err := s.ServeData(r.URL.Query().Get("cluster"), r.URL.Query().Get("id"), w, r); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	agent := &Agent{Server: ts.URL, Cluster: "edge", AgentToken: "token", Target: echo.Addr().String()}
+	go func() { _ = agent.Run(ctx) }()
+	select {
+	case <-ready:
+	case <-time.After(5 * time.Second):
+		t.Fatal("agent did not connect")
+	}
+
+	addr, ok := s.Addr("edge")
+	if !ok {
+		t.Fatal("tunnel address not found")
+	}
+	for i := 0; i < 3; i++ {
+		c, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg := []byte("hello kubepi")
+		if _, err := c.Write(msg); err != nil {
+			t.Fatal(err)
+		}
+		buf := make([]byte, len(msg))
+		_ = c.SetReadDeadline(time.Now().Add(5 * time.Second))
+		if _, err := io.ReadFull(c, buf); err != nil {
+			t.Fatal(err)
+		}
+		if string(buf) != string(msg) {
+			t.Errorf("got %s, want %s", buf, msg)
+		}
+		_ = c.Close()
+	}
+
+	s.Close("edge")
+	if _, ok := s.Addr("edge"); ok {
+		t.Error("tunnel should be closed")
+	}
+}
+
+func TestVerifyToken(t *testing.T) {
+	token, err := GenerateToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !VerifyToken(token, HashToken(token)) {
+		t.Error("token should match its hash")
+	}
+	if VerifyToken("other", HashToken(token)) || VerifyToken("", "") {
+		t.Error("unexpected token match")
+	}
+}
@@ -0,0 +1,77 @@
+package ldap
+
+import (
+	"strings"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// 嵌套用户组的最大解析层数,避免循环引用
+const maxGroupDepth = 10
+
+type Group struct {
+	DN      string   `json:"dn"`
+	Name    string   `json:"name"`
+	Members []string `json:"members"`
+}
+
+// SearchGroups 查询 baseDn 下的全部用户组,没有用户组时返回空
+func (l *Ldap) SearchGroups(baseDn, filter, memberAttribute, nameAttribute string, sizeLimit, timeLimit int) ([]Group, error) {
+	searchRequest := ldap.NewSearchRequest(baseDn,
+		ldap.ScopeWholeSubtree, ldap.DerefAlways, 0, timeLimit, false,
+		filter,
+		[]string{nameAttribute, memberAttribute},
+		nil)
+	defer l.release()
+	sr, err := l.Conn.SearchWithPaging(searchRequest, uint32(sizeLimit))
+	if err != nil {
+		return nil, err
+	}
+	groups := make([]Group, 0, len(sr.Entries))
+	for _, entry := range sr.Entries {
+		groups = append(groups, Group{
+			DN:      entry.DN,
+			Name:    entry.GetAttributeValue(nameAttribute),
+			Members: entry.GetAttributeValues(memberAttribute),
+		})
+	}
+	return groups, nil
+}
+
+// ResolveGroups 返回 member 所属的用户组名称,nested 为 true 时包含通过子组间接加入的用户组
+func ResolveGroups(groups []Group, member string, nested bool) []string {
+	var names []string
+	found := map[string]bool{}
+	current := []string{member}
+	for depth := 0; depth < maxGroupDepth && len(current) > 0; depth++ {
+		var next []string
+		for i := range groups {
+			key := strings.ToLower(groups[i].DN)
+			if found[key] || !hasMember(groups[i].Members, current) {
+				continue
+			}
+			found[key] = true
+			if groups[i].Name != "" {
+				names = append(names, groups[i].Name)
+			}
+			next = append(next, groups[i].DN)
+		}
+		if !nested {
+			break
+		}
+		current = next
+	}
+	return names
+}
+
+// hasMember DN 不区分大小写
+func hasMember(members []string, values []string) bool {
+	for i := range members {
+		for j := range values {
+			if strings.EqualFold(strings.TrimSpace(members[i]), values[j]) {
+				return true
+			}
+		}
+	}
+	return false
+}
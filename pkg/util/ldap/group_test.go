@@ -0,0 +1,31 @@
+package ldap
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestResolveGroups(t *testing.T) {
+	groups := []Group{
+		{DN: "cn=dev,ou=groups,dc=ko,dc=com", Name: "dev", Members: []string{"CN=Alice,OU=Users,DC=ko,DC=com"}},
+		{DN: "cn=ops,ou=groups,dc=ko,dc=com", Name: "ops", Members: []string{"cn=bob,ou=users,dc=ko,dc=com"}},
+		{DN: "cn=engineering,ou=groups,dc=ko,dc=com", Name: "engineering", Members: []string{"cn=dev,ou=groups,dc=ko,dc=com"}},
+		{DN: "cn=all,ou=groups,dc=ko,dc=com", Name: "all", Members: []string{"cn=engineering,ou=groups,dc=ko,dc=com", "cn=all,ou=groups,dc=ko,dc=com"}},
+	}
+	tests := []struct {
+		member string
+		nested bool
+		want   []string
+	}{
+		{member: "cn=alice,ou=users,dc=ko,dc=com", nested: false, want: []string{"dev"}},
+		{member: "cn=alice,ou=users,dc=ko,dc=com", nested: true, want: []string{"dev", "engineering", "all"}},
+		{member: "cn=bob,ou=users,dc=ko,dc=com", nested: true, want: []string{"ops"}},
+		{member: "cn=carol,ou=users,dc=ko,dc=com", nested: true, want: nil},
+	}
+	for _, tt := range tests {
+		got := ResolveGroups(groups, tt.member, tt.nested)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ResolveGroups(%s, %v) = %v, want %v", tt.member, tt.nested, got, tt.want)
+		}
+	}
+}
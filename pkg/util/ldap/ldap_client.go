@@ -1,7 +1,6 @@
 package ldap
 
 import (
-	"crypto/tls"
 	"errors"
 	"fmt"
 	"github.com/go-ldap/ldap/v3"
@@ -14,6 +13,11 @@ type Ldap struct {
 	Password string `json:"password"`
 	Conn     *ldap.Conn
 	TLS      bool `json:"tls"`
+	// StartTLS 使用普通端口连接后升级为 TLS
+	StartTLS   bool       `json:"startTLS"`
+	TLSOptions TLSOptions `json:"tlsOptions"`
+	// Pool 不为空时从连接池获取连接,使用后归还
+	Pool *Pool `json:"-"`
 }
 
 func NewLdapClient(address, port, username, password string, tls bool) *Ldap {
@@ -28,21 +32,70 @@ func NewLdapClient(address, port, username, password string, tls bool) *Ldap {
 
 func (l *Ldap) Connect() error {
 	var err error
-	if l.TLS {
-		l.Conn, err = ldap.DialTLS("tcp", fmt.Sprintf("%s:%s", l.Address, l.Port), &tls.Config{
-			InsecureSkipVerify: true,
-		})
+	if l.Pool != nil {
+		l.Conn, err = l.Pool.get(l.dial)
 	} else {
-		l.Conn, err = ldap.Dial("tcp", fmt.Sprintf("%s:%s", l.Address, l.Port))
+		l.Conn, err = l.dial()
 	}
+	return err
+}
 
-	if err != nil {
-		return err
+// dial 建立连接并使用管理员账号绑定
+func (l *Ldap) dial() (*ldap.Conn, error) {
+	var (
+		conn *ldap.Conn
+		err  error
+	)
+	addr := fmt.Sprintf("%s:%s", l.Address, l.Port)
+	if l.TLS || l.StartTLS {
+		config, err := l.TLSOptions.Config(l.Address)
+		if err != nil {
+			return nil, err
+		}
+		if l.TLS {
+			conn, err = ldap.DialTLS("tcp", addr, config)
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			conn, err = ldap.Dial("tcp", addr)
+			if err != nil {
+				return nil, err
+			}
+			if err := conn.StartTLS(config); err != nil {
+				conn.Close()
+				return nil, err
+			}
+		}
+	} else {
+		conn, err = ldap.Dial("tcp", addr)
+		if err != nil {
+			return nil, err
+		}
 	}
-	if err := l.Conn.Bind(l.Username, l.Password); err != nil {
-		return err
+	if err := conn.Bind(l.Username, l.Password); err != nil {
+		conn.Close()
+		return nil, err
 	}
-	return nil
+	return conn, nil
+}
+
+// Close 只建立连接而不执行查询时需要调用
+func (l *Ldap) Close() {
+	l.release()
+}
+
+// release 连接池中的连接归还,否则直接关闭
+func (l *Ldap) release() {
+	if l.Conn == nil {
+		return
+	}
+	if l.Pool != nil {
+		l.Pool.put(l.Conn)
+	} else {
+		l.Conn.Close()
+	}
+	l.Conn = nil
 }
 
 func (l *Ldap) Search(dn, filter string, sizeLimit, timeLimit int, attributes []string) ([]*ldap.Entry, error) {
@@ -51,6 +104,7 @@ func (l *Ldap) Search(dn, filter string, sizeLimit, timeLimit int, attributes []
 		filter,
 		attributes,
 		nil)
+	defer l.release()
 	sr, err := l.Conn.SearchWithPaging(searchRequest, uint32(sizeLimit))
 	if err != nil {
 		return nil, err
@@ -58,29 +112,34 @@ func (l *Ldap) Search(dn, filter string, sizeLimit, timeLimit int, attributes []
 	if len(sr.Entries) == 0 {
 		return nil, errors.New("user is not found")
 	}
-	defer l.Conn.Close()
 	return sr.Entries, err
 }
 
-func (l *Ldap) Login(dn, filter, password string, sizeLimit, timeLimit int) error {
+// Login 校验用户密码,成功时返回用户的 DN
+func (l *Ldap) Login(dn, filter, password string, sizeLimit, timeLimit int) (string, error) {
 	searchRequest := ldap.NewSearchRequest(dn,
 		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, sizeLimit, timeLimit, false,
 		filter,
 		[]string{"dn", "cn", "uid"},
 		nil)
+	defer l.release()
 	sr, err := l.Conn.Search(searchRequest)
 	if err != nil {
-		return err
+		return "", err
 	}
 	if len(sr.Entries) != 1 {
-		return errors.New("user is not found")
+		return "", errors.New("user is not found")
 	}
 	userdn := sr.Entries[0].DN
 	err = l.Conn.Bind(userdn, password)
+	// 归还连接池前重新使用管理员账号绑定
+	if l.Pool != nil {
+		if err := l.Conn.Bind(l.Username, l.Password); err != nil {
+			l.Conn.Close()
+		}
+	}
 	if err != nil {
-		return err
+		return "", err
 	}
-	defer l.Conn.Close()
-
-	return nil
+	return userdn, nil
 }
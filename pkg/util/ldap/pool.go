@@ -0,0 +1,108 @@
+package ldap
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+const (
+	// 每个目录保留的空闲连接数
+	poolSize = 4
+	// 空闲超过该时间的连接可能已被服务端断开,不再复用
+	poolIdleTimeout = 5 * time.Minute
+)
+
+type idleConn struct {
+	conn  *ldap.Conn
+	since time.Time
+}
+
+// Pool 复用已经使用管理员账号绑定的连接
+type Pool struct {
+	key    string
+	mu     sync.Mutex
+	idle   []idleConn
+	closed bool
+}
+
+var (
+	poolsMu sync.Mutex
+	pools   = map[string]*Pool{}
+)
+
+// UsePool 使用 name 对应的连接池,连接配置变化后旧的连接池会被关闭
+func (l *Ldap) UsePool(name string) *Ldap {
+	key := l.fingerprint()
+	poolsMu.Lock()
+	defer poolsMu.Unlock()
+	p, ok := pools[name]
+	if !ok || p.key != key {
+		if ok {
+			p.Close()
+		}
+		p = &Pool{key: key}
+		pools[name] = p
+	}
+	l.Pool = p
+	return l
+}
+
+// ClosePool 删除目录时关闭其连接池
+func ClosePool(name string) {
+	poolsMu.Lock()
+	defer poolsMu.Unlock()
+	if p, ok := pools[name]; ok {
+		p.Close()
+		delete(pools, name)
+	}
+}
+
+func (l *Ldap) fingerprint() string {
+	data, _ := json.Marshal(l)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func (p *Pool) get(dial func() (*ldap.Conn, error)) (*ldap.Conn, error) {
+	p.mu.Lock()
+	for len(p.idle) > 0 {
+		c := p.idle[len(p.idle)-1]
+		p.idle = p.idle[:len(p.idle)-1]
+		if c.conn.IsClosing() || time.Since(c.since) > poolIdleTimeout {
+			c.conn.Close()
+			continue
+		}
+		p.mu.Unlock()
+		return c.conn, nil
+	}
+	p.mu.Unlock()
+	return dial()
+}
+
+func (p *Pool) put(conn *ldap.Conn) {
+	if conn.IsClosing() {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed || len(p.idle) >= poolSize {
+		conn.Close()
+		return
+	}
+	p.idle = append(p.idle, idleConn{conn: conn, since: time.Now()})
+}
+
+func (p *Pool) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i := range p.idle {
+		p.idle[i].conn.Close()
+	}
+	p.idle = nil
+	p.closed = true
+}
@@ -0,0 +1,44 @@
+package ldap
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+)
+
+// TLSOptions ldaps 和 StartTLS 使用的证书配置,证书均为 PEM 格式
+type TLSOptions struct {
+	// CACert 校验服务端证书的 CA,为空时使用系统 CA
+	CACert string `json:"caCert"`
+	// ServerName 校验证书时使用的服务器名称,为空时使用连接地址
+	ServerName string `json:"serverName"`
+	ClientCert string `json:"clientCert"`
+	ClientKey  string `json:"clientKey"`
+	// InsecureSkipVerify 不校验服务端证书
+	InsecureSkipVerify bool `json:"insecureSkipVerify"`
+}
+
+func (o TLSOptions) Config(host string) (*tls.Config, error) {
+	config := &tls.Config{
+		ServerName:         host,
+		InsecureSkipVerify: o.InsecureSkipVerify,
+	}
+	if o.ServerName != "" {
+		config.ServerName = o.ServerName
+	}
+	if o.CACert != "" {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM([]byte(o.CACert)) {
+			return nil, errors.New("invalid ldap ca certificate")
+		}
+		config.RootCAs = pool
+	}
+	if o.ClientCert != "" || o.ClientKey != "" {
+		cert, err := tls.X509KeyPair([]byte(o.ClientCert), []byte(o.ClientKey))
+		if err != nil {
+			return nil, errors.New("invalid ldap client certificate: " + err.Error())
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
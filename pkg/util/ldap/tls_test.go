@@ -0,0 +1,26 @@
+package ldap
+
+import "testing"
+
+func TestTLSOptionsConfig(t *testing.T) {
+	config, err := TLSOptions{}.Config("ldap.ko.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.ServerName != "ldap.ko.com" || config.InsecureSkipVerify {
+		t.Errorf("unexpected default config: %s %v", config.ServerName, config.InsecureSkipVerify)
+	}
+	config, err = TLSOptions{ServerName: "dc01.ko.com"}.Config("10.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.ServerName != "dc01.ko.com" {
+		t.Errorf("server name should be overridden, got %s", config.ServerName)
+	}
+	if _, err := (TLSOptions{CACert: "not a certificate"}).Config("ldap.ko.com"); err == nil {
+		t.Error("invalid ca certificate should be rejected")
+	}
+	if _, err := (TLSOptions{ClientCert: "not a certificate"}).Config("ldap.ko.com"); err == nil {
+		t.Error("invalid client certificate should be rejected")
+	}
+}
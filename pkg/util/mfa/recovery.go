@@ -0,0 +1,39 @@
+package mfa
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+)
+
+const (
+	RecoveryCodeCount = 10
+	recoveryCodeChars = "abcdefghjkmnpqrstuvwxyz23456789"
+	recoveryCodeHalf  = 5
+)
+
+// GenerateRecoveryCodes 生成一次性恢复码,返回明文和对应的哈希,数据库中只保存哈希
+func GenerateRecoveryCodes(n int) (codes []string, hashes []string, err error) {
+	for i := 0; i < n; i++ {
+		b := make([]byte, recoveryCodeHalf*2)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+		for j := range b {
+			b[j] = recoveryCodeChars[int(b[j])%len(recoveryCodeChars)]
+		}
+		code := string(b[:recoveryCodeHalf]) + "-" + string(b[recoveryCodeHalf:])
+		codes = append(codes, code)
+		hashes = append(hashes, HashRecoveryCode(code))
+	}
+	return codes, hashes, nil
+}
+
+// HashRecoveryCode 忽略大小写、空格和连字符
+func HashRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	code = strings.NewReplacer("-", "", " ", "").Replace(code)
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
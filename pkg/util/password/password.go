@@ -0,0 +1,122 @@
+package password
+
+import (
+	"crypto/rand"
+	"fmt"
+	"math/big"
+	"strconv"
+	"unicode"
+)
+
+const (
+	upperChars   = "ABCDEFGHJKLMNPQRSTUVWXYZ"
+	lowerChars   = "abcdefghijkmnpqrstuvwxyz"
+	digitChars   = "23456789"
+	specialChars = "!@#$%^&*-_=+"
+	// generatedMinLength 随机密码的最小长度
+	generatedMinLength = 12
+)
+
+type Policy struct {
+	MinLength      int
+	RequireUpper   bool
+	RequireLower   bool
+	RequireDigit   bool
+	RequireSpecial bool
+}
+
+// ViolationError 密码不符合策略,Key 为 i18n 的 key
+type ViolationError struct {
+	Key  string
+	Args []string
+}
+
+func (e *ViolationError) Error() string {
+	args := make([]interface{}, 0, len(e.Args))
+	for i := range e.Args {
+		args = append(args, e.Args[i])
+	}
+	return fmt.Sprintf(e.Key, args...)
+}
+
+// Message 返回 i18n 格式的错误信息
+func (e *ViolationError) Message() []string {
+	return append([]string{e.Key}, e.Args...)
+}
+
+func NewViolationError(key string, args ...string) *ViolationError {
+	return &ViolationError{Key: key, Args: args}
+}
+
+func Validate(password string, p Policy) error {
+	if len([]rune(password)) < p.MinLength {
+		return NewViolationError("password must be at least %s characters", strconv.Itoa(p.MinLength))
+	}
+	var upper, lower, digit, special bool
+	for _, c := range password {
+		switch {
+		case unicode.IsUpper(c):
+			upper = true
+		case unicode.IsLower(c):
+			lower = true
+		case unicode.IsDigit(c):
+			digit = true
+		default:
+			special = true
+		}
+	}
+	if p.RequireUpper && !upper {
+		return NewViolationError("password must contain uppercase letters")
+	}
+	if p.RequireLower && !lower {
+		return NewViolationError("password must contain lowercase letters")
+	}
+	if p.RequireDigit && !digit {
+		return NewViolationError("password must contain digits")
+	}
+	if p.RequireSpecial && !special {
+		return NewViolationError("password must contain special characters")
+	}
+	return nil
+}
+
+// Generate 生成符合策略的随机密码,四类字符各至少包含一个
+func Generate(p Policy) (string, error) {
+	length := p.MinLength
+	if length < generatedMinLength {
+		length = generatedMinLength
+	}
+	sets := []string{upperChars, lowerChars, digitChars, specialChars}
+	all := upperChars + lowerChars + digitChars + specialChars
+	chars := make([]byte, 0, length)
+	for _, set := range sets {
+		c, err := randomChar(set)
+		if err != nil {
+			return "", err
+		}
+		chars = append(chars, c)
+	}
+	for len(chars) < length {
+		c, err := randomChar(all)
+		if err != nil {
+			return "", err
+		}
+		chars = append(chars, c)
+	}
+	for i := len(chars) - 1; i > 0; i-- {
+		j, err := rand.Int(rand.Reader, big.NewInt(int64(i+1)))
+		if err != nil {
+			return "", err
+		}
+		chars[i], chars[j.Int64()] = chars[j.Int64()], chars[i]
+	}
+	return string(chars), nil
+}
+
+func randomChar(set string) (byte, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(int64(len(set))))
+	if err != nil {
+		return 0, err
+	}
+	return set[n.Int64()], nil
+}
@@ -0,0 +1,39 @@
+package password
+
+import "testing"
+
+func TestValidate(t *testing.T) {
+	p := Policy{MinLength: 8, RequireUpper: true, RequireLower: true, RequireDigit: true, RequireSpecial: true}
+	cases := map[string]bool{
+		"Ab1!":       false,
+		"abcdefg1!":  false,
+		"ABCDEFG1!":  false,
+		"Abcdefgh!":  false,
+		"Abcdefgh1":  false,
+		"Abcdefg1!":  true,
+		"密码Abcdef1!": true,
+	}
+	for pw, ok := range cases {
+		if err := Validate(pw, p); (err == nil) != ok {
+			t.Errorf("validate %s: expect %v, got %v", pw, ok, err)
+		}
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	for _, p := range []Policy{
+		{},
+		{MinLength: 20, RequireUpper: true, RequireLower: true, RequireDigit: true, RequireSpecial: true},
+	} {
+		pw, err := Generate(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := Validate(pw, p); err != nil {
+			t.Errorf("generated password %s does not match policy: %v", pw, err)
+		}
+		if len(pw) < p.MinLength || len(pw) < generatedMinLength {
+			t.Errorf("generated password %s is too short", pw)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package sso
+
+import (
+	"fmt"
+	"strings"
+)
+
+// lookupClaim 按照 . 分隔的路径查找声明
+func lookupClaim(claims map[string]interface{}, path string) (interface{}, bool) {
+	var cur interface{} = claims
+	for _, key := range strings.Split(path, ".") {
+		m, ok := cur.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if cur, ok = m[key]; !ok {
+			return nil, false
+		}
+	}
+	return cur, true
+}
+
+// ClaimString 返回字符串类型的声明,不存在时返回空字符串
+func ClaimString(claims map[string]interface{}, path string) string {
+	v, ok := lookupClaim(claims, path)
+	if !ok || v == nil {
+		return ""
+	}
+	if s, ok := v.(string); ok {
+		return s
+	}
+	return fmt.Sprint(v)
+}
+
+// ClaimStrings 返回数组类型的声明,字符串类型的声明按逗号分隔
+func ClaimStrings(claims map[string]interface{}, path string) []string {
+	v, ok := lookupClaim(claims, path)
+	if !ok || v == nil {
+		return nil
+	}
+	var result []string
+	switch vs := v.(type) {
+	case []interface{}:
+		for i := range vs {
+			if s, ok := vs[i].(string); ok && s != "" {
+				result = append(result, s)
+			}
+		}
+	case []string:
+		result = append(result, vs...)
+	case string:
+		for _, s := range strings.Split(vs, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				result = append(result, s)
+			}
+		}
+	}
+	return result
+}
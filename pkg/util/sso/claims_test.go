@@ -0,0 +1,36 @@
+package sso
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestClaims(t *testing.T) {
+	var claims map[string]interface{}
+	raw := `{"preferred_username":"alice","groups":["dev","ops"],"realm_access":{"roles":["admin"]},"team":"a, b"}`
+	if err := json.Unmarshal([]byte(raw), &claims); err != nil {
+		t.Fatal(err)
+	}
+	if got := ClaimString(claims, "preferred_username"); got != "alice" {
+		t.Errorf("ClaimString() = %s, want alice", got)
+	}
+	if got := ClaimString(claims, "email"); got != "" {
+		t.Errorf("ClaimString() = %s, want empty", got)
+	}
+	tests := []struct {
+		path string
+		want []string
+	}{
+		{"groups", []string{"dev", "ops"}},
+		{"realm_access.roles", []string{"admin"}},
+		{"team", []string{"a", "b"}},
+		{"realm_access.groups", nil},
+		{"preferred_username.roles", nil},
+	}
+	for _, tt := range tests {
+		if got := ClaimStrings(claims, tt.path); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ClaimStrings(%s) = %v, want %v", tt.path, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package sso
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// GenerateKeyPair 生成 SAML 服务提供方用于签名的自签名证书和私钥
+func GenerateKeyPair(commonName string) (certPEM string, keyPEM string, err error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return "", "", err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return "", "", err
+	}
+	tmpl := x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().AddDate(10, 0, 0),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &tmpl, &tmpl, &key.PublicKey, key)
+	if err != nil {
+		return "", "", err
+	}
+	certPEM = string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+	keyPEM = string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}))
+	return certPEM, keyPEM, nil
+}
+
+// ParseKeyPair 解析 pem 格式的证书和 rsa 私钥
+func ParseKeyPair(certPEM, keyPEM string) (*x509.Certificate, *rsa.PrivateKey, error) {
+	pair, err := tls.X509KeyPair([]byte(certPEM), []byte(keyPEM))
+	if err != nil {
+		return nil, nil, err
+	}
+	key, ok := pair.PrivateKey.(*rsa.PrivateKey)
+	if !ok {
+		return nil, nil, errors.New("private key of saml service provider must be rsa")
+	}
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return nil, nil, err
+	}
+	return cert, key, nil
+}
+
+// SignRelayState 将认证请求 ID 签名后作为 RelayState,ACS 收到响应时不依赖 cookie 即可校验 InResponseTo
+func SignRelayState(requestID, key string) string {
+	return requestID + "." + relayStateMac(requestID, key)
+}
+
+// VerifyRelayState 校验签名并返回认证请求 ID
+func VerifyRelayState(state, key string) (string, bool) {
+	i := strings.LastIndex(state, ".")
+	if i <= 0 {
+		return "", false
+	}
+	requestID := state[:i]
+	if !hmac.Equal([]byte(state[i+1:]), []byte(relayStateMac(requestID, key))) {
+		return "", false
+	}
+	return requestID, true
+}
+
+func relayStateMac(requestID, key string) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(requestID))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
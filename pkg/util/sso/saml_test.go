@@ -0,0 +1,31 @@
+package sso
+
+import "testing"
+
+func TestRelayState(t *testing.T) {
+	state := SignRelayState("id-1234", "secret")
+	id, ok := VerifyRelayState(state, "secret")
+	if !ok || id != "id-1234" {
+		t.Errorf("VerifyRelayState() = %s, %v, want id-1234, true", id, ok)
+	}
+	if _, ok := VerifyRelayState(state, "other"); ok {
+		t.Error("VerifyRelayState() accepted state signed with another key")
+	}
+	if _, ok := VerifyRelayState("id-5678"+state[len("id-1234"):], "secret"); ok {
+		t.Error("VerifyRelayState() accepted tampered request id")
+	}
+}
+
+func TestKeyPair(t *testing.T) {
+	certPEM, keyPEM, err := GenerateKeyPair("kubepi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _, err := ParseKeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cert.Subject.CommonName != "kubepi" {
+		t.Errorf("CommonName = %s, want kubepi", cert.Subject.CommonName)
+	}
+}